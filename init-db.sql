@@ -29,6 +29,23 @@ CREATE TABLE IF NOT EXISTS projects (
     ssh_private_key TEXT,
     registry_user TEXT,
     registry_token TEXT,
+    compose_profiles TEXT[] DEFAULT '{}',  -- Profils compose activés au déploiement (--profile)
+    paused BOOLEAN DEFAULT FALSE,  -- Projet en pause : les webhooks sont acquittés sans lancer de pipeline
+    deploy_tags TEXT[] DEFAULT '{}',  -- Tags (globs, ex: v*) dont les pipelines sont déployés
+    clone_ssh_key TEXT,  -- Clé SSH (chiffrée) pour cloner les dépôts git@...
+    git_lfs BOOLEAN DEFAULT FALSE,  -- Récupère les fichiers Git LFS après le checkout
+    git_submodules BOOLEAN DEFAULT FALSE,  -- Clone les sous-modules Git avec l'authentification du dépôt
+    require_deployment BOOLEAN DEFAULT FALSE,  -- Un fichier de déploiement absent fait échouer le pipeline
+    webhook_secret TEXT,  -- Secret (chiffré) attendu dans l'en-tête X-Gitlab-Token des webhooks
+    secret_scan TEXT,  -- Scan des secrets avant déploiement : vide (désactivé), warn ou block
+    deployment_stacks TEXT[] DEFAULT '{}',  -- Fichiers compose déployés dans l'ordre, remplacent deployment_filename
+    health_check_url TEXT,  -- URL interrogée après un déploiement local, une réponse en erreur déclenche le rollback
+    slack_webhook_url TEXT,  -- Webhook Slack (chiffré) notifié à la fin de chaque pipeline
+    notify_webhook_url TEXT,  -- URL (chiffrée) recevant le résumé JSON de chaque pipeline terminé
+    schedule_cron TEXT,  -- Expression cron (5 champs ou @daily...) lançant un pipeline planifié, vide pour aucun
+    schedule_branch TEXT,  -- Branche construite par le pipeline planifié (main par défaut)
+    schedule_last_run TIMESTAMPTZ,  -- Dernière exécution planifiée réclamée, évite un double déclenchement après redémarrage
+    post_clone_command TEXT,  -- Commande exécutée dans le workspace après le clone (autorisée par POST_CLONE_COMMANDS)
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -39,6 +56,7 @@ CREATE TABLE IF NOT EXISTS variables (
     key TEXT NOT NULL,
     value TEXT NOT NULL,
     is_secret BOOLEAN DEFAULT FALSE,
+    masked BOOLEAN DEFAULT FALSE,  -- Valeur masquée (****) dans les logs et jamais renvoyée par l'API
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     UNIQUE(project_id, key)
 );
@@ -56,11 +74,24 @@ CREATE TABLE IF NOT EXISTS project_members (
 CREATE TABLE IF NOT EXISTS pipelines (
     id SERIAL PRIMARY KEY,
     project_id INTEGER NOT NULL,
-    status TEXT DEFAULT 'pending', -- pending, running, success, failed, cancelled
+    status TEXT DEFAULT 'pending', -- pending, queued, running, waiting_for_approval, success, failed, cancelled, superseded
     commit_hash TEXT,              -- Le hash du commit qui a déclenché la pipeline
     branch TEXT,                   -- La branche concernée (ex: main)
+    tag TEXT,                      -- Le tag de la pipeline (ex: v1.2.0), vide pour une branche
+    commit_message TEXT,           -- Message du commit (payload du webhook ou lu dans le clone)
+    commit_author_name TEXT,       -- Auteur du commit
+    commit_author_email TEXT,
+    labels TEXT[] DEFAULT '{}',    -- Étiquettes libres pour filtrer l'historique (ex: release, hotfix)
+    coverage NUMERIC(5,2),         -- Couverture agrégée des jobs (last, average ou max)
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,          -- Début de l'exécution, après l'attente dans la file
     finished_at TIMESTAMP,
+    approval_stage TEXT,           -- Étape qui attend une approbation manuelle : un stage ou "deployment"
+    approval_deadline TIMESTAMPTZ, -- Passé ce délai, l'approbation est rejetée
+    clone_log TEXT,                -- Sortie du clone, affichée dans le log de la pipeline
+    inline_config TEXT,            -- Config CI fournie au déclenchement manuel, remplace celle du dépôt
+    inline_deploy BOOLEAN DEFAULT FALSE, -- Une pipeline à config inline ne déploie que si c'est demandé
+    changed_files TEXT[],          -- Fichiers modifiés par le push, pour ne redéployer que les services touchés
     FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE
 );
 
@@ -71,8 +102,13 @@ CREATE TABLE IF NOT EXISTS jobs (
     name TEXT NOT NULL,            -- ex: build_job
     stage TEXT NOT NULL,           -- ex: build, test
     image TEXT NOT NULL,           -- ex: alpine:latest
-    status TEXT DEFAULT 'pending', -- pending, running, success, failed
+    status TEXT DEFAULT 'pending', -- pending, running, success, failed, cancelled, skipped
     exit_code INTEGER,             -- Code de retour du conteneur (0 = succès)
+    coverage NUMERIC(5,2),         -- Couverture extraite des logs via l'expression `coverage`
+    executor TEXT,                 -- Nœud d'exécution ayant lancé le job (hostname)
+    allow_failure BOOLEAN DEFAULT FALSE, -- Un échec du job ne fait pas échouer le pipeline
+    attempt INTEGER DEFAULT 1,     -- Tentative en cours ou dernière tentative (retry)
+    image_digest TEXT,             -- Digest de l'image pullée pour le job (sha256:...)
     started_at TIMESTAMP,
     finished_at TIMESTAMP,
     FOREIGN KEY(pipeline_id) REFERENCES pipelines(id) ON DELETE CASCADE
@@ -81,18 +117,23 @@ CREATE TABLE IF NOT EXISTS jobs (
 CREATE TABLE deployments (
     id SERIAL PRIMARY KEY,
     pipeline_id INTEGER NOT NULL REFERENCES pipelines(id) ON DELETE CASCADE,
-    status VARCHAR(20) NOT NULL,       -- 'deploying', 'success', 'failed', 'rolled_back'
+    status VARCHAR(20) NOT NULL,       -- 'deploying', 'success', 'failed', 'rolled_back', 'cancelled', 'skipped'
     started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    finished_at TIMESTAMP
+    finished_at TIMESTAMP,
+    exit_code INTEGER,  -- Code de sortie de la commande compose (1 service en échec, 125 mauvaise utilisation...)
+    stack VARCHAR(255) NOT NULL DEFAULT ''  -- Fichier compose de la stack, vide pour le déploiement global du pipeline
 );
 
 -- Table des logs (Stockage unitaire ligne par ligne pour le streaming)
 CREATE TABLE IF NOT EXISTS job_logs (
     id SERIAL PRIMARY KEY,
     job_id INTEGER NOT NULL,
+    line_number INTEGER,           -- Numéro de ligne dans le job (curseur de reprise ?after=)
+    stream TEXT DEFAULT 'stdout',  -- Flux d'origine de la ligne : stdout ou stderr
     content TEXT,                  -- Le contenu de la ligne de log
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Pour trier les logs dans l'ordre
-    FOREIGN KEY(job_id) REFERENCES jobs(id) ON DELETE CASCADE
+    FOREIGN KEY(job_id) REFERENCES jobs(id) ON DELETE CASCADE,
+    UNIQUE(job_id, line_number)    -- Une ligne par numéro, les écritures concurrentes sont sérialisées par job
 );
 
 CREATE TABLE IF NOT EXISTS deployment_logs (
@@ -109,6 +150,7 @@ CREATE INDEX IF NOT EXISTS idx_variables_project_id ON variables(project_id);
 CREATE INDEX IF NOT EXISTS idx_project_members_user_id ON project_members(user_id);
 CREATE INDEX IF NOT EXISTS idx_pipelines_project_id ON pipelines(project_id);
 CREATE INDEX IF NOT EXISTS idx_pipelines_status ON pipelines(status);
+CREATE INDEX IF NOT EXISTS idx_pipelines_labels ON pipelines USING GIN (labels);
 CREATE INDEX IF NOT EXISTS idx_jobs_pipeline_id ON jobs(pipeline_id);
 CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs(status);
 CREATE INDEX IF NOT EXISTS idx_logs_job_id ON job_logs(job_id);
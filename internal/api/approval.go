@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+)
+
+// approvalDeployment is the gate of the deployment step, the other gates are named after their stage
+const approvalDeployment = "deployment"
+
+var (
+	errApprovalRejected    = errors.New("approval rejected")
+	errApprovalTimedOut    = errors.New("approval timed out")
+	errApprovalUnavailable = errors.New("approval requires a pipeline record")
+)
+
+// approvalGates keeps track of the pipelines of this process waiting at an approval gate
+type approvalGates struct {
+	mu      sync.Mutex
+	waiting map[int]chan bool
+}
+
+func newApprovalGates() *approvalGates {
+	return &approvalGates{waiting: make(map[int]chan bool)}
+}
+
+// open registers a pipeline at a gate, the returned channel receives the decision
+func (g *approvalGates) open(pipelineID int) chan bool {
+	decision := make(chan bool, 1)
+	g.mu.Lock()
+	g.waiting[pipelineID] = decision
+	g.mu.Unlock()
+	return decision
+}
+
+// withdraw removes a pipeline from its gate, it returns false if the gate was decided meanwhile
+func (g *approvalGates) withdraw(pipelineID int, decision chan bool) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.waiting[pipelineID] != decision {
+		return false
+	}
+	delete(g.waiting, pipelineID)
+	return true
+}
+
+// wait blocks until the gate opened for a pipeline is decided, it times out or ctx is done
+// A decision made as the timeout expires still counts
+func (g *approvalGates) wait(ctx context.Context, pipelineID int, decision chan bool, timeout time.Duration) error {
+	defer g.withdraw(pipelineID, decision)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case approved := <-decision:
+		return approvalResult(approved)
+	case <-timer.C:
+		if g.withdraw(pipelineID, decision) {
+			return fmt.Errorf("%w after %s", errApprovalTimedOut, timeout)
+		}
+		return approvalResult(<-decision)
+	}
+}
+
+// decide approves or rejects the gate a pipeline waits at
+// It returns false if the pipeline does not wait at a gate of this process, only the first decision counts
+func (g *approvalGates) decide(pipelineID int, approved bool) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	decision, ok := g.waiting[pipelineID]
+	if ok {
+		delete(g.waiting, pipelineID)
+		decision <- approved
+	}
+	return ok
+}
+
+func approvalResult(approved bool) error {
+	if approved {
+		return nil
+	}
+	return errApprovalRejected
+}
+
+// awaitApproval holds a pipeline at a gate, a stage or the deployment, until it is approved
+// The pipeline has the waiting_for_approval status meanwhile, a timeout of 0 uses PIPELINE_APPROVAL_TIMEOUT_MINUTES
+func (s *Server) awaitApproval(ctx context.Context, pipelineID int, gate string, timeout time.Duration) error {
+	if s.db == nil || pipelineID <= 0 {
+		return errApprovalUnavailable
+	}
+	if timeout <= 0 {
+		timeout = s.approvalTimeout
+	}
+
+	// Other pipelines take the slot and the concurrency group of the run while it waits
+	hold := runHoldFrom(ctx)
+	hold.releaseSlot()
+	s.concurrency.release(pipelineID)
+
+	// The gate is open before the status says so, an approval sent right away is not lost
+	decision := s.approvals.open(pipelineID)
+	deadline := time.Now().Add(timeout)
+	log := logger.FromContext(ctx)
+	if err := s.db.SetPipelineApproval(pipelineID, gate, deadline); err != nil {
+		log.Error("Failed to record pipeline approval", "error", err)
+	}
+	log.Info("Pipeline waiting for approval", "gate", gate, "deadline", deadline)
+
+	err := s.approvals.wait(ctx, pipelineID, decision, timeout)
+	if endErr := s.db.EndPipelineApproval(pipelineID); endErr != nil {
+		log.Error("Failed to clear pipeline approval", "error", endErr)
+	}
+	if err != nil {
+		return err
+	}
+	log.Info("Pipeline approved", "gate", gate)
+	return s.resumeRun(ctx, hold)
+}
+
+// resumeRun takes the concurrency group and a scheduler slot back for an approved run
+// A queue group is waited for; in the other groups a pipeline that took the group meanwhile is newer and wins
+func (s *Server) resumeRun(ctx context.Context, hold *runHold) error {
+	if hold == nil {
+		return nil
+	}
+	if hold.group != "" {
+		policy := pipeline.ConcurrencyRejectNew
+		if hold.policy == pipeline.ConcurrencyQueue {
+			policy = pipeline.ConcurrencyQueue
+		}
+		if err := s.concurrency.acquire(ctx, hold.group, policy, hold.pipelineID, hold.cancel, nil); err != nil {
+			return err
+		}
+	}
+	return hold.acquireSlot(ctx, nil)
+}
+
+// resumeWaitingPipelines takes over the pipelines a stopped server left waiting for an approval
+// Those past their deadline fail, the others run again from their commit and wait at the gate anew
+// The run and its workspace are gone, so the stages before the gate run again and the approval is asked again
+// The changed files of a webhook pipeline are read back from the pipeline row, the deployment stays limited to them
+func (s *Server) resumeWaitingPipelines() {
+	failed, err := s.db.FailExpiredWaitingPipelines()
+	if err != nil {
+		logger.Error("Failed to fail expired pipelines waiting for approval", "error", err)
+	} else if failed > 0 {
+		logger.Warn("Failed pipelines left waiting for approval past their deadline", "count", failed)
+	}
+
+	waiting, err := s.db.GetWaitingPipelines()
+	if err != nil {
+		logger.Error("Failed to get pipelines waiting for approval", "error", err)
+		return
+	}
+	for i := range waiting {
+		p := &waiting[i]
+		project, err := s.db.GetProject(p.ProjectID)
+		if err != nil {
+			logger.Error("Failed to resume pipeline waiting for approval", "pipeline_id", p.ID, "error", err)
+			s.setPipelineStatus(p.ID, "failed")
+			continue
+		}
+		if endErr := s.db.EndPipelineApproval(p.ID); endErr != nil {
+			logger.Error("Failed to clear pipeline approval", "pipeline_id", p.ID, "error", endErr)
+		}
+		s.resetPipelineJobs(p.ID)
+		logger.Info("Resuming pipeline left waiting for approval", "pipeline_id", p.ID, "gate", p.ApprovalStage)
+		s.submitManualPipeline(project, p, p.Branch)
+	}
+}
+
+// handlePipelineApproval handles POST /api/v1/projects/{projectId}/pipelines/{pipelineId}/approve (and /reject)
+// The pipeline resumes at the gate it waits at, or fails when rejected
+func (s *Server) handlePipelineApproval(w http.ResponseWriter, r *http.Request, approved bool) {
+	projectID, err := parseIDFromPath(r.URL.Path, 3)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	pipelineID, err := parseIDFromPath(r.URL.Path, 5)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid pipeline ID")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if s.db == nil {
+		respondError(w, http.StatusServiceUnavailable, "Database not available")
+		return
+	}
+
+	// Only the owner and the members of the project decide on its pipelines
+	if _, ok := s.authorizeProject(w, r, projectID); !ok {
+		return
+	}
+
+	p, err := s.db.GetPipeline(pipelineID)
+	if err != nil || p.ProjectID != projectID {
+		respondError(w, http.StatusNotFound, "Pipeline not found")
+		return
+	}
+
+	if p.Status != "waiting_for_approval" || !s.approvals.decide(pipelineID, approved) {
+		respondError(w, http.StatusConflict, "Pipeline is not waiting for approval")
+		return
+	}
+
+	decision := "approved"
+	if !approved {
+		decision = "rejected"
+	}
+	userID, _ := getUserIDFromContext(r)
+	logger.Info("Pipeline approval decided", "pipeline_id", pipelineID, "decision", decision, "gate", p.ApprovalStage, "user_id", userID)
+
+	respondJSON(w, http.StatusAccepted, map[string]string{"status": decision, "gate": p.ApprovalStage})
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestApprovalGatesDecide(t *testing.T) {
+	for _, approved := range []bool{true, false} {
+		g := newApprovalGates()
+		decision := g.open(7)
+		if !g.decide(7, approved) {
+			t.Fatal("Expected the waiting pipeline to be decided")
+		}
+		// Only the first decision counts
+		if g.decide(7, !approved) {
+			t.Error("Expected a second decision to be refused")
+		}
+
+		err := g.wait(context.Background(), 7, decision, time.Minute)
+		if approved && err != nil {
+			t.Errorf("Expected the approved gate to pass, got %v", err)
+		}
+		if !approved && !errors.Is(err, errApprovalRejected) {
+			t.Errorf("Expected the gate to be rejected, got %v", err)
+		}
+	}
+}
+
+func TestApprovalGatesTimeout(t *testing.T) {
+	g := newApprovalGates()
+	decision := g.open(7)
+	if err := g.wait(context.Background(), 7, decision, time.Millisecond); !errors.Is(err, errApprovalTimedOut) {
+		t.Fatalf("Expected the gate to time out, got %v", err)
+	}
+	if g.decide(7, true) {
+		t.Error("Expected a timed out gate not to be decided")
+	}
+}
+
+func TestApprovalGatesCancel(t *testing.T) {
+	g := newApprovalGates()
+	decision := g.open(7)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.wait(ctx, 7, decision, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the cancellation to end the wait, got %v", err)
+	}
+	if g.decide(7, true) {
+		t.Error("Expected a cancelled pipeline not to wait anymore")
+	}
+}
+
+func TestAwaitApprovalWithoutDatabase(t *testing.T) {
+	s := &Server{approvals: newApprovalGates(), approvalTimeout: time.Minute}
+	if err := s.awaitApproval(context.Background(), 7, approvalDeployment, 0); !errors.Is(err, errApprovalUnavailable) {
+		t.Errorf("Expected an approval without database to fail, got %v", err)
+	}
+}
+
+func TestHandlePipelineApproval(t *testing.T) {
+	s := &Server{approvals: newApprovalGates()}
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodPost, "/api/v1/projects/abc/pipelines/7/approve", http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/projects/3/pipelines/abc/approve", http.StatusBadRequest},
+		{http.MethodGet, "/api/v1/projects/3/pipelines/7/approve", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/v1/projects/3/pipelines/7/reject", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.routeProjectsSubpath(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.status, rec.Code)
+		}
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"strings"
@@ -19,6 +18,7 @@ import (
 	"golang.org/x/oauth2/google"
 
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
 )
 
 var (
@@ -32,7 +32,7 @@ var (
 func InitializeOAuth() {
 	if len(jwtSecret) == 0 {
 		jwtSecret = []byte("your-secret-key-should-be-in-env")
-		log.Println("WARNING: JWT_SECRET not set, using default insecure key")
+		logger.Warn("JWT_SECRET not set, using default insecure key")
 	}
 
 	googleOauthConfig = &oauth2.Config{
@@ -142,7 +142,7 @@ func (s *Server) handleAuthCallback(w http.ResponseWriter, r *http.Request) {
 
 	userInfo, err := getUserInfo(provider, token.AccessToken)
 	if err != nil {
-		log.Printf("Failed to get user info: %v", err)
+		logger.Error("Failed to get user info", "error", err)
 		http.Error(w, "Failed to get user info", http.StatusInternalServerError)
 		return
 	}
@@ -150,7 +150,7 @@ func (s *Server) handleAuthCallback(w http.ResponseWriter, r *http.Request) {
 	// Save/Update user in DB
 	err = s.db.CreateUser(userInfo)
 	if err != nil {
-		log.Printf("Failed to save user: %v", err)
+		logger.Error("Failed to save user", "error", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
@@ -158,7 +158,7 @@ func (s *Server) handleAuthCallback(w http.ResponseWriter, r *http.Request) {
 	// Retrieve full user (with ID)
 	dbUser, err := s.db.GetUserByEmail(userInfo.Email)
 	if err != nil {
-		log.Printf("Failed to retrieve user: %v", err)
+		logger.Error("Failed to retrieve user", "error", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
@@ -310,4 +310,4 @@ func getUserIDFromContext(r *http.Request) (int, error) {
 		return 0, fmt.Errorf("user ID not found in context")
 	}
 	return userID, nil
-}
\ No newline at end of file
+}
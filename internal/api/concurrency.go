@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+)
+
+// errConcurrencyRejected is returned when a reject-new group already runs a pipeline
+var errConcurrencyRejected = errors.New("another pipeline of the concurrency group is running")
+
+// concurrencyGroups lets a single pipeline of each concurrency group run at a time
+// The pipeline holding a group keeps it until it is released, deployment included
+type concurrencyGroups struct {
+	mu     sync.Mutex
+	groups map[string]*concurrencyGroup
+}
+
+type concurrencyGroup struct {
+	holder  int
+	cancel  func() // cancels the holder, for cancel-running
+	waiters []*groupWaiter
+}
+
+type groupWaiter struct {
+	pipelineID int
+	cancel     func()
+	ready      chan struct{}
+}
+
+func newConcurrencyGroups() *concurrencyGroups {
+	return &concurrencyGroups{groups: make(map[string]*concurrencyGroup)}
+}
+
+// acquire takes the group for a pipeline, applying the policy when another pipeline holds it:
+// queue waits for it, cancel-running cancels it (through its cancel function) then waits, reject-new fails
+// cancel is the function other pipelines use to cancel this one, waiting (optional) is called before waiting for the group
+func (c *concurrencyGroups) acquire(ctx context.Context, key, policy string, pipelineID int, cancel, waiting func()) error {
+	c.mu.Lock()
+	group, ok := c.groups[key]
+	if !ok {
+		c.groups[key] = &concurrencyGroup{holder: pipelineID, cancel: cancel}
+		c.mu.Unlock()
+		return nil
+	}
+	if group.holder == pipelineID {
+		// Retried attempts of the holder keep the group
+		c.mu.Unlock()
+		return nil
+	}
+
+	switch policy {
+	case pipeline.ConcurrencyRejectNew:
+		c.mu.Unlock()
+		return fmt.Errorf("%w (pipeline %d)", errConcurrencyRejected, group.holder)
+	case pipeline.ConcurrencyCancelRunning:
+		// The newest pipeline wins, older ones running or waiting are cancelled
+		group.cancel()
+		for _, w := range group.waiters {
+			w.cancel()
+		}
+	}
+
+	w := &groupWaiter{pipelineID: pipelineID, cancel: cancel, ready: make(chan struct{})}
+	group.waiters = append(group.waiters, w)
+	c.mu.Unlock()
+
+	if waiting != nil {
+		waiting()
+	}
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		select {
+		case <-w.ready:
+			// The group was handed over meanwhile, pass it on
+			c.releaseLocked(key, pipelineID)
+		default:
+			group.removeWaiter(w)
+		}
+		return ctx.Err()
+	}
+}
+
+// release frees the group held by a pipeline, the next waiting pipeline takes it
+// Releasing when the pipeline holds no group is harmless
+func (c *concurrencyGroups) release(pipelineID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, group := range c.groups {
+		if group.holder == pipelineID {
+			c.releaseLocked(key, pipelineID)
+		}
+	}
+}
+
+func (c *concurrencyGroups) releaseLocked(key string, pipelineID int) {
+	group, ok := c.groups[key]
+	if !ok || group.holder != pipelineID {
+		return
+	}
+	if len(group.waiters) == 0 {
+		delete(c.groups, key)
+		return
+	}
+
+	next := group.waiters[0]
+	group.waiters = group.waiters[1:]
+	group.holder = next.pipelineID
+	group.cancel = next.cancel
+	close(next.ready)
+}
+
+func (g *concurrencyGroup) removeWaiter(w *groupWaiter) {
+	for i, waiter := range g.waiters {
+		if waiter == w {
+			g.waiters = append(g.waiters[:i], g.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// concurrencyKey scopes a concurrency group to its project
+func concurrencyKey(projectID int, group string) string {
+	return fmt.Sprintf("%d/%s", projectID, group)
+}
+
+// concurrencyFor returns the concurrency group and policy of a pipeline, an empty group when it has none
+// The group of the pipeline file wins, otherwise branchPolicy puts the pipelines of a branch in a group of their own
+func concurrencyFor(config pipeline.ConcurrencyConfig, params models.PipelineRunParams, branchPolicy string) (string, string) {
+	if config.Group != "" {
+		policy := config.Policy
+		if policy == "" {
+			policy = pipeline.ConcurrencyQueue
+		}
+		return config.Group, policy
+	}
+	if branchPolicy != "" && params.Branch != "" && params.Tag == "" {
+		return "branch:" + params.Branch, branchPolicy
+	}
+	return "", ""
+}
+
+// branchConcurrencyPolicy validates PIPELINE_BRANCH_CONCURRENCY, an unknown policy disables it
+func branchConcurrencyPolicy(policy string) string {
+	switch policy {
+	case "", pipeline.ConcurrencyQueue, pipeline.ConcurrencyCancelRunning:
+		return policy
+	}
+	logger.Warn(fmt.Sprintf("Invalid PIPELINE_BRANCH_CONCURRENCY %q (queue or cancel-running), branch pipelines are not deduplicated", policy))
+	return ""
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+)
+
+// acquireAsync acquires the group in a goroutine and returns the channel receiving the result
+func acquireAsync(c *concurrencyGroups, ctx context.Context, policy string, pipelineID int, cancel func()) chan error {
+	result := make(chan error, 1)
+	go func() { result <- c.acquire(ctx, "1/deploy", policy, pipelineID, cancel, nil) }()
+	return result
+}
+
+func TestConcurrencyQueue(t *testing.T) {
+	c := newConcurrencyGroups()
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	defer cancelFirst()
+
+	if err := c.acquire(firstCtx, "1/deploy", pipeline.ConcurrencyQueue, 1, cancelFirst, nil); err != nil {
+		t.Fatalf("Expected first pipeline to take the group, got %v", err)
+	}
+
+	second := acquireAsync(c, context.Background(), pipeline.ConcurrencyQueue, 2, func() {})
+	select {
+	case err := <-second:
+		t.Fatalf("Expected second pipeline to wait, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.release(1)
+	select {
+	case err := <-second:
+		if err != nil {
+			t.Fatalf("Expected second pipeline to run once the first is over, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected second pipeline to take the released group")
+	}
+	if firstCtx.Err() != nil {
+		t.Error("Expected the first pipeline not to be cancelled")
+	}
+}
+
+func TestConcurrencyCancelRunning(t *testing.T) {
+	c := newConcurrencyGroups()
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	defer cancelFirst()
+
+	if err := c.acquire(firstCtx, "1/deploy", pipeline.ConcurrencyCancelRunning, 1, cancelFirst, nil); err != nil {
+		t.Fatalf("Expected first pipeline to take the group, got %v", err)
+	}
+
+	second := acquireAsync(c, context.Background(), pipeline.ConcurrencyCancelRunning, 2, func() {})
+	select {
+	case <-firstCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expected the running pipeline to be cancelled")
+	}
+
+	// The new pipeline starts once the cancelled one released the group
+	c.release(1)
+	select {
+	case err := <-second:
+		if err != nil {
+			t.Fatalf("Expected newest pipeline to run, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected newest pipeline to take the group")
+	}
+}
+
+func TestConcurrencyRejectNew(t *testing.T) {
+	c := newConcurrencyGroups()
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	defer cancelFirst()
+
+	if err := c.acquire(firstCtx, "1/deploy", pipeline.ConcurrencyRejectNew, 1, cancelFirst, nil); err != nil {
+		t.Fatalf("Expected first pipeline to take the group, got %v", err)
+	}
+
+	err := c.acquire(context.Background(), "1/deploy", pipeline.ConcurrencyRejectNew, 2, func() {}, nil)
+	if !errors.Is(err, errConcurrencyRejected) {
+		t.Fatalf("Expected new pipeline to be rejected, got %v", err)
+	}
+	if firstCtx.Err() != nil {
+		t.Error("Expected the running pipeline to keep running")
+	}
+
+	// Other groups and other projects are independent
+	if err := c.acquire(context.Background(), "2/deploy", pipeline.ConcurrencyRejectNew, 3, func() {}, nil); err != nil {
+		t.Errorf("Expected another project to take its own group, got %v", err)
+	}
+
+	// Once released, the group is free again
+	c.release(1)
+	if err := c.acquire(context.Background(), "1/deploy", pipeline.ConcurrencyRejectNew, 2, func() {}, nil); err != nil {
+		t.Errorf("Expected released group to be free, got %v", err)
+	}
+}
+
+func TestConcurrencyWaiterCancelled(t *testing.T) {
+	c := newConcurrencyGroups()
+	if err := c.acquire(context.Background(), "1/deploy", pipeline.ConcurrencyQueue, 1, func() {}, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	second := acquireAsync(c, ctx, pipeline.ConcurrencyQueue, 2, cancel)
+	cancel()
+	if err := <-second; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected cancelled waiter to give up, got %v", err)
+	}
+
+	// The cancelled waiter never takes the group
+	c.release(1)
+	if err := c.acquire(context.Background(), "1/deploy", pipeline.ConcurrencyRejectNew, 3, func() {}, nil); err != nil {
+		t.Errorf("Expected group to be free, got %v", err)
+	}
+}
+
+func TestConcurrencyFor(t *testing.T) {
+	push := models.PipelineRunParams{Branch: "main"}
+	tag := models.PipelineRunParams{Branch: "main", Tag: "v1.0.0"}
+	fileGroup := pipeline.ConcurrencyConfig{Group: "deploy"}
+
+	tests := []struct {
+		name         string
+		config       pipeline.ConcurrencyConfig
+		params       models.PipelineRunParams
+		branchPolicy string
+		group        string
+		policy       string
+	}{
+		{"no group", pipeline.ConcurrencyConfig{}, push, "", "", ""},
+		{"file group defaults to queue", fileGroup, push, "", "deploy", pipeline.ConcurrencyQueue},
+		{"file group wins over branch", pipeline.ConcurrencyConfig{Group: "deploy", Policy: pipeline.ConcurrencyRejectNew}, push, pipeline.ConcurrencyCancelRunning, "deploy", pipeline.ConcurrencyRejectNew},
+		{"branch group", pipeline.ConcurrencyConfig{}, push, pipeline.ConcurrencyCancelRunning, "branch:main", pipeline.ConcurrencyCancelRunning},
+		{"tags are not grouped by branch", pipeline.ConcurrencyConfig{}, tag, pipeline.ConcurrencyQueue, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group, policy := concurrencyFor(tt.config, tt.params, tt.branchPolicy)
+			if group != tt.group || policy != tt.policy {
+				t.Errorf("Expected %q (%q), got %q (%q)", tt.group, tt.policy, group, policy)
+			}
+		})
+	}
+}
+
+func TestBranchConcurrencyPolicy(t *testing.T) {
+	for _, policy := range []string{"", pipeline.ConcurrencyQueue, pipeline.ConcurrencyCancelRunning} {
+		if got := branchConcurrencyPolicy(policy); got != policy {
+			t.Errorf("Expected %q to be kept, got %q", policy, got)
+		}
+	}
+	if got := branchConcurrencyPolicy("drop"); got != "" {
+		t.Errorf("Expected an unknown policy to be disabled, got %q", got)
+	}
+}
+
+func TestConcurrencyWaitingHandsSlotOver(t *testing.T) {
+	s := &Server{scheduler: newPipelineScheduler(1), concurrency: newConcurrencyGroups()}
+	if err := s.concurrency.acquire(context.Background(), "1/deploy", pipeline.ConcurrencyQueue, 1, func() {}, nil); err != nil {
+		t.Fatal(err)
+	}
+	hold := &runHold{scheduler: s.scheduler, pipelineID: 2}
+	if err := hold.acquireSlot(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The run waiting for its group gives its slot to a pipeline of another group
+	result := make(chan error, 1)
+	go func() {
+		result <- s.concurrency.acquire(context.Background(), "1/deploy", pipeline.ConcurrencyQueue, 2, func() {}, func() { hold.releaseSlot() })
+	}()
+	other, otherQueued := scheduleAsync(s.scheduler, context.Background())
+	waitQueued(t, otherQueued)
+	select {
+	case err := <-other:
+		if err != nil {
+			t.Fatalf("Expected the other pipeline to take the slot, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the waiting run to hand its slot over")
+	}
+
+	s.concurrency.release(1)
+	if err := <-result; err != nil {
+		t.Fatalf("Expected the run to take the group, got %v", err)
+	}
+}
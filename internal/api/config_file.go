@@ -0,0 +1,55 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// defaultConfigCandidates are tried, in order, when none of the configured CI config files exists
+// GitHub Actions workflows are not among them, a project runs one only when its config path names it
+var defaultConfigCandidates = []string{".gitlab-ci.yml", ".gitlab-ci.yaml", "pipeline.yml", "pipeline.yaml"}
+
+// findConfigFile returns the path of the CI config of a workspace
+// configured is a comma-separated list of paths or globs relative to the workspace, tried in order before the defaults
+// A glob matching several files takes the first one in lexical order
+// It returns the paths checked when no config file is found
+func findConfigFile(workspaceDir, configured string) (string, []string, error) {
+	var candidates []string
+	for _, candidate := range strings.Split(configured, ",") {
+		if candidate = strings.TrimSpace(candidate); candidate != "" {
+			candidates = append(candidates, candidate)
+		}
+	}
+	candidates = append(candidates, defaultConfigCandidates...)
+
+	seen := make(map[string]bool)
+	var checked []string
+	for _, candidate := range candidates {
+		if seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		checked = append(checked, candidate)
+
+		// A config outside of the workspace is never read
+		clean := filepath.Clean(candidate)
+		if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(workspaceDir, clean))
+		if err != nil {
+			return "", checked, fmt.Errorf("invalid CI config pattern %q: %w", candidate, err)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+				return match, checked, nil
+			}
+		}
+	}
+	return "", checked, fmt.Errorf("CI config file not found, checked: %s", strings.Join(checked, ", "))
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("stages: [build]\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing to find, every checked path is reported
+	_, checked, err := findConfigFile(dir, "ci/pipeline.yml")
+	if err == nil {
+		t.Fatal("Expected an error without config file")
+	}
+	for _, path := range append([]string{"ci/pipeline.yml"}, defaultConfigCandidates...) {
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("Expected %s in the error, got %v", path, err)
+		}
+	}
+	if len(checked) != 1+len(defaultConfigCandidates) {
+		t.Errorf("Expected %d checked paths, got %v", 1+len(defaultConfigCandidates), checked)
+	}
+
+	// The configured file is absent, a default name is found
+	write(".gitlab-ci.yaml")
+	if path, _, err := findConfigFile(dir, ".gitlab-ci.yml"); err != nil || path != filepath.Join(dir, ".gitlab-ci.yaml") {
+		t.Errorf("Expected the .yaml fallback, got %s (%v)", path, err)
+	}
+
+	// Configured paths and globs come first, in order
+	write("ci/b.yml")
+	write("ci/a.yml")
+	if path, _, err := findConfigFile(dir, "missing.yml, ci/*.yml"); err != nil || path != filepath.Join(dir, "ci", "a.yml") {
+		t.Errorf("Expected the first match of the glob, got %s (%v)", path, err)
+	}
+
+	// A GitHub Actions workflow runs only when the project names it
+	write(".github/workflows/ci.yml")
+	if path, _, err := findConfigFile(dir, "missing.yml"); err != nil || path != filepath.Join(dir, ".gitlab-ci.yaml") {
+		t.Errorf("Expected the workflow to be skipped by default, got %s (%v)", path, err)
+	}
+	if path, _, err := findConfigFile(dir, ".github/workflows/*.yml"); err != nil || path != filepath.Join(dir, ".github", "workflows", "ci.yml") {
+		t.Errorf("Expected the configured workflow, got %s (%v)", path, err)
+	}
+
+	// A directory or a path out of the workspace is not a config file
+	if path, _, err := findConfigFile(dir, "ci, ../outside.yml"); err != nil || path != filepath.Join(dir, ".gitlab-ci.yaml") {
+		t.Errorf("Expected the fallback, got %s (%v)", path, err)
+	}
+}
@@ -3,14 +3,19 @@ package api
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
+	"net/url"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/executor"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/git"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
 )
 
@@ -39,6 +44,109 @@ func parseIDFromPath(path string, segment int) (int, error) {
 	return strconv.Atoi(parts[segment])
 }
 
+// normalizeLabels lowercases and trims labels, dropping empty ones and duplicates
+func normalizeLabels(labels []string) []string {
+	var normalized []string
+	seen := make(map[string]bool)
+	for _, label := range labels {
+		label = strings.ToLower(strings.TrimSpace(label))
+		if label == "" || seen[label] {
+			continue
+		}
+		seen[label] = true
+		normalized = append(normalized, label)
+	}
+	return normalized
+}
+
+// labelsFromCommitMessage extracts labels declared in a "Labels: release, hotfix" trailer
+func labelsFromCommitMessage(message string) []string {
+	var labels []string
+	for _, line := range strings.Split(message, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if !found || !strings.EqualFold(strings.TrimSpace(key), "labels") {
+			continue
+		}
+		labels = append(labels, strings.Split(value, ",")...)
+	}
+	return normalizeLabels(labels)
+}
+
+// pipelineStatuses are the statuses a pipeline listing can be filtered on
+var pipelineStatuses = []string{"pending", "queued", "running", "waiting_for_approval", "success", "failed", "cancelled", "superseded"}
+
+// maxPipelinePageSize bounds the limit of a pipeline listing
+const maxPipelinePageSize = 100
+
+// pipelineFilterFromRequest builds a pipeline filter from the query string
+func pipelineFilterFromRequest(r *http.Request) (models.PipelineFilter, error) {
+	query := r.URL.Query()
+	filter := models.PipelineFilter{
+		Label:  strings.ToLower(strings.TrimSpace(query.Get("label"))),
+		Status: strings.ToLower(strings.TrimSpace(query.Get("status"))),
+		Branch: strings.TrimSpace(query.Get("branch")),
+	}
+	if filter.Status != "" && !slices.Contains(pipelineStatuses, filter.Status) {
+		return filter, fmt.Errorf("invalid status %q, expected one of %s", filter.Status, strings.Join(pipelineStatuses, ", "))
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPipelinePageSize {
+			return filter, fmt.Errorf("invalid limit %q, expected 1 to %d", value, maxPipelinePageSize)
+		}
+		filter.Limit = limit
+	}
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return filter, fmt.Errorf("invalid offset %q", value)
+		}
+		filter.Offset = offset
+	}
+	return filter, nil
+}
+
+// validHTTPURL reports whether raw is an absolute http(s) URL, "" disables the setting
+func validHTTPURL(raw string) bool {
+	if raw == "" {
+		return true
+	}
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// invalidProjectURL returns the first URL setting of a project that is not an http(s) URL, "" if all are valid
+// The Slack webhook URL carries its secret, it must be https
+func invalidProjectURL(project models.NewProject) string {
+	if project.SlackWebhookURL != "" && !strings.HasPrefix(project.SlackWebhookURL, "https://") {
+		return "slack_webhook_url"
+	}
+	urls := []struct{ field, value string }{
+		{"health_check_url", project.HealthCheckURL},
+		{"slack_webhook_url", project.SlackWebhookURL},
+		{"notify_webhook_url", project.NotifyWebhookURL},
+	}
+	for _, u := range urls {
+		if !validHTTPURL(u.value) {
+			return u.field
+		}
+	}
+	return ""
+}
+
+// composeProfilePattern matches the compose profile names a project may set, they end up in the shell of the deploy host
+var composeProfilePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// invalidComposeProfile returns the first compose profile of a project that is not a plain name, "" if they all are
+func invalidComposeProfile(profiles []string) string {
+	for _, profile := range profiles {
+		if !composeProfilePattern.MatchString(profile) {
+			return profile
+		}
+	}
+	return ""
+}
+
 // sanitizeProjectName sanitizes the project name for Docker Compose
 func sanitizeProjectName(name string) string {
 	name = strings.ToLower(name)
@@ -93,7 +201,7 @@ func (s *Server) listVariables(w http.ResponseWriter, r *http.Request, projectID
 	}
 
 	for i := range variables {
-		if variables[i].IsSecret {
+		if variables[i].IsSecret || variables[i].Masked {
 			variables[i].Value = "*****"
 		}
 	}
@@ -223,6 +331,31 @@ func (s *Server) createProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !validSecretScanMode(newProject.SecretScan) {
+		respondError(w, http.StatusBadRequest, "secret_scan must be empty, warn or block")
+		return
+	}
+
+	if err := validSchedule(newProject.ScheduleCron); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid schedule_cron: "+err.Error())
+		return
+	}
+
+	if field := invalidProjectURL(newProject); field != "" {
+		respondError(w, http.StatusBadRequest, field+" must be an http or https URL")
+		return
+	}
+
+	if profile := invalidComposeProfile(newProject.ComposeProfiles); profile != "" {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid compose profile %q: only letters, digits, '_', '.' and '-' are allowed", profile))
+		return
+	}
+
+	if first, second := stackNameCollision(newProject.DeploymentStacks); first != "" {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Deployment stacks %s and %s get the same name %s", first, second, stackName(first)))
+		return
+	}
+
 	userID, err := getUserIDFromContext(r)
 	if err != nil {
 		respondError(w, http.StatusUnauthorized, "Unauthorized")
@@ -230,6 +363,10 @@ func (s *Server) createProject(w http.ResponseWriter, r *http.Request) {
 	}
 	newProject.OwnerID = userID
 
+	if s.rejectNameCollision(w, newProject, 0) {
+		return
+	}
+
 	project, err := s.db.CreateProject(&newProject)
 	if err != nil {
 		logger.Error("Failed to create project: " + err.Error())
@@ -240,6 +377,36 @@ func (s *Server) createProject(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusCreated, project)
 }
 
+// rejectNameCollision refuses a project whose deployment name is already used by another project
+// It only applies with DEPLOY_PROJECT_NAME_STRATEGY=reject and returns true if a response was sent
+// An unknown strategy refuses every project rather than deploying under names that may collide
+func (s *Server) rejectNameCollision(w http.ResponseWriter, project models.NewProject, projectID int) bool {
+	if s.deploymentExecutor == nil {
+		return false
+	}
+	if err := s.deploymentExecutor.CheckNameStrategy(); err != nil {
+		logger.Error("Refusing to save project", "error", err)
+		respondError(w, http.StatusInternalServerError, "Invalid deployment name strategy, see DEPLOY_PROJECT_NAME_STRATEGY")
+		return true
+	}
+	if !s.deploymentExecutor.RejectsNameCollisions() {
+		return false
+	}
+
+	projects, err := s.db.GetAllProjects()
+	if err != nil {
+		logger.Error("Failed to check project name collisions: " + err.Error())
+		respondError(w, http.StatusInternalServerError, "Failed to check project name")
+		return true
+	}
+
+	if other := executor.FindProjectNameCollision(project.Name, project.RepoURL, projectID, projects); other != nil {
+		respondError(w, http.StatusConflict, fmt.Sprintf("Project name collides with project %d (%s) for deployments", other.ID, other.Name))
+		return true
+	}
+	return false
+}
+
 // getProject returns a project by ID
 func (s *Server) getProject(w http.ResponseWriter, r *http.Request, projectID int) {
 	if s.db == nil {
@@ -247,42 +414,56 @@ func (s *Server) getProject(w http.ResponseWriter, r *http.Request, projectID in
 		return
 	}
 
+	project, ok := s.authorizeProject(w, r, projectID)
+	if !ok {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, project)
+}
+
+// authorizeProject loads a project the user of the request owns or is a member of
+// It responds 401, 404 or 403 and returns false otherwise
+func (s *Server) authorizeProject(w http.ResponseWriter, r *http.Request, projectID int) (*models.Project, bool) {
 	userID, err := getUserIDFromContext(r)
 	if err != nil {
 		respondError(w, http.StatusUnauthorized, "Unauthorized")
-		return
+		return nil, false
 	}
 
 	project, err := s.db.GetProject(projectID)
 	if err != nil {
 		respondError(w, http.StatusNotFound, "Project not found")
-		return
+		return nil, false
 	}
 
-	// Check access permissions (Owner or Member)
-	if project.OwnerID != userID {
-		members, err := s.db.GetProjectMembers(projectID)
-		if err != nil {
-			logger.Error("Failed to check membership: " + err.Error())
-			respondError(w, http.StatusInternalServerError, "Failed to check permissions")
-			return
-		}
+	if !checkProjectAccess(w, project, userID, s.db.GetProjectMembers) {
+		return nil, false
+	}
+	return project, true
+}
 
-		isMember := false
-		for _, m := range members {
-			if m.UserID == userID {
-				isMember = true
-				break
-			}
-		}
+// checkProjectAccess lets the owner and the members of a project through, it responds 403 to anyone else
+func checkProjectAccess(w http.ResponseWriter, project *models.Project, userID int, members func(projectID int) ([]models.ProjectMember, error)) bool {
+	if project.OwnerID == userID {
+		return true
+	}
 
-		if !isMember {
-			respondError(w, http.StatusForbidden, "You do not have access to this project")
-			return
+	list, err := members(project.ID)
+	if err != nil {
+		logger.Error("Failed to check membership", "project_id", project.ID, "error", err)
+		respondError(w, http.StatusInternalServerError, "Failed to check permissions")
+		return false
+	}
+
+	for _, m := range list {
+		if m.UserID == userID {
+			return true
 		}
 	}
 
-	respondJSON(w, http.StatusOK, project)
+	respondError(w, http.StatusForbidden, "You do not have access to this project")
+	return false
 }
 
 // updateProject updates an existing project
@@ -320,6 +501,35 @@ func (s *Server) updateProject(w http.ResponseWriter, r *http.Request, projectID
 		return
 	}
 
+	if !validSecretScanMode(updateData.SecretScan) {
+		respondError(w, http.StatusBadRequest, "secret_scan must be empty, warn or block")
+		return
+	}
+
+	if err := validSchedule(updateData.ScheduleCron); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid schedule_cron: "+err.Error())
+		return
+	}
+
+	if field := invalidProjectURL(updateData); field != "" {
+		respondError(w, http.StatusBadRequest, field+" must be an http or https URL")
+		return
+	}
+
+	if profile := invalidComposeProfile(updateData.ComposeProfiles); profile != "" {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid compose profile %q: only letters, digits, '_', '.' and '-' are allowed", profile))
+		return
+	}
+
+	if first, second := stackNameCollision(updateData.DeploymentStacks); first != "" {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Deployment stacks %s and %s get the same name %s", first, second, stackName(first)))
+		return
+	}
+
+	if s.rejectNameCollision(w, updateData, projectID) {
+		return
+	}
+
 	project, err := s.db.UpdateProject(projectID, &updateData)
 	if err != nil {
 		logger.Error("Failed to update project: " + err.Error())
@@ -330,6 +540,53 @@ func (s *Server) updateProject(w http.ResponseWriter, r *http.Request, projectID
 	respondJSON(w, http.StatusOK, project)
 }
 
+// handleProjectPause handles POST /api/v1/projects/{projectId}/pause and /unpause
+// A paused project keeps acknowledging webhooks but does not create pipelines
+func (s *Server) handleProjectPause(w http.ResponseWriter, r *http.Request, paused bool) {
+	projectID, err := parseIDFromPath(r.URL.Path, 3)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if s.db == nil {
+		respondError(w, http.StatusServiceUnavailable, "Database not available")
+		return
+	}
+
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	project, err := s.db.GetProject(projectID)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	if project.OwnerID != userID {
+		respondError(w, http.StatusForbidden, "You are not the owner of this project")
+		return
+	}
+
+	if err := s.db.SetProjectPaused(projectID, paused); err != nil {
+		logger.Error("Failed to update project paused flag: " + err.Error())
+		respondError(w, http.StatusInternalServerError, "Failed to update project")
+		return
+	}
+
+	logger.Info("Project pause updated", "project_id", projectID, "paused", paused)
+	project.Paused = paused
+	respondJSON(w, http.StatusOK, project)
+}
+
 // deleteProject deletes a project
 func (s *Server) deleteProject(w http.ResponseWriter, r *http.Request, projectID int) {
 	if s.db == nil {
@@ -569,7 +826,13 @@ func (s *Server) listPipelines(w http.ResponseWriter, r *http.Request, projectID
 		return
 	}
 
-	pipelines, err := s.db.GetPipelinesByProject(projectID)
+	filter, err := pipelineFilterFromRequest(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	pipelines, err := s.db.GetPipelinesByProject(projectID, filter)
 	if err != nil {
 		logger.Error("Failed to get pipelines: " + err.Error())
 		respondError(w, http.StatusInternalServerError, "Failed to get pipelines")
@@ -593,19 +856,24 @@ func (s *Server) triggerPipeline(w http.ResponseWriter, r *http.Request, project
 		return
 	}
 
-	// Parse request body
-	var reqBody struct {
-		Branch string `json:"branch"`
+	if project.Paused {
+		respondError(w, http.StatusConflict, "Project is paused")
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
-		reqBody.Branch = "main" // Default branch
+
+	// Parse request body
+	reqBody, err := parseTriggerRequest(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	if reqBody.Branch == "" {
-		reqBody.Branch = "main"
+
+	if !checkInlineConfigOwner(w, r, project, reqBody) {
+		return
 	}
 
 	// Get latest commit hash
-	commitHash, err := git.GetRemoteHeadHash(project.RepoURL, reqBody.Branch, project.AccessToken)
+	commitHash, err := git.GetRemoteHeadHash(project.RepoURL, reqBody.Branch, project.AccessToken, s.cloneOptionsFor(project.CloneSSHKey, false, false))
 	if err != nil {
 		logger.Error("Failed to get latest commit hash: " + err.Error())
 		respondError(w, http.StatusInternalServerError, "Failed to get latest commit hash")
@@ -613,19 +881,209 @@ func (s *Server) triggerPipeline(w http.ResponseWriter, r *http.Request, project
 	}
 
 	// Create pipeline record
-	pipeline, err := s.db.CreatePipeline(projectID, reqBody.Branch, commitHash)
+	pipeline, err := s.db.CreatePipeline(projectID, reqBody.Branch, commitHash, normalizeLabels(reqBody.Labels))
 	if err != nil {
 		logger.Error("Failed to create pipeline: " + err.Error())
 		respondError(w, http.StatusInternalServerError, "Failed to create pipeline")
 		return
 	}
 
-	// Trigger pipeline execution asynchronously
-	go s.runPipelineFromManualTrigger(project, pipeline, reqBody.Branch)
+	// A tag keeps its kind, the run sets CI_COMMIT_TAG and follows the deploy_tags rules
+	if reqBody.Tag != "" {
+		if err := s.db.SetPipelineTag(pipeline.ID, reqBody.Tag); err != nil {
+			logger.Error("Failed to store pipeline tag", "pipeline_id", pipeline.ID, "error", err)
+			respondError(w, http.StatusInternalServerError, "Failed to create pipeline")
+			return
+		}
+		pipeline.Tag = reqBody.Tag
+	}
+
+	// The inline config is kept on the pipeline so that a resumed run uses it too
+	if reqBody.Config != "" {
+		if err := s.db.SetPipelineInlineConfig(pipeline.ID, reqBody.Config, reqBody.Deploy); err != nil {
+			logger.Error("Failed to store inline config", "pipeline_id", pipeline.ID, "error", err)
+			respondError(w, http.StatusInternalServerError, "Failed to create pipeline")
+			return
+		}
+		pipeline.InlineConfig = reqBody.Config
+		pipeline.InlineDeploy = reqBody.Deploy
+	}
+
+	// Queue pipeline execution, the scheduler runs it once a slot is free
+	s.submitManualPipeline(project, pipeline, reqBody.Branch)
 
 	respondJSON(w, http.StatusCreated, pipeline)
 }
 
+// triggerRequest is the body of a manual trigger
+type triggerRequest struct {
+	Branch string   `json:"branch"`
+	Labels []string `json:"labels"`
+	// Tag is set when Branch was given as refs/tags/<name>
+	Tag string `json:"-"`
+	// Config replaces the committed CI config of the repository for this pipeline
+	Config string `json:"config"`
+	// Deploy lets a pipeline run with an inline config deploy, it is skipped otherwise
+	Deploy bool `json:"deploy"`
+}
+
+// checkInlineConfigOwner reserves inline configs, arbitrary jobs on the runner, to the project owner
+// It responds 401 or 403 to anyone else
+func checkInlineConfigOwner(w http.ResponseWriter, r *http.Request, project *models.Project, reqBody triggerRequest) bool {
+	if reqBody.Config == "" {
+		return true
+	}
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, "Unauthorized")
+		return false
+	}
+	if project.OwnerID != userID {
+		respondError(w, http.StatusForbidden, "Only the project owner can trigger a pipeline with an inline config")
+		return false
+	}
+	return true
+}
+
+// parseTriggerRequest decodes a manual trigger, an empty or invalid body triggers the main branch
+// An inline config must be a valid pipeline config
+func parseTriggerRequest(r *http.Request) (triggerRequest, error) {
+	var reqBody triggerRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		reqBody = triggerRequest{}
+	}
+	// refs/heads/feature/x is recorded and cloned as feature/x, refs/tags/v1.0 as the tag v1.0
+	reqBody.Tag = tagFromRef(strings.TrimSpace(reqBody.Branch))
+	reqBody.Branch = git.NormalizeRef(reqBody.Branch)
+	if reqBody.Branch == "" {
+		reqBody.Branch = "main" // Default branch
+	}
+	if reqBody.Config != "" {
+		if err := validateInlineConfig(reqBody.Config); err != nil {
+			return reqBody, fmt.Errorf("invalid inline config: %w", err)
+		}
+	}
+	return reqBody, nil
+}
+
+// validateInlineConfig parses an inline CI config the way the runner will, GitLab or GitHub Actions
+func validateInlineConfig(config string) error {
+	parsed, err := pipeline.ParseConfig("", []byte(config))
+	if err != nil {
+		return err
+	}
+	return pipeline.CheckNotEmpty(parsed)
+}
+
+// handleCancelAll handles POST /api/v1/projects/{projectId}/cancel-all
+// It cancels every pipeline of the project that has not reached a terminal status
+func (s *Server) handleCancelAll(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseIDFromPath(r.URL.Path, 3)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if s.db == nil {
+		respondError(w, http.StatusServiceUnavailable, "Database not available")
+		return
+	}
+
+	// Only the owner and the members of the project cancel its pipelines
+	if _, ok := s.authorizeProject(w, r, projectID); !ok {
+		return
+	}
+
+	pipelineIDs, err := s.db.GetActivePipelineIDs(projectID)
+	if err != nil {
+		logger.Error("Failed to get active pipelines: " + err.Error())
+		respondError(w, http.StatusInternalServerError, "Failed to get active pipelines")
+		return
+	}
+
+	cancelled := s.cancelPipelines(pipelineIDs)
+	logger.Info("Cancelled project pipelines", "project_id", projectID, "count", len(cancelled))
+
+	respondJSON(w, http.StatusOK, map[string][]int{"cancelled": cancelled})
+}
+
+// handlePipelineCancel handles POST /api/v1/projects/{projectId}/pipelines/{pipelineId}/cancel
+// The running job is stopped, the remaining jobs are skipped and the pipeline never reaches the deployment
+func (s *Server) handlePipelineCancel(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseIDFromPath(r.URL.Path, 3)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	pipelineID, err := parseIDFromPath(r.URL.Path, 5)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid pipeline ID")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if s.db == nil {
+		respondError(w, http.StatusServiceUnavailable, "Database not available")
+		return
+	}
+
+	// Only the owner and the members of the project cancel its pipelines
+	if _, ok := s.authorizeProject(w, r, projectID); !ok {
+		return
+	}
+
+	pipeline, err := s.db.GetPipeline(pipelineID)
+	if err != nil || pipeline.ProjectID != projectID {
+		respondError(w, http.StatusNotFound, "Pipeline not found")
+		return
+	}
+
+	// Cancelling a cancelled pipeline again is a no-op
+	if pipeline.Status == "cancelled" {
+		respondJSON(w, http.StatusOK, map[string]string{"status": pipeline.Status})
+		return
+	}
+	if pipeline.FinishedAt != nil {
+		respondError(w, http.StatusConflict, "Pipeline already finished")
+		return
+	}
+
+	if len(s.cancelPipelines([]int{pipelineID})) == 0 {
+		respondError(w, http.StatusInternalServerError, "Failed to cancel pipeline")
+		return
+	}
+	logger.Info("Cancelled pipeline", "pipeline_id", pipelineID, "project_id", projectID)
+
+	respondJSON(w, http.StatusAccepted, map[string]string{"status": "cancelled"})
+}
+
+// cancelPipelines signals the given pipelines and returns the IDs that were cancelled
+// Pipelines not running in this process (e.g. left over by a restart) are marked as cancelled directly
+func (s *Server) cancelPipelines(pipelineIDs []int) []int {
+	cancelled := []int{}
+	for _, pipelineID := range pipelineIDs {
+		// A run still queued by the scheduler is withdrawn, it never started
+		if (s.scheduler.withdraw(pipelineID) || !s.runningPipelines.cancel(pipelineID)) && s.db != nil {
+			if err := s.db.UpdatePipelineStatus(pipelineID, "cancelled"); err != nil {
+				logger.Error("Failed to cancel pipeline", "pipeline_id", pipelineID, "error", err)
+				continue
+			}
+		}
+		cancelled = append(cancelled, pipelineID)
+	}
+	return cancelled
+}
+
 // getPipeline returns a specific pipeline
 func (s *Server) getPipeline(w http.ResponseWriter, r *http.Request, projectID, pipelineID int) {
 	if s.db == nil {
@@ -652,9 +1110,49 @@ func (s *Server) getPipeline(w http.ResponseWriter, r *http.Request, projectID,
 		return
 	}
 
+	jobs, err := s.db.GetJobsByPipeline(pipelineID)
+	if err != nil {
+		logger.Warn("Failed to load pipeline jobs", "pipeline_id", pipelineID, "error", err)
+	}
+	pipeline.Stages = stageTimings(jobs)
+	pipeline.Jobs = jobs
+
 	respondJSON(w, http.StatusOK, pipeline)
 }
 
+// stageTimings returns the timing of each stage of a pipeline, in the order the jobs were created
+// A stage starts with its first job and finishes with its last one, it has no finish while a job runs
+func stageTimings(jobs []models.Job) []models.StageTiming {
+	var stages []models.StageTiming
+	index := make(map[string]int)
+	running := make(map[string]bool)
+	for _, job := range jobs {
+		i, ok := index[job.Stage]
+		if !ok {
+			i = len(stages)
+			index[job.Stage] = i
+			stages = append(stages, models.StageTiming{Name: job.Stage})
+		}
+		stage := &stages[i]
+		if job.StartedAt != nil && (stage.StartedAt == nil || job.StartedAt.Before(*stage.StartedAt)) {
+			stage.StartedAt = job.StartedAt
+		}
+		if job.StartedAt != nil && job.FinishedAt == nil {
+			running[job.Stage] = true
+		}
+		if job.FinishedAt != nil && (stage.FinishedAt == nil || job.FinishedAt.After(*stage.FinishedAt)) {
+			stage.FinishedAt = job.FinishedAt
+		}
+	}
+	for i := range stages {
+		if running[stages[i].Name] {
+			stages[i].FinishedAt = nil
+		}
+		stages[i].Duration = models.DurationSeconds(stages[i].StartedAt, stages[i].FinishedAt)
+	}
+	return stages
+}
+
 // === Jobs Handlers ===
 
 // handleJobs handles /api/v1/projects/{projectId}/pipelines/{pipelineId}/jobs
@@ -807,41 +1305,111 @@ func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// getJobLogs returns logs for a specific job
-func (s *Server) getJobLogs(w http.ResponseWriter, r *http.Request, projectID, pipelineID, jobID int) {
+// handleLogStream handles /api/v1/projects/{projectId}/pipelines/{pipelineId}/jobs/{jobId}/logs/stream
+// The lines are sent as Server-Sent Events as the job writes them
+func (s *Server) handleLogStream(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseIDFromPath(r.URL.Path, 3)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	pipelineID, err := parseIDFromPath(r.URL.Path, 5)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid pipeline ID")
+		return
+	}
+
+	jobID, err := parseIDFromPath(r.URL.Path, 7)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid job ID")
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	job, ok := s.findJob(w, projectID, pipelineID, jobID)
+	if !ok {
+		return
+	}
+	s.streamJobLogsSSE(w, r, job)
+}
+
+// findJob loads a job of a pipeline of a project, responding with an error when any of them is missing
+func (s *Server) findJob(w http.ResponseWriter, projectID, pipelineID, jobID int) (*models.Job, bool) {
 	if s.db == nil {
 		respondError(w, http.StatusServiceUnavailable, "Database not available")
-		return
+		return nil, false
 	}
 
 	// Verify project exists
 	_, err := s.db.GetProject(projectID)
 	if err != nil {
 		respondError(w, http.StatusNotFound, "Project not found")
-		return
+		return nil, false
 	}
 
 	// Verify pipeline exists and belongs to project
 	pipeline, err := s.db.GetPipeline(pipelineID)
 	if err != nil || pipeline.ProjectID != projectID {
 		respondError(w, http.StatusNotFound, "Pipeline not found")
-		return
+		return nil, false
 	}
 
 	// Verify job exists and belongs to pipeline
 	job, err := s.db.GetJob(jobID)
 	if err != nil || job.PipelineID != pipelineID {
 		respondError(w, http.StatusNotFound, "Job not found")
+		return nil, false
+	}
+	return job, true
+}
+
+// getJobLogs returns logs for a specific job
+func (s *Server) getJobLogs(w http.ResponseWriter, r *http.Request, projectID, pipelineID, jobID int) {
+	if _, ok := s.findJob(w, projectID, pipelineID, jobID); !ok {
 		return
 	}
 
-	logs, err := s.db.GetLogsByJob(jobID)
+	// ?after=<lineNumber> resumes after the last line seen by the client
+	after, err := logCursorFromRequest(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid after cursor")
+		return
+	}
+
+	// ?follow=true keeps the response open and streams new lines until the job is over
+	if follow, _ := strconv.ParseBool(r.URL.Query().Get("follow")); follow {
+		s.streamJobLogs(w, r, jobID, after)
+		return
+	}
+
+	// ?limit=<n> returns a page of lines, the next one starts after X-Log-Cursor
+	limit, err := logLimitFromRequest(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit, expected 1 to %d", maxLogPageSize))
+		return
+	}
+
+	var logs []models.LogLine
+	switch {
+	case limit > 0:
+		logs, err = s.db.GetLogsPage(jobID, after, limit)
+	case after > 0:
+		logs, err = s.db.GetLogsAfter(jobID, after)
+	default:
+		logs, err = s.db.GetLogsByJob(jobID)
+	}
 	if err != nil {
 		logger.Error("Failed to get logs: " + err.Error())
 		respondError(w, http.StatusInternalServerError, "Failed to get logs")
 		return
 	}
 
+	w.Header().Set("X-Log-Cursor", strconv.Itoa(nextLogCursor(logs, after)))
 	respondJSON(w, http.StatusOK, logs)
 }
 
@@ -876,7 +1444,7 @@ func (s *Server) handleDeployment(w http.ResponseWriter, r *http.Request) {
 
 	deployment, err := s.db.GetDeploymentByPipeline(pipelineID)
 	if err != nil {
-		log.Printf("Failed to get deployment: %v", err)
+		logger.Error("Failed to get deployment", "error", err)
 		respondError(w, http.StatusInternalServerError, "Failed to get deployment")
 		return
 	}
@@ -886,6 +1454,14 @@ func (s *Server) handleDeployment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	stacks, err := s.db.GetStackDeployments(pipelineID)
+	if err != nil {
+		logger.Error("Failed to get stack deployments", "error", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get deployment")
+		return
+	}
+	deployment.Stacks = stacks
+
 	respondJSON(w, http.StatusOK, deployment)
 }
 
@@ -918,7 +1494,7 @@ func (s *Server) handleDeploymentLogs(w http.ResponseWriter, r *http.Request) {
 
 	logs, err := s.db.GetDeploymentLogs(pipelineID)
 	if err != nil {
-		log.Printf("Failed to get deployment logs: %v", err)
+		logger.Error("Failed to get deployment logs", "error", err)
 		respondError(w, http.StatusInternalServerError, "Failed to get deployment logs")
 		return
 	}
@@ -935,30 +1511,47 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
-// handleGitHubWebhook handles incoming GitHub push webhooks
-func (s *Server) handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
+// handleWebhook handles incoming GitHub and GitLab push webhooks
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Check GitHub event type
-	eventType := r.Header.Get("X-GitHub-Event")
-	if eventType != "push" {
-		logger.Info("Ignoring non-push event: " + eventType)
+	// Detect the provider (GitHub or GitLab) and check the event type
+	provider := webhookProvider(r)
+	if !isPushEvent(r, provider) {
+		logger.Info("Ignoring non-push event", "event", r.Header.Get("X-GitHub-Event")+r.Header.Get("X-Gitlab-Event"))
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]string{"message": "event ignored"})
 		return
 	}
 
-	// Parse the push event
-	var pushEvent models.PushEvent
-	if err := json.NewDecoder(r.Body).Decode(&pushEvent); err != nil {
+	// Parse the push event, the raw body is kept to verify its signature
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Error("Failed to read webhook payload: " + err.Error())
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	pushEvent, err := parsePushEvent(provider, body)
+	if err != nil {
 		logger.Error("Failed to parse webhook payload: " + err.Error())
 		http.Error(w, "Invalid payload", http.StatusBadRequest)
 		return
 	}
 
+	// Only the holder of the project secret may trigger its pipelines
+	var project *models.Project
+	if s.db != nil {
+		project, _ = s.findWebhookProject(pushEvent)
+		if project != nil && !verifyWebhook(r, provider, body, project.WebhookSecret, s.webhookStrict) {
+			logger.Warn("Rejecting webhook with an invalid or missing secret", "provider", provider, "project_id", project.ID)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
 	// Ignore branch deletions
 	if pushEvent.Deleted {
 		logger.Info("Ignoring branch deletion event")
@@ -967,15 +1560,30 @@ func (s *Server) handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Extract branch name from ref (refs/heads/main -> main)
-	branch := strings.TrimPrefix(pushEvent.Ref, "refs/heads/")
+	// Paused projects acknowledge the webhook without running anything
+	if project != nil && skipPausedProject(w, project) {
+		return
+	}
+
+	// Extract branch name from ref (refs/heads/main -> main), tags are cloned by name (refs/tags/v1.0 -> v1.0)
+	branch := git.NormalizeRef(pushEvent.Ref)
 	commitHash := pushEvent.After
 
-	logger.Info("Received push event for %s on branch %s (commit: %s)",
-		pushEvent.Repository.FullName, branch, commitHash[:8])
+	logger.Info("Received push event", "repository", pushEvent.Repository.FullName, "branch", branch, "commit", commitHash)
+
+	// Sync mode: block until the pipeline is over and return its final status
+	if timeout := webhookSyncTimeout(r); timeout > 0 {
+		runWebhookSync(w, func() (models.PipelineRunParams, bool) {
+			return s.webhookRunParams(pushEvent, branch, commitHash)
+		}, func(params models.PipelineRunParams) string {
+			s.runPipelineLogic(params)
+			return s.finalPipelineStatus(params.PipelineID)
+		}, timeout, branch, commitHash)
+		return
+	}
 
-	// Run pipeline asynchronously
-	go s.runPipelineFromWebhook(pushEvent, branch, commitHash)
+	// Queue the pipeline, the scheduler runs it once a slot is free
+	s.submitPipelineFromWebhook(pushEvent, branch, commitHash)
 
 	// Respond immediately
 	w.Header().Set("Content-Type", "application/json")
@@ -986,3 +1594,97 @@ func (s *Server) handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
 		"commit":  commitHash,
 	})
 }
+
+// Bounds of the wait of a sync webhook
+const (
+	defaultWebhookSyncTimeout = 10 * time.Minute
+	maxWebhookSyncTimeout     = 30 * time.Minute
+)
+
+// webhookSyncTimeout returns how long a webhook must wait for its pipeline
+// Sync mode is selected with ?wait=true or the X-Webhook-Mode: sync header, ?timeout= bounds the wait
+// It returns 0 for the default async mode
+func webhookSyncTimeout(r *http.Request) time.Duration {
+	sync := strings.EqualFold(r.Header.Get("X-Webhook-Mode"), "sync")
+	if wait, err := strconv.ParseBool(r.URL.Query().Get("wait")); err == nil {
+		sync = wait
+	}
+	if !sync {
+		return 0
+	}
+
+	timeout := defaultWebhookSyncTimeout
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			timeout = d
+		} else if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			timeout = time.Duration(seconds) * time.Second
+		}
+	}
+	if timeout > maxWebhookSyncTimeout {
+		timeout = maxWebhookSyncTimeout
+	}
+	return timeout
+}
+
+// runWebhookSync records the pipeline with prepare, runs it and answers once it is over (200) or the timeout expired (202)
+// The pipeline is recorded before the wait so that both answers tell its ID, it keeps running in the background after a timeout
+// A push matching no project answers 404
+func runWebhookSync(w http.ResponseWriter, prepare func() (models.PipelineRunParams, bool), run func(models.PipelineRunParams) string, timeout time.Duration, branch, commitHash string) {
+	params, ok := prepare()
+	if !ok {
+		respondError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+	pipelineID := params.PipelineID
+
+	done := make(chan string, 1)
+	go func() {
+		done <- run(params)
+	}()
+
+	select {
+	case status := <-done:
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"message":     "Pipeline finished",
+			"pipeline_id": pipelineID,
+			"status":      status,
+			"branch":      branch,
+			"commit":      commitHash,
+		})
+	case <-time.After(timeout):
+		respondJSON(w, http.StatusAccepted, map[string]interface{}{
+			"message":     "Pipeline still running after " + timeout.String(),
+			"pipeline_id": pipelineID,
+			"status":      "running",
+			"branch":      branch,
+			"commit":      commitHash,
+		})
+	}
+}
+
+// finalPipelineStatus returns the stored status of a finished pipeline
+func (s *Server) finalPipelineStatus(pipelineID int) string {
+	if s.db == nil || pipelineID <= 0 {
+		return "unknown"
+	}
+	pipeline, err := s.db.GetPipeline(pipelineID)
+	if err != nil {
+		return "unknown"
+	}
+	return pipeline.Status
+}
+
+// skipPausedProject acknowledges a webhook for a paused project
+// It returns true if the webhook has been answered and must not trigger a pipeline
+func skipPausedProject(w http.ResponseWriter, project *models.Project) bool {
+	if !project.Paused {
+		return false
+	}
+
+	logger.Info("Project paused, skipping pipeline creation", "project_id", project.ID, "project", project.Name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "project paused, pipeline skipped"})
+	return true
+}
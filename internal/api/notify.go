@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/notify"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+)
+
+// projectNotifiers returns the notifiers configured on a project
+func projectNotifiers(project *models.Project) []notify.Notifier {
+	var notifiers []notify.Notifier
+	if project.SlackWebhookURL != "" {
+		notifiers = append(notifiers, notify.SlackNotifier{URL: project.SlackWebhookURL})
+	}
+	if project.NotifyWebhookURL != "" {
+		notifiers = append(notifiers, notify.WebhookNotifier{URL: project.NotifyWebhookURL})
+	}
+	return notifiers
+}
+
+// newNotifySummary builds the notification summary of a finished pipeline
+func newNotifySummary(project *models.Project, pipeline *models.Pipeline, jobs []models.Job) notify.Summary {
+	finishedAt := time.Now()
+	if pipeline.FinishedAt != nil {
+		finishedAt = *pipeline.FinishedAt
+	}
+	failedJobs := []string{}
+	for _, job := range jobs {
+		if job.Status == "failed" {
+			failedJobs = append(failedJobs, job.Name)
+		}
+	}
+	return notify.Summary{
+		ProjectID:  project.ID,
+		Project:    project.Name,
+		PipelineID: pipeline.ID,
+		Branch:     pipeline.Branch,
+		Commit:     pipeline.CommitHash,
+		Status:     pipeline.Status,
+		Duration:   finishedAt.Sub(pipeline.CreatedAt).Round(time.Second).Seconds(),
+		FailedJobs: failedJobs,
+	}
+}
+
+// notifyTimeout bounds the notifications of a pipeline, retries included
+const notifyTimeout = time.Minute
+
+// notifyPipeline sends the result of a finished pipeline to the notifiers of its project
+// Notifications are sent in the background, a failure is only logged
+func (s *Server) notifyPipeline(params models.PipelineRunParams, project *models.Project) {
+	if s.db == nil || params.PipelineID <= 0 || project == nil {
+		return
+	}
+	notifiers := projectNotifiers(project)
+	if len(notifiers) == 0 {
+		return
+	}
+
+	pipeline, err := s.db.GetPipeline(params.PipelineID)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to load pipeline %d for notifications: %v", params.PipelineID, err))
+		return
+	}
+	jobs, err := s.db.GetJobsByPipeline(params.PipelineID)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Failed to load jobs of pipeline %d for notifications: %v", params.PipelineID, err))
+	}
+	summary := newNotifySummary(project, pipeline, jobs)
+
+	for _, notifier := range notifiers {
+		go func(notifier notify.Notifier) {
+			ctx, cancel := context.WithTimeout(s.ctx, notifyTimeout)
+			defer cancel()
+			if err := notifier.Notify(ctx, summary); err != nil {
+				logger.Warn(fmt.Sprintf("Failed to send %s notification of pipeline %d: %v", notifier.Name(), params.PipelineID, err))
+				return
+			}
+			logger.Info(fmt.Sprintf("Pipeline %d %s notification sent", params.PipelineID, notifier.Name()))
+		}(notifier)
+	}
+}
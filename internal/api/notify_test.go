@@ -0,0 +1,42 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+func TestProjectNotifiers(t *testing.T) {
+	if notifiers := projectNotifiers(&models.Project{}); len(notifiers) != 0 {
+		t.Errorf("Expected no notifier, got %d", len(notifiers))
+	}
+	project := &models.Project{SlackWebhookURL: "https://hooks.slack.com/services/T/B/X", NotifyWebhookURL: "https://example.com/hook"}
+	notifiers := projectNotifiers(project)
+	if len(notifiers) != 2 || notifiers[0].Name() != "slack" || notifiers[1].Name() != "webhook" {
+		t.Errorf("Expected slack and webhook notifiers, got %v", notifiers)
+	}
+}
+
+func TestNewNotifySummary(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	finishedAt := createdAt.Add(95 * time.Second)
+	project := &models.Project{ID: 3, Name: "my-app"}
+	pipeline := &models.Pipeline{ID: 7, Status: "failed", Branch: "main", CommitHash: "abc", CreatedAt: createdAt, FinishedAt: &finishedAt}
+	jobs := []models.Job{
+		{Name: "build", Status: "success"},
+		{Name: "test", Status: "failed"},
+		{Name: "deploy", Status: "skipped"},
+	}
+
+	summary := newNotifySummary(project, pipeline, jobs)
+	if summary.Project != "my-app" || summary.PipelineID != 7 || summary.Status != "failed" || summary.Commit != "abc" {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+	if summary.Duration != 95 {
+		t.Errorf("Expected a 95s duration, got %v", summary.Duration)
+	}
+	if len(summary.FailedJobs) != 1 || summary.FailedJobs[0] != "test" {
+		t.Errorf("Expected test as the only failed job, got %v", summary.FailedJobs)
+	}
+}
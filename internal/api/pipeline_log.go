@@ -0,0 +1,116 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+)
+
+// jobLog pairs a job with its stored log lines
+type jobLog struct {
+	job   models.Job
+	lines []models.LogLine
+}
+
+// writePipelineLog writes the consolidated log of a pipeline: checkout, then the jobs
+// in the order they ran, then the deployment, each under a section header
+func writePipelineLog(w io.Writer, pipeline *models.Pipeline, jobs []jobLog, deploymentLogs []models.DeploymentLog) {
+	fmt.Fprintf(w, "Pipeline #%d - %s\n", pipeline.ID, pipeline.Status)
+
+	fmt.Fprintf(w, "\n=== Clone ===\n")
+	fmt.Fprintf(w, "Branch: %s\nCommit: %s\n", pipeline.Branch, pipeline.CommitHash)
+	if pipeline.CloneLog != "" {
+		fmt.Fprintln(w, strings.TrimRight(pipeline.CloneLog, "\n"))
+	}
+
+	// Jobs that ran are ordered by start time, the others (skipped, cancelled) come last
+	sort.SliceStable(jobs, func(i, j int) bool {
+		a, b := jobs[i].job.StartedAt, jobs[j].job.StartedAt
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return a.Before(*b)
+	})
+	for _, jl := range jobs {
+		fmt.Fprintf(w, "\n=== Job %s (stage %s, %s) ===\n", jl.job.Name, jl.job.Stage, jl.job.Status)
+		for _, line := range jl.lines {
+			if line.Stream == models.LogStreamStderr {
+				fmt.Fprintf(w, "[stderr] %s\n", line.Content)
+			} else {
+				fmt.Fprintln(w, line.Content)
+			}
+		}
+	}
+
+	if len(deploymentLogs) > 0 {
+		fmt.Fprintf(w, "\n=== Deployment ===\n")
+		for _, line := range deploymentLogs {
+			fmt.Fprintln(w, strings.TrimRight(line.Content, "\n"))
+		}
+	}
+}
+
+// handlePipelineLog returns the consolidated log of a pipeline as plain text
+func (s *Server) handlePipelineLog(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseIDFromPath(r.URL.Path, 3)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	pipelineID, err := parseIDFromPath(r.URL.Path, 5)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid pipeline ID")
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if s.db == nil {
+		respondError(w, http.StatusServiceUnavailable, "Database not available")
+		return
+	}
+
+	// Verify pipeline exists and belongs to project
+	pipeline, err := s.db.GetPipeline(pipelineID)
+	if err != nil || pipeline.ProjectID != projectID {
+		respondError(w, http.StatusNotFound, "Pipeline not found")
+		return
+	}
+
+	jobs, err := s.db.GetJobsByPipeline(pipelineID)
+	if err != nil {
+		logger.Error("Failed to get jobs: " + err.Error())
+		respondError(w, http.StatusInternalServerError, "Failed to get jobs")
+		return
+	}
+
+	jobLogs := make([]jobLog, 0, len(jobs))
+	for _, job := range jobs {
+		lines, err := s.db.GetLogsByJob(job.ID)
+		if err != nil {
+			logger.Error("Failed to get logs: " + err.Error())
+			respondError(w, http.StatusInternalServerError, "Failed to get logs")
+			return
+		}
+		jobLogs = append(jobLogs, jobLog{job: job, lines: lines})
+	}
+
+	deploymentLogs, err := s.db.GetDeploymentLogs(pipelineID)
+	if err != nil {
+		logger.Error("Failed to get deployment logs: " + err.Error())
+		respondError(w, http.StatusInternalServerError, "Failed to get deployment logs")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writePipelineLog(w, pipeline, jobLogs, deploymentLogs)
+}
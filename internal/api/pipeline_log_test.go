@@ -0,0 +1,61 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+func TestWritePipelineLogSectionsInOrder(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	later := start.Add(time.Minute)
+
+	pipeline := &models.Pipeline{ID: 7, Status: "success", Branch: "main", CommitHash: "abc1234", CloneLog: "Cloning into '/tmp/ws'...\n"}
+	jobs := []jobLog{
+		// Returned by id, but the test job started after the build job
+		{job: models.Job{Name: "test", Stage: "test", Status: "success", StartedAt: &later},
+			lines: []models.LogLine{{Content: "PASS"}}},
+		{job: models.Job{Name: "lint", Stage: "test", Status: "skipped"}},
+		{job: models.Job{Name: "build", Stage: "build", Status: "success", StartedAt: &start},
+			lines: []models.LogLine{{Content: "go build ./..."}, {Stream: models.LogStreamStderr, Content: "warning"}}},
+	}
+	deploymentLogs := []models.DeploymentLog{{Content: "Deployment successful"}}
+
+	var out strings.Builder
+	writePipelineLog(&out, pipeline, jobs, deploymentLogs)
+	log := out.String()
+
+	sections := []string{
+		"Pipeline #7 - success",
+		"=== Clone ===",
+		"Commit: abc1234",
+		"Cloning into '/tmp/ws'...",
+		"=== Job build (stage build, success) ===",
+		"go build ./...",
+		"[stderr] warning",
+		"=== Job test (stage test, success) ===",
+		"PASS",
+		"=== Job lint (stage test, skipped) ===",
+		"=== Deployment ===",
+		"Deployment successful",
+	}
+	position := 0
+	for _, section := range sections {
+		i := strings.Index(log[position:], section)
+		if i < 0 {
+			t.Fatalf("Expected %q after position %d in log:\n%s", section, position, log)
+		}
+		position += i + len(section)
+	}
+}
+
+func TestWritePipelineLogWithoutDeployment(t *testing.T) {
+	var out strings.Builder
+	writePipelineLog(&out, &models.Pipeline{ID: 1, Status: "failed"}, nil, nil)
+
+	if strings.Contains(out.String(), "=== Deployment ===") {
+		t.Errorf("Expected no deployment section, got:\n%s", out.String())
+	}
+}
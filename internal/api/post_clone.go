@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/tracing"
+)
+
+// postCloneJobName is the job recording the output of the post-clone hook in the pipeline
+const postCloneJobName = "post-clone"
+
+// runPostCloneHook runs a post-clone command in the workspace and returns its combined output
+// The command runs on the runner host, so only the commands of the allowlist may run
+func runPostCloneHook(ctx context.Context, command, workspaceDir string, allowed []string) (string, error) {
+	if !slices.Contains(allowed, command) {
+		return "", fmt.Errorf("post-clone command %q is not allowed, add it to POST_CLONE_COMMANDS", command)
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = workspaceDir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("post-clone command failed: %w", err)
+	}
+	return string(output), nil
+}
+
+// runPostClone runs the post-clone hook of the project, if any, and records its output
+func (s *Server) runPostClone(ctx context.Context, params models.PipelineRunParams, project *models.Project, workspaceDir string) error {
+	if project == nil || project.PostCloneCommand == "" {
+		return nil
+	}
+
+	logger.Info(fmt.Sprintf("Running post-clone command: %s", project.PostCloneCommand))
+
+	ctx, span := tracing.Tracer().Start(ctx, "post_clone")
+	output, err := runPostCloneHook(ctx, project.PostCloneCommand, workspaceDir, s.postCloneCommands)
+	tracing.End(span, err)
+
+	s.recordPostClone(params.PipelineID, project.PostCloneCommand, output, err)
+	if err != nil {
+		logger.Error("Post-clone hook failed: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// postCloneLog returns the log lines, status and exit code of the post-clone job
+func postCloneLog(command, output string, hookErr error) ([]models.LogLine, string, int) {
+	lines := []models.LogLine{{Content: "$ " + command}}
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		if line != "" {
+			lines = append(lines, models.LogLine{Content: line})
+		}
+	}
+	if hookErr == nil {
+		return lines, "success", 0
+	}
+
+	exitCode := 1
+	var exitErr *exec.ExitError
+	if errors.As(hookErr, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	}
+	lines = append(lines, models.LogLine{Content: hookErr.Error(), Stream: models.LogStreamStderr})
+	return lines, "failed", exitCode
+}
+
+// recordPostClone stores the hook output as a job of the pipeline so that it shows in the pipeline log
+func (s *Server) recordPostClone(pipelineID int, command, output string, hookErr error) {
+	if s.db == nil || pipelineID <= 0 {
+		return
+	}
+
+	job, err := s.db.CreateJob(pipelineID, postCloneJobName, postCloneJobName, "")
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to create post-clone job: %v", err))
+		return
+	}
+
+	lines, status, exitCode := postCloneLog(command, output, hookErr)
+	if _, err := s.db.CreateLogBatch(job.ID, lines); err != nil {
+		logger.Error(fmt.Sprintf("Failed to store post-clone logs: %v", err))
+	}
+	s.db.UpdateJobStatus(job.ID, status, &exitCode)
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+func TestRunPostCloneHook(t *testing.T) {
+	dir := t.TempDir()
+	command := "echo decrypted > secret.txt && echo done"
+
+	output, err := runPostCloneHook(context.Background(), command, dir, []string{command})
+	if err != nil {
+		t.Fatalf("Expected hook to succeed, got %v", err)
+	}
+	if output != "done\n" {
+		t.Errorf("Expected hook output %q, got %q", "done\n", output)
+	}
+
+	// The hook runs in the workspace
+	content, err := os.ReadFile(filepath.Join(dir, "secret.txt"))
+	if err != nil {
+		t.Fatalf("Expected hook to write in the workspace: %v", err)
+	}
+	if string(content) != "decrypted\n" {
+		t.Errorf("Unexpected file content %q", content)
+	}
+}
+
+func TestRunPostCloneHookFailure(t *testing.T) {
+	command := "echo broken >&2; exit 3"
+
+	output, err := runPostCloneHook(context.Background(), command, t.TempDir(), []string{command})
+	if err == nil || err.Error() != "post-clone command failed: exit status 3" {
+		t.Fatalf("Expected the hook to fail with exit status 3, got %v", err)
+	}
+	if output != "broken\n" {
+		t.Errorf("Expected stderr of the hook %q, got %q", "broken\n", output)
+	}
+
+	// The job of the hook shows the command, its output and the error, with the exit code of the command
+	lines, status, exitCode := postCloneLog(command, output, err)
+	expected := []models.LogLine{
+		{Content: "$ " + command},
+		{Content: "broken"},
+		{Content: "post-clone command failed: exit status 3", Stream: models.LogStreamStderr},
+	}
+	if !reflect.DeepEqual(lines, expected) || status != "failed" || exitCode != 3 {
+		t.Errorf("Expected %+v failed with code 3, got %+v %s with code %d", expected, lines, status, exitCode)
+	}
+}
+
+func TestPostCloneLogSuccess(t *testing.T) {
+	lines, status, exitCode := postCloneLog("git lfs pull", "Downloading a.bin\n\nDownloading b.bin\n", nil)
+	expected := []models.LogLine{
+		{Content: "$ git lfs pull"},
+		{Content: "Downloading a.bin"},
+		{Content: "Downloading b.bin"},
+	}
+	if !reflect.DeepEqual(lines, expected) || status != "success" || exitCode != 0 {
+		t.Errorf("Expected %+v success with code 0, got %+v %s with code %d", expected, lines, status, exitCode)
+	}
+}
+
+func TestRunPostCloneHookNotAllowed(t *testing.T) {
+	dir := t.TempDir()
+
+	output, err := runPostCloneHook(context.Background(), "touch ran", dir, []string{"git lfs pull"})
+	expected := `post-clone command "touch ran" is not allowed, add it to POST_CLONE_COMMANDS`
+	if err == nil || err.Error() != expected {
+		t.Fatalf("Expected error %q, got %v", expected, err)
+	}
+	if output != "" {
+		t.Errorf("Expected no output, got %q", output)
+	}
+	if _, statErr := os.Stat(filepath.Join(dir, "ran")); statErr == nil {
+		t.Error("Expected a rejected command not to run")
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"sync"
+)
+
+// pipelineRegistry keeps track of the pipelines running in this process
+// so that they can be cancelled from an API call
+type pipelineRegistry struct {
+	mu         sync.Mutex
+	running    map[int]context.CancelFunc
+	superseded map[int]bool // pipelines cancelled by a newer pipeline
+}
+
+func newPipelineRegistry() *pipelineRegistry {
+	return &pipelineRegistry{
+		running:    make(map[int]context.CancelFunc),
+		superseded: make(map[int]bool),
+	}
+}
+
+// register creates the cancellable context of a pipeline run
+// The returned function must be called once the run is over
+func (r *pipelineRegistry) register(pipelineID int) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if pipelineID <= 0 {
+		return ctx, cancel
+	}
+
+	r.mu.Lock()
+	r.running[pipelineID] = cancel
+	r.mu.Unlock()
+
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.running, pipelineID)
+		delete(r.superseded, pipelineID)
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel signals the context of a running pipeline
+// It returns false if the pipeline is not running in this process
+// Cancelling the same pipeline twice is harmless
+func (r *pipelineRegistry) cancel(pipelineID int) bool {
+	r.mu.Lock()
+	cancel, ok := r.running[pipelineID]
+	r.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// supersede cancels a running pipeline on behalf of a newer pipeline
+// The run then ends with the superseded status rather than cancelled
+func (r *pipelineRegistry) supersede(pipelineID int) bool {
+	r.mu.Lock()
+	cancel, ok := r.running[pipelineID]
+	if ok {
+		r.superseded[pipelineID] = true
+	}
+	r.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// isSuperseded reports whether a running pipeline was cancelled by a newer pipeline
+func (r *pipelineRegistry) isSuperseded(pipelineID int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.superseded[pipelineID]
+}
@@ -0,0 +1,58 @@
+package api
+
+import "testing"
+
+func TestPipelineRegistry(t *testing.T) {
+	r := newPipelineRegistry()
+
+	ctx, done := r.register(7)
+	if !r.cancel(7) {
+		t.Fatal("Expected running pipeline to be cancellable")
+	}
+	if ctx.Err() == nil {
+		t.Error("Expected context to be cancelled")
+	}
+
+	done()
+	if r.cancel(7) {
+		t.Error("Expected finished pipeline to be unregistered")
+	}
+
+	// Pipelines without an ID are not tracked
+	_, doneAnonymous := r.register(0)
+	defer doneAnonymous()
+	if r.cancel(0) {
+		t.Error("Expected pipeline without ID not to be registered")
+	}
+}
+
+func TestPipelineRegistrySupersede(t *testing.T) {
+	r := newPipelineRegistry()
+
+	ctx, done := r.register(7)
+	if r.isSuperseded(7) {
+		t.Error("Expected new pipeline not to be superseded")
+	}
+	if !r.supersede(7) {
+		t.Fatal("Expected running pipeline to be superseded")
+	}
+	if ctx.Err() == nil {
+		t.Error("Expected context to be cancelled")
+	}
+	if !r.isSuperseded(7) {
+		t.Error("Expected pipeline to be marked as superseded")
+	}
+
+	done()
+	if r.isSuperseded(7) || r.supersede(7) {
+		t.Error("Expected finished pipeline to be forgotten")
+	}
+
+	// A plain cancel is not a supersede
+	_, done = r.register(8)
+	defer done()
+	r.cancel(8)
+	if r.isSuperseded(8) {
+		t.Error("Expected cancelled pipeline not to be superseded")
+	}
+}
@@ -1,92 +1,180 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/docker"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/executor"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/git"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/ssh"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// runPipelineLogic executes the CI/CD pipeline logic
+// runPipelineLogic executes the CI/CD pipeline logic, waiting for a scheduler slot first
 // This unifies logic from webhook and manual trigger
 func (s *Server) runPipelineLogic(params models.PipelineRunParams) {
+	s.runPipeline(params, false)
+}
+
+// submitPipeline hands a run over to the scheduler, which starts it once a slot is free
+// No goroutine is spent on the run while it is queued, cancelPipelines withdraws it from the queue
+func (s *Server) submitPipeline(params models.PipelineRunParams) {
+	s.scheduler.submit(params.PipelineID, func() {
+		logger.Info("Pipeline queued", "pipeline_id", params.PipelineID, "project_id", params.ProjectID)
+		s.setPipelineStatus(params.PipelineID, "queued")
+	}, func() {
+		s.runPipeline(params, true)
+	})
+}
+
+// runPipeline executes a run, admitted tells that the scheduler already gave the run its slot
+func (s *Server) runPipeline(params models.PipelineRunParams, admitted bool) {
 	// Fetch project details for SSH/Registry info
 	var project *models.Project
 	if s.db != nil {
 		project, _ = s.db.GetProject(params.ProjectID)
 	}
 
-	// Create a unique workspace directory
-	workspaceDir := filepath.Join("/tmp", "cicd-workspaces", fmt.Sprintf("%s-%s-%d", params.RepoName, params.CommitHash[:8], time.Now().Unix()))
-
-	logger.Info(fmt.Sprintf("Starting pipeline for %s", params.RepoName))
-
-	// Clone the repository
-	logger.Info(fmt.Sprintf("Cloning repository to %s", workspaceDir))
-
-	if err := git.Clone(params.RepoURL, params.Branch, workspaceDir, params.AccessToken, params.CommitHash); err != nil {
-		logger.Error("Failed to clone repository: " + err.Error())
-		if s.db != nil && params.PipelineID > 0 {
-			s.db.UpdatePipelineStatus(params.PipelineID, "failed")
+	// Register the run so that it can be cancelled through the API, queued or running
+	ctx, done := s.runningPipelines.register(params.PipelineID)
+	defer done()
+
+	// Every line logged for the run, its jobs included, carries the pipeline fields
+	log := logger.With("pipeline_id", params.PipelineID, "project_id", params.ProjectID, "branch", params.Branch, "commit", params.CommitHash)
+	ctx = logger.NewContext(ctx, log)
+
+	// Wait for a slot when the server already runs as many pipelines as allowed
+	hold := &runHold{scheduler: s.scheduler, pipelineID: params.PipelineID, slot: admitted}
+	ctx = withRunHold(ctx, hold)
+	queued := admitted
+	if !admitted {
+		err := hold.acquireSlot(ctx, func() {
+			queued = true
+			log.Info("Pipeline queued", "running", s.scheduler.stats().Running)
+			s.setPipelineStatus(params.PipelineID, "queued")
+		})
+		if err != nil {
+			log.Warn("Pipeline cancelled while queued")
+			s.setPipelineStatus(params.PipelineID, "cancelled")
+			return
 		}
-		return
 	}
-	defer git.Cleanup(workspaceDir)
+	defer hold.releaseSlot()
+	if queued {
+		s.setPipelineStatus(params.PipelineID, "running")
+	}
 
-	// Find and parse the CI config file
-	configPath := filepath.Join(workspaceDir, params.PipelineFilename)
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		logger.Warn(fmt.Sprintf("CI config file not found at %s", configPath))
-		if s.db != nil && params.PipelineID > 0 {
-			s.db.UpdatePipelineStatus(params.PipelineID, "failed")
+	// Hand the concurrency group over once the run, deployment included, is over
+	defer s.concurrency.release(params.PipelineID)
+
+	// Root span of the run, stages, jobs and deployment are recorded as children
+	ctx, span := tracing.Tracer().Start(ctx, "pipeline", trace.WithAttributes(
+		attribute.Int("pipeline.id", params.PipelineID),
+		attribute.Int("project.id", params.ProjectID),
+		attribute.String("repository", params.RepoName),
+		attribute.String("branch", params.Branch),
+		attribute.String("commit", params.CommitHash),
+	))
+	var pipelineErr error
+	defer func() { tracing.End(span, pipelineErr) }()
+
+	// Write the result summary once the final status is known
+	defer s.reportPipelineStatus(params)
+
+	// Notify the project once the final status is known
+	defer s.notifyPipeline(params, project)
+
+	// Remove the workspace on every exit path, a failed clone may have left a partial tree
+	var workspaceDir string
+	defer func() {
+		if workspaceDir != "" {
+			git.Cleanup(workspaceDir)
 		}
+	}()
+
+	// Run the jobs, once more if the infrastructure failed
+	var config *pipeline.PipelineConfig
+	pipelineSuccess, err := runWithInfraRetry(ctx, s.retryInfraFailures, func() (bool, error) {
+		if workspaceDir != "" {
+			// Start the retry from a fresh workspace and fresh job records
+			git.Cleanup(workspaceDir)
+			s.resetPipelineJobs(params.PipelineID)
+		}
+		var success bool
+		var err error
+		workspaceDir, config, success, err = s.runPipelineAttempt(ctx, params, project)
+		return success, err
+	})
+	pipelineErr = err
+
+	// A cancelled pipeline, or one rejected by its concurrency group, never proceeds to deployment
+	if ctx.Err() != nil || errors.Is(err, errConcurrencyRejected) {
+		pipelineErr = s.endCancelledPipeline(ctx, params, err)
 		return
 	}
 
-	logger.Info(fmt.Sprintf("Found CI config: %s", configPath))
-
-	// Parse the CI config
-	p := pipeline.NewParser(configPath)
-	config, err := p.Parse()
-	if err != nil {
-		logger.Error("Failed to parse CI config: " + err.Error())
-		if s.db != nil && params.PipelineID > 0 {
-			s.db.UpdatePipelineStatus(params.PipelineID, "failed")
+	// A successful pipeline without deployment file only deploys if the project requires it
+	var deployFileErr error
+	deployFileFound := true
+	if pipelineSuccess {
+		if len(params.DeploymentStacks) > 0 {
+			deployFileErr = checkStackFiles(workspaceDir, params.DeploymentStacks)
+		} else {
+			deployFileFound, deployFileErr = checkDeploymentFile(workspaceDir, params.DeploymentFilename, project != nil && project.RequireDeployment)
 		}
-		return
 	}
 
-	logger.Info(fmt.Sprintf("Config loaded with %d stages", len(config.Stages)))
+	// Scan the workspace for committed secrets right before deploying it
+	var secretScanErr error
+	if pipelineSuccess && deployFileErr == nil && deployFileFound && tagDeployAllowed(params.Tag, project) && !inlineDeploySkipped(params) {
+		secretScanErr = s.runSecretScan(ctx, params, project, workspaceDir)
+	}
 
-	// Pre-create jobs and deployment for visualization
-	if s.db != nil && params.PipelineID > 0 {
-		// Pre-create jobs
-		for _, stageName := range config.Stages {
-			for jobName, job := range config.Jobs {
-				if job.Stage == stageName {
-					if _, err := s.db.CreateJob(params.PipelineID, jobName, job.Stage, job.Image); err != nil {
-						logger.Error(fmt.Sprintf("Failed to pre-create job %s: %v", jobName, err))
-					}
-				}
-			}
-		}
-		// Pre-create deployment
-		if _, err := s.db.CreatePendingDeployment(params.PipelineID); err != nil {
-			logger.Error("Failed to pre-create deployment: " + err.Error())
+	// A deployment of the `approval:` config waits for someone to approve it
+	var approvalErr error
+	if pipelineSuccess && deployFileErr == nil && deployFileFound && secretScanErr == nil && tagDeployAllowed(params.Tag, project) && !inlineDeploySkipped(params) && config != nil && config.Approval.Deployment {
+		approvalErr = s.awaitApproval(ctx, params.PipelineID, approvalDeployment, time.Duration(config.Approval.Timeout)*time.Second)
+		if ctx.Err() != nil {
+			pipelineErr = s.endCancelledPipeline(ctx, params, approvalErr)
+			return
 		}
 	}
 
-	// Execute the pipeline jobs using delegated executor
-	pipelineSuccess := s.pipelineExecutor.Execute(config, workspaceDir, params.PipelineID, project)
-
-	// Deploy if successful
-	if pipelineSuccess {
-		logger.Info(fmt.Sprintf("Pipeline successful. Starting deployment using %s...", params.DeploymentFilename))
+	// Deploy if successful, tag pipelines only deploy when the project has a matching rule
+	// and inline config pipelines when the trigger asked for it
+	if pipelineSuccess && inlineDeploySkipped(params) {
+		s.skipDeployment(params, "Pipeline run with an inline config, deployment not requested")
+	} else if pipelineSuccess && !tagDeployAllowed(params.Tag, project) {
+		s.skipTagDeployment(params)
+	} else if pipelineSuccess && deployFileErr != nil {
+		s.failDeployment(params, deployFileErr.Error())
+		pipelineSuccess = false
+		pipelineErr = deployFileErr
+	} else if pipelineSuccess && secretScanErr != nil {
+		s.failDeployment(params, secretScanErr.Error())
+		pipelineSuccess = false
+		pipelineErr = secretScanErr
+	} else if pipelineSuccess && approvalErr != nil {
+		log.Warn("Deployment not approved", "error", approvalErr)
+		s.endDeployment(params, fmt.Sprintf("Deployment not approved: %v", approvalErr), "cancelled")
+		pipelineSuccess = false
+		pipelineErr = approvalErr
+	} else if pipelineSuccess && !deployFileFound {
+		s.skipDeployment(params, fmt.Sprintf("Deployment file %s not found, no deployment", params.DeploymentFilename))
+	} else if pipelineSuccess {
+		log.Info("Pipeline successful, starting deployment", "files", deploymentFiles(params))
 
 		var deploymentID int
 		if s.db != nil && params.PipelineID > 0 {
@@ -95,7 +183,7 @@ func (s *Server) runPipelineLogic(params models.PipelineRunParams) {
 				// Fallback if not found
 				deploy, err = s.db.CreateDeployment(params.PipelineID)
 				if err != nil {
-					logger.Error("Failed to create deployment record: " + err.Error())
+					log.Error("Failed to create deployment record", "error", err)
 				}
 			}
 
@@ -106,17 +194,20 @@ func (s *Server) runPipelineLogic(params models.PipelineRunParams) {
 		}
 
 		// Deploy to environment using delegated executor
-		_, err := s.deploymentExecutor.Execute(project, params, workspaceDir)
+		_, deploySpan := tracing.Tracer().Start(ctx, "deploy")
+		err := s.deploy(project, params, workspaceDir, true)
+		tracing.End(deploySpan, err)
+		s.recordDeploymentExitCode(deploymentID, err)
 
 		if err != nil {
-			logger.Error("Deployment failed: " + err.Error())
+			log.Error("Deployment failed", "error", err)
 
 			// Attempt Rollback
 			rollbackSuccess := false
 			if s.db != nil && project != nil {
 				lastPipeline, _ := s.db.GetLastSuccessfulPipeline(project.ID)
 				if lastPipeline != nil && lastPipeline.CommitHash != "" {
-					logger.Info(fmt.Sprintf("Attempting rollback to commit %s", lastPipeline.CommitHash))
+					log.Info("Attempting rollback", "rollback_commit", lastPipeline.CommitHash)
 
 					// Prepare rollback params
 					rollbackParams := params
@@ -124,26 +215,28 @@ func (s *Server) runPipelineLogic(params models.PipelineRunParams) {
 					// Note: We use the same config filenames as current project settings.
 
 					// Create unique workspace for rollback
-					rollbackDir := filepath.Join("/tmp", "cicd-workspaces", fmt.Sprintf("%s-rollback-%s-%d", params.RepoName, rollbackParams.CommitHash[:8], time.Now().Unix()))
-
-					logger.Info(fmt.Sprintf("Cloning rollback commit to %s", rollbackDir))
-					if cloneErr := git.Clone(rollbackParams.RepoURL, rollbackParams.Branch, rollbackDir, rollbackParams.AccessToken, rollbackParams.CommitHash); cloneErr == nil {
-						defer git.Cleanup(rollbackDir)
+					rollbackDir := s.workspacePath(fmt.Sprintf("%s-rollback-%s-%d", params.RepoName, rollbackParams.CommitHash[:8], time.Now().Unix()))
 
+					// Like the pipeline clone, cancelling the pipeline or CLONE_TIMEOUT_SECONDS aborts it
+					log.Info("Cloning rollback commit", "workspace", rollbackDir)
+					defer git.Cleanup(rollbackDir)
+					if cloneErr := git.CloneWithContext(ctx, rollbackParams.RepoURL, rollbackParams.Branch, rollbackDir, rollbackParams.AccessToken, rollbackParams.CommitHash, s.cloneOptionsFor(rollbackParams.SSHKey, rollbackParams.GitLFS, rollbackParams.Submodules)); cloneErr == nil {
 						// Log rollback start
 						s.db.CreateDeploymentLog(params.PipelineID, "=== ROLLBACK STARTED ===")
 
 						// Run deployment for old version using delegated executor
-						_, rbErr := s.deploymentExecutor.Execute(project, rollbackParams, rollbackDir)
+						_, rollbackSpan := tracing.Tracer().Start(ctx, "rollback", trace.WithAttributes(attribute.String("commit", rollbackParams.CommitHash)))
+						rbErr := s.deploy(project, rollbackParams, rollbackDir, false)
+						tracing.End(rollbackSpan, rbErr)
 
 						if rbErr == nil {
 							rollbackSuccess = true
-							logger.Info("Rollback successful")
+							log.Info("Rollback successful")
 						} else {
-							logger.Error("Rollback failed: " + rbErr.Error())
+							log.Error("Rollback failed", "error", rbErr)
 						}
 					} else {
-						logger.Error("Rollback clone failed: " + cloneErr.Error())
+						log.Error("Rollback clone failed", "error", cloneErr)
 					}
 				}
 			}
@@ -157,21 +250,25 @@ func (s *Server) runPipelineLogic(params models.PipelineRunParams) {
 				}
 			}
 		} else {
-			logger.Info("Deployment successful!")
+			log.Info("Deployment successful")
 			if s.db != nil && deploymentID > 0 {
 				s.db.UpdateDeploymentStatus(deploymentID, "success")
 			}
 		}
 	}
 
+	if !pipelineSuccess && pipelineErr == nil {
+		pipelineErr = fmt.Errorf("pipeline failed")
+	}
+
 	// Update final pipeline status
 	if s.db != nil && params.PipelineID > 0 {
 		if pipelineSuccess {
 			s.db.UpdatePipelineStatus(params.PipelineID, "success")
-			logger.Info(fmt.Sprintf("Pipeline %d completed successfully", params.PipelineID))
+			log.Info("Pipeline completed successfully")
 		} else {
 			s.db.UpdatePipelineStatus(params.PipelineID, "failed")
-			logger.Error(fmt.Sprintf("Pipeline %d failed", params.PipelineID))
+			log.Error("Pipeline failed")
 
 			// Mark pending deployment as failed if pipeline failed
 			deploy, err := s.db.GetDeploymentByPipeline(params.PipelineID)
@@ -182,27 +279,360 @@ func (s *Server) runPipelineLogic(params models.PipelineRunParams) {
 	}
 }
 
+// endCancelledPipeline records the end of a cancelled pipeline, or of one rejected by its concurrency group
+// It returns the error the run ended with
+func (s *Server) endCancelledPipeline(ctx context.Context, params models.PipelineRunParams, err error) error {
+	// A pipeline cancelled by a newer one of its concurrency group is superseded
+	status := "cancelled"
+	if s.runningPipelines.isSuperseded(params.PipelineID) {
+		status = "superseded"
+	}
+	logger.FromContext(ctx).Warn("Pipeline "+status, "status", status)
+	if s.db != nil && params.PipelineID > 0 {
+		s.db.UpdatePipelineStatus(params.PipelineID, status)
+		if deploy, err := s.db.GetDeploymentByPipeline(params.PipelineID); err == nil && deploy != nil {
+			s.db.UpdateDeploymentStatus(deploy.ID, "cancelled")
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// tagFromRef returns the tag name of a refs/tags/ ref, empty for branches
+func tagFromRef(ref string) string {
+	if !strings.HasPrefix(ref, "refs/tags/") {
+		return ""
+	}
+	return strings.TrimPrefix(ref, "refs/tags/")
+}
+
+// inlineDeploySkipped reports whether a pipeline run with an inline config skips its deployment
+// The config is not the committed one, so it only deploys when the trigger explicitly asked for it
+func inlineDeploySkipped(params models.PipelineRunParams) bool {
+	return params.InlineConfig != "" && !params.InlineDeploy
+}
+
+// tagDeployAllowed reports whether a pipeline may deploy
+// Branch pipelines always may, tag pipelines need a deploy_tags pattern of the project matching the tag
+func tagDeployAllowed(tag string, project *models.Project) bool {
+	if tag == "" {
+		return true
+	}
+	if project == nil {
+		return false
+	}
+	for _, pattern := range project.DeployTags {
+		if matched, _ := path.Match(pattern, tag); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// skipTagDeployment records that the deployment of a tag pipeline was skipped
+func (s *Server) skipTagDeployment(params models.PipelineRunParams) {
+	s.skipDeployment(params, fmt.Sprintf("Tag pipeline, deployment not configured: no deploy_tags rule matches %s", params.Tag))
+}
+
+// deploymentExitCode returns the exit code of the compose command from the outcome of a deployment
+// It returns false when the deployment failed before the compose command (registry login, build, ...)
+// or without a command exiting (health check, ...)
+func deploymentExitCode(err error) (int, bool) {
+	if err == nil {
+		return 0, true
+	}
+	var cmdErr *executor.DeployCommandError
+	if !errors.As(err, &cmdErr) {
+		return 0, false
+	}
+	if exitCode, ok := docker.ExitCode(cmdErr.Err); ok {
+		return exitCode, true
+	}
+	// Remote deployments run the compose command over SSH
+	return ssh.ExitCode(cmdErr.Err)
+}
+
+// recordDeploymentExitCode stores the exit code of the compose command on the deployment, if known
+func (s *Server) recordDeploymentExitCode(deploymentID int, err error) {
+	exitCode, ok := deploymentExitCode(err)
+	if !ok || s.db == nil || deploymentID <= 0 {
+		return
+	}
+	if err := s.db.SetDeploymentExitCode(deploymentID, exitCode); err != nil {
+		logger.Error("Failed to record deployment exit code", "deployment_id", deploymentID, "exit_code", exitCode, "error", err)
+	}
+}
+
+// checkDeploymentFile reports whether the deployment file exists in the workspace
+// A missing file is an error only when the project requires a deployment
+func checkDeploymentFile(workspaceDir, filename string, required bool) (bool, error) {
+	_, err := os.Stat(filepath.Join(workspaceDir, filename))
+	if err == nil {
+		return true, nil
+	}
+	if !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to check deployment file %s: %w", filename, err)
+	}
+	if required {
+		return false, fmt.Errorf("deployment file %s not found and the project requires a deployment", filename)
+	}
+	return false, nil
+}
+
+// skipDeployment records that the deployment of a successful pipeline was skipped
+func (s *Server) skipDeployment(params models.PipelineRunParams, message string) {
+	logger.Info(message)
+	s.endDeployment(params, message, "skipped")
+}
+
+// failDeployment records that the deployment failed before it started
+func (s *Server) failDeployment(params models.PipelineRunParams, message string) {
+	logger.Error(message)
+	s.endDeployment(params, message, "failed")
+}
+
+// endDeployment adds message to the deployment logs of the pipeline and sets its final status
+func (s *Server) endDeployment(params models.PipelineRunParams, message, status string) {
+	if s.db == nil || params.PipelineID <= 0 {
+		return
+	}
+	s.db.CreateDeploymentLog(params.PipelineID, message)
+	if deploy, err := s.db.GetDeploymentByPipeline(params.PipelineID); err == nil && deploy != nil {
+		s.db.UpdateDeploymentStatus(deploy.ID, status)
+	}
+}
+
+// recordCloneLog stores the output of the clone on the pipeline, with the error of a failed clone
+func (s *Server) recordCloneLog(ctx context.Context, pipelineID int, output string, cloneErr error) {
+	if s.db == nil || pipelineID <= 0 {
+		return
+	}
+	if cloneErr != nil {
+		output += fmt.Sprintf("Clone failed: %v\n", cloneErr)
+	}
+	if err := s.db.SetPipelineCloneLog(pipelineID, output); err != nil {
+		logger.FromContext(ctx).Error("Failed to record the clone log", "error", err)
+	}
+}
+
+// recordPipelineCommit reads the message and author of the commit from the clone and stores them on the pipeline
+// Webhook triggers already recorded them from their payload
+func (s *Server) recordPipelineCommit(ctx context.Context, params models.PipelineRunParams, workspaceDir string) {
+	if s.db == nil || params.PipelineID <= 0 || params.CommitMessage != "" {
+		return
+	}
+	log := logger.FromContext(ctx)
+	message, err := git.GetCommitMessage(workspaceDir, params.CommitHash)
+	if err != nil {
+		log.Warn("Failed to read the commit message", "error", err)
+		return
+	}
+	name, email, err := git.GetCommitAuthor(workspaceDir, params.CommitHash)
+	if err != nil {
+		log.Warn("Failed to read the commit author", "error", err)
+		return
+	}
+	if err := s.db.SetPipelineCommit(params.PipelineID, message, name, email); err != nil {
+		log.Error("Failed to record the pipeline commit", "error", err)
+	}
+}
+
+// runPipelineAttempt clones the repository, parses its CI config and runs the jobs
+// It returns the workspace to clean up and the config, nil if it was not parsed, along with the outcome of the jobs
+func (s *Server) runPipelineAttempt(ctx context.Context, params models.PipelineRunParams, project *models.Project) (string, *pipeline.PipelineConfig, bool, error) {
+	// Create a unique workspace directory
+	workspaceDir := s.workspacePath(fmt.Sprintf("%s-%s-%d", params.RepoName, params.CommitHash[:8], time.Now().Unix()))
+
+	log := logger.FromContext(ctx)
+	log.Info("Starting pipeline", "repo", params.RepoName)
+
+	// Fail fast rather than filling the disk halfway through the clone
+	if err := checkFreeSpace(s.workspaceDir, s.workspaceMinFree); err != nil {
+		log.Error("Not enough disk space to clone", "error", err)
+		return workspaceDir, nil, false, err
+	}
+
+	// Clone the repository
+	log.Info("Cloning repository", "workspace", workspaceDir)
+
+	// Cancelling the pipeline aborts the clone
+	cloneCtx, cloneSpan := tracing.Tracer().Start(ctx, "clone")
+	var cloneOutput strings.Builder
+	cloneOpts := s.cloneOptionsFor(params.SSHKey, params.GitLFS, params.Submodules)
+	cloneOpts.Output = &cloneOutput
+	cloneErr := git.CloneWithContext(cloneCtx, params.RepoURL, params.Branch, workspaceDir, params.AccessToken, params.CommitHash, cloneOpts)
+	tracing.End(cloneSpan, cloneErr)
+	s.recordCloneLog(ctx, params.PipelineID, cloneOutput.String(), cloneErr)
+	if err := cloneErr; err != nil {
+		log.Error("Failed to clone repository", "error", err)
+		// An oversized repository or a missing commit or ref fails the same way on every attempt
+		if errors.Is(err, git.ErrRepositoryTooLarge) || errors.Is(err, git.ErrCommitNotFound) || errors.Is(err, git.ErrRefNotFound) {
+			return workspaceDir, nil, false, err
+		}
+		return workspaceDir, nil, false, &executor.InfraError{Op: "clone", Err: err}
+	}
+
+	// Record who pushed what when the trigger did not tell
+	s.recordPipelineCommit(ctx, params, workspaceDir)
+
+	// Run the post-clone hook of the project before reading the config
+	if err := s.runPostClone(ctx, params, project, workspaceDir); err != nil {
+		return workspaceDir, nil, false, err
+	}
+
+	// Find and parse the CI config file, an inline config of a manual trigger replaces the committed one
+	var config *pipeline.PipelineConfig
+	var err error
+	if params.InlineConfig != "" {
+		log.Info("Using the inline config of the pipeline", "replaced", params.PipelineFilename)
+		config, err = pipeline.ParseConfig(params.PipelineFilename, []byte(params.InlineConfig))
+	} else {
+		configPath, checked, findErr := findConfigFile(workspaceDir, params.PipelineFilename)
+		if findErr != nil {
+			log.Warn("CI config file not found", "workspace", workspaceDir, "checked", strings.Join(checked, ", "))
+			return workspaceDir, nil, false, findErr
+		}
+		log.Info("Found CI config", "path", configPath)
+		config, err = pipeline.NewParser(configPath).Parse()
+	}
+	if err == nil {
+		err = pipeline.CheckNotEmpty(config)
+	}
+	if err != nil {
+		log.Error("Failed to parse CI config", "error", err)
+		return workspaceDir, nil, false, err
+	}
+
+	log.Info("Config loaded", "stages", len(config.Stages))
+
+	// Only one pipeline of a concurrency group runs at a time, a rejected run never gets job records
+	if group, policy := concurrencyFor(config.Concurrency, params, s.branchConcurrency); group != "" && params.PipelineID > 0 {
+		log.Info("Pipeline joins concurrency group", "group", group, "policy", policy)
+		cancel := func() { s.runningPipelines.supersede(params.PipelineID) }
+		key := concurrencyKey(params.ProjectID, group)
+		// A run waiting for its group hands its slot over, so that pipelines of other groups are not held up
+		hold := runHoldFrom(ctx)
+		handedOver := false
+		waiting := func() { handedOver = hold.releaseSlot() }
+		if err := s.concurrency.acquire(ctx, key, policy, params.PipelineID, cancel, waiting); err != nil {
+			log.Warn("Pipeline not run", "error", err)
+			return workspaceDir, config, false, err
+		}
+		if handedOver {
+			if err := hold.acquireSlot(ctx, func() { s.setPipelineStatus(params.PipelineID, "queued") }); err != nil {
+				return workspaceDir, config, false, err
+			}
+			s.setPipelineStatus(params.PipelineID, "running")
+		}
+		// An approval gate hands the group over and takes it back, see awaitApproval
+		if hold != nil {
+			hold.group, hold.policy, hold.cancel = key, policy, cancel
+		}
+	}
+
+	// Pre-create jobs and deployment for visualization
+	if s.db != nil && params.PipelineID > 0 {
+		// Pre-create jobs
+		for _, stageName := range config.Stages {
+			for _, jobName := range config.StageJobs(stageName) {
+				job := config.Jobs[jobName]
+				if _, err := s.db.CreateJob(params.PipelineID, jobName, job.Stage, job.Image); err != nil {
+					log.Error("Failed to pre-create job", "job_name", jobName, "stage", job.Stage, "error", err)
+				}
+			}
+		}
+		// Pre-create deployment, a retried run keeps the existing one
+		if deploy, _ := s.db.GetDeploymentByPipeline(params.PipelineID); deploy == nil {
+			if _, err := s.db.CreatePendingDeployment(params.PipelineID); err != nil {
+				log.Error("Failed to pre-create deployment", "error", err)
+			}
+		}
+	}
+
+	// Execute the pipeline jobs using delegated executor
+	success, err := s.pipelineExecutor.Execute(ctx, config, workspaceDir, params, project)
+	return workspaceDir, config, success, err
+}
+
+// cloneOptionsFor returns the clone options of the server with the SSH key, LFS and submodule settings of a project
+func (s *Server) cloneOptionsFor(sshKey string, lfs, submodules bool) git.CloneOptions {
+	opts := s.cloneOptions
+	opts.SSHKey = sshKey
+	opts.LFS = lfs
+	opts.Submodules = submodules
+	return opts
+}
+
+// runWithInfraRetry runs a pipeline attempt and, when retry is enabled, runs it
+// once more if it failed because of the infrastructure rather than a job script
+func runWithInfraRetry(ctx context.Context, retry bool, run func() (bool, error)) (bool, error) {
+	success, err := run()
+	if success || !retry || ctx.Err() != nil || !executor.IsInfraError(err) {
+		return success, err
+	}
+
+	logger.FromContext(ctx).Warn("Pipeline failed because of the infrastructure, retrying once", "error", err)
+	return run()
+}
+
+// resetPipelineJobs removes the job records of a pipeline before it is retried
+func (s *Server) resetPipelineJobs(pipelineID int) {
+	if s.db == nil || pipelineID <= 0 {
+		return
+	}
+	if err := s.db.DeleteJobsByPipeline(pipelineID); err != nil {
+		logger.Error("Failed to reset pipeline jobs", "pipeline_id", pipelineID, "error", err)
+	}
+}
+
 // === Higher level Wrappers ===
 
-// runPipelineFromWebhook adapts webhook data to the unified runner
-func (s *Server) runPipelineFromWebhook(pushEvent models.PushEvent, branch, commitHash string) {
+// setPipelineStatus records the status of a pipeline, when it has a record
+func (s *Server) setPipelineStatus(pipelineID int, status string) {
+	if s.db != nil && pipelineID > 0 {
+		s.db.UpdatePipelineStatus(pipelineID, status)
+	}
+}
+
+// submitPipelineFromWebhook queues the pipeline of a push event without waiting for it
+func (s *Server) submitPipelineFromWebhook(pushEvent models.PushEvent, branch, commitHash string) {
+	if params, ok := s.webhookRunParams(pushEvent, branch, commitHash); ok {
+		s.submitPipeline(params)
+	}
+}
+
+// webhookRunParams records the pipeline of a push event and returns its run parameters
+// It returns false when the repository matches no project
+func (s *Server) webhookRunParams(pushEvent models.PushEvent, branch, commitHash string) (models.PipelineRunParams, bool) {
 	// Find or create project in database
 	var projectID int
 	var accessToken string
+	var sshKey string
+	var gitLFS bool
+	var submodules bool
 	var pipelineFilename string
 	var deploymentFilename string
+	var deploymentStacks []string
+	repoURL := pushEvent.Repository.CloneURL
 
 	if s.db != nil {
-		project, err := s.db.FindProjectByUrl(pushEvent.Repository.CloneURL)
+		project, err := s.findWebhookProject(pushEvent)
 		if err != nil {
-			logger.Error(fmt.Sprintf("Project not found for repo %s: %v. Ignoring webhook.", pushEvent.Repository.CloneURL, err))
-			return
+			logger.Error("Project not found, ignoring webhook", "repo_url", pushEvent.Repository.CloneURL, "error", err)
+			return models.PipelineRunParams{}, false
 		}
 
 		projectID = project.ID
+		repoURL = project.RepoURL
 		accessToken = project.AccessToken
+		sshKey = project.CloneSSHKey
+		gitLFS = project.GitLFS
+		submodules = project.GitSubmodules
 		pipelineFilename = project.PipelineFilename
 		deploymentFilename = project.DeploymentFilename
+		deploymentStacks = project.DeploymentStacks
 	}
 
 	if pipelineFilename == "" {
@@ -212,40 +642,98 @@ func (s *Server) runPipelineFromWebhook(pushEvent models.PushEvent, branch, comm
 		deploymentFilename = "docker-compose.yml"
 	}
 
+	// The payload describes the head commit, the runner reads it from the clone otherwise
+	head := pushEvent.HeadCommit
+	if head.ID != commitHash {
+		head = models.Commit{}
+	}
+
 	// Create pipeline record
 	var pipelineID int
 	if s.db != nil && projectID > 0 {
-		pipeline, err := s.db.CreatePipeline(projectID, branch, commitHash)
+		labels := labelsFromCommitMessage(pushEvent.HeadCommit.Message)
+		pipeline, err := s.db.CreatePipeline(projectID, branch, commitHash, labels)
 		if err != nil {
-			logger.Error(fmt.Sprintf("Failed to create pipeline record: %v", err))
+			logger.Error("Failed to create pipeline record", "project_id", projectID, "branch", branch, "commit", commitHash, "error", err)
 		} else {
 			pipelineID = pipeline.ID
-			logger.Info(fmt.Sprintf("Pipeline created with ID: %d", pipelineID))
+			log := logger.With("pipeline_id", pipelineID, "project_id", projectID, "branch", branch, "commit", commitHash)
+			log.Info("Pipeline created")
 			s.db.UpdatePipelineStatus(pipelineID, "running")
+			if head.Message != "" {
+				if err := s.db.SetPipelineCommit(pipelineID, head.Message, head.Author.Name, head.Author.Email); err != nil {
+					log.Error("Failed to record the pipeline commit", "error", err)
+				}
+			}
+			// A resumed tag pipeline must still run as a tag
+			if tag := tagFromRef(pushEvent.Ref); tag != "" {
+				if err := s.db.SetPipelineTag(pipelineID, tag); err != nil {
+					log.Error("Failed to record the pipeline tag", "tag", tag, "error", err)
+				}
+			}
+			// A resumed run still only redeploys the services the push touched
+			if files := changedFilesFromPush(pushEvent); len(files) > 0 {
+				if err := s.db.SetPipelineChangedFiles(pipelineID, files); err != nil {
+					log.Error("Failed to record the pipeline changed files", "error", err)
+				}
+			}
 		}
 	}
 
 	params := models.PipelineRunParams{
-		RepoURL:            pushEvent.Repository.CloneURL,
+		RepoURL:            repoURL,
 		RepoName:           pushEvent.Repository.Name,
 		Branch:             branch,
 		CommitHash:         commitHash,
+		CommitMessage:      head.Message,
+		CommitAuthorName:   head.Author.Name,
+		CommitAuthorEmail:  head.Author.Email,
 		AccessToken:        accessToken,
+		SSHKey:             sshKey,
+		GitLFS:             gitLFS,
+		Submodules:         submodules,
 		PipelineFilename:   pipelineFilename,
 		DeploymentFilename: deploymentFilename,
+		DeploymentStacks:   deploymentStacks,
 		ProjectID:          projectID,
 		PipelineID:         pipelineID,
+		ChangedFiles:       changedFilesFromPush(pushEvent),
+		Tag:                tagFromRef(pushEvent.Ref),
 	}
+	return params, true
+}
 
-	s.runPipelineLogic(params)
+// findWebhookProject returns the project of the repository a push event comes from
+func (s *Server) findWebhookProject(pushEvent models.PushEvent) (*models.Project, error) {
+	project, err := s.db.FindProjectByUrl(pushEvent.Repository.CloneURL)
+	if err != nil && pushEvent.Repository.SSHURL != "" {
+		// Projects cloned over SSH are registered with the SSH URL
+		project, err = s.db.FindProjectByUrl(pushEvent.Repository.SSHURL)
+	}
+	return project, err
 }
 
-// runPipelineFromManualTrigger adapts manual trigger data to the unified runner
-func (s *Server) runPipelineFromManualTrigger(project *models.Project, pipeline *models.Pipeline, branch string) {
-	logger.Info(fmt.Sprintf("Starting manual pipeline %d for project %s", pipeline.ID, project.Name))
+// changedFilesFromPush lists the files added, modified or removed by the pushed commits
+func changedFilesFromPush(pushEvent models.PushEvent) []string {
+	seen := make(map[string]bool)
+	var files []string
+	for _, commit := range pushEvent.Commits {
+		for _, list := range [][]string{commit.Added, commit.Modified, commit.Removed} {
+			for _, file := range list {
+				if !seen[file] {
+					seen[file] = true
+					files = append(files, file)
+				}
+			}
+		}
+	}
+	return files
+}
 
-	// Update status to running
-	s.db.UpdatePipelineStatus(pipeline.ID, "running")
+// submitManualPipeline adapts manual trigger data to the unified runner and queues the run
+// The inline config recorded on the pipeline replaces the committed CI config
+func (s *Server) submitManualPipeline(project *models.Project, pipeline *models.Pipeline, branch string) {
+	logger.Info("Starting manual pipeline", "pipeline_id", pipeline.ID, "project_id", project.ID, "project", project.Name, "branch", branch)
 
 	pipelineFilename := project.PipelineFilename
 	if pipelineFilename == "" {
@@ -262,11 +750,19 @@ func (s *Server) runPipelineFromManualTrigger(project *models.Project, pipeline
 		Branch:             branch,
 		CommitHash:         pipeline.CommitHash,
 		AccessToken:        project.AccessToken,
+		SSHKey:             project.CloneSSHKey,
+		GitLFS:             project.GitLFS,
+		Submodules:         project.GitSubmodules,
 		PipelineFilename:   pipelineFilename,
 		DeploymentFilename: deploymentFilename,
+		DeploymentStacks:   project.DeploymentStacks,
 		ProjectID:          project.ID,
 		PipelineID:         pipeline.ID,
+		Tag:                pipeline.Tag,
+		InlineConfig:       pipeline.InlineConfig,
+		InlineDeploy:       pipeline.InlineDeploy,
+		ChangedFiles:       pipeline.ChangedFiles,
 	}
 
-	s.runPipelineLogic(params)
+	s.submitPipeline(params)
 }
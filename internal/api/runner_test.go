@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/executor"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+func TestRunWithInfraRetry(t *testing.T) {
+	infraErr := &executor.InfraError{Op: "pull alpine", Err: errors.New("registry unreachable")}
+
+	// An infrastructure failure triggers exactly one retry
+	attempts := 0
+	success, err := runWithInfraRetry(context.Background(), true, func() (bool, error) {
+		attempts++
+		if attempts == 1 {
+			return false, infraErr
+		}
+		return true, nil
+	})
+	if attempts != 2 || !success || err != nil {
+		t.Errorf("Expected a successful retry after an infrastructure failure, got %d attempts (success=%t, err=%v)", attempts, success, err)
+	}
+
+	// The retry is not repeated if the infrastructure fails again
+	attempts = 0
+	success, err = runWithInfraRetry(context.Background(), true, func() (bool, error) {
+		attempts++
+		return false, infraErr
+	})
+	if attempts != 2 || success || !executor.IsInfraError(err) {
+		t.Errorf("Expected 2 attempts ending with an infrastructure error, got %d (success=%t, err=%v)", attempts, success, err)
+	}
+
+	// A job script failure is the user's fault: no retry
+	attempts = 0
+	runWithInfraRetry(context.Background(), true, func() (bool, error) {
+		attempts++
+		return false, nil
+	})
+	if attempts != 1 {
+		t.Errorf("Expected no retry when a job failed, got %d attempts", attempts)
+	}
+
+	// Retry disabled
+	attempts = 0
+	runWithInfraRetry(context.Background(), false, func() (bool, error) {
+		attempts++
+		return false, infraErr
+	})
+	if attempts != 1 {
+		t.Errorf("Expected no retry when disabled, got %d attempts", attempts)
+	}
+}
+
+func TestTagDeployAllowed(t *testing.T) {
+	project := &models.Project{DeployTags: []string{"v*"}}
+
+	// Tag pipeline without a deploy rule: the deployment is skipped
+	if tagDeployAllowed("v1.2.0", &models.Project{}) {
+		t.Error("Expected tag pipeline without deploy_tags not to deploy")
+	}
+	if tagDeployAllowed("nightly-2024", project) {
+		t.Error("Expected tag not matching deploy_tags not to deploy")
+	}
+
+	if !tagDeployAllowed("v1.2.0", project) {
+		t.Error("Expected tag matching deploy_tags to deploy")
+	}
+	if !tagDeployAllowed("", &models.Project{}) {
+		t.Error("Expected branch pipelines to always deploy")
+	}
+}
+
+func TestInlineDeploySkipped(t *testing.T) {
+	if inlineDeploySkipped(models.PipelineRunParams{}) {
+		t.Error("Expected pipelines with the committed config to deploy")
+	}
+	if !inlineDeploySkipped(models.PipelineRunParams{InlineConfig: "stages: [test]\n"}) {
+		t.Error("Expected an inline config pipeline not to deploy unless requested")
+	}
+	if inlineDeploySkipped(models.PipelineRunParams{InlineConfig: "stages: [test]\n", InlineDeploy: true}) {
+		t.Error("Expected an inline config pipeline to deploy when requested")
+	}
+}
+
+func TestTagFromRef(t *testing.T) {
+	if tag := tagFromRef("refs/tags/v1.2.0"); tag != "v1.2.0" {
+		t.Errorf("Expected tag 'v1.2.0', got %q", tag)
+	}
+	if tag := tagFromRef("refs/heads/main"); tag != "" {
+		t.Errorf("Expected no tag for a branch ref, got %q", tag)
+	}
+}
+
+func TestCheckDeploymentFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "docker-compose.yml"), []byte("services: {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// Present file: deploy, whatever the project setting
+	for _, required := range []bool{false, true} {
+		found, err := checkDeploymentFile(dir, "docker-compose.yml", required)
+		if !found || err != nil {
+			t.Errorf("Expected deployment file to be found (required=%v), got %v, %v", required, found, err)
+		}
+	}
+
+	// Missing file, deployment optional: no deployment, the pipeline stays successful
+	found, err := checkDeploymentFile(dir, "compose.prod.yml", false)
+	if found || err != nil {
+		t.Errorf("Expected a missing optional file to skip the deployment, got %v, %v", found, err)
+	}
+
+	// Missing file, deployment required: the pipeline fails
+	found, err = checkDeploymentFile(dir, "compose.prod.yml", true)
+	if found || err == nil {
+		t.Errorf("Expected a missing required file to fail, got %v, %v", found, err)
+	}
+}
+
+func TestDeploymentExitCode(t *testing.T) {
+	// A successful deployment records 0
+	if exitCode, ok := deploymentExitCode(nil); !ok || exitCode != 0 {
+		t.Errorf("Expected exit code 0 for a successful deployment, got %d, %v", exitCode, ok)
+	}
+
+	// The exit code of the compose command survives the error wrapping
+	for _, expected := range []int{1, 125} {
+		cmdErr := exec.Command("sh", "-c", fmt.Sprintf("exit %d", expected)).Run()
+		err := fmt.Errorf("deployment failed: %w", &executor.DeployCommandError{Err: fmt.Errorf("docker compose up failed: %w", cmdErr)})
+		if exitCode, ok := deploymentExitCode(err); !ok || exitCode != expected {
+			t.Errorf("Expected exit code %d to be recorded, got %d, %v", expected, exitCode, ok)
+		}
+	}
+
+	// A command failing before the compose command, such as docker login, is not the deployment's
+	loginErr := fmt.Errorf("registry login failed: %w", exec.Command("sh", "-c", "exit 1").Run())
+	if exitCode, ok := deploymentExitCode(loginErr); ok {
+		t.Errorf("Expected no exit code for a failed registry login, got %d", exitCode)
+	}
+
+	// Failures without a command exiting record nothing
+	if _, ok := deploymentExitCode(&executor.DeployCommandError{Err: errors.New("deployment health check timed out")}); ok {
+		t.Error("Expected no exit code for a health check failure")
+	}
+}
+
+// initTestRepo creates a repository on branch main with the given files and returns its path and commit hash
+func initTestRepo(t *testing.T, files map[string]string) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	dir := t.TempDir()
+	run := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %s", args, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+
+	run("init", "-q")
+	run("symbolic-ref", "HEAD", "refs/heads/main")
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	run("add", "-A")
+	run("commit", "-q", "-m", "initial commit")
+	return dir, run("rev-parse", "HEAD")
+}
+
+func TestRunPipelineLogicRemovesWorkspace(t *testing.T) {
+	noConfig, noConfigHash := initTestRepo(t, map[string]string{"main.go": "package main\n"})
+	invalidConfig, invalidConfigHash := initTestRepo(t, map[string]string{".gitlab-ci.yml": "stages: [build\n"})
+
+	tests := []struct {
+		name   string
+		repo   string
+		commit string
+	}{
+		{"config not found", noConfig, noConfigHash},
+		{"invalid config", invalidConfig, invalidConfigHash},
+		{"commit not found", noConfig, "0123456789abcdef0123456789abcdef01234567"},
+		{"clone failed", filepath.Join(t.TempDir(), "missing"), noConfigHash},
+	}
+
+	workspaceDir := t.TempDir()
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				runningPipelines: newPipelineRegistry(),
+				concurrency:      newConcurrencyGroups(),
+				scheduler:        newPipelineScheduler(0),
+				workspaceDir:     workspaceDir,
+			}
+			params := models.PipelineRunParams{
+				RepoURL:          "file://" + tt.repo,
+				RepoName:         fmt.Sprintf("cleanup-test-%d-%d", time.Now().UnixNano(), i),
+				Branch:           "main",
+				CommitHash:       tt.commit,
+				PipelineFilename: ".gitlab-ci.yml",
+			}
+			s.runPipelineLogic(params)
+
+			leftovers, _ := filepath.Glob(filepath.Join(workspaceDir, params.RepoName+"-*"))
+			if len(leftovers) != 0 {
+				t.Errorf("Expected the workspace to be removed, found %v", leftovers)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/git"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+)
+
+// scheduleInterval is the delay between two checks of the project schedules
+var scheduleInterval = 30 * time.Second
+
+// scheduleLabel is carried by the pipelines started by a schedule
+const scheduleLabel = "scheduled"
+
+// maxMissedRuns bounds the occurrences walked to find the latest missed run of a schedule
+const maxMissedRuns = 100000
+
+// parseSchedule parses a standard 5-field cron expression (or a descriptor such as @daily)
+func parseSchedule(spec string) (cron.Schedule, error) {
+	return cron.ParseStandard(spec)
+}
+
+// validSchedule reports whether spec is empty (no schedule) or a valid cron expression
+func validSchedule(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	_, err := parseSchedule(spec)
+	return err
+}
+
+// scheduleBranch returns the branch built by the schedule of a project
+func scheduleBranch(project models.Project) string {
+	if project.ScheduleBranch == "" {
+		return "main"
+	}
+	return project.ScheduleBranch
+}
+
+// dueRun returns the run of a schedule to fire at now, fire=false when none is due
+// A schedule never run is armed at now rather than fired; after a downtime only the latest missed run fires
+func dueRun(schedule cron.Schedule, lastRun *time.Time, now time.Time) (runAt time.Time, fire bool) {
+	if lastRun == nil {
+		return now.Truncate(time.Minute), false
+	}
+	// Occurrences are computed in the time zone of now, whatever zone the database returned
+	runAt = schedule.Next(lastRun.In(now.Location()))
+	if runAt.IsZero() || runAt.After(now) {
+		return time.Time{}, false
+	}
+	for i := 0; i < maxMissedRuns; i++ {
+		next := schedule.Next(runAt)
+		if next.IsZero() || next.After(now) {
+			break
+		}
+		runAt = next
+	}
+	return runAt, true
+}
+
+// nextRun returns the next run of a schedule after now, nil for an invalid schedule
+func nextRun(project models.Project, now time.Time) *time.Time {
+	schedule, err := parseSchedule(project.ScheduleCron)
+	if err != nil {
+		return nil
+	}
+	next := schedule.Next(now)
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+// runSchedules starts the pipelines of the project schedules as they fall due, until ctx is done
+func (s *Server) runSchedules(ctx context.Context) {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		s.fireDueSchedules(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fireDueSchedules starts a pipeline for every schedule due at now
+func (s *Server) fireDueSchedules(now time.Time) {
+	projects, err := s.db.GetScheduledProjects()
+	if err != nil {
+		logger.Error("Failed to load schedules: " + err.Error())
+		return
+	}
+
+	for i := range projects {
+		project := &projects[i]
+		schedule, err := parseSchedule(project.ScheduleCron)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Ignoring invalid schedule %q of project %s: %v", project.ScheduleCron, project.Name, err))
+			continue
+		}
+
+		runAt, fire := dueRun(schedule, project.ScheduleLastRun, now)
+		if runAt.IsZero() {
+			continue
+		}
+		if !fire || project.Paused {
+			if s.claimScheduledRun(project, runAt) && fire {
+				logger.Info(fmt.Sprintf("Skipping scheduled pipeline of paused project %s", project.Name))
+			}
+			continue
+		}
+
+		// The head is fetched before the claim, a failed fetch leaves the run due for the next check
+		branch := scheduleBranch(*project)
+		commitHash, err := git.GetRemoteHeadHash(project.RepoURL, branch, project.AccessToken, s.cloneOptionsFor(project.CloneSSHKey, false, false))
+		if err != nil {
+			logger.Error(fmt.Sprintf("Scheduled pipeline of project %s not started, failed to get the head of %s: %v", project.Name, branch, err))
+			continue
+		}
+		if s.claimScheduledRun(project, runAt) {
+			s.startScheduledPipeline(project, branch, commitHash)
+		}
+	}
+}
+
+// claimScheduledRun records runAt as the last run of the schedule of a project
+// The claim is the only record of a run, a restarted or second server sees it and does not fire again
+func (s *Server) claimScheduledRun(project *models.Project, runAt time.Time) bool {
+	claimed, err := s.db.ClaimScheduledRun(project.ID, runAt)
+	if err != nil {
+		logger.Error(err.Error())
+		return false
+	}
+	return claimed
+}
+
+// startScheduledPipeline builds commitHash, the head of the schedule branch of a project
+func (s *Server) startScheduledPipeline(project *models.Project, branch, commitHash string) {
+	pipeline, err := s.db.CreatePipeline(project.ID, branch, commitHash, []string{scheduleLabel})
+	if err != nil {
+		logger.Error("Failed to create scheduled pipeline: " + err.Error())
+		return
+	}
+
+	logger.Info(fmt.Sprintf("Schedule %q of project %s started pipeline %d", project.ScheduleCron, project.Name, pipeline.ID))
+	s.submitManualPipeline(project, pipeline, branch)
+}
+
+// scheduleInfo describes the schedule of a project
+type scheduleInfo struct {
+	ProjectID int        `json:"project_id"`
+	Project   string     `json:"project"`
+	Cron      string     `json:"cron"`
+	Branch    string     `json:"branch"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	NextRunAt *time.Time `json:"next_run_at,omitempty"`
+}
+
+// projectSchedules returns the schedules of the projects that have one
+func projectSchedules(projects []models.Project, now time.Time) []scheduleInfo {
+	schedules := []scheduleInfo{}
+	for _, project := range projects {
+		if project.ScheduleCron == "" {
+			continue
+		}
+		schedules = append(schedules, scheduleInfo{
+			ProjectID: project.ID,
+			Project:   project.Name,
+			Cron:      project.ScheduleCron,
+			Branch:    scheduleBranch(project),
+			LastRunAt: project.ScheduleLastRun,
+			NextRunAt: nextRun(project, now),
+		})
+	}
+	return schedules
+}
+
+// handleSchedules lists the schedules of the projects of the user, with their next run
+func (s *Server) handleSchedules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if s.db == nil {
+		respondError(w, http.StatusServiceUnavailable, "Database not available")
+		return
+	}
+
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	projects, err := s.db.GetProjectsForUser(userID)
+	if err != nil {
+		logger.Error("Failed to get projects: " + err.Error())
+		respondError(w, http.StatusInternalServerError, "Failed to get schedules")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, projectSchedules(projects, time.Now()))
+}
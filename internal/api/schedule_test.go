@@ -0,0 +1,70 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+func TestValidSchedule(t *testing.T) {
+	for _, spec := range []string{"", "0 2 * * *", "*/15 * * * 1-5", "@daily"} {
+		if err := validSchedule(spec); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"nightly", "0 2 * *", "61 * * * *"} {
+		if err := validSchedule(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestDueRun(t *testing.T) {
+	schedule, _ := parseSchedule("0 2 * * *")
+	at := func(value string) *time.Time {
+		parsed, _ := time.Parse(time.RFC3339, value)
+		return &parsed
+	}
+
+	tests := []struct {
+		name    string
+		lastRun *time.Time
+		now     time.Time
+		runAt   time.Time
+		fire    bool
+	}{
+		{"NeverRunIsArmed", nil, *at("2026-03-10T14:30:45Z"), *at("2026-03-10T14:30:00Z"), false},
+		{"NotDueYet", at("2026-03-10T02:00:00Z"), *at("2026-03-10T14:30:00Z"), time.Time{}, false},
+		{"Due", at("2026-03-10T02:00:00Z"), *at("2026-03-11T02:00:20Z"), *at("2026-03-11T02:00:00Z"), true},
+		{"LatestMissedRunOnly", at("2026-03-10T02:00:00Z"), *at("2026-03-14T09:00:00Z"), *at("2026-03-14T02:00:00Z"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runAt, fire := dueRun(schedule, tt.lastRun, tt.now)
+			if !runAt.Equal(tt.runAt) || fire != tt.fire {
+				t.Errorf("Expected (%v, %v), got (%v, %v)", tt.runAt, tt.fire, runAt, fire)
+			}
+		})
+	}
+}
+
+func TestProjectSchedules(t *testing.T) {
+	now := time.Date(2026, 3, 10, 14, 30, 0, 0, time.UTC)
+	projects := []models.Project{
+		{ID: 1, Name: "api", ScheduleCron: "0 2 * * *"},
+		{ID: 2, Name: "web"},
+		{ID: 3, Name: "docs", ScheduleCron: "@hourly", ScheduleBranch: "develop"},
+	}
+
+	schedules := projectSchedules(projects, now)
+	if len(schedules) != 2 {
+		t.Fatalf("Expected the 2 scheduled projects, got %+v", schedules)
+	}
+	if schedules[0].Branch != "main" || !schedules[0].NextRunAt.Equal(time.Date(2026, 3, 11, 2, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected schedule %+v", schedules[0])
+	}
+	if schedules[1].Branch != "develop" || !schedules[1].NextRunAt.Equal(time.Date(2026, 3, 10, 15, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected schedule %+v", schedules[1])
+	}
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// pipelineScheduler bounds the number of pipelines running at the same time on the server
+// Pipelines over the limit wait for a slot in FIFO order, a freed slot is handed to the oldest one
+type pipelineScheduler struct {
+	mu      sync.Mutex
+	limit   int // 0 runs every pipeline right away
+	running int
+	queue   []*queuedRun
+}
+
+// queuedRun is a pipeline waiting for a slot: a run blocked in acquire (ready),
+// or a run submitted without a goroutine (start), started once it gets the slot
+type queuedRun struct {
+	ready      chan struct{}
+	pipelineID int
+	start      func()
+}
+
+// queueStats is the state of the pipeline queue
+type queueStats struct {
+	Limit   int `json:"limit"`
+	Running int `json:"running"`
+	Queued  int `json:"queued"`
+}
+
+func newPipelineScheduler(limit int) *pipelineScheduler {
+	return &pipelineScheduler{limit: limit}
+}
+
+// acquire takes a slot, waiting for one when the limit is reached
+// queued is called before waiting, acquire fails with ctx.Err() if ctx is done while the pipeline waits
+// A successful acquire must be followed by release once the pipeline is over
+func (s *pipelineScheduler) acquire(ctx context.Context, queued func()) error {
+	s.mu.Lock()
+	if s.limit <= 0 || s.running < s.limit {
+		s.running++
+		s.mu.Unlock()
+		return nil
+	}
+	waiting := &queuedRun{ready: make(chan struct{})}
+	s.queue = append(s.queue, waiting)
+	s.mu.Unlock()
+
+	if queued != nil {
+		queued()
+	}
+
+	select {
+	case <-waiting.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-waiting.ready:
+			// The slot was handed over meanwhile, pass it on
+			s.releaseLocked()
+		default:
+			s.removeLocked(waiting)
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// submit starts a run in a goroutine once it gets a slot, no goroutine waits for the slot meanwhile
+// It returns false when the run started right away; otherwise queued is called, with the scheduler locked
+// so that it happens before the run may start. The run must release its slot once it is over
+func (s *pipelineScheduler) submit(pipelineID int, queued func(), start func()) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.limit <= 0 || s.running < s.limit {
+		s.running++
+		go start()
+		return false
+	}
+	if queued != nil {
+		queued()
+	}
+	s.queue = append(s.queue, &queuedRun{pipelineID: pipelineID, start: start})
+	return true
+}
+
+// withdraw removes a submitted run still waiting for a slot, it reports whether the run was found
+func (s *pipelineScheduler) withdraw(pipelineID int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, waiting := range s.queue {
+		if waiting.start != nil && waiting.pipelineID == pipelineID {
+			s.removeLocked(waiting)
+			return true
+		}
+	}
+	return false
+}
+
+// release frees the slot of a pipeline, the oldest waiting pipeline takes it
+func (s *pipelineScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked()
+}
+
+func (s *pipelineScheduler) releaseLocked() {
+	if len(s.queue) > 0 {
+		next := s.queue[0]
+		s.queue = s.queue[1:]
+		if next.start != nil {
+			go next.start()
+		} else {
+			close(next.ready)
+		}
+		return
+	}
+	if s.running > 0 {
+		s.running--
+	}
+}
+
+func (s *pipelineScheduler) removeLocked(run *queuedRun) {
+	for i, waiting := range s.queue {
+		if waiting == run {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// stats returns the limit and the number of running and queued pipelines
+func (s *pipelineScheduler) stats() queueStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return queueStats{Limit: s.limit, Running: s.running, Queued: len(s.queue)}
+}
+
+// handlePipelineQueue handles GET /api/v1/pipelines/queue
+func (s *Server) handlePipelineQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	respondJSON(w, http.StatusOK, s.scheduler.stats())
+}
+
+// runHold is what a run holds while it runs: its scheduler slot and the concurrency group it joined
+// A run hands both over while it waits at an approval gate, so that a human does not hold other pipelines up
+type runHold struct {
+	mu         sync.Mutex
+	scheduler  *pipelineScheduler
+	pipelineID int
+	slot       bool   // the run holds a scheduler slot
+	group      string // key of the concurrency group joined by the run, empty for none
+	policy     string // policy the group was joined with
+	cancel     func() // cancels the run, for cancel-running groups
+}
+
+type runHoldKey struct{}
+
+// withRunHold returns a context carrying the hold of a run
+func withRunHold(ctx context.Context, hold *runHold) context.Context {
+	return context.WithValue(ctx, runHoldKey{}, hold)
+}
+
+// runHoldFrom returns the hold of the run of ctx, nil outside of a run
+func runHoldFrom(ctx context.Context) *runHold {
+	hold, _ := ctx.Value(runHoldKey{}).(*runHold)
+	return hold
+}
+
+// releaseSlot hands the scheduler slot over and reports whether the run held one
+// Releasing when the run holds no slot is harmless
+func (h *runHold) releaseSlot() bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.slot {
+		return false
+	}
+	h.slot = false
+	h.scheduler.release()
+	return true
+}
+
+// acquireSlot takes a scheduler slot, waiting for one when the limit is reached, see pipelineScheduler.acquire
+func (h *runHold) acquireSlot(ctx context.Context, queued func()) error {
+	if err := h.scheduler.acquire(ctx, queued); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.slot = true
+	h.mu.Unlock()
+	return nil
+}
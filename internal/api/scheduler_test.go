@@ -0,0 +1,229 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// scheduleAsync acquires a slot in a goroutine and returns the channel receiving the result
+// queued receives a value when the pipeline starts waiting
+func scheduleAsync(s *pipelineScheduler, ctx context.Context) (result chan error, queued chan struct{}) {
+	result = make(chan error, 1)
+	queued = make(chan struct{}, 1)
+	go func() { result <- s.acquire(ctx, func() { queued <- struct{}{} }) }()
+	return result, queued
+}
+
+func waitQueued(t *testing.T, queued chan struct{}) {
+	t.Helper()
+	select {
+	case <-queued:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the pipeline to be queued")
+	}
+}
+
+func TestSchedulerLimitFIFO(t *testing.T) {
+	s := newPipelineScheduler(1)
+	if err := s.acquire(context.Background(), nil); err != nil {
+		t.Fatalf("Expected the first pipeline to run, got %v", err)
+	}
+
+	second, secondQueued := scheduleAsync(s, context.Background())
+	waitQueued(t, secondQueued)
+	third, thirdQueued := scheduleAsync(s, context.Background())
+	waitQueued(t, thirdQueued)
+
+	if stats := s.stats(); stats != (queueStats{Limit: 1, Running: 1, Queued: 2}) {
+		t.Errorf("Unexpected queue stats %+v", stats)
+	}
+
+	// The oldest waiting pipeline takes the freed slot
+	s.release()
+	select {
+	case err := <-second:
+		if err != nil {
+			t.Fatalf("Expected the second pipeline to run, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the second pipeline to take the freed slot")
+	}
+	select {
+	case err := <-third:
+		t.Fatalf("Expected the third pipeline to keep waiting, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.release()
+	if err := <-third; err != nil {
+		t.Fatalf("Expected the third pipeline to run, got %v", err)
+	}
+	s.release()
+	if stats := s.stats(); stats != (queueStats{Limit: 1}) {
+		t.Errorf("Expected an idle scheduler, got %+v", stats)
+	}
+}
+
+func TestSchedulerCancelWhileQueued(t *testing.T) {
+	s := newPipelineScheduler(1)
+	if err := s.acquire(context.Background(), nil); err != nil {
+		t.Fatalf("Expected the first pipeline to run, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	second, secondQueued := scheduleAsync(s, ctx)
+	waitQueued(t, secondQueued)
+	cancel()
+	if err := <-second; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the queued pipeline to be cancelled, got %v", err)
+	}
+	if stats := s.stats(); stats.Queued != 0 {
+		t.Errorf("Expected the cancelled pipeline to leave the queue, got %+v", stats)
+	}
+
+	s.release()
+	if stats := s.stats(); stats.Running != 0 {
+		t.Errorf("Expected no running pipeline, got %+v", stats)
+	}
+}
+
+func TestSchedulerSubmit(t *testing.T) {
+	s := newPipelineScheduler(1)
+	started := make(chan int, 3)
+	submit := func(pipelineID int) bool {
+		return s.submit(pipelineID, nil, func() { started <- pipelineID })
+	}
+	expectStart := func(pipelineID int) {
+		t.Helper()
+		select {
+		case got := <-started:
+			if got != pipelineID {
+				t.Fatalf("Expected pipeline %d to start, got %d", pipelineID, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected pipeline %d to start", pipelineID)
+		}
+	}
+
+	if submit(1) {
+		t.Fatal("Expected the first pipeline to start right away")
+	}
+	expectStart(1)
+	if !submit(2) || !submit(3) {
+		t.Fatal("Expected the next pipelines to be queued")
+	}
+	if stats := s.stats(); stats != (queueStats{Limit: 1, Running: 1, Queued: 2}) {
+		t.Errorf("Unexpected queue stats %+v", stats)
+	}
+
+	// A withdrawn run never starts, the freed slot goes to the next one
+	if !s.withdraw(2) {
+		t.Fatal("Expected the queued pipeline to be withdrawn")
+	}
+	if s.withdraw(2) || s.withdraw(1) {
+		t.Error("Expected only queued pipelines to be withdrawn")
+	}
+	s.release()
+	expectStart(3)
+	s.release()
+	select {
+	case got := <-started:
+		t.Fatalf("Expected no other pipeline to start, got %d", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if stats := s.stats(); stats != (queueStats{Limit: 1}) {
+		t.Errorf("Expected an idle scheduler, got %+v", stats)
+	}
+}
+
+func TestSchedulerUnlimited(t *testing.T) {
+	s := newPipelineScheduler(0)
+	for i := 0; i < 10; i++ {
+		if err := s.acquire(context.Background(), func() { t.Error("Expected no pipeline to be queued") }); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if stats := s.stats(); stats.Running != 10 || stats.Queued != 0 {
+		t.Errorf("Unexpected queue stats %+v", stats)
+	}
+}
+
+func TestHandlePipelineQueue(t *testing.T) {
+	s := &Server{scheduler: newPipelineScheduler(2)}
+	s.scheduler.acquire(context.Background(), nil)
+
+	w := httptest.NewRecorder()
+	s.handlePipelineQueue(w, httptest.NewRequest("GET", "/api/v1/pipelines/queue", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	var stats queueStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if stats != (queueStats{Limit: 2, Running: 1}) {
+		t.Errorf("Unexpected queue stats %+v", stats)
+	}
+
+	w = httptest.NewRecorder()
+	s.handlePipelineQueue(w, httptest.NewRequest("POST", "/api/v1/pipelines/queue", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", w.Code)
+	}
+}
+
+func TestRunHoldHandsSlotOver(t *testing.T) {
+	s := &Server{scheduler: newPipelineScheduler(1), concurrency: newConcurrencyGroups()}
+	hold := &runHold{scheduler: s.scheduler, pipelineID: 1}
+	if err := hold.acquireSlot(context.Background(), nil); err != nil {
+		t.Fatalf("Expected the run to take the slot, got %v", err)
+	}
+
+	// The slot goes to the waiting pipeline while the run waits at a gate
+	other, otherQueued := scheduleAsync(s.scheduler, context.Background())
+	waitQueued(t, otherQueued)
+	hold.releaseSlot()
+	hold.releaseSlot()
+	if err := <-other; err != nil {
+		t.Fatalf("Expected the waiting pipeline to take the slot, got %v", err)
+	}
+	if stats := s.scheduler.stats(); stats.Running != 1 {
+		t.Errorf("Expected a released slot to be released once, got %+v", stats)
+	}
+
+	// The approved run waits for a slot again
+	resumed := make(chan error, 1)
+	go func() { resumed <- s.resumeRun(context.Background(), hold) }()
+	select {
+	case err := <-resumed:
+		t.Fatalf("Expected the resumed run to wait for a slot, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.scheduler.release()
+	select {
+	case err := <-resumed:
+		if err != nil {
+			t.Fatalf("Expected the resumed run to take the slot, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the resumed run to take the freed slot")
+	}
+}
+
+func TestResumeRunGroupTaken(t *testing.T) {
+	s := &Server{scheduler: newPipelineScheduler(0), concurrency: newConcurrencyGroups()}
+	hold := &runHold{scheduler: s.scheduler, pipelineID: 1, group: "1/deploy", policy: "cancel-running", cancel: func() {}}
+
+	// A newer pipeline took the group while the run waited, it wins
+	if err := s.concurrency.acquire(context.Background(), "1/deploy", "cancel-running", 2, func() {}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.resumeRun(context.Background(), hold); !errors.Is(err, errConcurrencyRejected) {
+		t.Errorf("Expected the resumed run to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/secretscan"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/tracing"
+)
+
+// secretScanJobName is the job recording the findings of the secret scan in the pipeline
+const secretScanJobName = "secret-scan"
+
+// validSecretScanMode reports whether mode is a secret scan mode of a project, "" disables the scan
+func validSecretScanMode(mode string) bool {
+	return mode == "" || mode == secretscan.ModeWarn || mode == secretscan.ModeBlock
+}
+
+// secretScanLines formats the findings for the pipeline log, the matched values are never printed
+func secretScanLines(findings []secretscan.Finding) []models.LogLine {
+	if len(findings) == 0 {
+		return []models.LogLine{{Content: "No secret found"}}
+	}
+	lines := make([]models.LogLine, 0, len(findings)+1)
+	for _, f := range findings {
+		lines = append(lines, models.LogLine{Content: fmt.Sprintf("%s:%d: potential %s", f.File, f.Line, f.Rule), Stream: models.LogStreamStderr})
+	}
+	return append(lines, models.LogLine{Content: fmt.Sprintf("%d potential secret(s) found", len(findings))})
+}
+
+// runSecretScan scans the workspace before the deployment when the project enables it
+// Findings are recorded in the pipeline log, they only fail the pipeline in block mode
+func (s *Server) runSecretScan(ctx context.Context, params models.PipelineRunParams, project *models.Project, workspaceDir string) error {
+	if project == nil || project.SecretScan == "" {
+		return nil
+	}
+
+	_, span := tracing.Tracer().Start(ctx, "secret_scan")
+	findings, err := secretscan.Scan(workspaceDir)
+	tracing.End(span, err)
+	if err != nil {
+		// A scan that cannot complete must not let secrets through in block mode
+		logger.Error("Secret scan failed: " + err.Error())
+		s.recordSecretScan(params.PipelineID, []models.LogLine{{Content: err.Error(), Stream: models.LogStreamStderr}}, true)
+		if project.SecretScan == secretscan.ModeBlock {
+			return fmt.Errorf("secret scan failed: %w", err)
+		}
+		return nil
+	}
+
+	blocked := len(findings) > 0 && project.SecretScan == secretscan.ModeBlock
+	s.recordSecretScan(params.PipelineID, secretScanLines(findings), blocked)
+	if len(findings) == 0 {
+		return nil
+	}
+
+	logger.Warn(fmt.Sprintf("Secret scan found %d potential secret(s) in pipeline %d", len(findings), params.PipelineID))
+	if blocked {
+		return fmt.Errorf("secret scan found %d potential secret(s), deployment blocked", len(findings))
+	}
+	return nil
+}
+
+// recordSecretScan stores the scan output as a job of the pipeline so that it shows in the pipeline log
+func (s *Server) recordSecretScan(pipelineID int, lines []models.LogLine, failed bool) {
+	if s.db == nil || pipelineID <= 0 {
+		return
+	}
+
+	job, err := s.db.CreateJob(pipelineID, secretScanJobName, secretScanJobName, "")
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to create secret scan job: %v", err))
+		return
+	}
+	if _, err := s.db.CreateLogBatch(job.ID, lines); err != nil {
+		logger.Error(fmt.Sprintf("Failed to store secret scan logs: %v", err))
+	}
+
+	exitCode := 0
+	status := "success"
+	if failed {
+		exitCode = 1
+		status = "failed"
+	}
+	s.db.UpdateJobStatus(job.ID, status, &exitCode)
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/secretscan"
+)
+
+func TestValidSecretScanMode(t *testing.T) {
+	for _, mode := range []string{"", "warn", "block"} {
+		if !validSecretScanMode(mode) {
+			t.Errorf("Expected %q to be valid", mode)
+		}
+	}
+	if validSecretScanMode("strict") {
+		t.Error("Expected unknown mode to be rejected")
+	}
+}
+
+func TestSecretScanLines(t *testing.T) {
+	lines := secretScanLines([]secretscan.Finding{{File: "aws.env", Line: 2, Rule: "AWS access key ID"}})
+	if len(lines) != 2 || lines[0].Content != "aws.env:2: potential AWS access key ID" {
+		t.Fatalf("Unexpected lines %v", lines)
+	}
+	if !strings.Contains(lines[1].Content, "1 potential secret") {
+		t.Errorf("Expected a summary line, got %q", lines[1].Content)
+	}
+}
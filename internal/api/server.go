@@ -1,28 +1,55 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/database"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/docker"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/executor"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/git"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/env"
 
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
 )
 
 // Server represents the API server
 type Server struct {
+	ctx                context.Context // base context of the background work of the server
 	db                 *database.DB
 	docker             *docker.DockerExecutor
 	port               string
 	pipelineExecutor   *executor.PipelineExecutor
 	deploymentExecutor *executor.DeploymentExecutor
+	runningPipelines   *pipelineRegistry
+	concurrency        *concurrencyGroups
+	branchConcurrency  string             // PIPELINE_BRANCH_CONCURRENCY, policy grouping the pipelines of a branch
+	scheduler          *pipelineScheduler // PIPELINE_MAX_CONCURRENT, pipelines running at the same time
+	statusDir          string             // PIPELINE_STATUS_DIR, where pipeline status files are written
+	workspaceDir       string             // WORKSPACE_DIR, root of the pipeline workspaces
+	workspaceMaxAge    time.Duration      // WORKSPACE_MAX_AGE_HOURS, age of the workspaces swept at startup
+	workspaceMinFree   uint64             // WORKSPACE_MIN_FREE_MB, free disk space required to clone
+	warmUpImages       []string
+	warmUpConcurrency  int
+	retryInfraFailures bool // PIPELINE_RETRY_ON_INFRA_FAILURE, retry a pipeline once when the infrastructure failed
+	cloneOptions       git.CloneOptions
+	postCloneCommands  []string // POST_CLONE_COMMANDS, the post-clone commands projects may run
+	webhookStrict      bool     // WEBHOOK_STRICT, reject webhooks of projects without a webhook secret
+	logHub             *executor.LogHub
+	approvals          *approvalGates
+	approvalTimeout    time.Duration // PIPELINE_APPROVAL_TIMEOUT_MINUTES, default wait at an approval gate before it is rejected
 }
 
 // NewServer creates a new API server
 func NewServer(db *database.DB, port string) (*Server, error) {
+	workspaceDir := env.String("WORKSPACE_DIR", defaultWorkspaceDir)
+	if err := checkWritableDir(workspaceDir); err != nil {
+		return nil, fmt.Errorf("workspace directory %s is not writable: %w", workspaceDir, err)
+	}
+
 	docker, err := docker.NewDockerExecutor()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create docker executor: %w", err)
@@ -31,13 +58,44 @@ func NewServer(db *database.DB, port string) (*Server, error) {
 	pipelineExecutor := executor.NewPipelineExecutor(db, docker)
 	deploymentExecutor := executor.NewDeploymentExecutor(db, docker)
 
-	return &Server{
+	s := &Server{
+		ctx:                context.Background(),
 		db:                 db,
 		docker:             docker,
 		port:               port,
 		pipelineExecutor:   pipelineExecutor,
 		deploymentExecutor: deploymentExecutor,
-	}, nil
+		runningPipelines:   newPipelineRegistry(),
+		concurrency:        newConcurrencyGroups(),
+		branchConcurrency:  branchConcurrencyPolicy(env.String("PIPELINE_BRANCH_CONCURRENCY", "")),
+		scheduler:          newPipelineScheduler(env.Int("PIPELINE_MAX_CONCURRENT", 0)),
+		statusDir:          env.String("PIPELINE_STATUS_DIR", ""),
+		workspaceDir:       workspaceDir,
+		workspaceMaxAge:    time.Duration(env.Int("WORKSPACE_MAX_AGE_HOURS", 24)) * time.Hour,
+		workspaceMinFree:   uint64(env.Int("WORKSPACE_MIN_FREE_MB", 0)) << 20,
+		warmUpImages:       env.List("WARMUP_IMAGES"),
+		warmUpConcurrency:  env.Int("WARMUP_PULL_CONCURRENCY", 2),
+		retryInfraFailures: env.Bool("PIPELINE_RETRY_ON_INFRA_FAILURE", false),
+		cloneOptions: git.CloneOptions{
+			MaxSize:          int64(env.Int("MAX_CLONE_SIZE_MB", 0)) << 20,
+			SSHKeyDir:        env.String("GIT_SSH_KEY_DIR", ""),
+			KnownHostsFile:   env.String("GIT_SSH_KNOWN_HOSTS", git.DefaultKnownHostsFile()),
+			HostKeyChecking:  env.String("GIT_SSH_HOST_KEY_CHECKING", git.HostKeyAcceptNew),
+			ReferenceDir:     env.String("GIT_REFERENCE_DIR", ""),
+			ReferenceRefresh: time.Duration(env.Int("GIT_REFERENCE_REFRESH_SECONDS", 300)) * time.Second,
+			Timeout:          time.Duration(env.Int("CLONE_TIMEOUT_SECONDS", 600)) * time.Second,
+			Depth:            env.Int("GIT_CLONE_DEPTH", 1),
+		},
+		postCloneCommands: env.List("POST_CLONE_COMMANDS"),
+		webhookStrict:     env.Bool("WEBHOOK_STRICT", false),
+		logHub:            pipelineExecutor.LogHub(),
+		approvals:         newApprovalGates(),
+		approvalTimeout:   time.Duration(env.Int("PIPELINE_APPROVAL_TIMEOUT_MINUTES", 24*60)) * time.Minute,
+	}
+
+	// Stages requiring an approval wait at the gates of the server
+	pipelineExecutor.SetApprovalGate(s.awaitApproval)
+	return s, nil
 }
 
 // enableCORS adds CORS headers to the response
@@ -45,7 +103,7 @@ func enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-GitHub-Event")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-GitHub-Event, X-Hub-Signature-256, X-Gitlab-Event, X-Gitlab-Token")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
@@ -60,11 +118,27 @@ func enableCORS(next http.Handler) http.Handler {
 func (s *Server) Start() error {
 	InitializeOAuth()
 
-	// Health check
+	// No pipeline runs yet, the workspaces left are those of a crashed process
+	s.sweepStaleWorkspaces()
+	s.sweepStaleNetworks()
+
+	// Pre-pull common images in the background, the server is ready without waiting for them
+	go s.docker.WarmUp(s.ctx, s.warmUpImages, s.warmUpConcurrency)
+
+	// Start the pipelines of the project schedules as they fall due
+	if s.db != nil {
+		s.resumeWaitingPipelines()
+		go s.runSchedules(s.ctx)
+	}
+
+	// Health checks, /healthz for the process, /readyz for the Docker daemon and the database
 	http.HandleFunc("/health", s.handleHealth)
+	http.HandleFunc("/healthz", s.handleHealth)
+	http.HandleFunc("/readyz", s.handleReady)
 
 	// Webhook
-	http.HandleFunc("/webhook/github", s.handleGitHubWebhook)
+	http.HandleFunc("/webhook/github", s.handleWebhook)
+	http.HandleFunc("/webhook/gitlab", s.handleWebhook)
 
 	// Auth routes
 	http.HandleFunc("/auth/google/login", s.handleAuthLogin)
@@ -75,11 +149,17 @@ func (s *Server) Start() error {
 	// API v1 routes
 	http.HandleFunc("/api/v1/projects", s.AuthMiddleware(s.handleProjects))
 	http.HandleFunc("/api/v1/projects/", s.AuthMiddleware(s.routeProjectsSubpath))
+	http.HandleFunc("/api/v1/pipelines/queue", s.AuthMiddleware(s.handlePipelineQueue))
+	http.HandleFunc("/api/v1/validate", s.AuthMiddleware(s.handleValidate))
+	http.HandleFunc("/api/v1/schedules", s.AuthMiddleware(s.handleSchedules))
 
 	logger.Info("Starting API server on port " + s.port)
 	logger.Info("Endpoints:")
 	logger.Info("  - GET    /health")
+	logger.Info("  - GET    /healthz")
+	logger.Info("  - GET    /readyz")
 	logger.Info("  - POST   /webhook/github")
+	logger.Info("  - POST   /webhook/gitlab")
 	logger.Info("  - GET    /auth/{provider}/login")
 	logger.Info("  - GET    /auth/{provider}/callback")
 	logger.Info("  - GET    /api/v1/projects")
@@ -93,12 +173,23 @@ func (s *Server) Start() error {
 	logger.Info("  - GET    /api/v1/projects/{id}/variables")
 	logger.Info("  - POST   /api/v1/projects/{id}/variables")
 	logger.Info("  - DELETE /api/v1/projects/{id}/variables/{key}")
+	logger.Info("  - POST   /api/v1/projects/{id}/pause")
+	logger.Info("  - POST   /api/v1/projects/{id}/unpause")
+	logger.Info("  - POST   /api/v1/projects/{id}/cancel-all")
 	logger.Info("  - GET    /api/v1/projects/{id}/pipelines")
 	logger.Info("  - POST   /api/v1/projects/{id}/pipelines")
 	logger.Info("  - GET    /api/v1/projects/{id}/pipelines/{id}")
+	logger.Info("  - POST   /api/v1/projects/{id}/pipelines/{id}/cancel")
+	logger.Info("  - POST   /api/v1/projects/{id}/pipelines/{id}/approve")
+	logger.Info("  - POST   /api/v1/projects/{id}/pipelines/{id}/reject")
+	logger.Info("  - GET    /api/v1/projects/{id}/pipelines/{id}/log")
 	logger.Info("  - GET    /api/v1/projects/{id}/pipelines/{id}/jobs")
 	logger.Info("  - GET    /api/v1/projects/{id}/pipelines/{id}/jobs/{id}")
 	logger.Info("  - GET    /api/v1/projects/{id}/pipelines/{id}/jobs/{id}/logs")
+	logger.Info("  - GET    /api/v1/projects/{id}/pipelines/{id}/jobs/{id}/logs/stream")
+	logger.Info("  - GET    /api/v1/pipelines/queue")
+	logger.Info("  - POST   /api/v1/validate")
+	logger.Info("  - GET    /api/v1/schedules")
 
 	return http.ListenAndServe(":"+s.port, enableCORS(http.DefaultServeMux))
 }
@@ -138,6 +229,24 @@ func (s *Server) routeProjectsSubpath(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// /api/v1/projects/{projectId}/pause
+	if len(parts) == 2 && parts[1] == "pause" {
+		s.handleProjectPause(w, r, true)
+		return
+	}
+
+	// /api/v1/projects/{projectId}/unpause
+	if len(parts) == 2 && parts[1] == "unpause" {
+		s.handleProjectPause(w, r, false)
+		return
+	}
+
+	// /api/v1/projects/{projectId}/cancel-all
+	if len(parts) == 2 && parts[1] == "cancel-all" {
+		s.handleCancelAll(w, r)
+		return
+	}
+
 	// /api/v1/projects/{projectId}/pipelines
 	if len(parts) == 2 && parts[1] == "pipelines" {
 		s.handlePipelines(w, r)
@@ -150,6 +259,30 @@ func (s *Server) routeProjectsSubpath(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// /api/v1/projects/{projectId}/pipelines/{pipelineId}/cancel
+	if len(parts) == 4 && parts[1] == "pipelines" && parts[3] == "cancel" {
+		s.handlePipelineCancel(w, r)
+		return
+	}
+
+	// /api/v1/projects/{projectId}/pipelines/{pipelineId}/approve
+	if len(parts) == 4 && parts[1] == "pipelines" && parts[3] == "approve" {
+		s.handlePipelineApproval(w, r, true)
+		return
+	}
+
+	// /api/v1/projects/{projectId}/pipelines/{pipelineId}/reject
+	if len(parts) == 4 && parts[1] == "pipelines" && parts[3] == "reject" {
+		s.handlePipelineApproval(w, r, false)
+		return
+	}
+
+	// /api/v1/projects/{projectId}/pipelines/{pipelineId}/log
+	if len(parts) == 4 && parts[1] == "pipelines" && parts[3] == "log" {
+		s.handlePipelineLog(w, r)
+		return
+	}
+
 	// /api/v1/projects/{projectId}/pipelines/{pipelineId}/jobs
 	if len(parts) == 4 && parts[1] == "pipelines" && parts[3] == "jobs" {
 		s.handleJobs(w, r)
@@ -168,6 +301,12 @@ func (s *Server) routeProjectsSubpath(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// /api/v1/projects/{projectId}/pipelines/{pipelineId}/jobs/{jobId}/logs/stream
+	if len(parts) == 7 && parts[1] == "pipelines" && parts[3] == "jobs" && parts[5] == "logs" && parts[6] == "stream" {
+		s.handleLogStream(w, r)
+		return
+	}
+
 	// /api/v1/projects/{projectId}/pipelines/{pipelineId}/deployment
 	if len(parts) == 4 && parts[1] == "pipelines" && parts[3] == "deployment" {
 		s.handleDeployment(w, r)
@@ -182,3 +321,15 @@ func (s *Server) routeProjectsSubpath(w http.ResponseWriter, r *http.Request) {
 
 	respondError(w, http.StatusNotFound, "Not found")
 }
+
+// sweepStaleNetworks removes the pipeline and job networks left behind by a crashed process
+func (s *Server) sweepStaleNetworks() {
+	removed, err := s.docker.SweepNetworks(s.ctx)
+	if err != nil {
+		logger.Warn("Failed to sweep stale networks", "error", err)
+		return
+	}
+	if len(removed) > 0 {
+		logger.Info("Removed stale networks", "count", len(removed), "networks", removed)
+	}
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+)
+
+// deploymentFiles returns the compose files a pipeline deploys, for logging
+func deploymentFiles(params models.PipelineRunParams) string {
+	if len(params.DeploymentStacks) > 0 {
+		return strings.Join(params.DeploymentStacks, ", ")
+	}
+	return params.DeploymentFilename
+}
+
+// checkStackFiles ensures every stack declared by the project exists in the workspace
+// Unlike the deployment file, a declared stack is always required
+func checkStackFiles(workspaceDir string, stacks []string) error {
+	for _, stack := range stacks {
+		if _, err := os.Stat(filepath.Join(workspaceDir, stack)); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("deployment stack %s not found", stack)
+			}
+			return fmt.Errorf("failed to check deployment stack %s: %w", stack, err)
+		}
+	}
+	return nil
+}
+
+// stackNameInvalid matches the characters a compose project name cannot hold
+var stackNameInvalid = regexp.MustCompile("[^a-z0-9]+")
+
+// stackName returns the name of a stack from the relative path of its compose file, directories included,
+// without the extensions of the file: db.compose.yml -> db, stacks/db.compose.yml -> stacks-db
+func stackName(composeFile string) string {
+	dir, base := path.Split(filepath.ToSlash(filepath.Clean(composeFile)))
+	if i := strings.Index(base, "."); i > 0 {
+		base = base[:i]
+	}
+	return strings.Trim(stackNameInvalid.ReplaceAllString(strings.ToLower(dir+base), "-"), "-")
+}
+
+// stackNameCollision returns two stacks getting the same name, they would deploy to the same compose project
+// It returns empty strings when every stack has its own name
+func stackNameCollision(stacks []string) (string, string) {
+	seen := make(map[string]string, len(stacks))
+	for _, stack := range stacks {
+		name := stackName(stack)
+		if first, ok := seen[name]; ok {
+			return first, stack
+		}
+		seen[name] = stack
+	}
+	return "", ""
+}
+
+// deployStacks deploys the stacks one after the other, in the given order
+// Each deployment waits for its services to be healthy, so a stack only starts once the previous one is up
+// The first failure stops the deployment, skip is called for the stacks left
+func deployStacks(stacks []string, deploy func(stack string) error, skip func(stack string)) error {
+	for i, stack := range stacks {
+		if err := deploy(stack); err != nil {
+			for _, left := range stacks[i+1:] {
+				skip(left)
+			}
+			return fmt.Errorf("stack %s: %w", stack, err)
+		}
+	}
+	return nil
+}
+
+// deploy runs the deployment of a pipeline, stack by stack when the project declares deployment_stacks
+// record stores a deployment per stack, rollbacks redeploy the stacks without recording them
+func (s *Server) deploy(project *models.Project, params models.PipelineRunParams, workspaceDir string, record bool) error {
+	if len(params.DeploymentStacks) == 0 {
+		_, err := s.deploymentExecutor.Execute(project, params, workspaceDir)
+		return err
+	}
+
+	// Projects saved before stack names were checked may still hold colliding stacks
+	if first, second := stackNameCollision(params.DeploymentStacks); first != "" {
+		return fmt.Errorf("deployment stacks %s and %s get the same name %s", first, second, stackName(first))
+	}
+
+	record = record && s.db != nil && params.PipelineID > 0
+	return deployStacks(params.DeploymentStacks, func(stack string) error {
+		stackParams := params
+		stackParams.DeploymentFilename = stack
+		stackParams.DeploymentStack = stackName(stack)
+
+		var deploymentID int
+		if record {
+			s.db.CreateDeploymentLog(params.PipelineID, fmt.Sprintf("=== STACK %s ===", stack))
+			if deploy, err := s.db.CreateStackDeployment(params.PipelineID, stack); err != nil {
+				logger.Error(fmt.Sprintf("Failed to create deployment record of stack %s: %v", stack, err))
+			} else {
+				deploymentID = deploy.ID
+			}
+		}
+
+		_, err := s.deploymentExecutor.Execute(project, stackParams, workspaceDir)
+		s.recordDeploymentExitCode(deploymentID, err)
+		if deploymentID > 0 {
+			status := "success"
+			if err != nil {
+				status = "failed"
+			}
+			s.db.UpdateDeploymentStatus(deploymentID, status)
+		}
+		return err
+	}, func(stack string) {
+		logger.Warn(fmt.Sprintf("Skipping deployment of stack %s", stack))
+		if !record {
+			return
+		}
+		s.db.CreateDeploymentLog(params.PipelineID, fmt.Sprintf("Stack %s not deployed, a previous stack failed", stack))
+		if deploy, err := s.db.CreateStackDeployment(params.PipelineID, stack); err == nil {
+			s.db.UpdateDeploymentStatus(deploy.ID, "skipped")
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDeployStacks(t *testing.T) {
+	stacks := []string{"db.yml", "backend.yml", "frontend.yml"}
+
+	t.Run("InOrder", func(t *testing.T) {
+		var deployed []string
+		err := deployStacks(stacks, func(stack string) error {
+			deployed = append(deployed, stack)
+			return nil
+		}, func(stack string) { t.Errorf("Unexpected skip of %s", stack) })
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(deployed, stacks) {
+			t.Errorf("Expected stacks deployed in order %v, got %v", stacks, deployed)
+		}
+	})
+
+	t.Run("StopOnFailure", func(t *testing.T) {
+		failure := errors.New("unhealthy")
+		var deployed, skipped []string
+		err := deployStacks(stacks, func(stack string) error {
+			deployed = append(deployed, stack)
+			if stack == "backend.yml" {
+				return failure
+			}
+			return nil
+		}, func(stack string) { skipped = append(skipped, stack) })
+
+		if !errors.Is(err, failure) {
+			t.Errorf("Expected the stack error, got %v", err)
+		}
+		if !reflect.DeepEqual(deployed, []string{"db.yml", "backend.yml"}) {
+			t.Errorf("Expected the deployment to stop at the failed stack, got %v", deployed)
+		}
+		if !reflect.DeepEqual(skipped, []string{"frontend.yml"}) {
+			t.Errorf("Expected the remaining stacks to be skipped, got %v", skipped)
+		}
+	})
+}
+
+func TestStackName(t *testing.T) {
+	for file, expected := range map[string]string{
+		"db.yml":                     "db",
+		"stacks/backend.compose.yml": "stacks-backend",
+		"./Ops/DB_Main.compose.yml":  "ops-db-main",
+		"docker-compose.yml":         "docker-compose",
+	} {
+		if name := stackName(file); name != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, file, name)
+		}
+	}
+}
+
+func TestStackNameCollision(t *testing.T) {
+	if first, second := stackNameCollision([]string{"db.yml", "stacks/db.yml", "backend.yml"}); first != "" {
+		t.Errorf("Expected stacks of different directories to get their own name, got %s and %s", first, second)
+	}
+	first, second := stackNameCollision([]string{"db.yml", "stacks/db.compose.yml", "db.compose.yaml"})
+	if first != "db.yml" || second != "db.compose.yaml" {
+		t.Errorf("Expected db.yml and db.compose.yaml to collide, got %q and %q", first, second)
+	}
+	if first, _ := stackNameCollision([]string{"ops/a_b.yml", "ops/a-b.yml"}); first != "ops/a_b.yml" {
+		t.Errorf("Expected names equal once sanitized to collide, got %q", first)
+	}
+}
+
+func TestCheckStackFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "db.yml"), []byte("services: {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write stack: %v", err)
+	}
+
+	if err := checkStackFiles(dir, []string{"db.yml"}); err != nil {
+		t.Errorf("Expected existing stack to pass, got %v", err)
+	}
+	if err := checkStackFiles(dir, []string{"db.yml", "backend.yml"}); err == nil {
+		t.Error("Expected a missing stack to fail the deployment")
+	}
+}
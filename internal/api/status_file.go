@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+)
+
+// pipelineStatusReport is the result summary written to the status file of a pipeline
+type pipelineStatusReport struct {
+	ProjectID   int                `json:"project_id"`
+	PipelineID  int                `json:"pipeline_id"`
+	Repository  string             `json:"repository"`
+	Branch      string             `json:"branch"`
+	CommitHash  string             `json:"commit_hash"`
+	Status      string             `json:"status"`
+	Labels      []string           `json:"labels,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+	FinishedAt  *time.Time         `json:"finished_at,omitempty"`
+	Jobs        []models.Job       `json:"jobs"`
+	Deployment  *models.Deployment `json:"deployment,omitempty"`
+	GeneratedAt time.Time          `json:"generated_at"`
+}
+
+// newPipelineStatusReport builds the status report of a finished pipeline
+func newPipelineStatusReport(params models.PipelineRunParams, pipeline *models.Pipeline, jobs []models.Job, deployment *models.Deployment) pipelineStatusReport {
+	if jobs == nil {
+		jobs = []models.Job{}
+	}
+	return pipelineStatusReport{
+		ProjectID:   pipeline.ProjectID,
+		PipelineID:  pipeline.ID,
+		Repository:  params.RepoName,
+		Branch:      pipeline.Branch,
+		CommitHash:  pipeline.CommitHash,
+		Status:      pipeline.Status,
+		Labels:      pipeline.Labels,
+		CreatedAt:   pipeline.CreatedAt,
+		FinishedAt:  pipeline.FinishedAt,
+		Jobs:        jobs,
+		Deployment:  deployment,
+		GeneratedAt: time.Now(),
+	}
+}
+
+// writeStatusFile writes the report to <dir>/<repository>/pipeline-<id>.json and latest.json
+// It returns the path of the pipeline file
+func writeStatusFile(dir string, report pipelineStatusReport) (string, error) {
+	projectDir := filepath.Join(dir, sanitizeProjectName(report.Repository))
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create status directory: %w", err)
+	}
+
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode status report: %w", err)
+	}
+
+	path := filepath.Join(projectDir, fmt.Sprintf("pipeline-%d.json", report.PipelineID))
+	for _, target := range []string{path, filepath.Join(projectDir, "latest.json")} {
+		// Write then rename so that readers never see a partial file
+		tmp := target + ".tmp"
+		if err := os.WriteFile(tmp, content, 0644); err != nil {
+			return "", fmt.Errorf("failed to write status file: %w", err)
+		}
+		if err := os.Rename(tmp, target); err != nil {
+			return "", fmt.Errorf("failed to write status file: %w", err)
+		}
+	}
+
+	return path, nil
+}
+
+// reportPipelineStatus writes the status file of a finished pipeline if PIPELINE_STATUS_DIR is set
+func (s *Server) reportPipelineStatus(params models.PipelineRunParams) {
+	if s.statusDir == "" || s.db == nil || params.PipelineID <= 0 {
+		return
+	}
+
+	pipeline, err := s.db.GetPipeline(params.PipelineID)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to load pipeline %d for status file: %v", params.PipelineID, err))
+		return
+	}
+	jobs, err := s.db.GetJobsByPipeline(params.PipelineID)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Failed to load jobs of pipeline %d for status file: %v", params.PipelineID, err))
+	}
+	deployment, _ := s.db.GetDeploymentByPipeline(params.PipelineID)
+
+	path, err := writeStatusFile(s.statusDir, newPipelineStatusReport(params, pipeline, jobs, deployment))
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to write status file of pipeline %d: %v", params.PipelineID, err))
+		return
+	}
+	logger.Info(fmt.Sprintf("Pipeline %d status written to %s", params.PipelineID, path))
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+func TestWriteStatusFile(t *testing.T) {
+	dir := t.TempDir()
+	finishedAt := time.Date(2024, 5, 1, 10, 5, 0, 0, time.UTC)
+
+	params := models.PipelineRunParams{RepoName: "My-App"}
+	pipeline := &models.Pipeline{
+		ID:         7,
+		ProjectID:  3,
+		Status:     "failed",
+		Branch:     "main",
+		CommitHash: "0123456789abcdef",
+		CreatedAt:  time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		FinishedAt: &finishedAt,
+	}
+	jobs := []models.Job{
+		{ID: 1, PipelineID: 7, Name: "build", Stage: "build", Status: "success"},
+		{ID: 2, PipelineID: 7, Name: "test", Stage: "test", Status: "failed", ExitCode: 2},
+	}
+	deployment := &models.Deployment{ID: 4, PipelineID: 7, Status: "pending"}
+
+	path, err := writeStatusFile(dir, newPipelineStatusReport(params, pipeline, jobs, deployment))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if expected := filepath.Join(dir, "my-app", "pipeline-7.json"); path != expected {
+		t.Errorf("Expected status file at %s, got %s", expected, path)
+	}
+
+	for _, file := range []string{path, filepath.Join(dir, "my-app", "latest.json")} {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", file, err)
+		}
+
+		var report pipelineStatusReport
+		if err := json.Unmarshal(content, &report); err != nil {
+			t.Fatalf("Invalid JSON in %s: %v", file, err)
+		}
+
+		if report.PipelineID != 7 || report.ProjectID != 3 {
+			t.Errorf("Expected pipeline 7 of project 3, got pipeline %d of project %d", report.PipelineID, report.ProjectID)
+		}
+		if report.Status != "failed" || report.Branch != "main" || report.CommitHash != "0123456789abcdef" {
+			t.Errorf("Unexpected pipeline summary: %+v", report)
+		}
+		if report.FinishedAt == nil || !report.FinishedAt.Equal(finishedAt) {
+			t.Errorf("Expected finished_at %v, got %v", finishedAt, report.FinishedAt)
+		}
+		if len(report.Jobs) != 2 || report.Jobs[1].Name != "test" || report.Jobs[1].ExitCode != 2 {
+			t.Errorf("Expected both jobs with their results, got %+v", report.Jobs)
+		}
+		if report.Deployment == nil || report.Deployment.Status != "pending" {
+			t.Errorf("Expected pending deployment, got %+v", report.Deployment)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+)
+
+// maxValidateSize bounds the size of a config sent to the validate endpoint
+const maxValidateSize = 1 << 20
+
+// validateResponse is the result of the validation of a CI config
+type validateResponse struct {
+	Valid  bool                       `json:"valid"`
+	Errors []pipeline.ValidationError `json:"errors"`
+}
+
+// handleValidate handles POST /api/v1/validate
+// The body is the raw YAML of a CI config, it is parsed and checked without running any job
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValidateSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(w, http.StatusRequestEntityTooLarge, "Config too large")
+			return
+		}
+		respondError(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+
+	errs := pipeline.Validate(data)
+	if errs == nil {
+		errs = []pipeline.ValidationError{}
+	}
+	respondJSON(w, http.StatusOK, validateResponse{Valid: len(errs) == 0, Errors: errs})
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleValidate(t *testing.T) {
+	s := &Server{}
+	validate := func(body string) validateResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.handleValidate(w, httptest.NewRequest("POST", "/api/v1/validate", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+		var resp validateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		return resp
+	}
+
+	resp := validate("stages: [build]\nbuild:\n  stage: build\n  image: alpine\n  script: [make]\n")
+	if !resp.Valid || len(resp.Errors) != 0 {
+		t.Errorf("Expected a valid config, got %+v", resp)
+	}
+
+	resp = validate("stages: [build]\nbuild:\n  stage: test\n  image: alpine\n  script: [make]\n")
+	if resp.Valid || len(resp.Errors) != 1 || resp.Errors[0].Job != "build" || resp.Errors[0].Field != "stage" {
+		t.Errorf("Expected an unknown stage error, got %+v", resp)
+	}
+
+	w := httptest.NewRecorder()
+	s.handleValidate(w, httptest.NewRequest("GET", "/api/v1/validate", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.handleValidate(w, httptest.NewRequest("POST", "/api/v1/validate", strings.NewReader(strings.Repeat("#", maxValidateSize+1))))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413, got %d", w.Code)
+	}
+}
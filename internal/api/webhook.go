@@ -0,0 +1,154 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+// Git providers whose push webhooks are understood
+const (
+	providerGitHub = "github"
+	providerGitLab = "gitlab"
+)
+
+// zeroCommit is the commit hash GitLab sends as "after" when a branch is deleted
+const zeroCommit = "0000000000000000000000000000000000000000"
+
+// webhookProvider detects the provider of a webhook from its headers, "" if unknown
+func webhookProvider(r *http.Request) string {
+	switch {
+	case r.Header.Get("X-GitHub-Event") != "":
+		return providerGitHub
+	case r.Header.Get("X-Gitlab-Event") != "":
+		return providerGitLab
+	}
+	return ""
+}
+
+// isPushEvent reports whether the event header of the provider announces a push (branch or tag)
+func isPushEvent(r *http.Request, provider string) bool {
+	switch provider {
+	case providerGitHub:
+		return r.Header.Get("X-GitHub-Event") == "push"
+	case providerGitLab:
+		event := r.Header.Get("X-Gitlab-Event")
+		return event == "Push Hook" || event == "Tag Push Hook"
+	}
+	return false
+}
+
+// parsePushEvent decodes the push payload of a provider into the normalized push event
+func parsePushEvent(provider string, body []byte) (models.PushEvent, error) {
+	var pushEvent models.PushEvent
+	switch provider {
+	case providerGitHub:
+		err := json.Unmarshal(body, &pushEvent)
+		return pushEvent, err
+	case providerGitLab:
+		var event gitlabPushEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			return pushEvent, err
+		}
+		return event.normalize(), nil
+	}
+	return pushEvent, fmt.Errorf("unknown webhook provider %q", provider)
+}
+
+// verifyWebhook authenticates a webhook with the project secret:
+// GitHub signs the body (X-Hub-Signature-256), GitLab sends the secret itself (X-Gitlab-Token)
+func verifyWebhook(r *http.Request, provider string, body []byte, secret string, strict bool) bool {
+	if provider == providerGitHub {
+		return verifyGitHubSignature(body, r.Header.Get("X-Hub-Signature-256"), secret, strict)
+	}
+	return verifyWebhookToken(r.Header.Get("X-Gitlab-Token"), secret, strict)
+}
+
+// verifyWebhookToken checks the token sent with a webhook against the project secret, in constant time
+// Projects without a secret accept any webhook, unless strict mode is enabled
+func verifyWebhookToken(token, secret string, strict bool) bool {
+	if secret == "" {
+		return !strict
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+}
+
+// verifyGitHubSignature checks the sha256=<hex> HMAC of the body computed with the project secret
+// Projects without a secret accept any webhook, unless strict mode is enabled
+func verifyGitHubSignature(body []byte, signature, secret string, strict bool) bool {
+	if secret == "" {
+		return !strict
+	}
+	sent, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sent, mac.Sum(nil))
+}
+
+// gitlabPushEvent is the payload of a GitLab push or tag push webhook
+type gitlabPushEvent struct {
+	Ref         string `json:"ref"`
+	Before      string `json:"before"`
+	After       string `json:"after"`
+	CheckoutSHA string `json:"checkout_sha"`
+	UserName    string `json:"user_name"`
+	UserEmail   string `json:"user_email"`
+	UserLogin   string `json:"user_username"`
+	Project     struct {
+		ID                int    `json:"id"`
+		Name              string `json:"name"`
+		PathWithNamespace string `json:"path_with_namespace"`
+		GitHTTPURL        string `json:"git_http_url"`
+		GitSSHURL         string `json:"git_ssh_url"`
+		DefaultBranch     string `json:"default_branch"`
+		VisibilityLevel   int    `json:"visibility_level"`
+	} `json:"project"`
+	Commits []models.Commit `json:"commits"`
+}
+
+// normalize converts the GitLab payload into the push event the runner works with
+func (g gitlabPushEvent) normalize() models.PushEvent {
+	after := g.After
+	if g.CheckoutSHA != "" {
+		after = g.CheckoutSHA
+	}
+
+	pushEvent := models.PushEvent{
+		Ref:     g.Ref,
+		Before:  g.Before,
+		After:   after,
+		Created: g.Before == zeroCommit,
+		Deleted: g.After == zeroCommit,
+		Repository: models.Repository{
+			ID:            g.Project.ID,
+			Name:          g.Project.Name,
+			FullName:      g.Project.PathWithNamespace,
+			CloneURL:      g.Project.GitHTTPURL,
+			SSHURL:        g.Project.GitSSHURL,
+			DefaultBranch: g.Project.DefaultBranch,
+			Private:       g.Project.VisibilityLevel == 0,
+		},
+		Pusher:  models.Pusher{Name: g.UserName, Email: g.UserEmail},
+		Sender:  models.Sender{Login: g.UserLogin},
+		Commits: g.Commits,
+	}
+
+	// The head commit is the one checked out, GitLab has no dedicated field
+	for _, commit := range g.Commits {
+		if commit.ID == after {
+			pushEvent.HeadCommit = commit
+		}
+	}
+	return pushEvent
+}
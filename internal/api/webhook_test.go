@@ -0,0 +1,149 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseGitLabPushEvent(t *testing.T) {
+	payload := `{
+		"object_kind": "push",
+		"ref": "refs/heads/main",
+		"before": "1111111111111111111111111111111111111111",
+		"after": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+		"checkout_sha": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+		"user_name": "Jane Doe",
+		"user_username": "jdoe",
+		"project": {
+			"name": "api",
+			"path_with_namespace": "team/api",
+			"git_http_url": "https://gitlab.example.com/team/api.git",
+			"git_ssh_url": "git@gitlab.example.com:team/api.git"
+		},
+		"commits": [
+			{"id": "b6568db1bc1dcd7f8b4d5a946b0b91f9dacd7327", "message": "Update docs", "added": ["docs/a.md"]},
+			{"id": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", "message": "Fix build [skip-deploy]", "modified": ["main.go"]}
+		]
+	}`
+
+	pushEvent, err := parsePushEvent(providerGitLab, []byte(payload))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if pushEvent.Ref != "refs/heads/main" || pushEvent.After != "da1560886d4f094c3e6c9ef40349f7d38b5d27d7" {
+		t.Errorf("Unexpected ref/commit %s %s", pushEvent.Ref, pushEvent.After)
+	}
+	if pushEvent.Repository.CloneURL != "https://gitlab.example.com/team/api.git" || pushEvent.Repository.SSHURL != "git@gitlab.example.com:team/api.git" {
+		t.Errorf("Unexpected repository %+v", pushEvent.Repository)
+	}
+	if pushEvent.Repository.Name != "api" || pushEvent.Repository.FullName != "team/api" {
+		t.Errorf("Unexpected repository name %+v", pushEvent.Repository)
+	}
+	if pushEvent.HeadCommit.Message != "Fix build [skip-deploy]" {
+		t.Errorf("Expected head commit to be the checked out one, got %+v", pushEvent.HeadCommit)
+	}
+	if files := changedFilesFromPush(pushEvent); len(files) != 2 {
+		t.Errorf("Expected the changed files of both commits, got %v", files)
+	}
+	if pushEvent.Deleted {
+		t.Error("Expected push not to be a deletion")
+	}
+
+	// Branch deletion
+	deletion, err := parsePushEvent(providerGitLab, []byte(`{"ref": "refs/heads/old", "after": "0000000000000000000000000000000000000000", "checkout_sha": null}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !deletion.Deleted {
+		t.Error("Expected zero after commit to be a deletion")
+	}
+}
+
+func TestParseGitHubPushEvent(t *testing.T) {
+	payload := `{"ref": "refs/heads/main", "after": "abc123", "repository": {"name": "api", "clone_url": "https://github.com/team/api.git"}, "head_commit": {"message": "Fix"}}`
+
+	pushEvent, err := parsePushEvent(providerGitHub, []byte(payload))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if pushEvent.Repository.CloneURL != "https://github.com/team/api.git" || pushEvent.HeadCommit.Message != "Fix" {
+		t.Errorf("Unexpected push event %+v", pushEvent)
+	}
+}
+
+func TestWebhookProvider(t *testing.T) {
+	tests := []struct {
+		header, value, provider string
+		push                    bool
+	}{
+		{"X-GitHub-Event", "push", providerGitHub, true},
+		{"X-GitHub-Event", "ping", providerGitHub, false},
+		{"X-Gitlab-Event", "Push Hook", providerGitLab, true},
+		{"X-Gitlab-Event", "Tag Push Hook", providerGitLab, true},
+		{"X-Gitlab-Event", "Merge Request Hook", providerGitLab, false},
+		{"X-Other-Event", "push", "", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/webhook/github", nil)
+		r.Header.Set(tt.header, tt.value)
+		provider := webhookProvider(r)
+		if provider != tt.provider {
+			t.Errorf("%s: %s, expected provider %q, got %q", tt.header, tt.value, tt.provider, provider)
+		}
+		if push := isPushEvent(r, provider); push != tt.push {
+			t.Errorf("%s: %s, expected push %v, got %v", tt.header, tt.value, tt.push, push)
+		}
+	}
+}
+
+func TestVerifyGitHubSignature(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !verifyGitHubSignature(body, signature, "s3cr3t", false) {
+		t.Error("Expected a valid signature to be accepted")
+	}
+	if verifyGitHubSignature([]byte(`{"ref":"refs/heads/evil"}`), signature, "s3cr3t", false) {
+		t.Error("Expected a tampered body to be rejected")
+	}
+	if verifyGitHubSignature(body, "", "s3cr3t", false) || verifyGitHubSignature(body, "sha1=abc", "s3cr3t", false) {
+		t.Error("Expected a missing or malformed signature to be rejected")
+	}
+	if !verifyGitHubSignature(body, "", "", false) {
+		t.Error("Expected projects without secret to accept unsigned webhooks")
+	}
+	if verifyGitHubSignature(body, signature, "", true) {
+		t.Error("Expected strict mode to reject projects without secret")
+	}
+}
+
+func TestVerifyWebhookToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		secret   string
+		strict   bool
+		expected bool
+	}{
+		{"MatchingToken", "s3cr3t", "s3cr3t", false, true},
+		{"WrongToken", "guess", "s3cr3t", false, false},
+		{"MissingToken", "", "s3cr3t", false, false},
+		{"NoSecret", "", "", false, true},
+		{"NoSecretStrict", "anything", "", true, false},
+		{"MatchingTokenStrict", "s3cr3t", "s3cr3t", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyWebhookToken(tt.token, tt.secret, tt.strict); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+)
+
+// defaultWorkspaceDir is the root of the pipeline workspaces when WORKSPACE_DIR is not set
+const defaultWorkspaceDir = "/tmp/cicd-workspaces"
+
+// workspacePath returns the path of a workspace under the workspace root
+func (s *Server) workspacePath(name string) string {
+	return filepath.Join(s.workspaceDir, name)
+}
+
+// checkWritableDir creates dir if needed and makes sure files can be written in it
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// sweepStaleWorkspaces removes the workspaces left behind by a crashed process
+func (s *Server) sweepStaleWorkspaces() {
+	if s.workspaceMaxAge <= 0 {
+		return
+	}
+	removed, err := sweepWorkspaces(s.workspaceDir, s.workspaceMaxAge, time.Now())
+	if err != nil {
+		logger.Warn("Failed to sweep stale workspaces: " + err.Error())
+		return
+	}
+	if len(removed) > 0 {
+		logger.Info(fmt.Sprintf("Removed %d stale workspaces from %s", len(removed), s.workspaceDir))
+	}
+}
+
+// sweepWorkspaces removes the entries of root last modified before now - maxAge
+// It returns the paths removed, a missing root has nothing to sweep
+func sweepWorkspaces(root string, maxAge time.Duration, now time.Time) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspaces: %w", err)
+	}
+
+	var removed []string
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < maxAge {
+			continue
+		}
+		path := filepath.Join(root, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to remove stale workspace %s: %v", path, err))
+			continue
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+// checkFreeSpace fails when the filesystem of root has less than minFree bytes available
+// A zero minFree disables the check
+func checkFreeSpace(root string, minFree uint64) error {
+	if minFree == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return fmt.Errorf("failed to create workspace root: %w", err)
+	}
+	free, err := freeSpace(root)
+	if err != nil {
+		return fmt.Errorf("failed to check free disk space: %w", err)
+	}
+	if free < minFree {
+		return fmt.Errorf("not enough free disk space in %s: %d MB available, %d MB required", root, free>>20, minFree>>20)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package api
+
+import "errors"
+
+// freeSpace is not implemented on this platform, WORKSPACE_MIN_FREE_MB must stay unset
+func freeSpace(path string) (uint64, error) {
+	return 0, errors.New("free disk space check not supported on this platform")
+}
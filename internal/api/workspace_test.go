@@ -0,0 +1,81 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSweepWorkspaces(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	for name, age := range map[string]time.Duration{
+		"stale-abcdef01-1":  48 * time.Hour,
+		"recent-abcdef01-2": time.Hour,
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Join(path, "src"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := sweepWorkspaces(root, 24*time.Hour, now)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(removed) != 1 || filepath.Base(removed[0]) != "stale-abcdef01-1" {
+		t.Errorf("Expected only the stale workspace to be removed, got %v", removed)
+	}
+	if _, err := os.Stat(filepath.Join(root, "stale-abcdef01-1")); !os.IsNotExist(err) {
+		t.Error("Expected the stale workspace to be gone")
+	}
+	if _, err := os.Stat(filepath.Join(root, "recent-abcdef01-2")); err != nil {
+		t.Errorf("Expected the recent workspace to be kept, got %v", err)
+	}
+
+	if removed, err := sweepWorkspaces(filepath.Join(root, "missing"), time.Hour, now); err != nil || removed != nil {
+		t.Errorf("Expected nothing to sweep in a missing root, got %v, %v", removed, err)
+	}
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "workspaces")
+
+	if err := checkFreeSpace(root, 0); err != nil {
+		t.Errorf("Expected a disabled check to pass, got %v", err)
+	}
+	if err := checkFreeSpace(root, 1); err != nil {
+		t.Errorf("Expected one free byte to be available, got %v", err)
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Errorf("Expected the workspace root to be created, got %v", err)
+	}
+
+	err := checkFreeSpace(root, 1<<62)
+	if err == nil || !strings.Contains(err.Error(), "not enough free disk space") {
+		t.Errorf("Expected a low disk space error, got %v", err)
+	}
+}
+
+func TestCheckWritableDir(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "nested", "workspaces")
+	if err := checkWritableDir(root); err != nil {
+		t.Fatalf("Expected the directory to be created and writable, got %v", err)
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 0 {
+		t.Errorf("Expected the write check to leave nothing behind, found %d entries", len(entries))
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkWritableDir(filepath.Join(file, "workspaces")); err == nil {
+		t.Error("Expected an error for a directory under a regular file")
+	}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
@@ -12,7 +13,7 @@ import (
 	"time"
 
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type DB struct {
@@ -47,6 +48,11 @@ func New(encryptionKey string) (*DB, error) {
 	}, nil
 }
 
+// Ping checks that the database is reachable
+func (db *DB) Ping(ctx context.Context) error {
+	return db.conn.PingContext(ctx)
+}
+
 // Close closes the database connection
 func (db *DB) Close() error {
 	return db.conn.Close()
@@ -143,6 +149,51 @@ func (db *DB) GetUserByID(id int) (*models.User, error) {
 
 // ============== Project Operations ==============
 
+// projectColumns lists the project columns read by scanProject (table alias "p")
+const projectColumns = `
+	p.id, p.owner_id, p.name, p.repo_url, p.access_token, p.pipeline_filename, p.deployment_filename,
+	COALESCE(p.ssh_host, ''), COALESCE(p.ssh_user, ''), COALESCE(p.ssh_private_key, ''),
+	COALESCE(p.registry_user, ''), COALESCE(p.registry_token, ''),
+	COALESCE(p.compose_profiles, '{}'), COALESCE(p.paused, FALSE), COALESCE(p.deploy_tags, '{}'),
+	COALESCE(p.post_clone_command, ''), COALESCE(p.clone_ssh_key, ''), COALESCE(p.git_lfs, FALSE), COALESCE(p.git_submodules, FALSE),
+	COALESCE(p.require_deployment, FALSE), COALESCE(p.webhook_secret, ''), COALESCE(p.secret_scan, ''),
+	COALESCE(p.deployment_stacks, '{}'), COALESCE(p.health_check_url, ''),
+	COALESCE(p.slack_webhook_url, ''), COALESCE(p.notify_webhook_url, ''),
+	COALESCE(p.schedule_cron, ''), COALESCE(p.schedule_branch, ''), p.schedule_last_run, p.created_at`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanProject scans a row selected with projectColumns and decrypts its sensitive fields
+func (db *DB) scanProject(row rowScanner) (*models.Project, error) {
+	var p models.Project
+	var scheduleLastRun sql.NullTime
+	if err := row.Scan(&p.ID, &p.OwnerID, &p.Name, &p.RepoURL, &p.AccessToken, &p.PipelineFilename, &p.DeploymentFilename,
+		&p.SSHHost, &p.SSHUser, &p.SSHPrivateKey, &p.RegistryUser, &p.RegistryToken,
+		pq.Array(&p.ComposeProfiles), &p.Paused, pq.Array(&p.DeployTags),
+		&p.PostCloneCommand, &p.CloneSSHKey, &p.GitLFS, &p.GitSubmodules, &p.RequireDeployment, &p.WebhookSecret, &p.SecretScan,
+		pq.Array(&p.DeploymentStacks), &p.HealthCheckURL, &p.SlackWebhookURL, &p.NotifyWebhookURL,
+		&p.ScheduleCron, &p.ScheduleBranch, &scheduleLastRun, &p.CreatedAt); err != nil {
+		return nil, err
+	}
+	if scheduleLastRun.Valid {
+		p.ScheduleLastRun = &scheduleLastRun.Time
+	}
+
+	// Decrypt sensitive fields
+	p.AccessToken, _ = db.Decrypt(p.AccessToken)
+	p.SSHPrivateKey, _ = db.Decrypt(p.SSHPrivateKey)
+	p.RegistryToken, _ = db.Decrypt(p.RegistryToken)
+	p.CloneSSHKey, _ = db.Decrypt(p.CloneSSHKey)
+	p.WebhookSecret, _ = db.Decrypt(p.WebhookSecret)
+	p.SlackWebhookURL, _ = db.Decrypt(p.SlackWebhookURL)
+	p.NotifyWebhookURL, _ = db.Decrypt(p.NotifyWebhookURL)
+
+	return &p, nil
+}
+
 // CreateProject creates a new project in the database
 func (db *DB) CreateProject(project *models.NewProject) (*models.Project, error) {
 	// Set defaults if empty
@@ -165,43 +216,40 @@ func (db *DB) CreateProject(project *models.NewProject) (*models.Project, error)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encrypt registry token: %w", err)
 	}
+	encCloneSSHKey, err := db.Encrypt(project.CloneSSHKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt clone ssh key: %w", err)
+	}
+	encWebhookSecret, err := db.Encrypt(project.WebhookSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt webhook secret: %w", err)
+	}
+	encSlackWebhookURL, err := db.Encrypt(project.SlackWebhookURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt slack webhook url: %w", err)
+	}
+	encNotifyWebhookURL, err := db.Encrypt(project.NotifyWebhookURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt notification webhook url: %w", err)
+	}
 
 	query := `
-		INSERT INTO projects (owner_id, name, repo_url, access_token, pipeline_filename, deployment_filename, ssh_host, ssh_user, ssh_private_key, registry_user, registry_token)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-		RETURNING id, owner_id, name, repo_url, access_token, pipeline_filename, deployment_filename, ssh_host, ssh_user, ssh_private_key, registry_user, registry_token, created_at
-	`
-	var p models.Project
-	err = db.conn.QueryRow(query, project.OwnerID, project.Name, project.RepoURL, encAccessToken, project.PipelineFilename, project.DeploymentFilename,
-		project.SSHHost, project.SSHUser, encSSHPrivateKey, project.RegistryUser, encRegistryToken).
-		Scan(&p.ID, &p.OwnerID, &p.Name, &p.RepoURL, &p.AccessToken, &p.PipelineFilename, &p.DeploymentFilename,
-			&p.SSHHost, &p.SSHUser, &p.SSHPrivateKey, &p.RegistryUser, &p.RegistryToken, &p.CreatedAt)
+		INSERT INTO projects AS p (owner_id, name, repo_url, access_token, pipeline_filename, deployment_filename, ssh_host, ssh_user, ssh_private_key, registry_user, registry_token, compose_profiles, deploy_tags, post_clone_command, clone_ssh_key, git_lfs, require_deployment, webhook_secret, secret_scan, deployment_stacks, health_check_url, slack_webhook_url, notify_webhook_url, git_submodules, schedule_cron, schedule_branch)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26)
+		RETURNING ` + projectColumns
+	p, err := db.scanProject(db.conn.QueryRow(query, project.OwnerID, project.Name, project.RepoURL, encAccessToken, project.PipelineFilename, project.DeploymentFilename,
+		project.SSHHost, project.SSHUser, encSSHPrivateKey, project.RegistryUser, encRegistryToken, pq.Array(project.ComposeProfiles), pq.Array(project.DeployTags), project.PostCloneCommand, encCloneSSHKey, project.GitLFS, project.RequireDeployment, encWebhookSecret, project.SecretScan, pq.Array(project.DeploymentStacks), project.HealthCheckURL, encSlackWebhookURL, encNotifyWebhookURL, project.GitSubmodules, project.ScheduleCron, project.ScheduleBranch))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create project: %w", err)
 	}
 
-	// Restore plaintext values in returned object
-	p.AccessToken = project.AccessToken
-	p.SSHPrivateKey = project.SSHPrivateKey
-	p.RegistryToken = project.RegistryToken
-
-	return &p, nil
+	return p, nil
 }
 
 // GetProject retrieves a project by ID
 func (db *DB) GetProject(id int) (*models.Project, error) {
-	query := `
-		SELECT id, owner_id, name, repo_url, access_token, pipeline_filename, deployment_filename,
-		COALESCE(ssh_host, ''), COALESCE(ssh_user, ''), COALESCE(ssh_private_key, ''),
-		COALESCE(registry_user, ''), COALESCE(registry_token, ''),
-		created_at
-		FROM projects WHERE id = $1
-	`
-	var p models.Project
-	err := db.conn.QueryRow(query, id).
-		Scan(&p.ID, &p.OwnerID, &p.Name, &p.RepoURL, &p.AccessToken, &p.PipelineFilename, &p.DeploymentFilename,
-			&p.SSHHost, &p.SSHUser, &p.SSHPrivateKey, &p.RegistryUser, &p.RegistryToken,
-			&p.CreatedAt)
+	query := `SELECT ` + projectColumns + ` FROM projects p WHERE p.id = $1`
+	p, err := db.scanProject(db.conn.QueryRow(query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("project not found")
@@ -209,34 +257,23 @@ func (db *DB) GetProject(id int) (*models.Project, error) {
 		return nil, fmt.Errorf("failed to get project: %w", err)
 	}
 
-	// Decrypt sensitive fields
-	p.AccessToken, _ = db.Decrypt(p.AccessToken)
-	p.SSHPrivateKey, _ = db.Decrypt(p.SSHPrivateKey)
-	p.RegistryToken, _ = db.Decrypt(p.RegistryToken)
-
 	variables, err := db.GetVariablesByProject(id)
 	if err == nil {
 		// Mask secrets
 		for i := range variables {
-			if variables[i].IsSecret {
+			if variables[i].IsSecret || variables[i].Masked {
 				variables[i].Value = "*****"
 			}
 		}
 		p.Variables = variables
 	}
 
-	return &p, nil
+	return p, nil
 }
 
 // GetAllProjects retrieves all projects
 func (db *DB) GetAllProjects() ([]models.Project, error) {
-	query := `
-		SELECT id, owner_id, name, repo_url, access_token, pipeline_filename, deployment_filename,
-		COALESCE(ssh_host, ''), COALESCE(ssh_user, ''), COALESCE(ssh_private_key, ''),
-		COALESCE(registry_user, ''), COALESCE(registry_token, ''),
-		created_at
-		FROM projects ORDER BY created_at DESC
-	`
+	query := `SELECT ` + projectColumns + ` FROM projects p ORDER BY p.created_at DESC`
 	rows, err := db.conn.Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query projects: %w", err)
@@ -245,19 +282,11 @@ func (db *DB) GetAllProjects() ([]models.Project, error) {
 
 	var projects []models.Project
 	for rows.Next() {
-		var p models.Project
-		if err := rows.Scan(&p.ID, &p.OwnerID, &p.Name, &p.RepoURL, &p.AccessToken, &p.PipelineFilename, &p.DeploymentFilename,
-			&p.SSHHost, &p.SSHUser, &p.SSHPrivateKey, &p.RegistryUser, &p.RegistryToken,
-			&p.CreatedAt); err != nil {
+		p, err := db.scanProject(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan project: %w", err)
 		}
-
-		// Decrypt sensitive fields
-		p.AccessToken, _ = db.Decrypt(p.AccessToken)
-		p.SSHPrivateKey, _ = db.Decrypt(p.SSHPrivateKey)
-		p.RegistryToken, _ = db.Decrypt(p.RegistryToken)
-
-		projects = append(projects, p)
+		projects = append(projects, *p)
 	}
 	return projects, nil
 }
@@ -265,10 +294,7 @@ func (db *DB) GetAllProjects() ([]models.Project, error) {
 // GetProjectsForUser retrieves projects where user is owner or member
 func (db *DB) GetProjectsForUser(userID int) ([]models.Project, error) {
 	query := `
-		SELECT DISTINCT p.id, p.owner_id, p.name, p.repo_url, p.access_token, p.pipeline_filename, p.deployment_filename,
-		COALESCE(p.ssh_host, ''), COALESCE(p.ssh_user, ''), COALESCE(p.ssh_private_key, ''),
-		COALESCE(p.registry_user, ''), COALESCE(p.registry_token, ''),
-		p.created_at
+		SELECT DISTINCT ` + projectColumns + `
 		FROM projects p
 		LEFT JOIN project_members pm ON p.id = pm.project_id
 		WHERE p.owner_id = $1 OR pm.user_id = $1
@@ -282,36 +308,18 @@ func (db *DB) GetProjectsForUser(userID int) ([]models.Project, error) {
 
 	var projects []models.Project
 	for rows.Next() {
-		var p models.Project
-		if err := rows.Scan(&p.ID, &p.OwnerID, &p.Name, &p.RepoURL, &p.AccessToken, &p.PipelineFilename, &p.DeploymentFilename,
-			&p.SSHHost, &p.SSHUser, &p.SSHPrivateKey, &p.RegistryUser, &p.RegistryToken,
-			&p.CreatedAt); err != nil {
+		p, err := db.scanProject(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan project: %w", err)
 		}
-
-		// Decrypt sensitive fields
-		p.AccessToken, _ = db.Decrypt(p.AccessToken)
-		p.SSHPrivateKey, _ = db.Decrypt(p.SSHPrivateKey)
-		p.RegistryToken, _ = db.Decrypt(p.RegistryToken)
-
-		projects = append(projects, p)
+		projects = append(projects, *p)
 	}
 	return projects, nil
 }
 
 func (db *DB) FindProjectByUrl(url string) (*models.Project, error) {
-	query := `
-		SELECT id, owner_id, name, repo_url, access_token, pipeline_filename, deployment_filename,
-		COALESCE(ssh_host, ''), COALESCE(ssh_user, ''), COALESCE(ssh_private_key, ''),
-		COALESCE(registry_user, ''), COALESCE(registry_token, ''),
-		created_at
-		FROM projects WHERE repo_url = $1
-	`
-	var p models.Project
-	err := db.conn.QueryRow(query, url).
-		Scan(&p.ID, &p.OwnerID, &p.Name, &p.RepoURL, &p.AccessToken, &p.PipelineFilename, &p.DeploymentFilename,
-			&p.SSHHost, &p.SSHUser, &p.SSHPrivateKey, &p.RegistryUser, &p.RegistryToken,
-			&p.CreatedAt)
+	query := `SELECT ` + projectColumns + ` FROM projects p WHERE p.repo_url = $1`
+	p, err := db.scanProject(db.conn.QueryRow(query, url))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("project not found")
@@ -319,12 +327,7 @@ func (db *DB) FindProjectByUrl(url string) (*models.Project, error) {
 		return nil, fmt.Errorf("failed to get project: %w", err)
 	}
 
-	// Decrypt sensitive fields
-	p.AccessToken, _ = db.Decrypt(p.AccessToken)
-	p.SSHPrivateKey, _ = db.Decrypt(p.SSHPrivateKey)
-	p.RegistryToken, _ = db.Decrypt(p.RegistryToken)
-
-	return &p, nil
+	return p, nil
 }
 
 // UpdateProject updates an existing project
@@ -349,29 +352,51 @@ func (db *DB) UpdateProject(id int, project *models.NewProject) (*models.Project
 	if err != nil {
 		return nil, fmt.Errorf("failed to encrypt registry token: %w", err)
 	}
+	encCloneSSHKey, err := db.Encrypt(project.CloneSSHKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt clone ssh key: %w", err)
+	}
+	encWebhookSecret, err := db.Encrypt(project.WebhookSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt webhook secret: %w", err)
+	}
+	encSlackWebhookURL, err := db.Encrypt(project.SlackWebhookURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt slack webhook url: %w", err)
+	}
+	encNotifyWebhookURL, err := db.Encrypt(project.NotifyWebhookURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt notification webhook url: %w", err)
+	}
 
 	query := `
-		UPDATE projects
+		UPDATE projects AS p
 		SET name = $1, repo_url = $2, access_token = $3, pipeline_filename = $4, deployment_filename = $5,
-		ssh_host = $6, ssh_user = $7, ssh_private_key = $8, registry_user = $9, registry_token = $10
-		WHERE id = $11
-		RETURNING id, name, repo_url, access_token, pipeline_filename, deployment_filename, ssh_host, ssh_user, ssh_private_key, registry_user, registry_token, created_at
-	`
-	var p models.Project
-	err = db.conn.QueryRow(query, project.Name, project.RepoURL, encAccessToken, project.PipelineFilename, project.DeploymentFilename,
-		project.SSHHost, project.SSHUser, encSSHPrivateKey, project.RegistryUser, encRegistryToken, id).
-		Scan(&p.ID, &p.Name, &p.RepoURL, &p.AccessToken, &p.PipelineFilename, &p.DeploymentFilename,
-			&p.SSHHost, &p.SSHUser, &p.SSHPrivateKey, &p.RegistryUser, &p.RegistryToken, &p.CreatedAt)
+		ssh_host = $6, ssh_user = $7, ssh_private_key = $8, registry_user = $9, registry_token = $10,
+		compose_profiles = $11, deploy_tags = $12, post_clone_command = $13, clone_ssh_key = $14, git_lfs = $15, require_deployment = $16, webhook_secret = $17, secret_scan = $18, deployment_stacks = $19, health_check_url = $20,
+		slack_webhook_url = $21, notify_webhook_url = $22, git_submodules = $23, schedule_cron = $24, schedule_branch = $25
+		WHERE p.id = $26
+		RETURNING ` + projectColumns
+	p, err := db.scanProject(db.conn.QueryRow(query, project.Name, project.RepoURL, encAccessToken, project.PipelineFilename, project.DeploymentFilename,
+		project.SSHHost, project.SSHUser, encSSHPrivateKey, project.RegistryUser, encRegistryToken, pq.Array(project.ComposeProfiles), pq.Array(project.DeployTags), project.PostCloneCommand, encCloneSSHKey, project.GitLFS, project.RequireDeployment, encWebhookSecret, project.SecretScan, pq.Array(project.DeploymentStacks), project.HealthCheckURL, encSlackWebhookURL, encNotifyWebhookURL, project.GitSubmodules, project.ScheduleCron, project.ScheduleBranch, id))
 	if err != nil {
 		return nil, fmt.Errorf("failed to update project: %w", err)
 	}
 
-	// Restore plaintext values in returned object
-	p.AccessToken = project.AccessToken
-	p.SSHPrivateKey = project.SSHPrivateKey
-	p.RegistryToken = project.RegistryToken
+	return p, nil
+}
 
-	return &p, nil
+// SetProjectPaused pauses or resumes the triggers of a project
+func (db *DB) SetProjectPaused(id int, paused bool) error {
+	result, err := db.conn.Exec(`UPDATE projects SET paused = $1 WHERE id = $2`, paused, id)
+	if err != nil {
+		return fmt.Errorf("failed to update project paused flag: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("project not found")
+	}
+	return nil
 }
 
 // DeleteProject deletes a project by ID
@@ -444,63 +469,178 @@ func (db *DB) RemoveProjectMember(projectID, userID int) error {
 	return nil
 }
 
+// GetScheduledProjects retrieves the projects with a cron schedule
+func (db *DB) GetScheduledProjects() ([]models.Project, error) {
+	query := `SELECT ` + projectColumns + ` FROM projects p WHERE COALESCE(p.schedule_cron, '') <> '' ORDER BY p.id`
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scheduled projects: %w", err)
+	}
+	defer rows.Close()
+
+	var projects []models.Project
+	for rows.Next() {
+		p, err := db.scanProject(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan project: %w", err)
+		}
+		projects = append(projects, *p)
+	}
+	return projects, nil
+}
+
+// ClaimScheduledRun records runAt as the last scheduled run of a project
+// It reports false when a run at or after runAt was already recorded, so that a run fires once across restarts and servers
+func (db *DB) ClaimScheduledRun(projectID int, runAt time.Time) (bool, error) {
+	result, err := db.conn.Exec(`
+		UPDATE projects SET schedule_last_run = $2
+		WHERE id = $1 AND (schedule_last_run IS NULL OR schedule_last_run < $2)
+	`, projectID, runAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim scheduled run: %w", err)
+	}
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim scheduled run: %w", err)
+	}
+	return claimed == 1, nil
+}
+
 // ============== Pipeline Operations ==============
 
-// CreatePipeline creates a new pipeline in the database
-func (db *DB) CreatePipeline(projectID int, branch, commitHash string) (*models.Pipeline, error) {
-	query := `
-		INSERT INTO pipelines (project_id, status, branch, commit_hash)
-		VALUES ($1, 'pending', $2, $3)
-		RETURNING id, project_id, status, commit_hash, branch, created_at, finished_at
-	`
+// pipelineColumns lists the pipeline columns read by scanPipeline
+const pipelineColumns = `id, project_id, status, COALESCE(commit_hash, ''), COALESCE(branch, ''), COALESCE(commit_message, ''), COALESCE(commit_author_name, ''), COALESCE(commit_author_email, ''), COALESCE(labels, '{}'), coverage, created_at, started_at, finished_at, COALESCE(approval_stage, ''), approval_deadline, COALESCE(inline_config, ''), COALESCE(inline_deploy, FALSE), COALESCE(clone_log, ''), COALESCE(tag, ''), COALESCE(changed_files, '{}')`
+
+// scanPipeline scans a row selected with pipelineColumns
+func scanPipeline(row rowScanner) (*models.Pipeline, error) {
 	var p models.Pipeline
-	var finishedAt sql.NullTime
-	err := db.conn.QueryRow(query, projectID, branch, commitHash).
-		Scan(&p.ID, &p.ProjectID, &p.Status, &p.CommitHash, &p.Branch, &p.CreatedAt, &finishedAt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create pipeline: %w", err)
+	var coverage sql.NullFloat64
+	var startedAt, finishedAt, approvalDeadline sql.NullTime
+	if err := row.Scan(&p.ID, &p.ProjectID, &p.Status, &p.CommitHash, &p.Branch, &p.CommitMessage, &p.CommitAuthorName, &p.CommitAuthorEmail, pq.Array(&p.Labels), &coverage, &p.CreatedAt, &startedAt, &finishedAt, &p.ApprovalStage, &approvalDeadline, &p.InlineConfig, &p.InlineDeploy, &p.CloneLog, &p.Tag, pq.Array(&p.ChangedFiles)); err != nil {
+		return nil, err
+	}
+	if approvalDeadline.Valid {
+		p.ApprovalDeadline = &approvalDeadline.Time
+	}
+	if coverage.Valid {
+		p.Coverage = &coverage.Float64
+	}
+	if startedAt.Valid {
+		p.StartedAt = &startedAt.Time
 	}
 	if finishedAt.Valid {
 		p.FinishedAt = &finishedAt.Time
 	}
+	p.Duration = models.DurationSeconds(p.StartedAt, p.FinishedAt)
 	return &p, nil
 }
 
+// CreatePipeline creates a new pipeline in the database
+func (db *DB) CreatePipeline(projectID int, branch, commitHash string, labels []string) (*models.Pipeline, error) {
+	if labels == nil {
+		labels = []string{}
+	}
+	query := `
+		INSERT INTO pipelines (project_id, status, branch, commit_hash, labels)
+		VALUES ($1, 'pending', $2, $3, $4)
+		RETURNING ` + pipelineColumns
+	p, err := scanPipeline(db.conn.QueryRow(query, projectID, branch, commitHash, pq.Array(labels)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pipeline: %w", err)
+	}
+	return p, nil
+}
+
+// SetPipelineCommit records the message and author of the commit a pipeline runs
+func (db *DB) SetPipelineCommit(id int, message, authorName, authorEmail string) error {
+	query := `UPDATE pipelines SET commit_message = $1, commit_author_name = $2, commit_author_email = $3 WHERE id = $4`
+	if _, err := db.conn.Exec(query, message, authorName, authorEmail, id); err != nil {
+		return fmt.Errorf("failed to set pipeline commit: %w", err)
+	}
+	return nil
+}
+
+// SetPipelineTag records that a pipeline runs a tag rather than a branch
+func (db *DB) SetPipelineTag(id int, tag string) error {
+	query := `UPDATE pipelines SET tag = $1 WHERE id = $2`
+	if _, err := db.conn.Exec(query, tag, id); err != nil {
+		return fmt.Errorf("failed to set pipeline tag: %w", err)
+	}
+	return nil
+}
+
+// SetPipelineChangedFiles records the files changed by the push a pipeline runs
+func (db *DB) SetPipelineChangedFiles(id int, files []string) error {
+	query := `UPDATE pipelines SET changed_files = $1 WHERE id = $2`
+	if _, err := db.conn.Exec(query, pq.Array(files), id); err != nil {
+		return fmt.Errorf("failed to set pipeline changed files: %w", err)
+	}
+	return nil
+}
+
+// SetPipelineCloneLog records the output of the clone of a pipeline
+func (db *DB) SetPipelineCloneLog(id int, output string) error {
+	query := `UPDATE pipelines SET clone_log = $1 WHERE id = $2`
+	if _, err := db.conn.Exec(query, output, id); err != nil {
+		return fmt.Errorf("failed to set pipeline clone log: %w", err)
+	}
+	return nil
+}
+
+// SetPipelineInlineConfig records the inline CI config of a manual trigger and whether it deploys
+func (db *DB) SetPipelineInlineConfig(id int, config string, deploy bool) error {
+	query := `UPDATE pipelines SET inline_config = $1, inline_deploy = $2 WHERE id = $3`
+	if _, err := db.conn.Exec(query, config, deploy, id); err != nil {
+		return fmt.Errorf("failed to set pipeline inline config: %w", err)
+	}
+	return nil
+}
+
 // GetPipeline retrieves a pipeline by ID
 func (db *DB) GetPipeline(id int) (*models.Pipeline, error) {
-	query := `SELECT id, project_id, status, commit_hash, branch, created_at, finished_at FROM pipelines WHERE id = $1`
-	var p models.Pipeline
-	var finishedAt sql.NullTime
-	var commitHash, branch sql.NullString
-	err := db.conn.QueryRow(query, id).
-		Scan(&p.ID, &p.ProjectID, &p.Status, &commitHash, &branch, &p.CreatedAt, &finishedAt)
+	query := `SELECT ` + pipelineColumns + ` FROM pipelines WHERE id = $1`
+	p, err := scanPipeline(db.conn.QueryRow(query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("pipeline not found")
 		}
 		return nil, fmt.Errorf("failed to get pipeline: %w", err)
 	}
-	if finishedAt.Valid {
-		p.FinishedAt = &finishedAt.Time
-	}
-	if commitHash.Valid {
-		p.CommitHash = commitHash.String
-	}
-	if branch.Valid {
-		p.Branch = branch.String
-	}
-	return &p, nil
+	return p, nil
 }
 
-// GetPipelinesByProject retrieves all pipelines for a project
-func (db *DB) GetPipelinesByProject(projectID int) ([]models.Pipeline, error) {
+// GetPipelinesByProject retrieves the pipelines of a project matching the filter
+func (db *DB) GetPipelinesByProject(projectID int, filter models.PipelineFilter) ([]models.Pipeline, error) {
 	query := `
-		SELECT id, project_id, status, commit_hash, branch, created_at, finished_at
+		SELECT ` + pipelineColumns + `
 		FROM pipelines
 		WHERE project_id = $1
-		ORDER BY created_at DESC
 	`
-	rows, err := db.conn.Query(query, projectID)
+	args := []interface{}{projectID}
+	if filter.Label != "" {
+		args = append(args, filter.Label)
+		query += fmt.Sprintf(" AND $%d = ANY(labels)", len(args))
+	}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		query += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if filter.Branch != "" {
+		args = append(args, filter.Branch)
+		query += fmt.Sprintf(" AND branch = $%d", len(args))
+	}
+	// The id breaks ties between pipelines created together, pages do not overlap
+	query += " ORDER BY created_at DESC, id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	rows, err := db.conn.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query pipelines: %w", err)
 	}
@@ -508,56 +648,63 @@ func (db *DB) GetPipelinesByProject(projectID int) ([]models.Pipeline, error) {
 
 	var pipelines []models.Pipeline
 	for rows.Next() {
-		var p models.Pipeline
-		var finishedAt sql.NullTime
-		var commitHash, branch sql.NullString
-		if err := rows.Scan(&p.ID, &p.ProjectID, &p.Status, &commitHash, &branch, &p.CreatedAt, &finishedAt); err != nil {
+		p, err := scanPipeline(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan pipeline: %w", err)
 		}
-		if finishedAt.Valid {
-			p.FinishedAt = &finishedAt.Time
-		}
-		if commitHash.Valid {
-			p.CommitHash = commitHash.String
-		}
-		if branch.Valid {
-			p.Branch = branch.String
-		}
-		pipelines = append(pipelines, p)
+		pipelines = append(pipelines, *p)
 	}
 	return pipelines, nil
 }
 
+// GetActivePipelineIDs returns the IDs of the pipelines of a project that have not reached a terminal status
+func (db *DB) GetActivePipelineIDs(projectID int) ([]int, error) {
+	query := `SELECT id FROM pipelines WHERE project_id = $1 AND finished_at IS NULL ORDER BY id ASC`
+	rows, err := db.conn.Query(query, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query active pipelines: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan pipeline id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
 // UpdatePipelineStatus updates the status of a pipeline
 // GetLastSuccessfulPipeline retrieves the last successful pipeline for a project
 func (db *DB) GetLastSuccessfulPipeline(projectID int) (*models.Pipeline, error) {
 	query := `
-		SELECT id, project_id, status, commit_hash, branch, created_at, finished_at
+		SELECT ` + pipelineColumns + `
 		FROM pipelines
 		WHERE project_id = $1 AND status = 'success'
 		ORDER BY id DESC
 		LIMIT 1
 	`
-	var p models.Pipeline
-	var finishedAt sql.NullTime
-	err := db.conn.QueryRow(query, projectID).
-		Scan(&p.ID, &p.ProjectID, &p.Status, &p.CommitHash, &p.Branch, &p.CreatedAt, &finishedAt)
+	p, err := scanPipeline(db.conn.QueryRow(query, projectID))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("failed to get last successful pipeline: %w", err)
 	}
-	if finishedAt.Valid {
-		p.FinishedAt = &finishedAt.Time
-	}
-	return &p, nil
+	return p, nil
 }
 
 func (db *DB) UpdatePipelineStatus(id int, status string) error {
 	var query string
-	if status == "success" || status == "failed" || status == "cancelled" {
+	if status == "success" || status == "failed" || status == "cancelled" || status == "superseded" {
+		// A pipeline cancelled before it started keeps started_at empty
 		query = `UPDATE pipelines SET status = $1, finished_at = CURRENT_TIMESTAMP WHERE id = $2`
+	} else if status == "running" {
+		// A queued pipeline is timed from the moment it leaves the queue
+		query = `UPDATE pipelines SET status = $1, started_at = CURRENT_TIMESTAMP WHERE id = $2`
 	} else {
 		query = `UPDATE pipelines SET status = $1 WHERE id = $2`
 	}
@@ -568,91 +715,145 @@ func (db *DB) UpdatePipelineStatus(id int, status string) error {
 	return nil
 }
 
-// ============== Job Operations ==============
+// SetPipelineApproval puts a pipeline in the waiting_for_approval status at a gate, a stage or "deployment"
+func (db *DB) SetPipelineApproval(id int, stage string, deadline time.Time) error {
+	query := `UPDATE pipelines SET status = 'waiting_for_approval', approval_stage = $1, approval_deadline = $2 WHERE id = $3`
+	if _, err := db.conn.Exec(query, stage, deadline, id); err != nil {
+		return fmt.Errorf("failed to set pipeline approval: %w", err)
+	}
+	return nil
+}
 
-// CreateJob creates a new job in the database
-func (db *DB) CreateJob(pipelineID int, name, stage, image string) (*models.Job, error) {
+// EndPipelineApproval clears the gate of a pipeline, a pipeline still waiting goes back to running
+func (db *DB) EndPipelineApproval(id int) error {
 	query := `
-		INSERT INTO jobs (pipeline_id, name, stage, image, status)
-		VALUES ($1, $2, $3, $4, 'pending')
-		RETURNING id, pipeline_id, name, stage, image, status, exit_code, started_at, finished_at
+		UPDATE pipelines
+		SET status = CASE WHEN status = 'waiting_for_approval' THEN 'running' ELSE status END,
+			approval_stage = NULL, approval_deadline = NULL
+		WHERE id = $1
+	`
+	if _, err := db.conn.Exec(query, id); err != nil {
+		return fmt.Errorf("failed to end pipeline approval: %w", err)
+	}
+	return nil
+}
+
+// FailExpiredWaitingPipelines fails the pipelines a stopped server left waiting for an approval past its deadline
+func (db *DB) FailExpiredWaitingPipelines() (int64, error) {
+	query := `
+		UPDATE pipelines
+		SET status = 'failed', finished_at = CURRENT_TIMESTAMP, approval_stage = NULL, approval_deadline = NULL
+		WHERE status = 'waiting_for_approval' AND approval_deadline < CURRENT_TIMESTAMP
 	`
+	result, err := db.conn.Exec(query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fail expired waiting pipelines: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// GetWaitingPipelines retrieves the pipelines waiting for an approval, oldest first
+func (db *DB) GetWaitingPipelines() ([]models.Pipeline, error) {
+	query := `SELECT ` + pipelineColumns + ` FROM pipelines WHERE status = 'waiting_for_approval' ORDER BY id`
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get waiting pipelines: %w", err)
+	}
+	defer rows.Close()
+
+	var pipelines []models.Pipeline
+	for rows.Next() {
+		p, err := scanPipeline(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan pipeline: %w", err)
+		}
+		pipelines = append(pipelines, *p)
+	}
+	return pipelines, rows.Err()
+}
+
+// UpdatePipelineCoverage stores the aggregated coverage of a pipeline
+func (db *DB) UpdatePipelineCoverage(id int, coverage float64) error {
+	_, err := db.conn.Exec(`UPDATE pipelines SET coverage = $1 WHERE id = $2`, coverage, id)
+	if err != nil {
+		return fmt.Errorf("failed to update pipeline coverage: %w", err)
+	}
+	return nil
+}
+
+// ============== Job Operations ==============
+
+// jobColumns lists the job columns read by scanJob
+const jobColumns = `id, pipeline_id, name, stage, image, status, exit_code, coverage, COALESCE(executor,''), COALESCE(allow_failure, FALSE), COALESCE(attempt, 1), COALESCE(image_digest, ''), started_at, finished_at`
+
+// scanJob scans a row selected with jobColumns
+func scanJob(row rowScanner) (*models.Job, error) {
 	var j models.Job
 	var exitCode sql.NullInt64
+	var coverage sql.NullFloat64
 	var startedAt, finishedAt sql.NullTime
-	err := db.conn.QueryRow(query, pipelineID, name, stage, image).
-		Scan(&j.ID, &j.PipelineID, &j.Name, &j.Stage, &j.Image, &j.Status, &exitCode, &startedAt, &finishedAt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create job: %w", err)
+	if err := row.Scan(&j.ID, &j.PipelineID, &j.Name, &j.Stage, &j.Image, &j.Status, &exitCode, &coverage, &j.Executor, &j.AllowFailure, &j.Attempt, &j.ImageDigest, &startedAt, &finishedAt); err != nil {
+		return nil, err
 	}
 	if exitCode.Valid {
 		j.ExitCode = int(exitCode.Int64)
 	}
+	if coverage.Valid {
+		j.Coverage = &coverage.Float64
+	}
 	if startedAt.Valid {
 		j.StartedAt = &startedAt.Time
 	}
 	if finishedAt.Valid {
 		j.FinishedAt = &finishedAt.Time
 	}
+	j.Duration = models.DurationSeconds(j.StartedAt, j.FinishedAt)
 	return &j, nil
 }
 
+// CreateJob creates a new job in the database
+func (db *DB) CreateJob(pipelineID int, name, stage, image string) (*models.Job, error) {
+	query := `
+		INSERT INTO jobs (pipeline_id, name, stage, image, status)
+		VALUES ($1, $2, $3, $4, 'pending')
+		RETURNING ` + jobColumns
+	j, err := scanJob(db.conn.QueryRow(query, pipelineID, name, stage, image))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job: %w", err)
+	}
+	return j, nil
+}
+
 // GetJob retrieves a job by ID
 func (db *DB) GetJob(id int) (*models.Job, error) {
-	query := `SELECT id, pipeline_id, name, stage, image, status, exit_code, started_at, finished_at FROM jobs WHERE id = $1`
-	var j models.Job
-	var exitCode sql.NullInt64
-	var startedAt, finishedAt sql.NullTime
-	err := db.conn.QueryRow(query, id).
-		Scan(&j.ID, &j.PipelineID, &j.Name, &j.Stage, &j.Image, &j.Status, &exitCode, &startedAt, &finishedAt)
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE id = $1`
+	j, err := scanJob(db.conn.QueryRow(query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("job not found")
 		}
 		return nil, fmt.Errorf("failed to get job: %w", err)
 	}
-	if exitCode.Valid {
-		j.ExitCode = int(exitCode.Int64)
-	}
-	if startedAt.Valid {
-		j.StartedAt = &startedAt.Time
-	}
-	if finishedAt.Valid {
-		j.FinishedAt = &finishedAt.Time
-	}
-	return &j, nil
+	return j, nil
 }
 
 // GetJobByName retrieves a job by pipeline ID and name
 func (db *DB) GetJobByName(pipelineID int, name string) (*models.Job, error) {
-	query := `SELECT id, pipeline_id, name, stage, image, status, exit_code, started_at, finished_at FROM jobs WHERE pipeline_id = $1 AND name = $2`
-	var j models.Job
-	var exitCode sql.NullInt64
-	var startedAt, finishedAt sql.NullTime
-	err := db.conn.QueryRow(query, pipelineID, name).
-		Scan(&j.ID, &j.PipelineID, &j.Name, &j.Stage, &j.Image, &j.Status, &exitCode, &startedAt, &finishedAt)
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE pipeline_id = $1 AND name = $2`
+	j, err := scanJob(db.conn.QueryRow(query, pipelineID, name))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("job not found")
 		}
 		return nil, fmt.Errorf("failed to get job: %w", err)
 	}
-	if exitCode.Valid {
-		j.ExitCode = int(exitCode.Int64)
-	}
-	if startedAt.Valid {
-		j.StartedAt = &startedAt.Time
-	}
-	if finishedAt.Valid {
-		j.FinishedAt = &finishedAt.Time
-	}
-	return &j, nil
+	return j, nil
 }
 
 // GetJobsByPipeline retrieves all jobs for a pipeline
 func (db *DB) GetJobsByPipeline(pipelineID int) ([]models.Job, error) {
 	query := `
-		SELECT id, pipeline_id, name, stage, image, status, exit_code, started_at, finished_at
+		SELECT ` + jobColumns + `
 		FROM jobs
 		WHERE pipeline_id = $1
 		ORDER BY id ASC
@@ -665,22 +866,11 @@ func (db *DB) GetJobsByPipeline(pipelineID int) ([]models.Job, error) {
 
 	var jobs []models.Job
 	for rows.Next() {
-		var j models.Job
-		var exitCode sql.NullInt64
-		var startedAt, finishedAt sql.NullTime
-		if err := rows.Scan(&j.ID, &j.PipelineID, &j.Name, &j.Stage, &j.Image, &j.Status, &exitCode, &startedAt, &finishedAt); err != nil {
+		j, err := scanJob(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan job: %w", err)
 		}
-		if exitCode.Valid {
-			j.ExitCode = int(exitCode.Int64)
-		}
-		if startedAt.Valid {
-			j.StartedAt = &startedAt.Time
-		}
-		if finishedAt.Valid {
-			j.FinishedAt = &finishedAt.Time
-		}
-		jobs = append(jobs, j)
+		jobs = append(jobs, *j)
 	}
 	return jobs, nil
 }
@@ -700,6 +890,9 @@ func (db *DB) UpdateJobStatus(id int, status string, exitCode *int) error {
 			ec = *exitCode
 		}
 		args = []interface{}{status, ec, id}
+	} else if status == "cancelled" {
+		query = `UPDATE jobs SET status = $1, finished_at = CURRENT_TIMESTAMP WHERE id = $2`
+		args = []interface{}{status, id}
 	} else {
 		query = `UPDATE jobs SET status = $1 WHERE id = $2`
 		args = []interface{}{status, id}
@@ -712,99 +905,216 @@ func (db *DB) UpdateJobStatus(id int, status string, exitCode *int) error {
 	return nil
 }
 
+// UpdateJobCoverage stores the coverage extracted from the logs of a job
+func (db *DB) UpdateJobCoverage(id int, coverage float64) error {
+	_, err := db.conn.Exec(`UPDATE jobs SET coverage = $1 WHERE id = $2`, coverage, id)
+	if err != nil {
+		return fmt.Errorf("failed to update job coverage: %w", err)
+	}
+	return nil
+}
+
+// DeleteJobsByPipeline removes the jobs of a pipeline and their logs
+func (db *DB) DeleteJobsByPipeline(pipelineID int) error {
+	_, err := db.conn.Exec(`DELETE FROM jobs WHERE pipeline_id = $1`, pipelineID)
+	if err != nil {
+		return fmt.Errorf("failed to delete jobs: %w", err)
+	}
+	return nil
+}
+
+// SetJobExecutor records the executor node running a job
+func (db *DB) SetJobExecutor(id int, executor string) error {
+	_, err := db.conn.Exec(`UPDATE jobs SET executor = $1 WHERE id = $2`, executor, id)
+	if err != nil {
+		return fmt.Errorf("failed to update job executor: %w", err)
+	}
+	return nil
+}
+
+// SetJobAllowFailure records that a failure of the job does not fail its pipeline
+func (db *DB) SetJobAllowFailure(id int, allowFailure bool) error {
+	_, err := db.conn.Exec(`UPDATE jobs SET allow_failure = $1 WHERE id = $2`, allowFailure, id)
+	if err != nil {
+		return fmt.Errorf("failed to update job allow_failure: %w", err)
+	}
+	return nil
+}
+
+// SetJobImageDigest records the digest of the image pulled for a job
+func (db *DB) SetJobImageDigest(id int, digest string) error {
+	_, err := db.conn.Exec(`UPDATE jobs SET image_digest = $1 WHERE id = $2`, digest, id)
+	if err != nil {
+		return fmt.Errorf("failed to update job image digest: %w", err)
+	}
+	return nil
+}
+
+// SetJobAttempt records the attempt of a job being run, 1 for its first run
+func (db *DB) SetJobAttempt(id int, attempt int) error {
+	_, err := db.conn.Exec(`UPDATE jobs SET attempt = $1 WHERE id = $2`, attempt, id)
+	if err != nil {
+		return fmt.Errorf("failed to update job attempt: %w", err)
+	}
+	return nil
+}
+
 // ============== Log Operations ==============
 
+// logColumns lists the job log columns read by scanLogLine
+const logColumns = `id, job_id, COALESCE(line_number, 0), COALESCE(stream, 'stdout'), content, created_at`
+
+// scanLogLine scans a row selected with logColumns
+func scanLogLine(row rowScanner) (*models.LogLine, error) {
+	var l models.LogLine
+	if err := row.Scan(&l.ID, &l.JobID, &l.LineNumber, &l.Stream, &l.Content, &l.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// queryLogs runs a job log query and scans the resulting lines
+func (db *DB) queryLogs(query string, args ...interface{}) ([]models.LogLine, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query logs: %w", err)
+	}
+	defer rows.Close()
+
+	var logs []models.LogLine
+	for rows.Next() {
+		l, err := scanLogLine(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan log: %w", err)
+		}
+		logs = append(logs, *l)
+	}
+	return logs, nil
+}
+
+// lockJobLogs serializes the writers of the logs of a job until the end of tx
+// Lines are numbered from the last stored one, two writers must not read the same
+func lockJobLogs(tx *sql.Tx, jobID int) error {
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('job_logs'), $1)`, jobID); err != nil {
+		return fmt.Errorf("failed to lock job logs: %w", err)
+	}
+	return nil
+}
+
 // CreateLog creates a new log entry for a job
 func (db *DB) CreateLog(jobID int, content string) (*models.LogLine, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockJobLogs(tx, jobID); err != nil {
+		return nil, err
+	}
+
 	query := `
-		INSERT INTO job_logs (job_id, content)
-		VALUES ($1, $2)
-		RETURNING id, job_id, content, created_at
-	`
-	var l models.LogLine
-	err := db.conn.QueryRow(query, jobID, content).
-		Scan(&l.ID, &l.JobID, &l.Content, &l.CreatedAt)
+		INSERT INTO job_logs (job_id, content, line_number)
+		VALUES ($1, $2, (SELECT COALESCE(MAX(line_number), 0) + 1 FROM job_logs WHERE job_id = $1))
+		RETURNING ` + logColumns
+	l, err := scanLogLine(tx.QueryRow(query, jobID, content))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create log: %w", err)
 	}
-	return &l, nil
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return l, nil
 }
 
 // CreateLogBatch creates multiple log entries for a job in a single transaction
-func (db *DB) CreateLogBatch(jobID int, contents []string) error {
+// Lines are numbered sequentially per job, starting at 1, and keep their stream (stdout by default)
+// It returns the stored lines, with their number
+func (db *DB) CreateLogBatch(jobID int, lines []models.LogLine) ([]models.LogLine, error) {
 	tx, err := db.conn.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(`INSERT INTO job_logs (job_id, content) VALUES ($1, $2)`)
+	if err := lockJobLogs(tx, jobID); err != nil {
+		return nil, err
+	}
+
+	var lastLine int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(line_number), 0) FROM job_logs WHERE job_id = $1`, jobID).Scan(&lastLine); err != nil {
+		return nil, fmt.Errorf("failed to get last log line: %w", err)
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO job_logs (job_id, content, line_number, stream) VALUES ($1, $2, $3, $4) RETURNING ` + logColumns)
 	if err != nil {
-		return fmt.Errorf("failed to prepare statement: %w", err)
+		return nil, fmt.Errorf("failed to prepare statement: %w", err)
 	}
 	defer stmt.Close()
 
-	for _, content := range contents {
-		_, err := stmt.Exec(jobID, content)
+	stored := make([]models.LogLine, 0, len(lines))
+	for i, line := range lines {
+		stream := line.Stream
+		if stream == "" {
+			stream = models.LogStreamStdout
+		}
+		l, err := scanLogLine(stmt.QueryRow(jobID, line.Content, lastLine+i+1, stream))
 		if err != nil {
-			return fmt.Errorf("failed to insert log: %w", err)
+			return nil, fmt.Errorf("failed to insert log: %w", err)
 		}
+		stored = append(stored, *l)
 	}
 
 	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
-	return nil
+	return stored, nil
 }
 
 // GetLogsByJob retrieves all logs for a job
 func (db *DB) GetLogsByJob(jobID int) ([]models.LogLine, error) {
 	query := `
-		SELECT id, job_id, content, created_at
+		SELECT ` + logColumns + `
 		FROM job_logs
 		WHERE job_id = $1
-		ORDER BY created_at ASC, id ASC
+		ORDER BY line_number ASC, id ASC
 	`
-	rows, err := db.conn.Query(query, jobID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query logs: %w", err)
-	}
-	defer rows.Close()
+	return db.queryLogs(query, jobID)
+}
 
-	var logs []models.LogLine
-	for rows.Next() {
-		var l models.LogLine
-		if err := rows.Scan(&l.ID, &l.JobID, &l.Content, &l.CreatedAt); err != nil {
-			return nil, fmt.Errorf("failed to scan log: %w", err)
-		}
-		logs = append(logs, l)
-	}
-	return logs, nil
+// GetLogsAfter retrieves the logs of a job stored after the given line number (for resuming a stream)
+func (db *DB) GetLogsAfter(jobID, afterLine int) ([]models.LogLine, error) {
+	query := `
+		SELECT ` + logColumns + `
+		FROM job_logs
+		WHERE job_id = $1 AND line_number > $2
+		ORDER BY line_number ASC
+	`
+	return db.queryLogs(query, jobID, afterLine)
+}
+
+// GetLogsPage retrieves at most limit logs of a job stored after the given line number (for paging through the logs)
+func (db *DB) GetLogsPage(jobID, afterLine, limit int) ([]models.LogLine, error) {
+	query := `
+		SELECT ` + logColumns + `
+		FROM job_logs
+		WHERE job_id = $1 AND line_number > $2
+		ORDER BY line_number ASC, id ASC
+		LIMIT $3
+	`
+	return db.queryLogs(query, jobID, afterLine, limit)
 }
 
 // GetLogsSince retrieves logs for a job since a given timestamp (for streaming)
 func (db *DB) GetLogsSince(jobID int, since time.Time) ([]models.LogLine, error) {
 	query := `
-		SELECT id, job_id, content, created_at
+		SELECT ` + logColumns + `
 		FROM job_logs
 		WHERE job_id = $1 AND created_at > $2
 		ORDER BY created_at ASC, id ASC
 	`
-	rows, err := db.conn.Query(query, jobID, since)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query logs: %w", err)
-	}
-	defer rows.Close()
-
-	var logs []models.LogLine
-	for rows.Next() {
-		var l models.LogLine
-		if err := rows.Scan(&l.ID, &l.JobID, &l.Content, &l.CreatedAt); err != nil {
-			return nil, fmt.Errorf("failed to scan log: %w", err)
-		}
-		logs = append(logs, l)
-	}
-	return logs, nil
+	return db.queryLogs(query, jobID, since)
 }
 
 // ============== Deployment Operations ==============
@@ -827,10 +1137,23 @@ func (db *DB) CreateDeployment(pipelineID int) (*models.Deployment, error) {
 	return &d, nil
 }
 
+// CreateStackDeployment creates the deployment of one stack of a pipeline deploying several compose files
+func (db *DB) CreateStackDeployment(pipelineID int, stack string) (*models.Deployment, error) {
+	query := `
+		INSERT INTO deployments (pipeline_id, status, stack)
+		VALUES ($1, 'deploying', $2)
+		RETURNING ` + deploymentColumns
+	d, err := scanDeployment(db.conn.QueryRow(query, pipelineID, stack))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stack deployment: %w", err)
+	}
+	return d, nil
+}
+
 // UpdateDeploymentStatus updates the status of a deployment
 func (db *DB) UpdateDeploymentStatus(id int, status string) error {
 	var query string
-	if status == "success" || status == "failed" || status == "rolled_back" {
+	if status == "success" || status == "failed" || status == "rolled_back" || status == "cancelled" {
 		query = `UPDATE deployments SET status = $1, finished_at = CURRENT_TIMESTAMP WHERE id = $2`
 	} else if status == "deploying" {
 		query = `UPDATE deployments SET status = $1, started_at = CURRENT_TIMESTAMP WHERE id = $2`
@@ -844,18 +1167,25 @@ func (db *DB) UpdateDeploymentStatus(id int, status string) error {
 	return nil
 }
 
-// GetDeploymentByPipeline retrieves the deployment for a pipeline
-func (db *DB) GetDeploymentByPipeline(pipelineID int) (*models.Deployment, error) {
-	query := `SELECT id, pipeline_id, status, started_at, finished_at FROM deployments WHERE pipeline_id = $1`
+// SetDeploymentExitCode records the exit code of the compose command of a deployment
+func (db *DB) SetDeploymentExitCode(id int, exitCode int) error {
+	_, err := db.conn.Exec(`UPDATE deployments SET exit_code = $1 WHERE id = $2`, exitCode, id)
+	if err != nil {
+		return fmt.Errorf("failed to set deployment exit code: %w", err)
+	}
+	return nil
+}
+
+// deploymentColumns lists the deployment columns read by scanDeployment
+const deploymentColumns = `id, pipeline_id, status, started_at, finished_at, exit_code, stack`
+
+// scanDeployment scans a row selected with deploymentColumns
+func scanDeployment(row rowScanner) (*models.Deployment, error) {
 	var d models.Deployment
 	var startedAt, finishedAt sql.NullTime
-	err := db.conn.QueryRow(query, pipelineID).
-		Scan(&d.ID, &d.PipelineID, &d.Status, &startedAt, &finishedAt)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // Return nil if no deployment found
-		}
-		return nil, fmt.Errorf("failed to get deployment: %w", err)
+	var exitCode sql.NullInt64
+	if err := row.Scan(&d.ID, &d.PipelineID, &d.Status, &startedAt, &finishedAt, &exitCode, &d.Stack); err != nil {
+		return nil, err
 	}
 	if startedAt.Valid {
 		d.StartedAt = &startedAt.Time
@@ -863,9 +1193,48 @@ func (db *DB) GetDeploymentByPipeline(pipelineID int) (*models.Deployment, error
 	if finishedAt.Valid {
 		d.FinishedAt = &finishedAt.Time
 	}
+	if exitCode.Valid {
+		code := int(exitCode.Int64)
+		d.ExitCode = &code
+	}
+	d.Duration = models.DurationSeconds(d.StartedAt, d.FinishedAt)
 	return &d, nil
 }
 
+// GetDeploymentByPipeline retrieves the deployment for a pipeline
+// The deployments of its stacks are left out, see GetStackDeployments
+func (db *DB) GetDeploymentByPipeline(pipelineID int) (*models.Deployment, error) {
+	query := `SELECT ` + deploymentColumns + ` FROM deployments WHERE pipeline_id = $1 AND stack = '' ORDER BY id ASC LIMIT 1`
+	d, err := scanDeployment(db.conn.QueryRow(query, pipelineID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Return nil if no deployment found
+		}
+		return nil, fmt.Errorf("failed to get deployment: %w", err)
+	}
+	return d, nil
+}
+
+// GetStackDeployments retrieves the deployments of the stacks of a pipeline, in the order they ran
+func (db *DB) GetStackDeployments(pipelineID int) ([]models.Deployment, error) {
+	query := `SELECT ` + deploymentColumns + ` FROM deployments WHERE pipeline_id = $1 AND stack <> '' ORDER BY id ASC`
+	rows, err := db.conn.Query(query, pipelineID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stack deployments: %w", err)
+	}
+	defer rows.Close()
+
+	var deployments []models.Deployment
+	for rows.Next() {
+		d, err := scanDeployment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan stack deployment: %w", err)
+		}
+		deployments = append(deployments, *d)
+	}
+	return deployments, rows.Err()
+}
+
 // CreateDeploymentLog creates a new log entry for a deployment
 func (db *DB) CreateDeploymentLog(pipelineID int, content string) error {
 	query := `INSERT INTO deployment_logs (pipeline_id, content) VALUES ($1, $2)`
@@ -908,16 +1277,16 @@ func (db *DB) CreateVariable(v *models.Variable) error {
 	}
 
 	query := `
-		INSERT INTO variables (project_id, key, value, is_secret)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO variables (project_id, key, value, is_secret, masked)
+		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id, created_at
 	`
-	return db.conn.QueryRow(query, v.ProjectID, v.Key, encryptedValue, v.IsSecret).Scan(&v.ID, &v.CreatedAt)
+	return db.conn.QueryRow(query, v.ProjectID, v.Key, encryptedValue, v.IsSecret, v.Masked).Scan(&v.ID, &v.CreatedAt)
 }
 
 func (db *DB) GetVariablesByProject(projectID int) ([]models.Variable, error) {
 	query := `
-		SELECT id, project_id, key, value, is_secret, created_at
+		SELECT id, project_id, key, value, is_secret, COALESCE(masked, FALSE), created_at
 		FROM variables
 		WHERE project_id = $1
 	`
@@ -930,7 +1299,7 @@ func (db *DB) GetVariablesByProject(projectID int) ([]models.Variable, error) {
 	var variables []models.Variable
 	for rows.Next() {
 		var v models.Variable
-		if err := rows.Scan(&v.ID, &v.ProjectID, &v.Key, &v.Value, &v.IsSecret, &v.CreatedAt); err != nil {
+		if err := rows.Scan(&v.ID, &v.ProjectID, &v.Key, &v.Value, &v.IsSecret, &v.Masked, &v.CreatedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan variable: %w", err)
 		}
 
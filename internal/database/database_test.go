@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// fakeRow returns fixed values to Scan, in the order of the selected columns
+type fakeRow []interface{}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	for i, d := range dest {
+		switch d := d.(type) {
+		case *int:
+			*d = r[i].(int)
+		case *string:
+			*d = r[i].(string)
+		case *bool:
+			*d = r[i].(bool)
+		case *sql.NullTime:
+			if t, ok := r[i].(time.Time); ok {
+				*d = sql.NullTime{Time: t, Valid: true}
+			}
+		default:
+			// Nullable columns are left NULL
+		}
+	}
+	return nil
+}
+
+func TestScanJobExecutor(t *testing.T) {
+	// id, pipeline_id, name, stage, image, status, exit_code, coverage, executor, allow_failure, attempt, image_digest, started_at, finished_at
+	row := fakeRow{1, 2, "build", "build", "alpine", "running", nil, nil, "runner-1", true, 2, "sha256:0123", nil, nil}
+
+	job, err := scanJob(row)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if job.Executor != "runner-1" {
+		t.Errorf("Expected executor 'runner-1' on the job record, got %q", job.Executor)
+	}
+	if job.Name != "build" || job.Status != "running" {
+		t.Errorf("Expected job build/running, got %s/%s", job.Name, job.Status)
+	}
+	if !job.AllowFailure || job.Attempt != 2 {
+		t.Errorf("Expected allow_failure and attempt 2, got %v/%d", job.AllowFailure, job.Attempt)
+	}
+	if job.ImageDigest != "sha256:0123" {
+		t.Errorf("Expected the image digest on the job record, got %q", job.ImageDigest)
+	}
+}
+
+func TestScanJobDuration(t *testing.T) {
+	startedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	row := fakeRow{1, 2, "build", "build", "alpine", "failed", nil, nil, "", false, 1, "", startedAt, startedAt.Add(90 * time.Second)}
+
+	job, err := scanJob(row)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if job.Duration == nil || *job.Duration != 90 {
+		t.Errorf("Expected a 90s duration, got %v", job.Duration)
+	}
+
+	// A job that has not finished has no duration yet
+	row = fakeRow{1, 2, "build", "build", "alpine", "running", nil, nil, "", false, 1, "", startedAt, nil}
+	if job, _ := scanJob(row); job.StartedAt == nil || job.Duration != nil {
+		t.Errorf("Expected a started job without duration, got %v/%v", job.StartedAt, job.Duration)
+	}
+}
+
+func TestScanPipelineCommit(t *testing.T) {
+	// id, project_id, status, commit_hash, branch, commit_message, commit_author_name, commit_author_email, labels, coverage, created_at, started_at, finished_at, approval_stage, approval_deadline, inline_config, inline_deploy, clone_log, tag, changed_files
+	row := fakeRow{1, 2, "success", "abc123", "main", "Fix the login page", "Jane Doe", "jane@example.com", nil, nil, nil, nil, nil, "", nil, "", false, "", "", nil}
+	p, err := scanPipeline(row)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if p.CommitMessage != "Fix the login page" || p.CommitAuthorName != "Jane Doe" || p.CommitAuthorEmail != "jane@example.com" {
+		t.Errorf("Expected the commit of the pipeline, got %q by %q <%s>", p.CommitMessage, p.CommitAuthorName, p.CommitAuthorEmail)
+	}
+}
+
+func TestScanPipelineInlineConfig(t *testing.T) {
+	row := fakeRow{1, 2, "pending", "abc123", "main", "", "", "", nil, nil, nil, nil, nil, "", nil, "stages: [test]\n", true, "", "", nil}
+	p, err := scanPipeline(row)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// A resumed run reads the inline config back from the pipeline row
+	if p.InlineConfig != "stages: [test]\n" || !p.InlineDeploy {
+		t.Errorf("Expected the inline config and its deploy flag, got %q/%v", p.InlineConfig, p.InlineDeploy)
+	}
+}
@@ -4,23 +4,47 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	cerrdefs "github.com/containerd/errdefs"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/client"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/env"
 )
 
 type DockerExecutor struct {
-	cli        *client.Client
+	cli *client.Client
+	// ctx is used by the deployment and registry operations, the job operations take the context of their caller
 	ctx        context.Context
 	authConfig string
+	// registryAuth holds the credentials pulling images of private registries, by host (REGISTRY_AUTH_FILE)
+	registryAuth map[string]PullSecret
+	// workspaceMode is how workspaces reach job containers, bind or copy (DOCKER_WORKSPACE_MODE)
+	workspaceMode string
+	// remote is set when DOCKER_HOST points to another machine
+	remote bool
+	// pullAttempts bounds the attempts of an image pull failing with a transient error (IMAGE_PULL_MAX_ATTEMPTS)
+	pullAttempts int
+	// pullRetryDelay is the delay before the first retry of a pull, doubled at each retry (IMAGE_PULL_RETRY_DELAY_SECONDS)
+	pullRetryDelay time.Duration
+	// snapshots holds the workspace snapshot of the job containers the workspace was copied into, by container ID
+	snapshots sync.Map
+	// copyBackLocks serializes the copies back to a workspace, by workspace path
+	copyBackLocks sync.Map
 }
 
 func NewDockerExecutor() (*DockerExecutor, error) {
@@ -29,23 +53,39 @@ func NewDockerExecutor() (*DockerExecutor, error) {
 	if err != nil {
 		return nil, err
 	}
+	host := os.Getenv(client.EnvOverrideHost)
 	return &DockerExecutor{
-		cli: cli,
-		ctx: context.Background(),
+		cli:            cli,
+		ctx:            context.Background(),
+		registryAuth:   loadRegistryAuth(env.String("REGISTRY_AUTH_FILE", "")),
+		workspaceMode:  workspaceMode(env.String("DOCKER_WORKSPACE_MODE", WorkspaceAuto), host),
+		remote:         remoteDaemon(host),
+		pullAttempts:   env.Int("IMAGE_PULL_MAX_ATTEMPTS", 3),
+		pullRetryDelay: time.Duration(env.Int("IMAGE_PULL_RETRY_DELAY_SECONDS", 2)) * time.Second,
 	}, nil
 }
 
-func (e *DockerExecutor) PullImage(imageName string) error {
-	reader, err := e.cli.ImagePull(e.ctx, imageName, image.PullOptions{})
-	if err != nil {
-		return err
-	}
-	defer reader.Close()
-	// On lit le flux jusqu'au bout pour attendre la fin du pull
-	_, err = io.Copy(io.Discard, reader)
+// Ping checks that the Docker daemon is reachable
+func (e *DockerExecutor) Ping(ctx context.Context) error {
+	_, err := e.cli.Ping(ctx)
 	return err
 }
 
+// PullImage pulls an image, with the server credentials of its registry if any
+func (e *DockerExecutor) PullImage(ctx context.Context, imageName string) error {
+	_, err := e.Pull(ctx, imageName, PullOptions{})
+	return err
+}
+
+// ImageExists reports whether an image is present in the local image store
+func (e *DockerExecutor) ImageExists(ctx context.Context, imageName string) (bool, error) {
+	_, err := e.cli.ImageInspect(ctx, imageName)
+	if cerrdefs.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func (e *DockerExecutor) Login(username, password, serverAddress string) error {
 	authConfig := registry.AuthConfig{
 		Username:      username,
@@ -128,51 +168,261 @@ func (e *DockerExecutor) ComposePush(workDir, composeFile, overrideFile string)
 	return string(output), err
 }
 
-// RunJobWithVolume runs a job with a workspace directory mounted into the container
-func (e *DockerExecutor) RunJobWithVolume(imageName string, commands []string, workspacePath string, envVars []string) (string, error) {
-	// On concatène les commandes avec " && " pour qu'elles s'exécutent séquentiellement
-	cmdString := strings.Join(commands, " && ")
+// JobOptions holds the optional settings of a job container
+type JobOptions struct {
+	// Name is the container name; empty lets Docker generate one
+	Name string
+	// Sysctls are the kernel parameters set in the container namespace
+	Sysctls map[string]string
+	// AfterScript runs once the commands are over, even when they failed
+	AfterScript []string
+	// ReadOnlyRootfs mounts the root filesystem read-only, only the workspace and ScratchPaths are writable
+	ReadOnlyRootfs bool
+	// ScratchPaths are mounted as writable tmpfs when the root filesystem is read-only
+	ScratchPaths []string
+	// NanoCPUs limits the CPU of the container in 1e-9 CPUs, unconstrained when zero
+	NanoCPUs int64
+	// Memory limits the memory of the container in bytes, unconstrained when zero
+	Memory int64
+	// User runs the commands as this user (name or uid[:gid]), the image user when empty
+	User string
+	// Networks are the user-defined networks the container is attached to, the default bridge when empty
+	Networks []string
+	// Volumes are the named volumes mounted into the container, by path in the container
+	// Docker creates a volume on its first mount
+	Volumes map[string]string
+	// Shell runs the commands (sh, bash or the path of a shell), sh when empty
+	Shell string
+	// Entrypoint replaces the entrypoint of the image, [""] clears it; the image one is kept when nil
+	Entrypoint []string
+	// EchoCommands prints each command (`$ go build ./...`) before running it, after scripts included
+	EchoCommands bool
+}
 
-	// Configuration du conteneur
-	containerConfig := &container.Config{
-		Image:      imageName,
-		Cmd:        []string{"sh", "-c", cmdString},
-		WorkingDir: "/workspace",
-		Env:        envVars,
+// ErrShellNotFound is returned when the shell or the entrypoint of a job is missing from its image
+var ErrShellNotFound = errors.New("shell or entrypoint not found in the image")
+
+// missingExecutable reports whether a container failed to start because its entrypoint or command does not exist
+func missingExecutable(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "executable file not found") ||
+		(strings.Contains(message, "exec: ") && strings.Contains(message, "no such file or directory"))
+}
+
+// jobShell returns the shell running the commands of a job
+func jobShell(opts JobOptions) string {
+	if opts.Shell == "" {
+		return "sh"
+	}
+	return opts.Shell
+}
+
+// maxNameAttempts bounds the suffixes tried when a container name is taken
+const maxNameAttempts = 10
+
+// resolveContainerName picks the name to give to a new container
+// A stopped container holding the name is stale and must be removed (remove=true),
+// a running one is kept and the name gets a numeric suffix instead
+// An empty name is returned if no free name was found
+func resolveContainerName(name string, state func(name string) (exists, running bool)) (string, bool) {
+	for attempt := 1; attempt <= maxNameAttempts; attempt++ {
+		candidate := name
+		if attempt > 1 {
+			candidate = fmt.Sprintf("%s-%d", name, attempt)
+		}
+		exists, running := state(candidate)
+		if !exists {
+			return candidate, false
+		}
+		if !running {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// containerState reports whether a container with this name exists and is running
+func (e *DockerExecutor) containerState(ctx context.Context, name string) (bool, bool) {
+	info, err := e.cli.ContainerInspect(ctx, name)
+	if err != nil {
+		return false, false
 	}
+	return true, info.State != nil && info.State.Running
+}
 
+// jobHostConfig builds the host configuration of a job container
+// An empty workspacePath mounts nothing, the workspace is then copied into the container
+func jobHostConfig(workspacePath string, opts JobOptions) *container.HostConfig {
 	// Configuration de l'hôte avec le volume monté
-	hostConfig := &container.HostConfig{
-		Mounts: []mount.Mount{
+	hostConfig := &container.HostConfig{}
+	if workspacePath != "" {
+		hostConfig.Mounts = []mount.Mount{
 			{
 				Type:   mount.TypeBind,
-				Source: workspacePath,        // Chemin sur l'hôte
-				Target: "/workspace",         // Chemin dans le conteneur
+				Source: workspacePath,   // Chemin sur l'hôte
+				Target: workspaceTarget, // Chemin dans le conteneur
 			},
-		},
+		}
+	}
+	targets := make([]string, 0, len(opts.Volumes))
+	for target := range opts.Volumes {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
+			Type:   mount.TypeVolume,
+			Source: opts.Volumes[target],
+			Target: target,
+		})
+	}
+	if len(opts.Sysctls) > 0 {
+		hostConfig.Sysctls = opts.Sysctls
+	}
+	if len(opts.Networks) > 0 {
+		hostConfig.NetworkMode = container.NetworkMode(opts.Networks[0])
+	}
+	hostConfig.Resources.NanoCPUs = opts.NanoCPUs
+	hostConfig.Resources.Memory = opts.Memory
+	if opts.ReadOnlyRootfs {
+		hostConfig.ReadonlyRootfs = true
+		hostConfig.Tmpfs = make(map[string]string, len(opts.ScratchPaths))
+		for _, path := range opts.ScratchPaths {
+			hostConfig.Tmpfs[path] = ""
+		}
+	}
+	return hostConfig
+}
+
+// errexitPrelude stops a script at its first failing command, including a failing command of a pipeline
+// pipefail is only set when the shell supports it, dash for one does not
+const errexitPrelude = "set -e\n(set -o pipefail) 2>/dev/null && set -o pipefail"
+
+// jobScript builds the shell script of a list of commands, each command on its own line after errexitPrelude
+// Commands are kept as written, their own && and || included; with echo, each one is printed before it runs
+func jobScript(commands []string, echo bool) string {
+	lines := make([]string, 0, 2*len(commands)+1)
+	lines = append(lines, errexitPrelude)
+	for _, command := range commands {
+		if echo {
+			lines = append(lines, "printf '%s\\n' "+shellQuote("$ "+command))
+		}
+		lines = append(lines, command)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// shellQuote quotes a value for sh
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// jobCommand builds the shell script of a job
+// The commands stop at the first failure; the after script then runs whatever happened,
+// and the job exits with the status of the commands, failures of the after script are ignored
+func jobCommand(commands, afterScript []string, echo bool) string {
+	script := jobScript(commands, echo)
+	if len(afterScript) == 0 {
+		return script
+	}
+
+	// Sous-shells : un `exit` du script n'empêche pas l'after_script de s'exécuter
+	return fmt.Sprintf("(\n%s\n)\njob_status=$?\n(\n%s\n)\nexit $job_status", script, jobScript(afterScript, echo))
+}
+
+// containerEnv returns the variables of a job container, with CI_PROJECT_DIR set to the workspace in the container
+func containerEnv(envVars []string) []string {
+	return append(slices.Clone(envVars), "CI_PROJECT_DIR="+workspaceTarget)
+}
+
+// RunJobWithVolume runs a job with a workspace directory mounted into the container
+// In copy mode the workspace is copied into the container instead, see CopyWorkspaceBack
+func (e *DockerExecutor) RunJobWithVolume(ctx context.Context, imageName string, commands []string, workspacePath string, envVars []string, opts JobOptions) (string, error) {
+	// A remote daemon would mount an empty or unrelated directory of its own host
+	if e.workspaceMode == WorkspaceBind && e.remote {
+		return "", ErrRemoteBindMount
+	}
+	cmdString := jobCommand(commands, opts.AfterScript, opts.EchoCommands)
+	shell := jobShell(opts)
+
+	// Configuration du conteneur
+	containerConfig := &container.Config{
+		Image:      imageName,
+		Entrypoint: opts.Entrypoint,
+		Cmd:        []string{shell, "-c", cmdString},
+		WorkingDir: workspaceTarget,
+		Env:        containerEnv(envVars),
+		User:       opts.User,
+	}
+
+	mountPath := workspacePath
+	if e.workspaceMode == WorkspaceCopy {
+		mountPath = ""
+	}
+	hostConfig := jobHostConfig(mountPath, opts)
+
+	// Nom déterministe du conteneur (les conteneurs obsolètes du même nom sont supprimés)
+	containerName := ""
+	if opts.Name != "" {
+		name, stale := resolveContainerName(opts.Name, func(name string) (bool, bool) { return e.containerState(ctx, name) })
+		if stale {
+			if err := e.RemoveContainer(ctx, name); err != nil {
+				return "", fmt.Errorf("failed to remove stale container %s: %w", name, err)
+			}
+		}
+		containerName = name
 	}
 
 	// Créer le conteneur
-	resp, err := e.cli.ContainerCreate(e.ctx, containerConfig, hostConfig, nil, nil, "")
+	resp, err := e.cli.ContainerCreate(ctx, containerConfig, hostConfig, nil, nil, containerName)
 	if err != nil {
 		return "", err
 	}
 
+	// The container is created on its first network, it joins the others before it starts
+	if len(opts.Networks) > 1 {
+		for _, networkName := range opts.Networks[1:] {
+			if err := e.cli.NetworkConnect(ctx, networkName, resp.ID, nil); err != nil {
+				e.RemoveContainer(context.WithoutCancel(ctx), resp.ID)
+				return "", fmt.Errorf("failed to connect the container to network %s: %w", networkName, err)
+			}
+		}
+	}
+
+	if e.workspaceMode == WorkspaceCopy {
+		if err := e.copyWorkspaceIn(ctx, resp.ID, workspacePath); err != nil {
+			e.RemoveContainer(context.WithoutCancel(ctx), resp.ID)
+			return "", err
+		}
+	}
+
 	// Démarrer le conteneur
-	err = e.cli.ContainerStart(e.ctx, resp.ID, container.StartOptions{})
-	return resp.ID, err
+	if err := e.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		e.RemoveContainer(context.WithoutCancel(ctx), resp.ID)
+		if missingExecutable(err) {
+			program := shell
+			if len(opts.Entrypoint) > 0 && opts.Entrypoint[0] != "" {
+				program = opts.Entrypoint[0]
+			}
+			return "", fmt.Errorf("%w: %s is missing from %s, set the `shell:` of the job or the entrypoint of its `image:`: %v", ErrShellNotFound, program, imageName, err)
+		}
+		return "", err
+	}
+	return resp.ID, nil
 }
 
-func (e *DockerExecutor) GetLogs(containerID string) (io.ReadCloser, error) {
-	return e.cli.ContainerLogs(e.ctx, containerID, container.LogsOptions{
+// GetLogs follows the logs of a container until it exits or ctx is done
+func (e *DockerExecutor) GetLogs(ctx context.Context, containerID string) (io.ReadCloser, error) {
+	return e.cli.ContainerLogs(ctx, containerID, container.LogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
 		Follow:     true, // Important pour le temps réel
 	})
 }
 
-func (e *DockerExecutor) WaitForContainer(containerID string) (int64, error) {
-	statusCh, errCh := e.cli.ContainerWait(e.ctx, containerID, container.WaitConditionNotRunning)
+// Cancelling ctx stops the wait, not the container
+func (e *DockerExecutor) WaitForContainer(ctx context.Context, containerID string) (int64, error) {
+	statusCh, errCh := e.cli.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
 	select {
 	case err := <-errCh:
 		return 0, err
@@ -181,25 +431,67 @@ func (e *DockerExecutor) WaitForContainer(containerID string) (int64, error) {
 	}
 }
 
+// StopContainer stops a container gracefully: it gets the stop signal of its image (SIGTERM by default),
+// then is killed if it did not exit within timeout
+// A container that is already gone is not an error
+func (e *DockerExecutor) StopContainer(ctx context.Context, containerID string, timeout time.Duration) error {
+	seconds := int(timeout / time.Second)
+	err := e.cli.ContainerStop(ctx, containerID, container.StopOptions{Timeout: &seconds})
+	if cerrdefs.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
 // RemoveContainer removes a container (cleanup)
-func (e *DockerExecutor) RemoveContainer(containerID string) error {
-	return e.cli.ContainerRemove(e.ctx, containerID, container.RemoveOptions{
+// A container that is already gone is not an error
+func (e *DockerExecutor) RemoveContainer(ctx context.Context, containerID string) error {
+	e.snapshots.Delete(containerID)
+	err := e.cli.ContainerRemove(ctx, containerID, container.RemoveOptions{
 		Force: true,
 	})
+	if cerrdefs.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// ComposeOptions holds the optional settings of a compose deployment
+type ComposeOptions struct {
+	// Env is appended to the server environment of every compose command,
+	// so that ${VAR} references in the compose file resolve to these values
+	Env []string
+	// Profiles are the compose profiles to enable (--profile)
+	Profiles []string
+	// Services restricts the pull and up commands to these services, all services when empty
+	Services []string
+	// Wait makes `up` block until the services are running and healthy (--wait),
+	// when the installed compose supports it, instead of polling their health
+	Wait bool
+	// WaitTimeout bounds the wait (--wait-timeout), no limit when zero
+	WaitTimeout time.Duration
+	// HealthTimeout bounds the health checks of the new version, defaultHealthTimeout when zero
+	HealthTimeout time.Duration
+	// HealthGracePeriod is how long the services must stay up once healthy, catching crash loops
+	HealthGracePeriod time.Duration
+	// HealthURL is requested once the services are healthy, it must answer with a 2xx or 3xx status
+	HealthURL string
+	// HealthAllowPrivate lets HealthURL reach loopback, link-local and private addresses
+	HealthAllowPrivate bool
+	// Teardown stops and removes the containers of the previous version before `up`
+	// Only the containers labelled with the compose project are touched
+	Teardown bool
 }
 
 // DeployCompose deploys using docker-compose with rollback capability
-func (e *DockerExecutor) DeployCompose(workDir, composeFile, projectName string) (string, error) {
+func (e *DockerExecutor) DeployCompose(workDir, composeFile, projectName string, opts ComposeOptions) (string, error) {
 	var logs strings.Builder
-	
-	baseArgs := []string{"compose"}
-	if projectName != "" {
-		baseArgs = append(baseArgs, "-p", projectName)
-	}
-	baseArgs = append(baseArgs, "-f", composeFile)
+	env := opts.Env
+
+	baseArgs := composeBaseArgs(composeFile, projectName, opts.Profiles)
 
 	// 1. Snapshot: Identify currently running containers and tag their images
-	backupImages, err := e.backupContainers(workDir, baseArgs, &logs)
+	backupImages, err := e.backupContainers(workDir, baseArgs, env, &logs)
 	if err != nil {
 		// Log but don't fail, we just won't have rollback
 		logs.WriteString(fmt.Sprintf("Backup warning: %v\n", err))
@@ -212,41 +504,104 @@ func (e *DockerExecutor) DeployCompose(workDir, composeFile, projectName string)
 			return
 		}
 		logs.WriteString("Performing rollback...\n")
-		e.restoreBackup(workDir, baseArgs, backupImages, &logs)
+		e.restoreBackup(workDir, baseArgs, env, backupImages, &logs)
 	}
 
 	// 2. Pull
-	if err := e.runComposeCommand(workDir, append(baseArgs, "pull"), &logs); err != nil {
+	if err := e.runComposeCommand(workDir, composeServiceArgs(baseArgs, opts.Services, "pull"), env, &logs); err != nil {
 		return logs.String(), fmt.Errorf("docker compose pull failed: %w", err)
 	}
 
-	// 3. Up
-	if err := e.runComposeCommand(workDir, append(baseArgs, "up", "-d", "--build"), &logs); err != nil {
+	// 3. Teardown of the previous version, the backup tags keep it available for rollback
+	if opts.Teardown {
+		if projectName == "" {
+			logs.WriteString("Skipping teardown: the deployment has no compose project name\n")
+		} else if err := e.runComposeCommand(workDir, composeTeardownArgs(baseArgs, opts.Services), env, &logs); err != nil {
+			performRollback()
+			return logs.String(), fmt.Errorf("docker compose teardown failed: %w", err)
+		}
+	}
+
+	// 4. Up, waiting for the services to be healthy if requested and supported
+	wait := opts.Wait && composeSupportsWait(workDir)
+	if opts.Wait && !wait {
+		logs.WriteString("docker compose does not support --wait, falling back to the health check poller\n")
+	}
+	if err := e.runComposeCommand(workDir, composeServiceArgs(baseArgs, opts.Services, composeUpCommand(wait, opts.WaitTimeout)...), env, &logs); err != nil {
 		// Attempt to resolve container name conflicts automatically
 		// Note: The original logic for conflict resolution was complex and specific.
 		// For clarity, I am simplifying to standard rollback behavior on failure.
 		// If specific conflict resolution is needed, it should be in a dedicated method.
-		
+
 		performRollback()
 		return logs.String(), fmt.Errorf("docker compose up failed: %w", err)
 	}
 
-	// 4. Health Check
-	if err := e.checkDeploymentHealth(workDir, baseArgs, &logs); err != nil {
+	// 5. Health Check, the service states are already checked by compose with --wait
+	if err := e.verifyDeployment(workDir, baseArgs, env, opts, !wait, &logs); err != nil {
+		logs.WriteString(fmt.Sprintf("Health check failed: %v\n", err))
 		performRollback()
 		return logs.String(), err
 	}
 
-	// 5. Cleanup Backups
+	// 6. Cleanup Backups
 	e.cleanupBackups(backupImages)
 
 	return logs.String(), nil
 }
 
+// composeBaseArgs builds the arguments shared by every compose command of a deployment
+func composeBaseArgs(composeFile, projectName string, profiles []string) []string {
+	baseArgs := []string{"compose"}
+	if projectName != "" {
+		baseArgs = append(baseArgs, "-p", projectName)
+	}
+	baseArgs = append(baseArgs, "-f", composeFile)
+	for _, profile := range profiles {
+		baseArgs = append(baseArgs, "--profile", profile)
+	}
+	return baseArgs
+}
+
+// composeUpCommand builds the up command of a deployment
+func composeUpCommand(wait bool, waitTimeout time.Duration) []string {
+	command := []string{"up", "-d", "--build"}
+	if wait {
+		command = append(command, "--wait")
+		if waitTimeout > 0 {
+			command = append(command, "--wait-timeout", strconv.Itoa(int(waitTimeout.Seconds())))
+		}
+	}
+	return command
+}
+
+// composeTeardownArgs builds the command removing the containers of the previous version
+// `down` is scoped to the compose project (-p), orphans are the containers of services removed from the file
+// When the deployment is limited to services, only their containers are removed
+func composeTeardownArgs(baseArgs, services []string) []string {
+	if len(services) > 0 {
+		return composeServiceArgs(baseArgs, services, "rm", "--stop", "--force")
+	}
+	return composeServiceArgs(baseArgs, nil, "down", "--remove-orphans")
+}
+
+// composeSupportsWait reports whether the installed docker compose has `up --wait`
+func composeSupportsWait(workDir string) bool {
+	output, err := composeCommand(workDir, nil, "compose", "up", "--help").Output()
+	return err == nil && strings.Contains(string(output), "--wait")
+}
+
+// composeServiceArgs builds the arguments of a compose command limited to services
+func composeServiceArgs(baseArgs, services []string, command ...string) []string {
+	args := make([]string, 0, len(baseArgs)+len(command)+len(services))
+	args = append(args, baseArgs...)
+	args = append(args, command...)
+	return append(args, services...)
+}
+
 // backupContainers identifies running containers and tags them for rollback
-func (e *DockerExecutor) backupContainers(workDir string, baseArgs []string, logs *strings.Builder) (map[string]string, error) {
-	cmdPs := exec.Command("docker", append(baseArgs, "ps", "-q")...)
-	cmdPs.Dir = workDir
+func (e *DockerExecutor) backupContainers(workDir string, baseArgs, env []string, logs *strings.Builder) (map[string]string, error) {
+	cmdPs := composeCommand(workDir, env, append(baseArgs, "ps", "-q")...)
 	output, err := cmdPs.Output()
 	if err != nil {
 		return nil, err
@@ -256,7 +611,9 @@ func (e *DockerExecutor) backupContainers(workDir string, baseArgs []string, log
 	if len(output) > 0 {
 		containerIDs := strings.Split(strings.TrimSpace(string(output)), "\n")
 		for _, cid := range containerIDs {
-			if cid == "" { continue }
+			if cid == "" {
+				continue
+			}
 			info, err := e.cli.ContainerInspect(e.ctx, cid)
 			if err != nil {
 				continue
@@ -277,7 +634,7 @@ func (e *DockerExecutor) backupContainers(workDir string, baseArgs []string, log
 }
 
 // restoreBackup restores the previous version of images
-func (e *DockerExecutor) restoreBackup(workDir string, baseArgs []string, backupImages map[string]string, logs *strings.Builder) {
+func (e *DockerExecutor) restoreBackup(workDir string, baseArgs, env []string, backupImages map[string]string, logs *strings.Builder) {
 	for name, id := range backupImages {
 		if err := e.cli.ImageTag(e.ctx, id, name); err != nil {
 			logs.WriteString(fmt.Sprintf("Error restoring tag %s: %v\n", name, err))
@@ -285,7 +642,7 @@ func (e *DockerExecutor) restoreBackup(workDir string, baseArgs []string, backup
 	}
 
 	argsRollback := append(baseArgs, "up", "-d", "--force-recreate")
-	if err := e.runComposeCommand(workDir, argsRollback, logs); err != nil {
+	if err := e.runComposeCommand(workDir, argsRollback, env, logs); err != nil {
 		logs.WriteString(fmt.Sprintf("Rollback failed: %v\n", err))
 	} else {
 		logs.WriteString("Rollback successful.\n")
@@ -299,103 +656,31 @@ func (e *DockerExecutor) cleanupBackups(backupImages map[string]string) {
 	}
 }
 
-// runComposeCommand executes a docker compose command and writes output to logs
-func (e *DockerExecutor) runComposeCommand(workDir string, args []string, logs *strings.Builder) error {
+// composeCommand prepares a docker command running in workDir
+// env is added on top of the server environment (used for ${VAR} substitution)
+func composeCommand(workDir string, env []string, args ...string) *exec.Cmd {
 	cmd := exec.Command("docker", args...)
 	cmd.Dir = workDir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd
+}
+
+// runComposeCommand executes a docker compose command and writes output to logs
+func (e *DockerExecutor) runComposeCommand(workDir string, args, env []string, logs *strings.Builder) error {
+	cmd := composeCommand(workDir, env, args...)
 	output, err := cmd.CombinedOutput()
 	logs.Write(output)
 	return err
 }
 
-// checkDeploymentHealth monitors service health
-func (e *DockerExecutor) checkDeploymentHealth(workDir string, baseArgs []string, logs *strings.Builder) error {
-	logs.WriteString("Starting deployment health check...\n")
-
-	// Get expected services
-	cmdServices := exec.Command("docker", append(baseArgs, "config", "--services")...)
-	cmdServices.Dir = workDir
-	outServices, err := cmdServices.Output()
-	if err != nil {
-		return fmt.Errorf("could not determine services from compose file: %w", err)
-	}
-
-	expectedServices := make(map[string]bool)
-	for _, s := range strings.Split(strings.TrimSpace(string(outServices)), "\n") {
-		if s != "" { expectedServices[s] = true }
-	}
-
-	if len(expectedServices) == 0 {
-		logs.WriteString("No services found in compose file. Assuming success.\n")
-		return nil
-	}
-
-	timeout := 2 * time.Minute
-	deadline := time.Now().Add(timeout)
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
-
-	for time.Now().Before(deadline) {
-		<-ticker.C
-		
-		cmdHealth := exec.Command("docker", append(baseArgs, "ps", "--all", "--format", "json")...)
-		cmdHealth.Dir = workDir
-		outHealth, err := cmdHealth.Output()
-		if err != nil {
-			logs.WriteString(fmt.Sprintf("Health check 'ps' command failed: %v\n", err))
-			continue
-		}
-
-		type ComposePsInfo struct {
-			Service string `json:"Service"`
-			State   string `json:"State"`
-			Health  string `json:"Health"`
-		}
-
-		serviceStatus := make(map[string]ComposePsInfo)
-		lines := strings.Split(strings.TrimSpace(string(outHealth)), "\n")
-		for _, line := range lines {
-			if line == "" { continue }
-			var info ComposePsInfo
-			if err := json.Unmarshal([]byte(line), &info); err == nil && info.Service != "" {
-				serviceStatus[info.Service] = info
-			}
-		}
-
-		if len(serviceStatus) < len(expectedServices) {
-			logs.WriteString(fmt.Sprintf("Waiting for all services to be created. Found %d/%d\n", len(serviceStatus), len(expectedServices)))
-			continue
-		}
-
-		allHealthy := true
-		for srvName := range expectedServices {
-			status, ok := serviceStatus[srvName]
-			if !ok {
-				allHealthy = false
-				break
-			}
-
-			if status.State == "running" {
-				if status.Health == "unhealthy" {
-					return fmt.Errorf("service %s is unhealthy", srvName)
-				}
-				if status.Health == "starting" {
-					allHealthy = false
-					logs.WriteString(fmt.Sprintf("Service %s is starting...\n", srvName))
-				}
-			} else if status.State == "exited" || status.State == "dead" {
-				return fmt.Errorf("service %s has stopped unexpectedly", srvName)
-			} else {
-				allHealthy = false
-				logs.WriteString(fmt.Sprintf("Service %s state: %s\n", srvName, status.State))
-			}
-		}
-
-		if allHealthy {
-			logs.WriteString("Deployment successful: All services are running and healthy.\n")
-			return nil
-		}
+// ExitCode returns the exit code of the failed docker command wrapped in err
+// It returns false when err does not come from a command that exited (health check failures, ...)
+func ExitCode(err error) (int, bool) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), true
 	}
-
-	return fmt.Errorf("deployment failed: health check timed out")
+	return 0, false
 }
@@ -0,0 +1,332 @@
+package docker
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+func TestComposeCommandEnv(t *testing.T) {
+	workDir := t.TempDir()
+	env := []string{"CI_COMMIT_SHA=0123456789abcdef"}
+
+	cmd := composeCommand(workDir, env, "compose", "-p", "demo", "-f", "docker-compose.yml", "up", "-d")
+
+	if cmd.Dir != workDir {
+		t.Errorf("Expected command to run in %s, got %s", workDir, cmd.Dir)
+	}
+
+	found := false
+	for _, kv := range cmd.Env {
+		if kv == "CI_COMMIT_SHA=0123456789abcdef" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Expected CI_COMMIT_SHA to be passed to the compose command, env: %v", cmd.Env)
+	}
+
+	// The process must see the variable so that ${CI_COMMIT_SHA} substitutions resolve
+	shell, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	cmd.Path = shell
+	cmd.Err = nil
+	cmd.Args = []string{"sh", "-c", "echo image:${CI_COMMIT_SHA}"}
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := strings.TrimSpace(string(output)); got != "image:0123456789abcdef" {
+		t.Errorf("Expected substituted value 'image:0123456789abcdef', got '%s'", got)
+	}
+}
+
+func TestComposeCommandWithoutEnvInheritsServerEnv(t *testing.T) {
+	cmd := composeCommand(t.TempDir(), nil, "compose", "ps")
+	if cmd.Env != nil {
+		t.Errorf("Expected nil env (inherit server environment), got %v", cmd.Env)
+	}
+}
+
+func TestComposeBaseArgsProfiles(t *testing.T) {
+	args := composeBaseArgs("docker-compose.yml", "demo", []string{"debug", "metrics"})
+
+	expected := []string{"compose", "-p", "demo", "-f", "docker-compose.yml", "--profile", "debug", "--profile", "metrics"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected args %v, got %v", expected, args)
+	}
+
+	// Without profiles only the default services are deployed
+	args = composeBaseArgs("docker-compose.yml", "demo", nil)
+	for _, arg := range args {
+		if arg == "--profile" {
+			t.Errorf("Expected no --profile flag, got %v", args)
+		}
+	}
+}
+
+func TestComposeServiceArgs(t *testing.T) {
+	baseArgs := composeBaseArgs("docker-compose.yml", "demo", nil)
+
+	args := composeServiceArgs(baseArgs, []string{"api", "worker"}, "up", "-d", "--build")
+	expected := []string{"compose", "-p", "demo", "-f", "docker-compose.yml", "up", "-d", "--build", "api", "worker"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected args %v, got %v", expected, args)
+	}
+
+	// Without services every service is deployed
+	args = composeServiceArgs(baseArgs, nil, "up", "-d", "--build")
+	expected = []string{"compose", "-p", "demo", "-f", "docker-compose.yml", "up", "-d", "--build"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected args %v, got %v", expected, args)
+	}
+}
+
+func TestComposeUpCommandWait(t *testing.T) {
+	command := composeUpCommand(true, 90*time.Second)
+	expected := []string{"up", "-d", "--build", "--wait", "--wait-timeout", "90"}
+	if !reflect.DeepEqual(command, expected) {
+		t.Errorf("Expected command %v, got %v", expected, command)
+	}
+
+	// Without timeout compose waits as long as needed
+	command = composeUpCommand(true, 0)
+	expected = []string{"up", "-d", "--build", "--wait"}
+	if !reflect.DeepEqual(command, expected) {
+		t.Errorf("Expected command %v, got %v", expected, command)
+	}
+
+	// Disabled: the health check poller is used
+	command = composeUpCommand(false, 90*time.Second)
+	expected = []string{"up", "-d", "--build"}
+	if !reflect.DeepEqual(command, expected) {
+		t.Errorf("Expected command %v, got %v", expected, command)
+	}
+}
+
+func TestComposeTeardownArgs(t *testing.T) {
+	baseArgs := composeBaseArgs("docker-compose.yml", "demo", nil)
+
+	args := composeTeardownArgs(baseArgs, nil)
+	expected := []string{"compose", "-p", "demo", "-f", "docker-compose.yml", "down", "--remove-orphans"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected args %v, got %v", expected, args)
+	}
+
+	// The other services keep running
+	args = composeTeardownArgs(baseArgs, []string{"api"})
+	expected = []string{"compose", "-p", "demo", "-f", "docker-compose.yml", "rm", "--stop", "--force", "api"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected args %v, got %v", expected, args)
+	}
+}
+
+func TestResolveContainerName(t *testing.T) {
+	// name -> running
+	containers := map[string]bool{
+		"dnd-1-build":   false,
+		"dnd-2-build":   true,
+		"dnd-2-build-2": true,
+		"dnd-2-build-3": false,
+	}
+	state := func(name string) (bool, bool) {
+		running, exists := containers[name]
+		return exists, running
+	}
+
+	tests := []struct {
+		name       string
+		expected   string
+		wantRemove bool
+	}{
+		{"dnd-3-build", "dnd-3-build", false},  // free name
+		{"dnd-1-build", "dnd-1-build", true},   // stale container is removed
+		{"dnd-2-build", "dnd-2-build-3", true}, // running containers are kept, next stale suffix is reused
+	}
+
+	for _, tt := range tests {
+		got, remove := resolveContainerName(tt.name, state)
+		if got != tt.expected || remove != tt.wantRemove {
+			t.Errorf("resolveContainerName(%q) = (%q, %t), expected (%q, %t)", tt.name, got, remove, tt.expected, tt.wantRemove)
+		}
+	}
+
+	// Every suffix taken by a running container: let Docker pick a name
+	allRunning := func(string) (bool, bool) { return true, true }
+	if got, _ := resolveContainerName("dnd-4-build", allRunning); got != "" {
+		t.Errorf("Expected empty name when no name is free, got %q", got)
+	}
+}
+
+func TestJobHostConfigSysctls(t *testing.T) {
+	sysctls := map[string]string{"net.core.somaxconn": "1024"}
+
+	hostConfig := jobHostConfig("/tmp/workspace", JobOptions{Sysctls: sysctls})
+	if !reflect.DeepEqual(hostConfig.Sysctls, sysctls) {
+		t.Errorf("Expected sysctls %v, got %v", sysctls, hostConfig.Sysctls)
+	}
+	if len(hostConfig.Mounts) != 1 || hostConfig.Mounts[0].Target != "/workspace" {
+		t.Errorf("Expected workspace mount, got %v", hostConfig.Mounts)
+	}
+
+	if hostConfig := jobHostConfig("/tmp/workspace", JobOptions{}); hostConfig.Sysctls != nil {
+		t.Errorf("Expected no sysctls, got %v", hostConfig.Sysctls)
+	}
+}
+
+func TestJobHostConfigNetwork(t *testing.T) {
+	hostConfig := jobHostConfig("/tmp/workspace", JobOptions{Networks: []string{"dnd-app-7", "dnd-7-test-services"}})
+	if hostConfig.NetworkMode != "dnd-app-7" {
+		t.Errorf("Expected the job created on the pipeline network, got %q", hostConfig.NetworkMode)
+	}
+	if hostConfig := jobHostConfig("/tmp/workspace", JobOptions{}); hostConfig.NetworkMode != "" {
+		t.Errorf("Expected the default network, got %q", hostConfig.NetworkMode)
+	}
+}
+
+func TestJobHostConfigVolumes(t *testing.T) {
+	hostConfig := jobHostConfig("/tmp/workspace", JobOptions{Volumes: map[string]string{"/cache": "dnd-cache-3-0123456789abcdef"}})
+	if len(hostConfig.Mounts) != 2 {
+		t.Fatalf("Expected the workspace and the volume mounted, got %+v", hostConfig.Mounts)
+	}
+	volume := hostConfig.Mounts[1]
+	if volume.Type != mount.TypeVolume || volume.Source != "dnd-cache-3-0123456789abcdef" || volume.Target != "/cache" {
+		t.Errorf("Unexpected volume mount %+v", volume)
+	}
+}
+
+func TestJobCommandAfterScript(t *testing.T) {
+	if cmd := jobCommand([]string{"make", "make test"}, nil, false); cmd != errexitPrelude+"\nmake\nmake test" {
+		t.Errorf("Expected one command per line, got %q", cmd)
+	}
+
+	tests := []struct {
+		name     string
+		commands []string
+		after    []string
+		output   string
+		exitCode int
+	}{
+		{"Success", []string{"echo build"}, []string{"echo cleanup"}, "build\ncleanup\n", 0},
+		{"ScriptFails", []string{"echo build", "exit 3", "echo never"}, []string{"echo cleanup"}, "build\ncleanup\n", 3},
+		{"AfterScriptFails", []string{"echo build"}, []string{"false", "echo never"}, "build\n", 0},
+		{"OwnOperators", []string{"false || echo recovered", "true && echo chained"}, nil, "recovered\nchained\n", 0},
+		{"MiddleCommandFails", []string{"echo build", "sh -c 'exit 4'", "echo never"}, nil, "build\n", 4},
+		{"Comment", []string{"echo build # comment", "echo test"}, nil, "build\ntest\n", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := exec.Command("sh", "-c", jobCommand(tt.commands, tt.after, false)).Output()
+			exitCode := 0
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				exitCode = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatalf("Failed to run command: %v", err)
+			}
+			if string(output) != tt.output || exitCode != tt.exitCode {
+				t.Errorf("Expected %q (exit %d), got %q (exit %d)", tt.output, tt.exitCode, output, exitCode)
+			}
+		})
+	}
+}
+
+func TestJobHostConfigReadOnlyRootfs(t *testing.T) {
+	hostConfig := jobHostConfig("/tmp/workspace", JobOptions{ReadOnlyRootfs: true, ScratchPaths: []string{"/tmp", "/root/.cache"}})
+	if !hostConfig.ReadonlyRootfs {
+		t.Error("Expected a read-only root filesystem")
+	}
+	expected := map[string]string{"/tmp": "", "/root/.cache": ""}
+	if !reflect.DeepEqual(hostConfig.Tmpfs, expected) {
+		t.Errorf("Expected scratch tmpfs %v, got %v", expected, hostConfig.Tmpfs)
+	}
+
+	if hostConfig := jobHostConfig("/tmp/workspace", JobOptions{ScratchPaths: []string{"/tmp"}}); hostConfig.ReadonlyRootfs || hostConfig.Tmpfs != nil {
+		t.Errorf("Expected a writable root filesystem without tmpfs, got %v", hostConfig.Tmpfs)
+	}
+}
+
+func TestJobHostConfigResources(t *testing.T) {
+	hostConfig := jobHostConfig("/tmp/workspace", JobOptions{NanoCPUs: 1500000000, Memory: 512 * 1024 * 1024})
+	if hostConfig.NanoCPUs != 1500000000 || hostConfig.Memory != 512*1024*1024 {
+		t.Errorf("Expected cpu and memory limits, got %d / %d", hostConfig.NanoCPUs, hostConfig.Memory)
+	}
+
+	if hostConfig := jobHostConfig("/tmp/workspace", JobOptions{}); hostConfig.NanoCPUs != 0 || hostConfig.Memory != 0 {
+		t.Errorf("Expected unconstrained resources by default, got %d / %d", hostConfig.NanoCPUs, hostConfig.Memory)
+	}
+}
+
+func TestMissingExecutable(t *testing.T) {
+	tests := []struct {
+		err     error
+		missing bool
+	}{
+		{errors.New(`failed to create task for container: exec: "bash": executable file not found in $PATH: unknown`), true},
+		{errors.New(`exec: "/busybox/sh": stat /busybox/sh: no such file or directory: unknown`), true},
+		{errors.New("bind source path does not exist: /tmp/workspace: no such file or directory"), false},
+		{errors.New("Cannot connect to the Docker daemon"), false},
+	}
+	for _, tt := range tests {
+		if got := missingExecutable(tt.err); got != tt.missing {
+			t.Errorf("missingExecutable(%q) = %v, want %v", tt.err, got, tt.missing)
+		}
+	}
+}
+
+func TestJobShell(t *testing.T) {
+	if shell := jobShell(JobOptions{}); shell != "sh" {
+		t.Errorf("Expected sh by default, got %q", shell)
+	}
+	if shell := jobShell(JobOptions{Shell: "bash"}); shell != "bash" {
+		t.Errorf("Expected the shell of the job, got %q", shell)
+	}
+}
+
+func TestContainerEnv(t *testing.T) {
+	envVars := []string{"CI_JOB_NAME=unit"}
+	env := containerEnv(envVars)
+	if !slices.Equal(env, []string{"CI_JOB_NAME=unit", "CI_PROJECT_DIR=/workspace"}) {
+		t.Errorf("Unexpected container env %v", env)
+	}
+	if len(envVars) != 1 {
+		t.Error("Expected the job variables to be left untouched")
+	}
+}
+
+func TestJobCommandPipefail(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not available")
+	}
+	// A failing command at the start of a pipeline fails the job where the shell supports pipefail
+	err = exec.Command(bash, "-c", jobCommand([]string{"false | cat", "echo never"}, nil, false)).Run()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Errorf("Expected the pipeline to fail the script, got %v", err)
+	}
+}
+
+func TestJobCommandEcho(t *testing.T) {
+	output, err := exec.Command("sh", "-c", jobCommand([]string{"echo build", `echo "it's $((1+1))"`}, []string{"echo cleanup"}, true)).Output()
+	if err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+	expected := "$ echo build\nbuild\n$ echo \"it's $((1+1))\"\nit's 2\n$ echo cleanup\ncleanup\n"
+	if string(output) != expected {
+		t.Errorf("Expected %q, got %q", expected, output)
+	}
+}
+
+func TestNetworkCreateOptionsLabel(t *testing.T) {
+	opts := networkCreateOptions()
+	if opts.Driver != "bridge" || opts.Labels[NetworkLabel] != "true" {
+		t.Errorf("Expected a labelled bridge network, got %+v", opts)
+	}
+}
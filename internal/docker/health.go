@@ -0,0 +1,252 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/safehttp"
+)
+
+// defaultHealthTimeout bounds the health checks of a deployment when ComposeOptions.HealthTimeout is not set
+const defaultHealthTimeout = 2 * time.Minute
+
+// healthPollInterval is the delay between two health checks of a deployment
+const healthPollInterval = 10 * time.Second
+
+// composePsInfo is a container listed by `docker compose ps --format json`
+type composePsInfo struct {
+	ID      string `json:"ID"`
+	Service string `json:"Service"`
+	State   string `json:"State"`
+	Health  string `json:"Health"`
+}
+
+// verifyDeployment checks the new version of a deployment before it is kept
+// The services must become running and healthy (skipped when compose already waited for them),
+// stay so during the grace period, then the health endpoint must answer
+func (e *DockerExecutor) verifyDeployment(workDir string, baseArgs, env []string, opts ComposeOptions, pollStates bool, logs *strings.Builder) error {
+	timeout := opts.HealthTimeout
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+
+	if pollStates || opts.HealthGracePeriod > 0 {
+		services, err := composeServices(workDir, baseArgs, env)
+		if err != nil {
+			return err
+		}
+		if len(services) == 0 {
+			logs.WriteString("No services found in compose file. Assuming success.\n")
+			return nil
+		}
+		if pollStates {
+			if err := e.checkDeploymentHealth(workDir, baseArgs, env, services, timeout, logs); err != nil {
+				return err
+			}
+		}
+		if opts.HealthGracePeriod > 0 {
+			if err := e.watchDeploymentHealth(workDir, baseArgs, env, services, opts.HealthGracePeriod, logs); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.HealthURL != "" {
+		return waitHTTPHealthy(healthClient(timeout, opts.HealthAllowPrivate), opts.HealthURL, timeout, healthPollInterval, logs)
+	}
+	return nil
+}
+
+// composeServices lists the services of the compose file
+func composeServices(workDir string, baseArgs, env []string) ([]string, error) {
+	output, err := composeCommand(workDir, env, append(baseArgs, "config", "--services")...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not determine services from compose file: %w", err)
+	}
+	var services []string
+	for _, s := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if s != "" {
+			services = append(services, s)
+		}
+	}
+	return services, nil
+}
+
+// composePs returns the containers of the deployment by service
+func composePs(workDir string, baseArgs, env []string) (map[string]composePsInfo, error) {
+	output, err := composeCommand(workDir, env, append(baseArgs, "ps", "--all", "--format", "json")...).Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseComposePs(output), nil
+}
+
+// parseComposePs parses the output of `docker compose ps --format json`, a JSON object per line
+func parseComposePs(output []byte) map[string]composePsInfo {
+	statuses := make(map[string]composePsInfo)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line == "" {
+			continue
+		}
+		var info composePsInfo
+		if err := json.Unmarshal([]byte(line), &info); err == nil && info.Service != "" {
+			statuses[info.Service] = info
+		}
+	}
+	return statuses
+}
+
+// serviceHealth checks the containers of the expected services
+// It fails as soon as a service is unhealthy or stopped, pending lists the services not healthy yet
+func serviceHealth(services []string, statuses map[string]composePsInfo) (pending []string, err error) {
+	for _, service := range services {
+		status, ok := statuses[service]
+		switch {
+		case !ok:
+			pending = append(pending, service+" (not created)")
+		case status.State == "running" && status.Health == "unhealthy":
+			return nil, fmt.Errorf("service %s is unhealthy", service)
+		case status.State == "running" && status.Health == "starting":
+			pending = append(pending, service+" (starting)")
+		case status.State == "running":
+		case status.State == "exited" || status.State == "dead":
+			return nil, fmt.Errorf("service %s has stopped unexpectedly", service)
+		default:
+			pending = append(pending, fmt.Sprintf("%s (%s)", service, status.State))
+		}
+	}
+	return pending, nil
+}
+
+// checkDeploymentHealth waits until the services are running and healthy
+func (e *DockerExecutor) checkDeploymentHealth(workDir string, baseArgs, env, services []string, timeout time.Duration, logs *strings.Builder) error {
+	logs.WriteString("Starting deployment health check...\n")
+
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(healthPollInterval)
+	defer ticker.Stop()
+
+	for time.Now().Before(deadline) {
+		<-ticker.C
+
+		statuses, err := composePs(workDir, baseArgs, env)
+		if err != nil {
+			logs.WriteString(fmt.Sprintf("Health check 'ps' command failed: %v\n", err))
+			continue
+		}
+
+		pending, err := serviceHealth(services, statuses)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			logs.WriteString("Deployment successful: All services are running and healthy.\n")
+			return nil
+		}
+		logs.WriteString(fmt.Sprintf("Waiting for services: %s\n", strings.Join(pending, ", ")))
+	}
+
+	return fmt.Errorf("deployment failed: health check timed out")
+}
+
+// watchDeploymentHealth checks that the services stay running and healthy during the grace period
+// A container restarted by its restart policy in the meantime is crash-looping
+func (e *DockerExecutor) watchDeploymentHealth(workDir string, baseArgs, env, services []string, grace time.Duration, logs *strings.Builder) error {
+	logs.WriteString(fmt.Sprintf("Watching services for %s...\n", grace))
+
+	deadline := time.Now().Add(grace)
+	var initialRestarts map[string]int
+	for {
+		statuses, err := composePs(workDir, baseArgs, env)
+		if err != nil {
+			logs.WriteString(fmt.Sprintf("Health check 'ps' command failed: %v\n", err))
+		} else {
+			pending, err := serviceHealth(services, statuses)
+			if err != nil {
+				return err
+			}
+			if len(pending) > 0 {
+				return fmt.Errorf("services are no longer healthy: %s", strings.Join(pending, ", "))
+			}
+
+			restarts := e.restartCounts(statuses)
+			if initialRestarts == nil {
+				initialRestarts = restarts
+			} else if restarted := restartedServices(initialRestarts, restarts); len(restarted) > 0 {
+				return fmt.Errorf("services restarted during the grace period: %s", strings.Join(restarted, ", "))
+			}
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		time.Sleep(min(healthPollInterval, remaining))
+	}
+
+	logs.WriteString(fmt.Sprintf("Services stayed healthy for %s.\n", grace))
+	return nil
+}
+
+// restartCounts returns the restart count of the container of each service
+func (e *DockerExecutor) restartCounts(statuses map[string]composePsInfo) map[string]int {
+	counts := make(map[string]int, len(statuses))
+	for service, status := range statuses {
+		if status.ID == "" {
+			continue
+		}
+		if info, err := e.cli.ContainerInspect(e.ctx, status.ID); err == nil {
+			counts[service] = info.RestartCount
+		}
+	}
+	return counts
+}
+
+// restartedServices returns the services whose container restarted between two restart counts
+func restartedServices(before, after map[string]int) []string {
+	var restarted []string
+	for service, count := range after {
+		if initial, ok := before[service]; ok && count > initial {
+			restarted = append(restarted, service)
+		}
+	}
+	sort.Strings(restarted)
+	return restarted
+}
+
+// healthClient returns the client requesting the health endpoint, limited to public addresses unless allowPrivate
+// The endpoint is set by the project, it must not let its owner probe the server or its private network
+func healthClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	timeout = min(timeout, 10*time.Second)
+	if allowPrivate {
+		return &http.Client{Timeout: timeout}
+	}
+	return safehttp.NewClient(timeout)
+}
+
+// waitHTTPHealthy requests url until it answers with a 2xx or 3xx status, or the timeout expires
+func waitHTTPHealthy(client *http.Client, url string, timeout, interval time.Duration, logs *strings.Builder) error {
+	logs.WriteString(fmt.Sprintf("Checking health endpoint %s...\n", url))
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < http.StatusBadRequest {
+				logs.WriteString(fmt.Sprintf("Health endpoint answered %d.\n", resp.StatusCode))
+				return nil
+			}
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		logs.WriteString(fmt.Sprintf("Health endpoint not ready: %v\n", err))
+
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("health endpoint %s did not answer successfully within %s: %w", url, timeout, err)
+		}
+		time.Sleep(interval)
+	}
+}
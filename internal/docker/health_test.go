@@ -0,0 +1,120 @@
+package docker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/safehttp"
+)
+
+func TestParseComposePs(t *testing.T) {
+	output := `{"ID":"abc","Service":"api","State":"running","Health":"healthy"}
+{"ID":"def","Service":"db","State":"restarting","Health":""}
+not json
+`
+	statuses := parseComposePs([]byte(output))
+	expected := map[string]composePsInfo{
+		"api": {ID: "abc", Service: "api", State: "running", Health: "healthy"},
+		"db":  {ID: "def", Service: "db", State: "restarting"},
+	}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("Expected %v, got %v", expected, statuses)
+	}
+}
+
+func TestServiceHealth(t *testing.T) {
+	services := []string{"api", "db", "worker"}
+
+	pending, err := serviceHealth(services, map[string]composePsInfo{
+		"api": {State: "running", Health: "healthy"},
+		"db":  {State: "running", Health: "starting"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"db (starting)", "worker (not created)"}
+	if !reflect.DeepEqual(pending, expected) {
+		t.Errorf("Expected pending %v, got %v", expected, pending)
+	}
+
+	// A container without health check is healthy once running
+	pending, err = serviceHealth(services, map[string]composePsInfo{
+		"api":    {State: "running", Health: "healthy"},
+		"db":     {State: "running"},
+		"worker": {State: "restarting"},
+	})
+	if err != nil || !reflect.DeepEqual(pending, []string{"worker (restarting)"}) {
+		t.Errorf("Expected the restarting worker to be pending, got %v, %v", pending, err)
+	}
+
+	if _, err := serviceHealth(services, map[string]composePsInfo{"api": {State: "running", Health: "unhealthy"}}); err == nil || !strings.Contains(err.Error(), "api is unhealthy") {
+		t.Errorf("Expected an unhealthy error, got %v", err)
+	}
+	if _, err := serviceHealth(services, map[string]composePsInfo{"db": {State: "exited"}}); err == nil || !strings.Contains(err.Error(), "db has stopped") {
+		t.Errorf("Expected a stopped error, got %v", err)
+	}
+}
+
+func TestRestartedServices(t *testing.T) {
+	before := map[string]int{"api": 0, "db": 2}
+	after := map[string]int{"api": 3, "db": 2, "worker": 1}
+	if restarted := restartedServices(before, after); !reflect.DeepEqual(restarted, []string{"api"}) {
+		t.Errorf("Expected api to have restarted, got %v", restarted)
+	}
+}
+
+func TestWaitHTTPHealthy(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The application is still starting for the first requests
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var logs strings.Builder
+	if err := waitHTTPHealthy(healthClient(time.Second, true), server.URL, time.Second, 10*time.Millisecond, &logs); err != nil {
+		t.Fatalf("Expected the endpoint to become healthy, got %v", err)
+	}
+	if requests.Load() != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests.Load())
+	}
+	if !strings.Contains(logs.String(), "status 503") {
+		t.Errorf("Expected the failed attempts in the logs, got %q", logs.String())
+	}
+}
+
+func TestWaitHTTPHealthyTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	var logs strings.Builder
+	err := waitHTTPHealthy(healthClient(time.Second, true), server.URL, 50*time.Millisecond, 10*time.Millisecond, &logs)
+	if err == nil || !strings.Contains(err.Error(), "status 500") {
+		t.Errorf("Expected a timeout with the last status, got %v", err)
+	}
+}
+
+func TestWaitHTTPHealthyBlocksPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected the loopback endpoint not to be requested")
+	}))
+	defer server.Close()
+
+	var logs strings.Builder
+	err := waitHTTPHealthy(healthClient(time.Second, false), server.URL, 50*time.Millisecond, 10*time.Millisecond, &logs)
+	if !errors.Is(err, safehttp.ErrBlockedAddress) {
+		t.Errorf("Expected the loopback address to be blocked, got %v", err)
+	}
+}
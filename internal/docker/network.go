@@ -0,0 +1,97 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+)
+
+// NetworkLabel marks the networks the server creates for pipelines and jobs, SweepNetworks removes them
+const NetworkLabel = "dnd.network"
+
+// networkCreateOptions returns the options of the networks the server creates: labelled bridge networks
+func networkCreateOptions() network.CreateOptions {
+	return network.CreateOptions{Driver: "bridge", Labels: map[string]string{NetworkLabel: "true"}}
+}
+
+// CreateNetwork creates a user-defined bridge network, the containers attached to it reach each other by name and alias
+// A network of the same name left by a crashed run is removed first
+func (e *DockerExecutor) CreateNetwork(ctx context.Context, name string) (string, error) {
+	resp, err := e.cli.NetworkCreate(ctx, name, networkCreateOptions())
+	if cerrdefs.IsConflict(err) {
+		if err := e.RemoveNetwork(ctx, name); err != nil {
+			return "", fmt.Errorf("failed to remove stale network %s: %w", name, err)
+		}
+		resp, err = e.cli.NetworkCreate(ctx, name, networkCreateOptions())
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create network %s: %w", name, err)
+	}
+	return resp.ID, nil
+}
+
+// SweepNetworks removes the labelled networks left behind by a crashed process
+// It is meant for startup, when no pipeline of the server runs yet, and returns the names of the networks removed
+func (e *DockerExecutor) SweepNetworks(ctx context.Context) ([]string, error) {
+	networks, err := e.cli.NetworkList(ctx, network.ListOptions{Filters: filters.NewArgs(filters.Arg("label", NetworkLabel))})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+	var removed []string
+	for _, stale := range networks {
+		if err := e.RemoveNetwork(ctx, stale.ID); err != nil {
+			logger.Warn("Failed to remove stale network", "network", stale.Name, "error", err)
+			continue
+		}
+		removed = append(removed, stale.Name)
+	}
+	return removed, nil
+}
+
+// RemoveNetwork removes a network (by ID or name)
+// Containers still attached, such as helper containers a job started and left running, are disconnected first
+// A network that is already gone is not an error
+func (e *DockerExecutor) RemoveNetwork(ctx context.Context, networkID string) error {
+	err := e.cli.NetworkRemove(ctx, networkID)
+	if err == nil || cerrdefs.IsNotFound(err) {
+		return nil
+	}
+
+	info, inspectErr := e.cli.NetworkInspect(ctx, networkID, network.InspectOptions{})
+	if cerrdefs.IsNotFound(inspectErr) {
+		return nil
+	}
+	if inspectErr != nil || len(info.Containers) == 0 {
+		return err
+	}
+
+	attached := attachedContainers(info)
+	logger.Warn(fmt.Sprintf("Disconnecting %d container(s) still attached to network %s: %v", len(attached), networkID, attached))
+	for _, containerID := range attached {
+		if err := e.cli.NetworkDisconnect(ctx, networkID, containerID, true); err != nil && !cerrdefs.IsNotFound(err) {
+			return fmt.Errorf("failed to disconnect container %s from network %s: %w", containerID, networkID, err)
+		}
+	}
+
+	err = e.cli.NetworkRemove(ctx, networkID)
+	if err == nil || cerrdefs.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// attachedContainers lists the containers attached to a network, sorted
+func attachedContainers(info network.Inspect) []string {
+	ids := make([]string, 0, len(info.Containers))
+	for id := range info.Containers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,231 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/pkg/jsonmessage"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+)
+
+// maxPullRetryDelay caps the delay between two attempts of a pull
+const maxPullRetryDelay = 30 * time.Second
+
+// pullProgressInterval is the minimum delay between two progress lines of the layers being downloaded
+var pullProgressInterval = 2 * time.Second
+
+// PullOptions holds the optional settings of an image pull
+type PullOptions struct {
+	// Secret holds the credentials of the pull, the server credentials of the registry when nil
+	Secret *PullSecret
+	// Progress receives the progress of the pull as readable lines, at most one download line per pullProgressInterval
+	Progress func(line string)
+}
+
+// Pull pulls an image and returns its digest, empty when the registry did not report it
+func (e *DockerExecutor) Pull(ctx context.Context, imageName string, opts PullOptions) (string, error) {
+	var pullOpts image.PullOptions
+	var err error
+	if opts.Secret != nil {
+		pullOpts, err = pullOptions(*opts.Secret)
+	} else {
+		pullOpts, err = registryPullOptions(imageName, e.registryAuth)
+	}
+	if err != nil {
+		return "", err
+	}
+	return e.pullImage(ctx, imageName, pullOpts, opts.Progress)
+}
+
+// ImageDigest returns the digest of a local image: the registry digest of its repository when it was pulled,
+// the image ID otherwise (an image built locally or loaded from an archive)
+func (e *DockerExecutor) ImageDigest(ctx context.Context, imageName string) (string, error) {
+	inspect, err := e.cli.ImageInspect(ctx, imageName)
+	if err != nil {
+		return "", err
+	}
+	return imageDigest(imageName, inspect.RepoDigests, inspect.ID), nil
+}
+
+// imageDigest picks the digest of imageName among the repo digests of an image ("alpine@sha256:..."),
+// an image tagged in several repositories has one per repository; the image ID is the fallback
+func imageDigest(imageName string, repoDigests []string, id string) string {
+	name := imageName
+	if named, err := reference.ParseNormalizedNamed(imageName); err == nil {
+		name = named.Name()
+	}
+	for _, repoDigest := range repoDigests {
+		repo, digest, ok := strings.Cut(repoDigest, "@")
+		if !ok {
+			continue
+		}
+		if named, err := reference.ParseNormalizedNamed(repo); err == nil {
+			repo = named.Name()
+		}
+		if repo == name {
+			return digest
+		}
+	}
+	return id
+}
+
+// transientPullErrors are the messages of the pull failures a retry may fix:
+// network errors and timeouts, rate limits and registry errors
+var transientPullErrors = []string{
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"unexpected eof",
+	"i/o timeout",
+	"tls handshake timeout",
+	"timeout awaiting response headers",
+	"temporary failure in name resolution",
+	"toomanyrequests",
+	"too many requests",
+	"internal server error",
+	"bad gateway",
+	"service unavailable",
+	"gateway timeout",
+}
+
+// transientPullStatus matches the HTTP status of a rate limit (429) or a registry error (5xx) in a message
+var transientPullStatus = regexp.MustCompile(`\b(?:status|code):? (?:429|5\d\d)\b`)
+
+// retryablePullError reports whether a pull failed for a reason that may go away: a network error or timeout,
+// a rate limit (429) or a registry error (5xx); any other failure, such as an unknown image or denied access, is not retried
+func retryablePullError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if cerrdefs.IsNotFound(err) || cerrdefs.IsUnauthorized(err) || cerrdefs.IsPermissionDenied(err) || cerrdefs.IsInvalidArgument(err) {
+		return false
+	}
+	if cerrdefs.IsUnavailable(err) || cerrdefs.IsResourceExhausted(err) || cerrdefs.IsInternal(err) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	// The daemon reports most registry failures as plain messages
+	message := strings.ToLower(err.Error())
+	for _, transient := range transientPullErrors {
+		if strings.Contains(message, transient) {
+			return true
+		}
+	}
+	return transientPullStatus.MatchString(message)
+}
+
+// pullRetryDelay returns the delay before the retry following a failed attempt, doubled at each attempt
+func pullRetryDelay(base time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < maxPullRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxPullRetryDelay)
+}
+
+// retryPull runs pull until it succeeds, fails with an error that is not retryable or attempts are exhausted
+func retryPull(ctx context.Context, imageName string, attempts int, delay time.Duration, pull func() error) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		err := pull()
+		if err == nil || attempt >= attempts || !retryablePullError(err) {
+			return err
+		}
+
+		wait := pullRetryDelay(delay, attempt)
+		logger.Warn(fmt.Sprintf("Pull of %s failed (attempt %d/%d), retrying in %s: %v", imageName, attempt, attempts, wait, err))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// pullImage pulls an image with the given options, retrying the transient failures, and returns its digest
+func (e *DockerExecutor) pullImage(ctx context.Context, imageName string, opts image.PullOptions, progress func(line string)) (string, error) {
+	var digest string
+	err := retryPull(ctx, imageName, e.pullAttempts, e.pullRetryDelay, func() error {
+		reader, err := e.cli.ImagePull(ctx, imageName, opts)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		digest, err = readPullStream(reader, newPullReporter(progress))
+		return err
+	})
+	return digest, err
+}
+
+// readPullStream reads the JSON messages of a pull until its end and returns the digest it reported
+// The pull fails when one of the messages is an error
+func readPullStream(r io.Reader, reporter *pullReporter) (string, error) {
+	var digest string
+	decoder := json.NewDecoder(r)
+	for {
+		var message jsonmessage.JSONMessage
+		if err := decoder.Decode(&message); err != nil {
+			if err == io.EOF {
+				return digest, nil
+			}
+			return digest, err
+		}
+		if message.Error != nil {
+			return digest, message.Error
+		}
+		if value, ok := strings.CutPrefix(message.Status, "Digest: "); ok {
+			digest = value
+		}
+		reporter.handle(message)
+	}
+}
+
+// pullReporter turns the messages of a pull stream into progress lines
+type pullReporter struct {
+	report   func(line string)
+	interval time.Duration
+	now      func() time.Time
+	last     time.Time
+}
+
+func newPullReporter(report func(line string)) *pullReporter {
+	return &pullReporter{report: report, interval: pullProgressInterval, now: time.Now}
+}
+
+// handle reports the download progress of the layers, throttled, the layers pulled and the outcome of the pull
+func (r *pullReporter) handle(message jsonmessage.JSONMessage) {
+	if r.report == nil {
+		return
+	}
+	switch {
+	case message.Status == "Downloading" && message.Progress != nil && message.Progress.Total > 0:
+		now := r.now()
+		if now.Sub(r.last) < r.interval {
+			return
+		}
+		r.last = now
+		r.report(fmt.Sprintf("Pulling layer %s: %d%%", message.ID, message.Progress.Current*100/message.Progress.Total))
+	case message.Status == "Pull complete":
+		r.report(fmt.Sprintf("Pulled layer %s", message.ID))
+	case strings.HasPrefix(message.Status, "Digest: "), strings.HasPrefix(message.Status, "Status: "):
+		r.report(message.Status)
+	}
+}
@@ -0,0 +1,59 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// PullSecret is a named registry credential set jobs can reference with `pull_secret:`
+type PullSecret struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	Token         string `json:"token"` // Bearer token sent to the registry instead of the password
+	ServerAddress string `json:"server"`
+}
+
+// String keeps the password and token out of logs and error messages
+func (s PullSecret) String() string {
+	return fmt.Sprintf("%s@%s", s.Username, s.ServerAddress)
+}
+
+// LoadPullSecrets reads the named pull secrets from a JSON file
+// Format: {"<name>": {"username": "...", "password": "...", "server": "ghcr.io"}}
+func LoadPullSecrets(path string) (map[string]PullSecret, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pull secrets: %w", err)
+	}
+
+	var secrets map[string]PullSecret
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse pull secrets: %w", err)
+	}
+	return secrets, nil
+}
+
+// pullOptions builds the options of an image pull authenticated with secret
+func pullOptions(secret PullSecret) (image.PullOptions, error) {
+	auth, err := registry.EncodeAuthConfig(registry.AuthConfig{
+		Username:      secret.Username,
+		Password:      secret.Password,
+		RegistryToken: secret.Token,
+		ServerAddress: secret.ServerAddress,
+	})
+	if err != nil {
+		return image.PullOptions{}, err
+	}
+	return image.PullOptions{RegistryAuth: auth}, nil
+}
+
+// PullImageWithSecret pulls an image using the credentials of a named pull secret
+func (e *DockerExecutor) PullImageWithSecret(ctx context.Context, imageName string, secret PullSecret) error {
+	_, err := e.Pull(ctx, imageName, PullOptions{Secret: &secret})
+	return err
+}
@@ -0,0 +1,50 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+)
+
+func TestPullSecretAuthIsUsedForPull(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pull-secrets.json")
+	content := `{"ghcr": {"username": "bot", "password": "s3cret", "server": "ghcr.io"}}`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, err := LoadPullSecrets(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	secret, ok := secrets["ghcr"]
+	if !ok {
+		t.Fatalf("Expected secret 'ghcr' to be loaded, got %v", secrets)
+	}
+
+	opts, err := pullOptions(secret)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	auth, err := registry.DecodeAuthConfig(opts.RegistryAuth)
+	if err != nil {
+		t.Fatalf("Expected a valid registry auth, got %v", err)
+	}
+	if auth.Username != "bot" || auth.Password != "s3cret" || auth.ServerAddress != "ghcr.io" {
+		t.Errorf("Expected the credentials of the 'ghcr' secret, got %+v", auth)
+	}
+}
+
+func TestLoadPullSecretsInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pull-secrets.json")
+	if err := os.WriteFile(path, []byte("not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadPullSecrets(path); err == nil {
+		t.Error("Expected an error for an invalid pull secrets file")
+	}
+}
@@ -0,0 +1,165 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+func TestRetryablePullError(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{errors.New("toomanyrequests: You have reached your pull rate limit"), true},
+		{errors.New("received unexpected HTTP status: 503 Service Unavailable"), true},
+		{errors.New("read tcp 10.0.0.2:41234->104.18.0.1:443: read: connection reset by peer"), true},
+		{cerrdefs.ErrNotFound.WithMessage("No such image: alpine:nope"), false},
+		{cerrdefs.ErrUnauthenticated, false},
+		{errors.New("manifest for alpine:nope not found: manifest unknown"), false},
+		{errors.New("pull access denied for private/app, repository does not exist or may require 'docker login'"), false},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("no route to host")}, true},
+		{errors.New("Get \"https://registry-1.docker.io/v2/\": net/http: TLS handshake timeout"), true},
+		{errors.New("unexpected status code 502 Bad Gateway"), true},
+		{errors.New("received unexpected HTTP status: 429 Too Many Requests"), true},
+		{cerrdefs.ErrUnavailable, true},
+		{errors.New("invalid reference format: repository name must be lowercase"), false},
+		{errors.New("no matching manifest for linux/arm64 in the manifest list entries"), false},
+		{errors.New("failed to register layer: no space left on device"), false},
+		{context.Canceled, false},
+	}
+	for _, tt := range tests {
+		if got := retryablePullError(tt.err); got != tt.retryable {
+			t.Errorf("retryablePullError(%q) = %v, want %v", tt.err, got, tt.retryable)
+		}
+	}
+}
+
+func TestImageDigest(t *testing.T) {
+	repoDigests := []string{
+		"registry.example.com/tools/alpine@sha256:1111111111111111111111111111111111111111111111111111111111111111",
+		"alpine@sha256:2222222222222222222222222222222222222222222222222222222222222222",
+	}
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"alpine:3.20", "sha256:2222222222222222222222222222222222222222222222222222222222222222"},
+		{"docker.io/library/alpine", "sha256:2222222222222222222222222222222222222222222222222222222222222222"},
+		{"registry.example.com/tools/alpine:3.20", "sha256:1111111111111111111111111111111111111111111111111111111111111111"},
+		// An image without repo digest of its repository, built or loaded locally, is identified by its ID
+		{"my-app:dev", "sha256:abcdef"},
+	}
+	for _, tt := range tests {
+		if got := imageDigest(tt.image, repoDigests, "sha256:abcdef"); got != tt.want {
+			t.Errorf("imageDigest(%q) = %q, want %q", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestPullRetryDelay(t *testing.T) {
+	for attempt, want := range map[int]time.Duration{1: 2 * time.Second, 2: 4 * time.Second, 3: 8 * time.Second, 10: maxPullRetryDelay} {
+		if got := pullRetryDelay(2*time.Second, attempt); got != want {
+			t.Errorf("Expected a delay of %s after attempt %d, got %s", want, attempt, got)
+		}
+	}
+}
+
+func TestRetryPull(t *testing.T) {
+	calls := 0
+	err := retryPull(context.Background(), "alpine", 3, time.Millisecond, func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("toomanyrequests: rate limit")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Expected the pull to succeed on the third attempt, got %v after %d attempt(s)", err, calls)
+	}
+
+	calls = 0
+	err = retryPull(context.Background(), "alpine", 3, time.Millisecond, func() error {
+		calls++
+		return errors.New("toomanyrequests: rate limit")
+	})
+	if err == nil || calls != 3 {
+		t.Errorf("Expected the pull to fail after 3 attempts, got %v after %d attempt(s)", err, calls)
+	}
+
+	calls = 0
+	err = retryPull(context.Background(), "alpine:nope", 3, time.Millisecond, func() error {
+		calls++
+		return errors.New("manifest unknown")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Expected an unknown image not to be retried, got %d attempt(s)", calls)
+	}
+
+	// A cancelled pipeline does not wait for the next attempt
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	retryPull(ctx, "alpine", 3, time.Hour, func() error {
+		calls++
+		return errors.New("connection reset by peer")
+	})
+	if calls != 1 {
+		t.Errorf("Expected no retry once cancelled, got %d attempt(s)", calls)
+	}
+}
+
+func TestReadPullStream(t *testing.T) {
+	stream := `{"status":"Pulling from library/alpine","id":"3.20"}
+{"status":"Downloading","progressDetail":{"current":512,"total":1024},"id":"abc123"}
+{"status":"Downloading","progressDetail":{"current":768,"total":1024},"id":"abc123"}
+{"status":"Pull complete","id":"abc123"}
+{"status":"Digest: sha256:77726ef6b57ddf65bb551896826ec38bc3e53f75cdde31354fbffb4f25238ebd"}
+{"status":"Status: Downloaded newer image for alpine:3.20"}
+`
+	var lines []string
+	reporter := newPullReporter(func(line string) { lines = append(lines, line) })
+	digest, err := readPullStream(strings.NewReader(stream), reporter)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if digest != "sha256:77726ef6b57ddf65bb551896826ec38bc3e53f75cdde31354fbffb4f25238ebd" {
+		t.Errorf("Expected the digest of the pulled image, got %q", digest)
+	}
+	// The second download line comes too early and is dropped
+	want := []string{
+		"Pulling layer abc123: 50%",
+		"Pulled layer abc123",
+		"Digest: sha256:77726ef6b57ddf65bb551896826ec38bc3e53f75cdde31354fbffb4f25238ebd",
+		"Status: Downloaded newer image for alpine:3.20",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("Unexpected progress lines %q", lines)
+	}
+
+	failed := stream + `{"errorDetail":{"message":"toomanyrequests: rate limit"},"error":"toomanyrequests: rate limit"}` + "\n"
+	if _, err := readPullStream(strings.NewReader(failed), newPullReporter(nil)); err == nil || !retryablePullError(err) {
+		t.Errorf("Expected the retryable error of the stream, got %v", err)
+	}
+}
+
+func TestPullReporterThrottle(t *testing.T) {
+	var lines []string
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	reporter := &pullReporter{report: func(line string) { lines = append(lines, line) }, interval: 2 * time.Second, now: func() time.Time { return now }}
+
+	for i, elapsed := range []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second, 5 * time.Second} {
+		now = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).Add(elapsed)
+		reporter.handle(jsonmessage.JSONMessage{Status: "Downloading", ID: "abc123", Progress: &jsonmessage.JSONProgress{Current: int64(i + 1), Total: 10}})
+	}
+	if want := []string{"Pulling layer abc123: 10%", "Pulling layer abc123: 30%", "Pulling layer abc123: 50%"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("Expected one line every 2s, got %q", lines)
+	}
+}
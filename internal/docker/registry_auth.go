@@ -0,0 +1,47 @@
+package docker
+
+import (
+	"fmt"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/image"
+)
+
+// loadRegistryAuth loads the registry credentials of the server, keyed by registry host, none when path is empty
+// Format: {"harbor.internal": {"username": "robot$ci", "password": "..."}, "docker.io": {"username": "bot", "token": "..."}}
+func loadRegistryAuth(path string) map[string]PullSecret {
+	if path == "" {
+		return nil
+	}
+
+	credentials, err := LoadPullSecrets(path)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Ignoring registry credentials: %v", err))
+		return nil
+	}
+	logger.Info(fmt.Sprintf("Loaded registry credentials for %d host(s)", len(credentials)))
+	return credentials
+}
+
+// imageRegistry returns the registry host of an image, docker.io for Docker Hub images
+func imageRegistry(imageName string) string {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return ""
+	}
+	return reference.Domain(named)
+}
+
+// registryPullOptions returns the options pulling an image, authenticated when credentials exist for its registry
+func registryPullOptions(imageName string, credentials map[string]PullSecret) (image.PullOptions, error) {
+	host := imageRegistry(imageName)
+	secret, ok := credentials[host]
+	if !ok {
+		return image.PullOptions{}, nil
+	}
+	if secret.ServerAddress == "" {
+		secret.ServerAddress = host
+	}
+	return pullOptions(secret)
+}
@@ -0,0 +1,55 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+)
+
+func TestImageRegistry(t *testing.T) {
+	for imageName, expected := range map[string]string{
+		"alpine:3.19":                  "docker.io",
+		"library/node:18":              "docker.io",
+		"harbor.internal/team/app:1.2": "harbor.internal",
+		"localhost:5000/app":           "localhost:5000",
+		"ghcr.io/owner/tool@sha256:" + strings.Repeat("a", 64): "ghcr.io",
+	} {
+		if host := imageRegistry(imageName); host != expected {
+			t.Errorf("Expected registry %s for %s, got %s", expected, imageName, host)
+		}
+	}
+}
+
+func TestRegistryPullOptions(t *testing.T) {
+	credentials := map[string]PullSecret{
+		"harbor.internal": {Username: "robot$ci", Password: "s3cret"},
+	}
+
+	opts, err := registryPullOptions("harbor.internal/team/app:1.2", credentials)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	auth, err := registry.DecodeAuthConfig(opts.RegistryAuth)
+	if err != nil {
+		t.Fatalf("Expected a valid registry auth, got %v", err)
+	}
+	if auth.Username != "robot$ci" || auth.Password != "s3cret" || auth.ServerAddress != "harbor.internal" {
+		t.Errorf("Expected the credentials of harbor.internal, got %+v", auth)
+	}
+
+	// Images of other registries are pulled anonymously
+	if opts, _ := registryPullOptions("alpine:3.19", credentials); opts.RegistryAuth != "" {
+		t.Error("Expected no credentials for Docker Hub")
+	}
+}
+
+func TestPullSecretString(t *testing.T) {
+	secret := PullSecret{Username: "bot", Password: "s3cret", Token: "t0ken", ServerAddress: "ghcr.io"}
+	for _, format := range []string{"%v", "%s", "%+v"} {
+		if out := fmt.Sprintf(format, secret); strings.Contains(out, "s3cret") || strings.Contains(out, "t0ken") {
+			t.Errorf("Expected %s to hide the credentials, got %q", format, out)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+// ServiceOptions holds the settings of a service container started next to a job
+type ServiceOptions struct {
+	// Name is the container name; empty lets Docker generate one
+	Name string
+	// Network is the network the service and the job share
+	Network string
+	// Aliases are the host names of the service on Network
+	Aliases []string
+	// Env holds the environment variables of the service
+	Env []string
+	// Command and Entrypoint replace those of the image when set
+	Command    []string
+	Entrypoint []string
+}
+
+// servicePollInterval is the delay between two readiness checks of a service
+var servicePollInterval = 500 * time.Millisecond
+
+// StartService creates and starts a service container on the network of its job
+func (e *DockerExecutor) StartService(ctx context.Context, imageName string, opts ServiceOptions) (string, error) {
+	config := &container.Config{
+		Image:      imageName,
+		Env:        opts.Env,
+		Cmd:        opts.Command,
+		Entrypoint: opts.Entrypoint,
+	}
+	hostConfig := &container.HostConfig{NetworkMode: container.NetworkMode(opts.Network)}
+	networking := &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			opts.Network: {Aliases: opts.Aliases},
+		},
+	}
+
+	name := ""
+	if opts.Name != "" {
+		candidate, stale := resolveContainerName(opts.Name, func(name string) (bool, bool) { return e.containerState(ctx, name) })
+		if stale {
+			if err := e.RemoveContainer(ctx, candidate); err != nil {
+				return "", fmt.Errorf("failed to remove stale container %s: %w", candidate, err)
+			}
+		}
+		name = candidate
+	}
+
+	resp, err := e.cli.ContainerCreate(ctx, config, hostConfig, networking, nil, name)
+	if err != nil {
+		return "", err
+	}
+	if err := e.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		e.RemoveContainer(context.WithoutCancel(ctx), resp.ID)
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// WaitForService waits until a service is ready: healthy when its image defines a health check,
+// accepting connections on its exposed TCP ports otherwise
+// The ports are only probed when the daemon is local, the runner cannot reach the networks of a remote one
+func (e *DockerExecutor) WaitForService(ctx context.Context, containerID, networkName string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dial := func(address string) error {
+		conn, err := net.DialTimeout("tcp", address, time.Second)
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	if e.remote {
+		dial = nil
+	}
+
+	for {
+		info, err := e.cli.ContainerInspect(ctx, containerID)
+		if err != nil {
+			return fmt.Errorf("failed to inspect service: %w", err)
+		}
+		ready, err := serviceReady(info, networkName, dial)
+		if ready || err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("service not ready after %s", timeout)
+		case <-time.After(servicePollInterval):
+		}
+	}
+}
+
+// serviceReady tells from the state of a service container whether it is ready
+// It fails once the container exited or became unhealthy; a nil dial skips the port probes
+func serviceReady(info container.InspectResponse, networkName string, dial func(address string) error) (bool, error) {
+	var state *container.State
+	if info.ContainerJSONBase != nil {
+		state = info.State
+	}
+	if state == nil || !state.Running {
+		exitCode := 0
+		if state != nil {
+			exitCode = state.ExitCode
+		}
+		return false, fmt.Errorf("service exited with code %d", exitCode)
+	}
+
+	if state.Health != nil {
+		switch state.Health.Status {
+		case container.Healthy:
+			return true, nil
+		case container.Unhealthy:
+			return false, fmt.Errorf("service is unhealthy")
+		}
+		return false, nil
+	}
+
+	if dial == nil || info.Config == nil || len(info.Config.ExposedPorts) == 0 {
+		return true, nil
+	}
+	ip := ""
+	if info.NetworkSettings != nil {
+		if endpoint, ok := info.NetworkSettings.Networks[networkName]; ok && endpoint != nil {
+			ip = endpoint.IPAddress
+		}
+	}
+	if ip == "" {
+		return false, nil
+	}
+	for port := range info.Config.ExposedPorts {
+		if port.Proto() != "tcp" {
+			continue
+		}
+		if dial(net.JoinHostPort(ip, port.Port())) != nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
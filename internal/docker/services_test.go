@@ -0,0 +1,58 @@
+package docker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+)
+
+// serviceInfo describes a running service exposing 5432 on the jobs network
+func serviceInfo(health *container.Health) container.InspectResponse {
+	return container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{State: &container.State{Running: true, Health: health}},
+		Config:            &container.Config{ExposedPorts: nat.PortSet{"5432/tcp": {}}},
+		NetworkSettings: &container.NetworkSettings{Networks: map[string]*network.EndpointSettings{
+			"jobs": {IPAddress: "172.18.0.2"},
+		}},
+	}
+}
+
+func TestServiceReady(t *testing.T) {
+	var dialed string
+	listening := func(address string) error { dialed = address; return nil }
+	closed := func(address string) error { return errors.New("connection refused") }
+
+	if ready, err := serviceReady(serviceInfo(nil), "jobs", listening); !ready || err != nil {
+		t.Errorf("Expected a service accepting connections to be ready, got %v, %v", ready, err)
+	}
+	if dialed != "172.18.0.2:5432" {
+		t.Errorf("Expected the exposed port to be probed on the jobs network, got %q", dialed)
+	}
+	if ready, err := serviceReady(serviceInfo(nil), "jobs", closed); ready || err != nil {
+		t.Errorf("Expected a service refusing connections to be waited for, got %v, %v", ready, err)
+	}
+	// Without probes (remote daemon), a running service is ready
+	if ready, _ := serviceReady(serviceInfo(nil), "jobs", nil); !ready {
+		t.Error("Expected a running service to be ready without probes")
+	}
+
+	// A health check takes precedence over the ports
+	if ready, _ := serviceReady(serviceInfo(&container.Health{Status: container.Starting}), "jobs", listening); ready {
+		t.Error("Expected a starting service not to be ready")
+	}
+	if ready, _ := serviceReady(serviceInfo(&container.Health{Status: container.Healthy}), "jobs", closed); !ready {
+		t.Error("Expected a healthy service to be ready")
+	}
+	if _, err := serviceReady(serviceInfo(&container.Health{Status: container.Unhealthy}), "jobs", listening); err == nil {
+		t.Error("Expected an unhealthy service to fail")
+	}
+
+	exited := serviceInfo(nil)
+	exited.State = &container.State{ExitCode: 1}
+	if _, err := serviceReady(exited, "jobs", listening); err == nil {
+		t.Error("Expected an exited service to fail")
+	}
+}
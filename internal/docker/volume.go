@@ -0,0 +1,46 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+)
+
+// VolumeInfo describes a named volume
+type VolumeInfo struct {
+	Name      string
+	CreatedAt time.Time
+}
+
+// ListVolumes returns the named volumes whose name starts with prefix
+func (e *DockerExecutor) ListVolumes(ctx context.Context, prefix string) ([]VolumeInfo, error) {
+	resp, err := e.cli.VolumeList(ctx, volume.ListOptions{Filters: filters.NewArgs(filters.Arg("name", prefix))})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+	var volumes []VolumeInfo
+	for _, v := range resp.Volumes {
+		// The name filter matches anywhere in the name
+		if v == nil || !strings.HasPrefix(v.Name, prefix) {
+			continue
+		}
+		created, _ := time.Parse(time.RFC3339, v.CreatedAt)
+		volumes = append(volumes, VolumeInfo{Name: v.Name, CreatedAt: created})
+	}
+	return volumes, nil
+}
+
+// RemoveVolume removes a named volume, a volume that is already gone is not an error
+// A volume still mounted by a container is kept and reported as an error
+func (e *DockerExecutor) RemoveVolume(ctx context.Context, name string) error {
+	err := e.cli.VolumeRemove(ctx, name, false)
+	if err == nil || cerrdefs.IsNotFound(err) {
+		return nil
+	}
+	return fmt.Errorf("failed to remove volume %s: %w", name, err)
+}
@@ -0,0 +1,57 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+)
+
+// WarmUp pre-pulls commonly used images so that the first pipelines start faster
+// At most concurrency images are pulled at the same time
+func (e *DockerExecutor) WarmUp(ctx context.Context, images []string, concurrency int) {
+	if len(images) == 0 {
+		return
+	}
+
+	logger.Info(fmt.Sprintf("Warming up %d image(s)", len(images)))
+	failed := pullImages(images, concurrency, func(imageName string) error { return e.PullImage(ctx, imageName) })
+	logger.Info(fmt.Sprintf("Image warm-up done: %d pulled, %d failed", len(images)-len(failed), len(failed)))
+}
+
+// pullImages pulls the images with a bounded number of concurrent pulls
+// It returns the images that could not be pulled
+func pullImages(images []string, concurrency int, pull func(imageName string) error) []string {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []string
+	)
+	slots := make(chan struct{}, concurrency)
+
+	for _, imageName := range images {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(imageName string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if err := pull(imageName); err != nil {
+				logger.Warn(fmt.Sprintf("Failed to pre-pull image %s: %v", imageName, err))
+				mu.Lock()
+				failed = append(failed, imageName)
+				mu.Unlock()
+				return
+			}
+			logger.Info(fmt.Sprintf("Pre-pulled image %s", imageName))
+		}(imageName)
+	}
+
+	wg.Wait()
+	return failed
+}
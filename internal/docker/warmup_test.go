@@ -0,0 +1,56 @@
+package docker
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPullImages(t *testing.T) {
+	images := []string{"golang:1.25", "node:20", "python:3.12", "alpine:3.20"}
+
+	var (
+		mu      sync.Mutex
+		pulled  []string
+		current int
+		peak    int
+	)
+	pull := func(imageName string) error {
+		mu.Lock()
+		current++
+		if current > peak {
+			peak = current
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		current--
+		pulled = append(pulled, imageName)
+		mu.Unlock()
+
+		if imageName == "node:20" {
+			return errors.New("manifest unknown")
+		}
+		return nil
+	}
+
+	failed := pullImages(images, 2, pull)
+
+	sort.Strings(pulled)
+	expected := append([]string(nil), images...)
+	sort.Strings(expected)
+	if !reflect.DeepEqual(pulled, expected) {
+		t.Errorf("Expected every declared image to be pulled, got %v", pulled)
+	}
+	if peak > 2 {
+		t.Errorf("Expected at most 2 concurrent pulls, got %d", peak)
+	}
+	if !reflect.DeepEqual(failed, []string{"node:20"}) {
+		t.Errorf("Expected [node:20] to be reported as failed, got %v", failed)
+	}
+}
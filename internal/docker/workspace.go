@@ -0,0 +1,295 @@
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+)
+
+// Workspace modes (DOCKER_WORKSPACE_MODE), how the workspace reaches job containers
+const (
+	// WorkspaceBind bind-mounts the workspace, the daemon must see the paths of the runner host
+	WorkspaceBind = "bind"
+	// WorkspaceCopy copies the workspace into the container before the job and back once it is over
+	WorkspaceCopy = "copy"
+	// WorkspaceAuto copies the workspace when the daemon is remote and bind-mounts it otherwise
+	WorkspaceAuto = "auto"
+)
+
+// workspaceTarget is where the workspace lives in job containers
+const workspaceTarget = "/workspace"
+
+// ErrRemoteBindMount is returned when a workspace would be bind-mounted into a remote daemon
+var ErrRemoteBindMount = errors.New("cannot bind-mount the workspace into a remote Docker daemon, set DOCKER_WORKSPACE_MODE=copy")
+
+// remoteDaemon reports whether the daemon at host (DOCKER_HOST) runs on another machine
+// Unix sockets, named pipes and loopback TCP addresses are local
+func remoteDaemon(host string) bool {
+	if host == "" {
+		return false
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return true
+	}
+	switch u.Scheme {
+	case "unix", "npipe":
+		return false
+	case "tcp", "http", "https":
+		hostname := u.Hostname()
+		if hostname == "localhost" {
+			return false
+		}
+		ip := net.ParseIP(hostname)
+		return ip == nil || !ip.IsLoopback()
+	default:
+		// ssh:// and unknown schemes reach another machine
+		return true
+	}
+}
+
+// workspaceMode resolves the mode of the workspaces from the configured one and the daemon host
+func workspaceMode(configured, host string) string {
+	switch configured {
+	case WorkspaceBind, WorkspaceCopy:
+		return configured
+	case WorkspaceAuto, "":
+	default:
+		logger.Warn(fmt.Sprintf("Unknown DOCKER_WORKSPACE_MODE %q, using auto", configured))
+	}
+	if remoteDaemon(host) {
+		return WorkspaceCopy
+	}
+	return WorkspaceBind
+}
+
+// workspaceSnapshot holds the files copied into a container, by path relative to the workspace, with a digest
+// of their mode and content (of their target for symlinks)
+// The copy back skips the files the job left untouched, they would revert what jobs running alongside wrote meanwhile
+type workspaceSnapshot map[string]string
+
+// copyWorkspaceIn copies the workspace into a created container, before it starts
+func (e *DockerExecutor) copyWorkspaceIn(ctx context.Context, containerID, workspacePath string) error {
+	snapshot := workspaceSnapshot{}
+	reader, writer := io.Pipe()
+	tarErr := make(chan error, 1)
+	go func() {
+		err := tarWorkspace(writer, workspacePath, snapshot)
+		writer.CloseWithError(err)
+		tarErr <- err
+	}()
+
+	err := e.cli.CopyToContainer(ctx, containerID, "/", reader, container.CopyToContainerOptions{})
+	reader.Close()
+	if archiveErr := <-tarErr; err == nil {
+		err = archiveErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy the workspace into the container: %w", err)
+	}
+	e.snapshots.Store(containerID, snapshot)
+	return nil
+}
+
+// CopyWorkspaceBack copies the workspace of a finished job container back to the runner host
+// Files the job created or changed are written back, files it left untouched or deleted are kept as they are now
+// Copies back to the same workspace run one at a time; it does nothing when the workspace is bind-mounted
+func (e *DockerExecutor) CopyWorkspaceBack(ctx context.Context, containerID, workspacePath string) error {
+	if e.workspaceMode != WorkspaceCopy {
+		return nil
+	}
+	var snapshot workspaceSnapshot
+	if stored, ok := e.snapshots.LoadAndDelete(containerID); ok {
+		snapshot = stored.(workspaceSnapshot)
+	}
+
+	reader, _, err := e.cli.CopyFromContainer(ctx, containerID, workspaceTarget)
+	if err != nil {
+		return fmt.Errorf("failed to copy the workspace out of the container: %w", err)
+	}
+	defer reader.Close()
+
+	lock, _ := e.copyBackLocks.LoadOrStore(filepath.Clean(workspacePath), &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+	return untarWorkspace(reader, workspacePath, snapshot)
+}
+
+// fileDigest is the snapshot digest of a regular file
+func fileDigest(mode os.FileMode, sum []byte) string {
+	return fmt.Sprintf("%o:%s", mode.Perm(), hex.EncodeToString(sum))
+}
+
+// symlinkDigest is the snapshot digest of a symlink
+func symlinkDigest(target string) string {
+	return "symlink:" + target
+}
+
+// tarWorkspace writes dir as a tar archive whose entries are under workspace/
+// The files archived are recorded in snapshot when it is not nil
+func tarWorkspace(w io.Writer, dir string, snapshot workspaceSnapshot) error {
+	tw := tar.NewWriter(w)
+	root := strings.TrimPrefix(workspaceTarget, "/")
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(root, filepath.ToSlash(rel))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if link != "" && snapshot != nil {
+			snapshot[filepath.ToSlash(rel)] = symlinkDigest(link)
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		hash := sha256.New()
+		if _, err := io.Copy(tw, io.TeeReader(f, hash)); err != nil {
+			return err
+		}
+		if snapshot != nil {
+			snapshot[filepath.ToSlash(rel)] = fileDigest(info.Mode(), hash.Sum(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// untarWorkspace extracts an archive of the workspace directory into dir
+// The first path element of the entries is the archived directory and is dropped
+// Entries matching snapshot, the files as they were copied into the container, are left out
+func untarWorkspace(r io.Reader, dir string, snapshot workspaceSnapshot) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid workspace archive: %w", err)
+		}
+
+		_, rel, _ := strings.Cut(strings.TrimPrefix(path.Clean(header.Name), "/"), "/")
+		if rel == "" {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(rel))
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(filepath.Separator)) {
+			return fmt.Errorf("workspace archive entry escapes the workspace: %s", header.Name)
+		}
+
+		if err := checkNoSymlinkParent(dir, rel); err != nil {
+			return err
+		}
+
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			// A symlink or file the job replaced with a directory goes first
+			if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+				os.Remove(target)
+			}
+			if err := os.MkdirAll(target, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeWorkspaceFile(target, mode, tr, snapshot[rel]); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if digest, ok := snapshot[rel]; ok && digest == symlinkDigest(header.Linkname) {
+				continue
+			}
+			os.Remove(target)
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkNoSymlinkParent fails when a parent of rel in dir is a symlink, which could lead outside the workspace
+func checkNoSymlinkParent(dir, rel string) error {
+	parent := dir
+	elements := strings.Split(filepath.FromSlash(rel), string(filepath.Separator))
+	for _, element := range elements[:len(elements)-1] {
+		parent = filepath.Join(parent, element)
+		info, err := os.Lstat(parent)
+		if err != nil {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("workspace archive entry under a symlink: %s", rel)
+		}
+	}
+	return nil
+}
+
+// writeWorkspaceFile replaces the file at target with the content of r, unless it has the digest unchanged
+func writeWorkspaceFile(target string, mode os.FileMode, r io.Reader, unchanged string) error {
+	f, err := os.CreateTemp(filepath.Dir(target), ".dnd-copy-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	hash := sha256.New()
+	if _, err := io.Copy(f, io.TeeReader(r, hash)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if unchanged != "" && fileDigest(mode, hash.Sum(nil)) == unchanged {
+		return nil
+	}
+	if err := os.Chmod(f.Name(), mode); err != nil {
+		return err
+	}
+	// Renaming replaces a symlink rather than writing through it
+	return os.Rename(f.Name(), target)
+}
@@ -0,0 +1,163 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoteDaemon(t *testing.T) {
+	tests := []struct {
+		host   string
+		remote bool
+	}{
+		{"", false},
+		{"unix:///var/run/docker.sock", false},
+		{"npipe:////./pipe/docker_engine", false},
+		{"tcp://localhost:2375", false},
+		{"tcp://127.0.0.1:2376", false},
+		{"tcp://[::1]:2375", false},
+		{"tcp://192.168.1.20:2375", true},
+		{"tcp://docker.internal:2376", true},
+		{"ssh://deploy@build-host", true},
+	}
+	for _, tt := range tests {
+		if remote := remoteDaemon(tt.host); remote != tt.remote {
+			t.Errorf("remoteDaemon(%q) = %v, expected %v", tt.host, remote, tt.remote)
+		}
+	}
+}
+
+func TestWorkspaceMode(t *testing.T) {
+	tests := []struct {
+		configured string
+		host       string
+		mode       string
+	}{
+		{"", "", WorkspaceBind},
+		{WorkspaceAuto, "unix:///var/run/docker.sock", WorkspaceBind},
+		{WorkspaceAuto, "tcp://10.0.0.5:2376", WorkspaceCopy},
+		{WorkspaceBind, "tcp://10.0.0.5:2376", WorkspaceBind},
+		{WorkspaceCopy, "", WorkspaceCopy},
+		{"volume", "ssh://build-host", WorkspaceCopy},
+	}
+	for _, tt := range tests {
+		if mode := workspaceMode(tt.configured, tt.host); mode != tt.mode {
+			t.Errorf("workspaceMode(%q, %q) = %q, expected %q", tt.configured, tt.host, mode, tt.mode)
+		}
+	}
+}
+
+func TestRunJobRejectsRemoteBindMount(t *testing.T) {
+	e := &DockerExecutor{workspaceMode: WorkspaceBind, remote: true}
+	if _, err := e.RunJobWithVolume(context.Background(), "alpine", []string{"true"}, "/tmp/workspace", nil, JobOptions{}); err != ErrRemoteBindMount {
+		t.Errorf("Expected ErrRemoteBindMount, got %v", err)
+	}
+}
+
+func TestJobHostConfigWithoutWorkspace(t *testing.T) {
+	if hostConfig := jobHostConfig("", JobOptions{}); len(hostConfig.Mounts) != 0 {
+		t.Errorf("Expected no mount for a copied workspace, got %v", hostConfig.Mounts)
+	}
+}
+
+func TestWorkspaceArchiveRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "build", "bin"), 0755)
+	os.WriteFile(filepath.Join(src, "README.md"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(src, "build", "bin", "app"), []byte("binary"), 0755)
+	os.Symlink("build/bin/app", filepath.Join(src, "app"))
+
+	var archive bytes.Buffer
+	if err := tarWorkspace(&archive, src, nil); err != nil {
+		t.Fatalf("Failed to archive the workspace: %v", err)
+	}
+
+	dst := t.TempDir()
+	os.WriteFile(filepath.Join(dst, "README.md"), []byte("stale"), 0644)
+	if err := untarWorkspace(&archive, dst, nil); err != nil {
+		t.Fatalf("Failed to extract the workspace: %v", err)
+	}
+
+	if content, _ := os.ReadFile(filepath.Join(dst, "README.md")); string(content) != "hello" {
+		t.Errorf("Expected README.md to be replaced, got %q", content)
+	}
+	info, err := os.Stat(filepath.Join(dst, "build", "bin", "app"))
+	if err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("Expected an executable build/bin/app, got %v (%v)", info, err)
+	}
+	if link, _ := os.Readlink(filepath.Join(dst, "app")); link != "build/bin/app" {
+		t.Errorf("Expected the app symlink to be kept, got %q", link)
+	}
+}
+
+func TestUntarWorkspaceRejectsSymlinkParent(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "etc"), 0755)
+	os.WriteFile(filepath.Join(src, "etc", "passwd"), []byte("owned"), 0644)
+	var archive bytes.Buffer
+	if err := tarWorkspace(&archive, src, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The workspace holds a symlink where the job created a directory
+	outside := t.TempDir()
+	dst := t.TempDir()
+	os.Symlink(outside, filepath.Join(dst, "etc"))
+	if err := untarWorkspace(&archive, dst, nil); err != nil {
+		t.Fatalf("Expected the symlink to be replaced by the directory, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "passwd")); !os.IsNotExist(err) {
+		t.Error("Expected nothing to be written outside the workspace")
+	}
+	if content, _ := os.ReadFile(filepath.Join(dst, "etc", "passwd")); string(content) != "owned" {
+		t.Errorf("Expected etc/passwd in the workspace, got %q", content)
+	}
+}
+
+func TestUntarWorkspaceParallelJobs(t *testing.T) {
+	workspace := t.TempDir()
+	os.WriteFile(filepath.Join(workspace, "build.log"), []byte("initial"), 0644)
+	os.WriteFile(filepath.Join(workspace, "test.log"), []byte("initial"), 0644)
+
+	// Both jobs get the same workspace copied in
+	snapshots := make([]workspaceSnapshot, 2)
+	jobs := make([]string, 2)
+	for i := range jobs {
+		jobs[i] = t.TempDir()
+		snapshots[i] = workspaceSnapshot{}
+		var archive bytes.Buffer
+		if err := tarWorkspace(&archive, workspace, snapshots[i]); err != nil {
+			t.Fatal(err)
+		}
+		if err := untarWorkspace(&archive, jobs[i], nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each job writes a different file, then both are copied back
+	os.WriteFile(filepath.Join(jobs[0], "build.log"), []byte("built"), 0644)
+	os.WriteFile(filepath.Join(jobs[1], "test.log"), []byte("tested"), 0644)
+	os.WriteFile(filepath.Join(jobs[1], "report.xml"), []byte("<testsuite/>"), 0644)
+	for i := range jobs {
+		var archive bytes.Buffer
+		if err := tarWorkspace(&archive, jobs[i], nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := untarWorkspace(&archive, workspace, snapshots[i]); err != nil {
+			t.Fatalf("Failed to copy job %d back: %v", i, err)
+		}
+	}
+
+	expected := map[string]string{"build.log": "built", "test.log": "tested", "report.xml": "<testsuite/>"}
+	for name, content := range expected {
+		if got, _ := os.ReadFile(filepath.Join(workspace, name)); string(got) != content {
+			t.Errorf("Expected %s to hold %q, got %q", name, content, got)
+		}
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(workspace, ".dnd-copy-*")); len(leftovers) > 0 {
+		t.Errorf("Expected no temporary file left, got %v", leftovers)
+	}
+}
@@ -0,0 +1,38 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+)
+
+// ApprovalGate blocks until a gate of a pipeline, a stage or the deployment, is approved
+// It returns an error once the gate is rejected or times out, and ctx.Err() when the pipeline is cancelled
+type ApprovalGate func(ctx context.Context, pipelineID int, gate string, timeout time.Duration) error
+
+// errNoApprovalGate fails the stages requiring an approval when nothing can approve them
+var errNoApprovalGate = errors.New("stage requires an approval but no approval gate is available")
+
+// SetApprovalGate sets the gate the stages requiring an approval wait at
+func (e *PipelineExecutor) SetApprovalGate(gate ApprovalGate) {
+	e.approvalGate = gate
+}
+
+// awaitStageApproval waits for the approval of a stage, a pipeline without record cannot be approved
+func (e *PipelineExecutor) awaitStageApproval(ctx context.Context, pipelineID int, stage string, timeout time.Duration) error {
+	if e.approvalGate == nil || pipelineID <= 0 {
+		return errNoApprovalGate
+	}
+	return e.approvalGate(ctx, pipelineID, stage, timeout)
+}
+
+// skipStages records the jobs of stages that will not run as skipped
+func (e *PipelineExecutor) skipStages(config *pipeline.PipelineConfig, stages []string, pipelineID int) {
+	for _, stage := range stages {
+		for _, jobName := range config.StageJobs(stage) {
+			e.skipJob(jobName, pipelineID)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+)
+
+// approvalConfig gates the deploy stage, whose job fails before starting a container (sysctl not allowlisted)
+func approvalConfig() *pipeline.PipelineConfig {
+	return &pipeline.PipelineConfig{
+		Stages:   []string{"deploy"},
+		Approval: pipeline.ApprovalConfig{Stages: []string{"deploy"}, Timeout: 60},
+		Jobs: map[string]pipeline.JobConfig{
+			"release": {Stage: "deploy", Image: "alpine", Sysctls: map[string]string{"net.core.somaxconn": "1024"}},
+		},
+	}
+}
+
+func TestExecuteApprovalRejected(t *testing.T) {
+	rejected := errors.New("rejected")
+	var gate string
+	var timeout time.Duration
+	e := &PipelineExecutor{}
+	e.SetApprovalGate(func(ctx context.Context, pipelineID int, g string, t time.Duration) error {
+		gate, timeout = g, t
+		return rejected
+	})
+
+	success, err := e.Execute(context.Background(), approvalConfig(), t.TempDir(), models.PipelineRunParams{PipelineID: 7}, nil)
+	if success || !errors.Is(err, rejected) {
+		t.Fatalf("Expected the rejection to fail the pipeline, got %v, %v", success, err)
+	}
+	if gate != "deploy" || timeout != time.Minute {
+		t.Errorf("Expected the deploy gate with a 1m timeout, got %q and %v", gate, timeout)
+	}
+}
+
+func TestExecuteApprovalApproved(t *testing.T) {
+	e := &PipelineExecutor{}
+	e.SetApprovalGate(func(ctx context.Context, pipelineID int, gate string, timeout time.Duration) error {
+		return nil
+	})
+
+	// The stage runs once approved, its job then fails on its own
+	success, err := e.Execute(context.Background(), approvalConfig(), t.TempDir(), models.PipelineRunParams{PipelineID: 7}, nil)
+	if success || err != nil {
+		t.Fatalf("Expected the approved stage to run and its job to fail, got %v, %v", success, err)
+	}
+}
+
+func TestExecuteApprovalWithoutGate(t *testing.T) {
+	e := &PipelineExecutor{}
+	_, err := e.Execute(context.Background(), approvalConfig(), t.TempDir(), models.PipelineRunParams{PipelineID: 7}, nil)
+	if !errors.Is(err, errNoApprovalGate) {
+		t.Errorf("Expected a stage nobody can approve to fail, got %v", err)
+	}
+}
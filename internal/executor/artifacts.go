@@ -0,0 +1,328 @@
+package executor
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+)
+
+// artifactStore keeps the artifact archives of a pipeline, one per job, in the order they were stored
+// Archives are restored into the workspace before the jobs of the following stages run
+type artifactStore struct {
+	dir      string // created on the first archive
+	root     string
+	pipeline int
+	archives []string
+}
+
+func newArtifactStore(root string, pipelineID int) *artifactStore {
+	return &artifactStore{root: root, pipeline: pipelineID}
+}
+
+// store archives the artifacts of a job, the patterns matching no file are returned
+func (s *artifactStore) store(workspaceDir, jobName string, paths []string) ([]string, error) {
+	if s.dir == "" {
+		if err := os.MkdirAll(s.root, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create artifact store: %w", err)
+		}
+		dir, err := os.MkdirTemp(s.root, fmt.Sprintf("pipeline-%d-*", s.pipeline))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create artifact store: %w", err)
+		}
+		s.dir = dir
+	}
+
+	archivePath := filepath.Join(s.dir, invalidContainerNameChars.ReplaceAllString(jobName, "_")+".tar.gz")
+	missing, err := archiveArtifacts(workspaceDir, paths, archivePath)
+	if err != nil {
+		return missing, err
+	}
+	s.archives = append(s.archives, archivePath)
+	return missing, nil
+}
+
+// restore extracts every stored archive into the workspace
+// The files a job changed since are kept, their paths are returned as conflicts
+func (s *artifactStore) restore(workspaceDir string) ([]string, error) {
+	var conflicts []string
+	for _, archivePath := range s.archives {
+		skipped, err := extractArtifacts(archivePath, workspaceDir)
+		conflicts = append(conflicts, skipped...)
+		if err != nil {
+			return conflicts, err
+		}
+	}
+	return conflicts, nil
+}
+
+// cleanup removes the archives of the pipeline
+func (s *artifactStore) cleanup() {
+	if s.dir != "" {
+		os.RemoveAll(s.dir)
+	}
+}
+
+// storeJobArtifacts archives the artifacts of the jobs of a stage that ran successfully
+// A pattern matching no file is reported in the job logs, an archive that cannot be written fails the job
+func (e *PipelineExecutor) storeJobArtifacts(store *artifactStore, config *pipeline.PipelineConfig, jobNames []string, outcomes []jobOutcome, workspaceDir string, pipelineID int) bool {
+	success := true
+	for i, jobName := range jobNames {
+		job := config.Jobs[jobName]
+		if len(job.Artifacts.Paths) == 0 || !outcomes[i].success || outcomes[i].skipped {
+			continue
+		}
+
+		missing, err := store.store(workspaceDir, jobName, job.Artifacts.Paths)
+		for _, pattern := range missing {
+			e.jobLog(pipelineID, jobName, fmt.Sprintf("WARNING: artifacts path %s matched no file", pattern))
+		}
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to store artifacts of job %s: %v", jobName, err))
+			e.jobLog(pipelineID, jobName, fmt.Sprintf("Failed to store artifacts: %v", err))
+			if e.db != nil && pipelineID > 0 {
+				if dbJob, err := e.db.GetJobByName(pipelineID, jobName); err == nil {
+					exitCode := 1
+					e.db.UpdateJobStatus(dbJob.ID, "failed", &exitCode)
+				}
+			}
+			success = false
+		}
+	}
+	return success
+}
+
+// jobLog appends a line to the logs of a job
+func (e *PipelineExecutor) jobLog(pipelineID int, jobName, line string) {
+	if e.db == nil || pipelineID <= 0 {
+		return
+	}
+	if dbJob, err := e.db.GetJobByName(pipelineID, jobName); err == nil {
+		e.writeJobLine(dbJob.ID, line)
+	}
+}
+
+// archiveArtifacts writes the workspace files matching patterns to a tar.gz archive
+// Patterns are globs relative to the workspace, matched directories are archived with their content
+// The patterns matching no file are returned, they do not fail the archive
+func archiveArtifacts(workspaceDir string, patterns []string, archivePath string) ([]string, error) {
+	var missing, matches []string
+	for _, pattern := range patterns {
+		clean := filepath.Clean(pattern)
+		if !filepath.IsLocal(clean) {
+			return nil, fmt.Errorf("artifacts path %s is outside the workspace", pattern)
+		}
+		found, err := filepath.Glob(filepath.Join(workspaceDir, clean))
+		if err != nil {
+			return nil, fmt.Errorf("invalid artifacts path %s: %w", pattern, err)
+		}
+		// Symlinks of the repository must not expose files of the runner host
+		found = slices.DeleteFunc(found, func(path string) bool { return !insideDir(workspaceDir, path) })
+		if len(found) == 0 {
+			missing = append(missing, pattern)
+		}
+		matches = append(matches, found...)
+	}
+	sort.Strings(matches)
+
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return missing, fmt.Errorf("failed to create artifacts archive: %w", err)
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	seen := make(map[string]bool)
+	for _, match := range matches {
+		err := filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			name, err := filepath.Rel(workspaceDir, path)
+			if err != nil || seen[name] {
+				return err
+			}
+			seen[name] = true
+			return addArtifact(tw, path, filepath.ToSlash(name))
+		})
+		if err != nil {
+			return missing, fmt.Errorf("failed to archive %s: %w", match, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return missing, err
+	}
+	if err := gz.Close(); err != nil {
+		return missing, err
+	}
+	return missing, file.Close()
+}
+
+// addArtifact writes a file or directory of the workspace to the archive
+// Symlinks, sockets, devices, ... are not artifacts and are left out
+func addArtifact(tw *tar.Writer, path, name string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() && !info.IsDir() {
+		return nil
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// insideDir reports whether path, symlinks resolved, is located in dir
+func insideDir(dir, path string) bool {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, resolved)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// extractArtifacts restores an artifacts archive into the workspace
+// Files already in the workspace are never overwritten, the ones that differ from the archive are returned
+func extractArtifacts(archivePath, workspaceDir string) ([]string, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open artifacts archive: %w", err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read artifacts archive: %w", err)
+	}
+	defer gz.Close()
+
+	var conflicts []string
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return conflicts, nil
+		}
+		if err != nil {
+			return conflicts, fmt.Errorf("failed to read artifacts archive: %w", err)
+		}
+
+		name := filepath.FromSlash(strings.TrimSuffix(header.Name, "/"))
+		if !filepath.IsLocal(name) {
+			return conflicts, fmt.Errorf("artifact %s is outside the workspace", header.Name)
+		}
+		target := filepath.Join(workspaceDir, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, header.FileInfo().Mode().Perm()); err != nil {
+				return conflicts, err
+			}
+			if !insideDir(workspaceDir, target) {
+				return conflicts, fmt.Errorf("artifact %s is outside the workspace", header.Name)
+			}
+		case tar.TypeReg:
+			conflict, err := extractFile(tr, workspaceDir, target, header.Size, header.FileInfo().Mode().Perm())
+			if err != nil {
+				return conflicts, err
+			}
+			if conflict {
+				conflicts = append(conflicts, filepath.ToSlash(name))
+			}
+		}
+	}
+}
+
+// extractFile writes an archived file to target unless something already exists there
+// It reports a conflict when the existing entry differs from the archived file
+func extractFile(r io.Reader, workspaceDir, target string, size int64, mode fs.FileMode) (bool, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return false, err
+	}
+	// Never write through a symlinked directory of the workspace
+	if !insideDir(workspaceDir, filepath.Dir(target)) {
+		return false, fmt.Errorf("artifact %s is outside the workspace", target)
+	}
+	if info, err := os.Lstat(target); err == nil {
+		if !info.Mode().IsRegular() || info.Size() != size {
+			return true, nil
+		}
+		same, err := sameContent(target, r)
+		return !same, err
+	}
+
+	// O_EXCL never follows a symlink created since the check
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return false, err
+	}
+	return false, f.Close()
+}
+
+// sameContent reports whether the file at path holds exactly what r yields
+func sameContent(path string, r io.Reader) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	a, b := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		n, errA := io.ReadFull(f, a)
+		m, errB := io.ReadFull(r, b)
+		if n != m || !bytes.Equal(a[:n], b[:m]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeWorkspaceFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+}
+
+func TestArtifactsArchiveAndExtract(t *testing.T) {
+	workspace := t.TempDir()
+	writeWorkspaceFile(t, workspace, "bin/app", "binary")
+	writeWorkspaceFile(t, workspace, "dist/css/site.css", "body{}")
+	writeWorkspaceFile(t, workspace, "report-unit.xml", "<unit/>")
+	writeWorkspaceFile(t, workspace, "report-e2e.xml", "<e2e/>")
+	writeWorkspaceFile(t, workspace, "main.go", "package main")
+
+	archive := filepath.Join(t.TempDir(), "build.tar.gz")
+	missing, err := archiveArtifacts(workspace, []string{"bin/app", "dist", "report-*.xml", "coverage/*.out"}, archive)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(missing, []string{"coverage/*.out"}) {
+		t.Errorf("Expected the unmatched pattern to be reported, got %v", missing)
+	}
+
+	// Restore into an isolated workspace
+	restored := t.TempDir()
+	if conflicts, err := extractArtifacts(archive, restored); err != nil || len(conflicts) != 0 {
+		t.Fatalf("Expected no error nor conflict, got %v (%v)", conflicts, err)
+	}
+	for name, content := range map[string]string{
+		"bin/app":           "binary",
+		"dist/css/site.css": "body{}",
+		"report-unit.xml":   "<unit/>",
+		"report-e2e.xml":    "<e2e/>",
+	} {
+		data, err := os.ReadFile(filepath.Join(restored, name))
+		if err != nil || string(data) != content {
+			t.Errorf("Expected %s to be restored with %q, got %q (%v)", name, content, data, err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(restored, "bin/app")); err != nil || info.Mode().Perm()&0100 == 0 {
+		t.Errorf("Expected the file mode to be kept, got %v (%v)", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(restored, "main.go")); err == nil {
+		t.Error("Expected files outside the artifacts paths not to be archived")
+	}
+}
+
+func TestArtifactsStayInWorkspace(t *testing.T) {
+	workspace := t.TempDir()
+	outside := t.TempDir()
+	writeWorkspaceFile(t, outside, "passwd", "root:x:0:0")
+	if err := os.Symlink(outside, filepath.Join(workspace, "host")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "job.tar.gz")
+	if _, err := archiveArtifacts(workspace, []string{"../secret"}, archive); err == nil {
+		t.Error("Expected a path outside the workspace to be rejected")
+	}
+
+	// Files reached through a symlink are not archived
+	missing, err := archiveArtifacts(workspace, []string{"host/*"}, archive)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(missing, []string{"host/*"}) {
+		t.Errorf("Expected files behind a symlink to be ignored, got %v", missing)
+	}
+}
+
+func TestArtifactStoreRestore(t *testing.T) {
+	workspace := t.TempDir()
+	writeWorkspaceFile(t, workspace, "bin/app", "v1")
+
+	store := newArtifactStore(t.TempDir(), 42)
+	defer store.cleanup()
+	if _, err := store.store(workspace, "build", []string{"bin/*"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// A later job sees the artifact even if the workspace lost it
+	os.RemoveAll(filepath.Join(workspace, "bin"))
+	if conflicts, err := store.restore(workspace); err != nil || len(conflicts) != 0 {
+		t.Fatalf("Expected no error nor conflict, got %v (%v)", conflicts, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(workspace, "bin/app")); err != nil || string(data) != "v1" {
+		t.Errorf("Expected artifact to be restored, got %q (%v)", data, err)
+	}
+
+	// An unchanged file is no conflict, restoring again before the next stage is silent
+	if conflicts, err := store.restore(workspace); err != nil || len(conflicts) != 0 {
+		t.Fatalf("Expected no error nor conflict, got %v (%v)", conflicts, err)
+	}
+}
+
+func TestArtifactStoreRestoreConflict(t *testing.T) {
+	workspace := t.TempDir()
+	writeWorkspaceFile(t, workspace, "bin/app", "v1")
+	writeWorkspaceFile(t, workspace, "bin/tool", "t1")
+
+	store := newArtifactStore(t.TempDir(), 42)
+	defer store.cleanup()
+	if _, err := store.store(workspace, "build", []string{"bin/*"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// A later job rebuilt the binary, the artifact must not overwrite it
+	writeWorkspaceFile(t, workspace, "bin/app", "v2")
+	conflicts, err := store.restore(workspace)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(conflicts, []string{"bin/app"}) {
+		t.Errorf("Expected the changed file to be reported, got %v", conflicts)
+	}
+	if data, err := os.ReadFile(filepath.Join(workspace, "bin/app")); err != nil || string(data) != "v2" {
+		t.Errorf("Expected the workspace file to be kept, got %q (%v)", data, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(workspace, "bin/tool")); err != nil || string(data) != "t1" {
+		t.Errorf("Expected the unchanged file to stay, got %q (%v)", data, err)
+	}
+}
@@ -0,0 +1,280 @@
+package executor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/docker"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+)
+
+// cacheTarget is where the cache volume is mounted in the containers restoring and saving it
+const cacheTarget = "/cache"
+
+// cachePruneInterval is the least time between two prunings of the cache volumes
+const cachePruneInterval = time.Hour
+
+// jobCache is the cache of a job, the volume it lives in and the workspace paths it holds
+type jobCache struct {
+	key    string
+	volume string
+	paths  []string
+}
+
+// cacheKey returns the key of a cache, its name or, with key files, its prefix and the hash of the files
+// As with GitLab, key files missing from the workspace are left out and the key is "default" without any of them
+// Key files resolving out of the workspace, through ".." or a symlink, are left out as well
+func cacheKey(key pipeline.CacheKey, workspaceDir string) string {
+	if len(key.Files) == 0 {
+		if key.Name == "" {
+			return "default"
+		}
+		return key.Name
+	}
+
+	hash := sha256.New()
+	found := false
+	for _, file := range key.Files {
+		path := filepath.Join(workspaceDir, filepath.Clean(file))
+		if !insideDir(workspaceDir, path) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		found = true
+		fmt.Fprintf(hash, "%s\x00%d\x00", file, len(data))
+		hash.Write(data)
+	}
+	if !found {
+		return "default"
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if key.Prefix == "" {
+		return sum
+	}
+	return key.Prefix + "-" + sum
+}
+
+// cacheVolumePrefix returns the prefix of the names of the cache volumes
+func cacheVolumePrefix(prefix string) string {
+	if prefix == "" {
+		prefix = "dnd"
+	}
+	return prefix + "-cache-"
+}
+
+// cacheVolumeName returns the volume of a cache, named from the project and the hash of the key
+func cacheVolumeName(prefix string, projectID int, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%s%d-%s", cacheVolumePrefix(prefix), projectID, hex.EncodeToString(sum[:8]))
+}
+
+// cacheUsage records when this process last used each cache volume, for pruning
+// Volumes it never used count as used when they were created
+type cacheUsage struct {
+	mu         sync.Mutex
+	used       map[string]time.Time
+	lastPruned time.Time
+}
+
+// touch records a use of a cache volume
+func (u *cacheUsage) touch(volume string, now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.used == nil {
+		u.used = make(map[string]time.Time)
+	}
+	u.used[volume] = now
+}
+
+// due reports whether a pruning is due and, if so, records it as done
+func (u *cacheUsage) due(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if now.Sub(u.lastPruned) < cachePruneInterval {
+		return false
+	}
+	u.lastPruned = now
+	return true
+}
+
+// lastUses returns the last use of the volumes found on the daemon, forgetting those that are gone
+func (u *cacheUsage) lastUses(volumes []docker.VolumeInfo) map[string]time.Time {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	found := make(map[string]time.Time, len(volumes))
+	for _, volume := range volumes {
+		used, ok := u.used[volume.Name]
+		if !ok {
+			used = volume.CreatedAt
+		}
+		found[volume.Name] = used
+	}
+	u.used = make(map[string]time.Time, len(found))
+	for name, used := range found {
+		u.used[name] = used
+	}
+	return found
+}
+
+// staleCacheVolumes returns the cache volumes to prune: those unused for more than maxAge,
+// then the least recently used ones beyond maxVolumes; zero disables either bound
+func staleCacheVolumes(used map[string]time.Time, now time.Time, maxAge time.Duration, maxVolumes int) []string {
+	names := make([]string, 0, len(used))
+	for name := range used {
+		names = append(names, name)
+	}
+	// Most recently used first
+	sort.Slice(names, func(i, j int) bool {
+		if !used[names[i]].Equal(used[names[j]]) {
+			return used[names[i]].After(used[names[j]])
+		}
+		return names[i] < names[j]
+	})
+
+	var stale []string
+	for i, name := range names {
+		if (maxVolumes > 0 && i >= maxVolumes) || (maxAge > 0 && now.Sub(used[name]) > maxAge) {
+			stale = append(stale, name)
+		}
+	}
+	return stale
+}
+
+// restoreCacheScript copies the content of the cache, if it was ever saved, into the workspace
+func restoreCacheScript() string {
+	return fmt.Sprintf("if [ -d %[1]s/data ]; then cp -a %[1]s/data/. .; fi", cacheTarget)
+}
+
+// saveCacheScript copies the cached paths found in the workspace to a new copy of the cache, which then replaces the
+// previous one; an interrupted save leaves the previous copy in place
+func saveCacheScript(paths []string) string {
+	quoted := make([]string, len(paths))
+	for i, path := range paths {
+		quoted[i] = shellQuote(filepath.ToSlash(filepath.Clean(path)))
+	}
+	return fmt.Sprintf(`rm -rf %[1]s/.new && mkdir -p %[1]s/.new && `+
+		`for path in %[2]s; do if [ -e "$path" ]; then mkdir -p "%[1]s/.new/$(dirname "$path")" && cp -a "$path" "%[1]s/.new/$path" || exit 1; fi; done && `+
+		`rm -rf %[1]s/data && mv %[1]s/.new %[1]s/data`, cacheTarget, strings.Join(quoted, " "))
+}
+
+// shellQuote quotes a value for sh
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// cacheLock returns the lock of a cache volume, restores share it and saves hold it alone
+// so that no job of this server restores a cache while another job saves it
+// The lock is held in memory: servers sharing a Docker daemon do not see the locks of one another,
+// the save replacing the cache in a single rename keeps what they restore consistent, not necessarily the newest
+func (e *PipelineExecutor) cacheLock(volume string) *sync.RWMutex {
+	lock, _ := e.cacheLocks.LoadOrStore(volume, &sync.RWMutex{})
+	return lock.(*sync.RWMutex)
+}
+
+// restoreCache restores the cache of a job into the workspace, it returns nil for a job without cache
+// A cache that cannot be restored only makes the job slower, the failure is reported in the job logs
+func (e *PipelineExecutor) restoreCache(ctx context.Context, log *slog.Logger, jobName string, job pipeline.JobConfig, workspaceDir string, pipelineID, projectID int) *jobCache {
+	if job.Cache == nil {
+		return nil
+	}
+	key := cacheKey(job.Cache.Key, workspaceDir)
+	cache := &jobCache{key: key, volume: cacheVolumeName(e.containerPrefix, projectID, key), paths: job.Cache.Paths}
+
+	e.cacheUsage.touch(cache.volume, time.Now())
+	lock := e.cacheLock(cache.volume)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	e.jobLog(pipelineID, jobName, fmt.Sprintf("Restoring cache %s", key))
+	if err := e.runCacheStep(ctx, job, restoreCacheScript(), workspaceDir, cache, true); err != nil {
+		log.Warn("Failed to restore cache", "key", key, "error", err)
+		e.jobLog(pipelineID, jobName, fmt.Sprintf("WARNING: failed to restore cache %s: %v", key, err))
+	}
+	return cache
+}
+
+// saveCache saves the cached paths of a successful job, a failure is reported in the job logs and does not fail the job
+func (e *PipelineExecutor) saveCache(ctx context.Context, log *slog.Logger, jobName string, job pipeline.JobConfig, workspaceDir string, pipelineID int, cache *jobCache) {
+	if cache == nil {
+		return
+	}
+
+	// Prune the old caches in the background once the save is over
+	defer func() { go e.pruneCaches(context.WithoutCancel(ctx), log) }()
+
+	e.cacheUsage.touch(cache.volume, time.Now())
+	lock := e.cacheLock(cache.volume)
+	lock.Lock()
+	defer lock.Unlock()
+
+	e.jobLog(pipelineID, jobName, fmt.Sprintf("Saving cache %s", cache.key))
+	if err := e.runCacheStep(ctx, job, saveCacheScript(cache.paths), workspaceDir, cache, false); err != nil {
+		log.Warn("Failed to save cache", "key", cache.key, "error", err)
+		e.jobLog(pipelineID, jobName, fmt.Sprintf("WARNING: failed to save cache %s: %v", cache.key, err))
+	}
+}
+
+// pruneCaches removes the cache volumes unused for CACHE_MAX_AGE_DAYS and the least recently used ones beyond
+// CACHE_MAX_VOLUMES, at most once per cachePruneInterval; a volume in use by a job is kept for a later pruning
+func (e *PipelineExecutor) pruneCaches(ctx context.Context, log *slog.Logger) {
+	if (e.cacheMaxAge <= 0 && e.cacheMaxVolumes <= 0) || !e.cacheUsage.due(time.Now()) {
+		return
+	}
+	volumes, err := e.docker.ListVolumes(ctx, cacheVolumePrefix(e.containerPrefix))
+	if err != nil {
+		log.Warn("Failed to list cache volumes", "error", err)
+		return
+	}
+	for _, volume := range staleCacheVolumes(e.cacheUsage.lastUses(volumes), time.Now(), e.cacheMaxAge, e.cacheMaxVolumes) {
+		lock := e.cacheLock(volume)
+		if !lock.TryLock() {
+			continue
+		}
+		err := e.docker.RemoveVolume(ctx, volume)
+		lock.Unlock()
+		if err != nil {
+			log.Warn("Failed to prune cache volume", "volume", volume, "error", err)
+			continue
+		}
+		log.Info("Pruned cache volume", "volume", volume)
+	}
+}
+
+// runCacheStep runs a cache script in a container of the job image, with the workspace and the cache volume mounted
+// copyBack brings the files the script wrote back to the workspace when it is copied rather than bind-mounted
+func (e *PipelineExecutor) runCacheStep(ctx context.Context, job pipeline.JobConfig, script, workspaceDir string, cache *jobCache, copyBack bool) error {
+	containerID, err := e.docker.RunJobWithVolume(ctx, job.Image, []string{script}, workspaceDir, nil, docker.JobOptions{
+		Volumes:    map[string]string{cacheTarget: cache.volume},
+		Shell:      job.Shell,
+		Entrypoint: job.Entrypoint,
+	})
+	if containerID != "" {
+		defer e.docker.RemoveContainer(context.WithoutCancel(ctx), containerID)
+	}
+	if err != nil {
+		return err
+	}
+	statusCode, err := e.docker.WaitForContainer(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	if statusCode != 0 {
+		return fmt.Errorf("cache container exited with code %d", statusCode)
+	}
+	if copyBack {
+		return e.docker.CopyWorkspaceBack(ctx, containerID, workspaceDir)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package executor
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+)
+
+func TestCacheKey(t *testing.T) {
+	workspace := t.TempDir()
+	os.WriteFile(filepath.Join(workspace, "package-lock.json"), []byte(`{"lockfileVersion": 3}`), 0644)
+
+	if key := cacheKey(pipeline.CacheKey{}, workspace); key != "default" {
+		t.Errorf("Expected the default key, got %q", key)
+	}
+	if key := cacheKey(pipeline.CacheKey{Name: "deps"}, workspace); key != "deps" {
+		t.Errorf("Expected the key name, got %q", key)
+	}
+	if key := cacheKey(pipeline.CacheKey{Files: []string{"go.sum"}}, workspace); key != "default" {
+		t.Errorf("Expected the default key without key files, got %q", key)
+	}
+
+	lockKey := pipeline.CacheKey{Files: []string{"package-lock.json", "yarn.lock"}, Prefix: "npm"}
+	key := cacheKey(lockKey, workspace)
+	if !strings.HasPrefix(key, "npm-") || len(key) != len("npm-")+64 {
+		t.Errorf("Expected the prefix and the hash of the lockfile, got %q", key)
+	}
+	if again := cacheKey(lockKey, workspace); again != key {
+		t.Errorf("Expected the same key for the same lockfile, got %q and %q", key, again)
+	}
+
+	os.WriteFile(filepath.Join(workspace, "package-lock.json"), []byte(`{"lockfileVersion": 2}`), 0644)
+	if changed := cacheKey(lockKey, workspace); changed == key {
+		t.Errorf("Expected the key to change with the lockfile")
+	}
+}
+
+func TestCacheVolumeName(t *testing.T) {
+	name := cacheVolumeName("", 3, "deps")
+	if !strings.HasPrefix(name, "dnd-cache-3-") || len(name) != len("dnd-cache-3-")+16 {
+		t.Errorf("Unexpected volume name %q", name)
+	}
+	if other := cacheVolumeName("", 4, "deps"); other == name {
+		t.Errorf("Expected the projects to get their own volume")
+	}
+	if other := cacheVolumeName("", 3, "npm"); other == name {
+		t.Errorf("Expected the keys to get their own volume")
+	}
+}
+
+// runCacheScript runs a cache script in workspace, with the cache directory in place of the volume
+func runCacheScript(t *testing.T, script, workspace, cacheDir string) {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", strings.ReplaceAll(script, cacheTarget, cacheDir))
+	cmd.Dir = workspace
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Cache script failed: %v: %s", err, output)
+	}
+}
+
+func TestCacheScripts(t *testing.T) {
+	cacheDir := t.TempDir()
+	workspace := t.TempDir()
+	os.MkdirAll(filepath.Join(workspace, "node_modules", "left-pad"), 0755)
+	os.WriteFile(filepath.Join(workspace, "node_modules", "left-pad", "index.js"), []byte("module.exports = pad"), 0644)
+	os.MkdirAll(filepath.Join(workspace, "build", "it's cached"), 0755)
+	os.WriteFile(filepath.Join(workspace, "main.go"), []byte("package main"), 0644)
+
+	// A first run finds no cache, then saves the cached paths present in the workspace
+	runCacheScript(t, restoreCacheScript(), workspace, cacheDir)
+	runCacheScript(t, saveCacheScript([]string{"node_modules", "build/it's cached", ".npm"}), workspace, cacheDir)
+
+	next := t.TempDir()
+	runCacheScript(t, restoreCacheScript(), next, cacheDir)
+	if data, err := os.ReadFile(filepath.Join(next, "node_modules", "left-pad", "index.js")); err != nil || string(data) != "module.exports = pad" {
+		t.Errorf("Expected the cached dependencies restored, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(next, "build", "it's cached")); err != nil {
+		t.Errorf("Expected the nested cached path restored: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(next, "main.go")); !os.IsNotExist(err) {
+		t.Errorf("Expected only the cached paths restored")
+	}
+
+	// A save replaces the previous copy of the cache
+	os.RemoveAll(filepath.Join(workspace, "node_modules", "left-pad"))
+	runCacheScript(t, saveCacheScript([]string{"node_modules"}), workspace, cacheDir)
+	if _, err := os.Stat(filepath.Join(cacheDir, "data", "node_modules", "left-pad")); !os.IsNotExist(err) {
+		t.Errorf("Expected the saved cache to replace the previous one")
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, ".new")); !os.IsNotExist(err) {
+		t.Errorf("Expected no copy in progress left in the cache")
+	}
+}
+
+func TestCacheKeyFilesOutsideWorkspace(t *testing.T) {
+	root := t.TempDir()
+	workspace := filepath.Join(root, "workspace")
+	os.Mkdir(workspace, 0755)
+	os.WriteFile(filepath.Join(root, "secret"), []byte("token"), 0644)
+	if err := os.Symlink(filepath.Join(root, "secret"), filepath.Join(workspace, "package-lock.json")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+
+	for _, file := range []string{"package-lock.json", "../secret"} {
+		if key := cacheKey(pipeline.CacheKey{Files: []string{file}}, workspace); key != "default" {
+			t.Errorf("Expected %s to be left out of the key, got %q", file, key)
+		}
+	}
+}
+
+func TestStaleCacheVolumes(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	used := map[string]time.Time{
+		"dnd-cache-1-a": now.Add(-time.Hour),
+		"dnd-cache-1-b": now.Add(-48 * time.Hour),
+		"dnd-cache-2-c": now.Add(-40 * 24 * time.Hour),
+		"dnd-cache-2-d": now.Add(-2 * time.Hour),
+	}
+
+	if stale := staleCacheVolumes(used, now, 30*24*time.Hour, 0); !reflect.DeepEqual(stale, []string{"dnd-cache-2-c"}) {
+		t.Errorf("Expected the volume unused for 40 days to be pruned, got %v", stale)
+	}
+	if stale := staleCacheVolumes(used, now, 0, 2); !reflect.DeepEqual(stale, []string{"dnd-cache-1-b", "dnd-cache-2-c"}) {
+		t.Errorf("Expected the least recently used volumes to be pruned, got %v", stale)
+	}
+	if stale := staleCacheVolumes(used, now, 0, 0); len(stale) != 0 {
+		t.Errorf("Expected no pruning without bounds, got %v", stale)
+	}
+}
@@ -0,0 +1,89 @@
+package executor
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+)
+
+// coverageNumber matches the percentage inside a coverage match
+var coverageNumber = regexp.MustCompile(`\d+(?:\.\d+)?`)
+
+// compileCoverage compiles the `coverage` expression of a job
+func compileCoverage(pattern string) (*regexp.Regexp, error) {
+	re, err := compileJobExpression(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid coverage expression: %w", err)
+	}
+	return re, nil
+}
+
+// compileLogFilter compiles the `log_filter` expression of a job
+func compileLogFilter(pattern string) (*regexp.Regexp, error) {
+	re, err := compileJobExpression(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log filter: %w", err)
+	}
+	return re, nil
+}
+
+// compileJobExpression compiles a regular expression of a job config, written as /regex/ or regex
+// An empty expression compiles to nil
+func compileJobExpression(pattern string) (*regexp.Regexp, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return nil, nil
+	}
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		pattern = pattern[1 : len(pattern)-1]
+	}
+	return regexp.Compile(pattern)
+}
+
+// coverageFromLine extracts the coverage percentage of a log line
+// The first capture group is used if any, otherwise the whole match
+func coverageFromLine(re *regexp.Regexp, line string) (float64, bool) {
+	match := re.FindStringSubmatch(line)
+	if match == nil {
+		return 0, false
+	}
+	text := match[0]
+	if len(match) > 1 && match[1] != "" {
+		text = match[1]
+	}
+	value, err := strconv.ParseFloat(coverageNumber.FindString(text), 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// aggregateCoverage combines the coverage of the jobs, given in execution order
+// It returns nil when no job reported a coverage
+func aggregateCoverage(strategy string, values []float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+
+	var result float64
+	switch strategy {
+	case pipeline.CoverageAverage:
+		for _, v := range values {
+			result += v
+		}
+		result /= float64(len(values))
+	case pipeline.CoverageMax:
+		result = values[0]
+		for _, v := range values[1:] {
+			if v > result {
+				result = v
+			}
+		}
+	default:
+		result = values[len(values)-1]
+	}
+	return &result
+}
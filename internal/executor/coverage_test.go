@@ -0,0 +1,46 @@
+package executor
+
+import "testing"
+
+func TestCoverageFromLine(t *testing.T) {
+	re, err := compileCoverage(`/total:\s+\(statements\)\s+(\d+\.\d+)%/`)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if value, ok := coverageFromLine(re, "total:	(statements)	81.25%"); !ok || value != 81.25 {
+		t.Errorf("Expected coverage 81.25, got %v (matched: %t)", value, ok)
+	}
+	if _, ok := coverageFromLine(re, "ok  	example.com/pkg	0.002s"); ok {
+		t.Error("Expected no coverage for a line without match")
+	}
+
+	if _, err := compileCoverage("/(unclosed/"); err == nil {
+		t.Error("Expected error for an invalid expression")
+	}
+}
+
+func TestAggregateCoverage(t *testing.T) {
+	values := []float64{80, 90, 70}
+
+	tests := []struct {
+		strategy string
+		expected float64
+	}{
+		{"last", 70},
+		{"", 70}, // last is the default
+		{"average", 80},
+		{"max", 90},
+	}
+
+	for _, tt := range tests {
+		got := aggregateCoverage(tt.strategy, values)
+		if got == nil || *got != tt.expected {
+			t.Errorf("aggregateCoverage(%q) = %v, expected %v", tt.strategy, got, tt.expected)
+		}
+	}
+
+	if got := aggregateCoverage("average", nil); got != nil {
+		t.Errorf("Expected nil coverage without jobs reporting one, got %v", *got)
+	}
+}
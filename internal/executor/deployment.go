@@ -6,12 +6,14 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/database"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/docker"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/compose"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/ssh"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/env"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
 )
 
@@ -61,12 +63,35 @@ fi
 type DeploymentExecutor struct {
 	db     *database.DB
 	docker *docker.DockerExecutor
+	// nameStrategy names the compose project of the deployments (DEPLOY_PROJECT_NAME_STRATEGY)
+	nameStrategy string
+	// changedServicesOnly limits local deployments to the services affected by the pushed changes
+	changedServicesOnly bool
+	// composeWait waits for healthy services with `up --wait` (DEPLOY_COMPOSE_WAIT), bounded by composeWaitTimeout
+	composeWait        bool
+	composeWaitTimeout time.Duration
+	// healthTimeout bounds the health checks of local deployments (DEPLOY_HEALTH_TIMEOUT)
+	healthTimeout time.Duration
+	// healthGracePeriod is how long the services must stay healthy after a local deployment (DEPLOY_HEALTH_GRACE_PERIOD)
+	healthGracePeriod time.Duration
+	// healthAllowPrivate lets the health_check_url of the projects reach private addresses (DEPLOY_HEALTH_ALLOW_PRIVATE)
+	healthAllowPrivate bool
+	// teardown removes the containers of the previous version before a local deployment (DEPLOY_TEARDOWN)
+	teardown bool
 }
 
 func NewDeploymentExecutor(db *database.DB, docker *docker.DockerExecutor) *DeploymentExecutor {
 	return &DeploymentExecutor{
-		db:     db,
-		docker: docker,
+		db:                  db,
+		docker:              docker,
+		nameStrategy:        env.String("DEPLOY_PROJECT_NAME_STRATEGY", ProjectNameStrategyName),
+		changedServicesOnly: env.Bool("DEPLOY_CHANGED_SERVICES_ONLY", false),
+		composeWait:         env.Bool("DEPLOY_COMPOSE_WAIT", false),
+		composeWaitTimeout:  time.Duration(env.Int("DEPLOY_COMPOSE_WAIT_TIMEOUT", 120)) * time.Second,
+		healthTimeout:       time.Duration(env.Int("DEPLOY_HEALTH_TIMEOUT", 120)) * time.Second,
+		healthGracePeriod:   time.Duration(env.Int("DEPLOY_HEALTH_GRACE_PERIOD", 30)) * time.Second,
+		healthAllowPrivate:  env.Bool("DEPLOY_HEALTH_ALLOW_PRIVATE", false),
+		teardown:            env.Bool("DEPLOY_TEARDOWN", false),
 	}
 }
 
@@ -74,28 +99,77 @@ func NewDeploymentExecutor(db *database.DB, docker *docker.DockerExecutor) *Depl
 func (e *DeploymentExecutor) Execute(project *models.Project, params models.PipelineRunParams, workspaceDir string) (string, error) {
 	dLogger := e.newDeploymentLogger(params.PipelineID)
 
+	// Pipeline and project variables are exposed to the compose file (${CI_COMMIT_SHA}, ...)
+	variables, masker := projectVariables(e.db, project)
+	dLogger.masker = masker
+	envVars := append(predefinedVariables(params), variables...)
+
 	var err error
 	// Check if we should use Registry/SSH flow
 	if project != nil && project.RegistryUser != "" && project.SSHHost != "" {
-		err = e.deployRemote(project, params, workspaceDir, dLogger)
+		err = e.deployRemote(project, params, workspaceDir, envVars, dLogger)
 	} else {
-		err = e.deployLocal(params, workspaceDir, dLogger)
+		err = e.deployLocal(project, params, workspaceDir, envVars, dLogger)
 	}
 
 	return dLogger.String(), err
 }
 
 // deployLocal handles execution on the same machine
-func (e *DeploymentExecutor) deployLocal(params models.PipelineRunParams, workspaceDir string, dLogger *DeploymentLogger) error {
+func (e *DeploymentExecutor) deployLocal(project *models.Project, params models.PipelineRunParams, workspaceDir string, envVars []string, dLogger *DeploymentLogger) error {
 	dLogger.Log("Using local deployment flow")
-	sanitizedRepoName := sanitizeProjectName(params.RepoName)
-	localLogs, localErr := e.docker.DeployCompose(workspaceDir, params.DeploymentFilename, sanitizedRepoName)
+	sanitizedRepoName := e.composeProjectName(params)
+	// A stack must be healthy before the next one is deployed
+	opts := docker.ComposeOptions{Env: envVars, Wait: e.composeWait || params.DeploymentStack != "", WaitTimeout: e.composeWaitTimeout, Teardown: e.teardown}
+	opts.HealthTimeout = e.healthTimeout
+	opts.HealthGracePeriod = e.healthGracePeriod
+	opts.HealthURL = healthCheckURL(project, params)
+	opts.HealthAllowPrivate = e.healthAllowPrivate
+	if project != nil && len(project.ComposeProfiles) > 0 {
+		opts.Profiles = project.ComposeProfiles
+		dLogger.Log(fmt.Sprintf("Active compose profiles: %s", strings.Join(opts.Profiles, ", ")))
+	}
+	if e.changedServicesOnly {
+		opts.Services = e.changedServices(params, workspaceDir, dLogger)
+	}
+	localLogs, localErr := e.docker.DeployCompose(workspaceDir, params.DeploymentFilename, sanitizedRepoName, opts)
 	dLogger.Log(localLogs)
-	return localErr
+	if localErr != nil {
+		return &DeployCommandError{Err: localErr}
+	}
+	return nil
+}
+
+// healthCheckURL returns the endpoint checked after the deployment, only after the last stack of a project
+func healthCheckURL(project *models.Project, params models.PipelineRunParams) string {
+	if project == nil {
+		return ""
+	}
+	if stacks := params.DeploymentStacks; params.DeploymentStack != "" && len(stacks) > 0 && params.DeploymentFilename != stacks[len(stacks)-1] {
+		return ""
+	}
+	return project.HealthCheckURL
+}
+
+// changedServices returns the compose services affected by the pushed changes, nil for all services
+func (e *DeploymentExecutor) changedServices(params models.PipelineRunParams, workspaceDir string, dLogger *DeploymentLogger) []string {
+	contexts, err := compose.ParseBuildContexts(filepath.Join(workspaceDir, params.DeploymentFilename))
+	if err != nil {
+		dLogger.Log(fmt.Sprintf("Deploying all services: %v", err))
+		return nil
+	}
+
+	services := compose.AffectedServices(params.DeploymentFilename, contexts, params.ChangedFiles)
+	if services == nil {
+		dLogger.Log("Deploying all services")
+	} else {
+		dLogger.Log(fmt.Sprintf("Deploying changed services: %s", strings.Join(services, ", ")))
+	}
+	return services
 }
 
 // deployRemote handles the build-push-deploy-ssh flow
-func (e *DeploymentExecutor) deployRemote(project *models.Project, params models.PipelineRunParams, workspaceDir string, dLogger *DeploymentLogger) error {
+func (e *DeploymentExecutor) deployRemote(project *models.Project, params models.PipelineRunParams, workspaceDir string, envVars []string, dLogger *DeploymentLogger) error {
 	dLogger.Log("Using Registry/SSH deployment flow")
 
 	// 1. Generate docker-compose.override.yml
@@ -111,7 +185,7 @@ func (e *DeploymentExecutor) deployRemote(project *models.Project, params models
 	}
 
 	// 3. Remote Deploy via SSH
-	return e.executeRemoteSSH(project, params, workspaceDir, overrideFilename, overrideContent, dLogger)
+	return e.executeRemoteSSH(project, params, workspaceDir, overrideFilename, overrideContent, envVars, dLogger)
 }
 
 // generateOverride creates the compose override file for registry usage
@@ -169,7 +243,7 @@ func (e *DeploymentExecutor) buildAndPushImages(project *models.Project, params
 }
 
 // executeRemoteSSH handles the SSH connection and remote command execution
-func (e *DeploymentExecutor) executeRemoteSSH(project *models.Project, params models.PipelineRunParams, workspaceDir, overrideFilename string, overrideContent []byte, dLogger *DeploymentLogger) error {
+func (e *DeploymentExecutor) executeRemoteSSH(project *models.Project, params models.PipelineRunParams, workspaceDir, overrideFilename string, overrideContent []byte, envVars []string, dLogger *DeploymentLogger) error {
 	if project.SSHHost == "" {
 		dLogger.Log("No SSH host configured, skipping remote deployment.")
 		return nil // Or error? Logic in original was "skip" but effectively success or just doing nothing.
@@ -184,7 +258,7 @@ func (e *DeploymentExecutor) executeRemoteSSH(project *models.Project, params mo
 	defer client.Close()
 	dLogger.Log(fmt.Sprintf("Connected via SSH to %s", project.SSHHost))
 
-	sanitizedRepoName := sanitizeProjectName(params.RepoName)
+	sanitizedRepoName := e.composeProjectName(params)
 	remoteDir := fmt.Sprintf("deploy/%s", sanitizedRepoName)
 	client.RunCommand("mkdir -p " + remoteDir)
 
@@ -203,8 +277,10 @@ func (e *DeploymentExecutor) executeRemoteSSH(project *models.Project, params mo
 	logger.Debug(fmt.Sprintf("The sanitizedRepoName %s", sanitizedRepoName))
 
 	// Run script
-	cmd := fmt.Sprintf("export PATH=$PATH:/usr/local/bin:/usr/bin && cd %s && ./deploy.sh %s %s %s",
-		remoteDir, sanitizedRepoName, params.DeploymentFilename, overrideFilename)
+	if len(project.ComposeProfiles) > 0 {
+		dLogger.Log(fmt.Sprintf("Active compose profiles: %s", strings.Join(project.ComposeProfiles, ", ")))
+	}
+	cmd := remoteDeployCommand(remoteDir, envVars, project.ComposeProfiles, sanitizedRepoName, params.DeploymentFilename, overrideFilename)
 
 	remoteErr := client.RunCommandStream(cmd, func(line string) {
 		dLogger.Log(line)
@@ -212,18 +288,42 @@ func (e *DeploymentExecutor) executeRemoteSSH(project *models.Project, params mo
 
 	if remoteErr != nil {
 		dLogger.Log(fmt.Sprintf("Remote command error: %v", remoteErr))
-		return remoteErr
+		return &DeployCommandError{Err: remoteErr}
 	}
 
 	return nil
 }
 
+// remoteDeployCommand builds the shell command running deploy.sh on the remote host
+// envVars (KEY=VALUE, the CI_* and project variables) are exported for the compose file like in local deployments
+// Every value comes from the project or the repository and is quoted, none of them can run commands on the host
+func remoteDeployCommand(remoteDir string, envVars, profiles []string, projectName, composeFile, overrideFile string) string {
+	cmd := fmt.Sprintf("export PATH=$PATH:/usr/local/bin:/usr/bin && cd %s && ", shellQuote(remoteDir))
+	for _, envVar := range envVars {
+		key, value, _ := strings.Cut(envVar, "=")
+		// A name the shell cannot export is skipped rather than breaking the command
+		if !shellVariableName.MatchString(key) {
+			continue
+		}
+		cmd += fmt.Sprintf("export %s=%s && ", key, shellQuote(value))
+	}
+	if len(profiles) > 0 {
+		// docker compose reads the active profiles from COMPOSE_PROFILES
+		cmd += fmt.Sprintf("export COMPOSE_PROFILES=%s && ", shellQuote(strings.Join(profiles, ",")))
+	}
+	return cmd + fmt.Sprintf("./deploy.sh %s %s %s", shellQuote(projectName), shellQuote(composeFile), shellQuote(overrideFile))
+}
+
+// shellVariableName matches the variable names a POSIX shell can export
+var shellVariableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 // === Deployment Helper Struct ===
 
 type DeploymentLogger struct {
 	db         *database.DB
 	pipelineID int
 	logs       strings.Builder
+	masker     *logMasker // hides the masked variables exposed to the compose file
 }
 
 func (e *DeploymentExecutor) newDeploymentLogger(pipelineID int) *DeploymentLogger {
@@ -234,6 +334,8 @@ func (e *DeploymentExecutor) newDeploymentLogger(pipelineID int) *DeploymentLogg
 }
 
 func (dLogger *DeploymentLogger) Log(msg string) {
+	msg = dLogger.masker.mask(msg)
+
 	// 1. Append to local builder (for return)
 	dLogger.logs.WriteString(msg + "\n")
 
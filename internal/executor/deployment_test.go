@@ -0,0 +1,53 @@
+package executor
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRemoteDeployCommand(t *testing.T) {
+	cmd := remoteDeployCommand("deploy/my-app", nil, []string{"web", "debug"}, "my-app", "docker-compose.yml", "docker-compose.override.yml")
+	expected := "export PATH=$PATH:/usr/local/bin:/usr/bin && cd 'deploy/my-app' && export COMPOSE_PROFILES='web,debug' && " +
+		"./deploy.sh 'my-app' 'docker-compose.yml' 'docker-compose.override.yml'"
+	if cmd != expected {
+		t.Errorf("Expected %q, got %q", expected, cmd)
+	}
+	if cmd := remoteDeployCommand("deploy/my-app", nil, nil, "my-app", "c.yml", "o.yml"); strings.Contains(cmd, "COMPOSE_PROFILES") {
+		t.Errorf("Expected no profiles to be exported, got %q", cmd)
+	}
+}
+
+func TestRemoteDeployCommandVariables(t *testing.T) {
+	envVars := []string{"CI_COMMIT_SHA=abc123", "DB_PASSWORD=it's $(secret)", "BAD-NAME=x", "CI_PIPELINE_ID=42"}
+	cmd := remoteDeployCommand(".", envVars, nil, "app", "c.yml", "o.yml")
+	if strings.Contains(cmd, "BAD-NAME") {
+		t.Errorf("Expected a variable name the shell cannot export to be skipped, got %q", cmd)
+	}
+
+	// The values reach deploy.sh literally, quotes and command substitutions included
+	cmd = strings.Replace(cmd, "./deploy.sh", `printf '%s\n' "$CI_COMMIT_SHA" "$DB_PASSWORD" "$CI_PIPELINE_ID"; true`, 1)
+	output, err := exec.Command("sh", "-c", cmd).Output()
+	if err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+	expected := []string{"abc123", "it's $(secret)", "42"}
+	if lines := strings.Split(strings.TrimSpace(string(output)), "\n"); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected the exported variables %q, got %q", expected, lines)
+	}
+}
+
+func TestRemoteDeployCommandHostileProfile(t *testing.T) {
+	// The profile must reach compose as a value, never run as a command
+	cmd := remoteDeployCommand(".", nil, []string{"x;echo pwned|sh", "$(echo pwned)"}, "app", "c.yml", "o.yml")
+	cmd = strings.Replace(cmd, "./deploy.sh", "printf '%s\\n' \"$COMPOSE_PROFILES\"", 1)
+	output, err := exec.Command("sh", "-c", cmd).Output()
+	if err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if lines[0] != "x;echo pwned|sh,$(echo pwned)" {
+		t.Errorf("Expected the profiles to be passed literally, got %q", output)
+	}
+}
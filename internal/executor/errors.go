@@ -0,0 +1,38 @@
+package executor
+
+import "errors"
+
+// InfraError reports a failure of the CI infrastructure (image pull, Docker daemon, clone)
+// as opposed to a job script exiting with a non-zero code
+type InfraError struct {
+	Op  string
+	Err error
+}
+
+func (e *InfraError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *InfraError) Unwrap() error {
+	return e.Err
+}
+
+// IsInfraError reports whether err was caused by the infrastructure
+func IsInfraError(err error) bool {
+	var infraErr *InfraError
+	return errors.As(err, &infraErr)
+}
+
+// DeployCommandError wraps the failure of the command deploying the services, docker compose up
+// locally or deploy.sh over SSH, as opposed to the steps before it (registry login, build, push)
+type DeployCommandError struct {
+	Err error
+}
+
+func (e *DeployCommandError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DeployCommandError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,145 @@
+package executor
+
+import (
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+func TestProcessLogsFilter(t *testing.T) {
+	filterRe, err := compileLogFilter(`/^(Downloading|Progress:)/`)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	logs := strings.Join([]string{
+		"Downloading golang.org/x/net v0.1.0",
+		"Progress: 42%",
+		"ok  	example.com/pkg	0.002s",
+		"Downloading golang.org/x/sys v0.1.0",
+		"PASS",
+	}, "\n")
+
+	var stored []string
+	e := &PipelineExecutor{}
+	e.processLogs(slog.Default(), strings.NewReader(logs), strings.NewReader(""), nil, filterRe, nil, func(lines []models.LogLine) {
+		for _, line := range lines {
+			stored = append(stored, line.Content)
+		}
+	})
+
+	expected := []string{"ok  	example.com/pkg	0.002s", "PASS"}
+	if !reflect.DeepEqual(stored, expected) {
+		t.Errorf("Expected stored lines %q, got %q", expected, stored)
+	}
+}
+
+func TestProcessLogsWithoutFilter(t *testing.T) {
+	var stored []models.LogLine
+	e := &PipelineExecutor{}
+	e.processLogs(slog.Default(), strings.NewReader("Downloading\nPASS"), strings.NewReader(""), nil, nil, nil, func(lines []models.LogLine) {
+		stored = append(stored, lines...)
+	})
+
+	if len(stored) != 2 {
+		t.Errorf("Expected every line to be stored, got %q", stored)
+	}
+}
+
+func TestCompileLogFilterInvalid(t *testing.T) {
+	if _, err := compileLogFilter("("); err == nil {
+		t.Error("Expected an error for an invalid log filter")
+	}
+}
+
+func TestProcessLogsStreams(t *testing.T) {
+	var stored []models.LogLine
+	e := &PipelineExecutor{}
+	e.processLogs(slog.Default(), strings.NewReader("building\ndone"), strings.NewReader("warning: deprecated flag"), nil, nil, nil, func(lines []models.LogLine) {
+		stored = append(stored, lines...)
+	})
+
+	streams := make(map[string]string)
+	for _, line := range stored {
+		streams[line.Content] = line.Stream
+	}
+	expected := map[string]string{
+		"building":                 models.LogStreamStdout,
+		"done":                     models.LogStreamStdout,
+		"warning: deprecated flag": models.LogStreamStderr,
+	}
+	if !reflect.DeepEqual(streams, expected) {
+		t.Errorf("Expected streams %v, got %v", expected, streams)
+	}
+}
+
+func TestProcessLogsEcho(t *testing.T) {
+	var console strings.Builder
+	log := slog.New(slog.NewTextHandler(&console, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	masker := newLogMasker([]models.Variable{{Key: "API_TOKEN", Value: "s3cr3t", Masked: true}})
+	e := &PipelineExecutor{}
+	e.processLogs(log, strings.NewReader("token s3cr3t"), strings.NewReader(""), nil, nil, masker, func([]models.LogLine) {})
+	e.processLogs(log, strings.NewReader(""), strings.NewReader("warning: deprecated flag"), nil, nil, nil, func([]models.LogLine) {})
+
+	lines := strings.Split(strings.TrimSpace(console.String()), "\n")
+	expected := []string{
+		`level=DEBUG msg="job output" stream=stdout line="token ****"`,
+		`level=DEBUG msg="job output" stream=stderr line="warning: deprecated flag"`,
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d echoed lines, got %q", len(expected), lines)
+	}
+	for i, line := range lines {
+		// Drop the time attribute
+		if _, rest, _ := strings.Cut(line, " "); rest != expected[i] {
+			t.Errorf("Expected echo %q, got %q", expected[i], rest)
+		}
+	}
+}
+
+func TestScanStreamLongLine(t *testing.T) {
+	lines := make(chan models.LogLine, 10)
+	input := strings.Repeat("a", 100) + "\r\nnext line\nlast"
+	scanStream(strings.NewReader(input), models.LogStreamStdout, 32, lines)
+	close(lines)
+
+	var got []string
+	for line := range lines {
+		got = append(got, line.Content)
+	}
+	expected := []string{
+		strings.Repeat("a", 32) + " [line truncated, 68 bytes dropped]",
+		"next line",
+		"last",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected the long line to be truncated without stopping the collection, got %v", got)
+	}
+}
+
+func TestScanStreamLongLineMultiByte(t *testing.T) {
+	lines := make(chan models.LogLine, 10)
+	// 31 bytes then "é" (2 bytes) straddling the 32-byte limit
+	input := strings.Repeat("a", 31) + strings.Repeat("é", 10) + "\nnext line\n"
+	scanStream(strings.NewReader(input), models.LogStreamStdout, 32, lines)
+	close(lines)
+
+	var got []string
+	for line := range lines {
+		got = append(got, line.Content)
+	}
+	expected := []string{
+		strings.Repeat("a", 31) + " [line truncated, 20 bytes dropped]",
+		"next line",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected the line cut before the split character, got %q", got)
+	}
+	if !utf8.ValidString(got[0]) {
+		t.Errorf("Expected valid UTF-8, got %q", got[0])
+	}
+}
@@ -0,0 +1,120 @@
+package executor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+// maxBufferedLines bounds the lines a running job keeps for late subscribers, the oldest are dropped first
+const maxBufferedLines = 10000
+
+// subscriberBuffer is the number of lines a subscriber may lag behind before it is dropped
+const subscriberBuffer = 1024
+
+// LogHub fans the log lines of running jobs out to live subscribers
+// The lines of a job are buffered until the job is over, so that late subscribers get them too
+type LogHub struct {
+	mu   sync.Mutex
+	jobs map[int]*jobLogs
+}
+
+type jobLogs struct {
+	lines       []models.LogLine
+	next        int  // number of the next line
+	started     bool // the job published at least once
+	subscribers map[chan models.LogLine]struct{}
+}
+
+func NewLogHub() *LogHub {
+	return &LogHub{jobs: make(map[int]*jobLogs)}
+}
+
+func (h *LogHub) job(jobID int) *jobLogs {
+	logs, ok := h.jobs[jobID]
+	if !ok {
+		logs = &jobLogs{next: 1, subscribers: make(map[chan models.LogLine]struct{})}
+		h.jobs[jobID] = logs
+	}
+	return logs
+}
+
+// Publish sends a line of a job to the subscribers of the job
+// A stored line keeps its number in the job logs, a line without number is numbered after the last one
+// A subscriber too slow to keep up is dropped, its channel is closed
+func (h *LogHub) Publish(jobID int, line models.LogLine) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	logs := h.job(jobID)
+	logs.started = true
+	line.JobID = jobID
+	if line.LineNumber <= 0 {
+		line.LineNumber = logs.next
+	}
+	if line.CreatedAt.IsZero() {
+		line.CreatedAt = time.Now()
+	}
+	logs.next = line.LineNumber + 1
+
+	logs.lines = append(logs.lines, line)
+	if len(logs.lines) > maxBufferedLines {
+		logs.lines = logs.lines[len(logs.lines)-maxBufferedLines:]
+	}
+
+	for ch := range logs.subscribers {
+		select {
+		case ch <- line:
+		default:
+			delete(logs.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Finish closes the subscriptions of a job once it is over and forgets its lines
+func (h *LogHub) Finish(jobID int) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if logs, ok := h.jobs[jobID]; ok {
+		for ch := range logs.subscribers {
+			delete(logs.subscribers, ch)
+			close(ch)
+		}
+		delete(h.jobs, jobID)
+	}
+}
+
+// Subscribe returns the lines a job already published and the channel of its next lines
+// The channel is closed when the job is over; cancel must be called once the subscriber leaves
+func (h *LogHub) Subscribe(jobID int) (backlog []models.LogLine, lines <-chan models.LogLine, cancel func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	logs := h.job(jobID)
+	ch := make(chan models.LogLine, subscriberBuffer)
+	logs.subscribers[ch] = struct{}{}
+	backlog = append([]models.LogLine(nil), logs.lines...)
+
+	cancel = func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := logs.subscribers[ch]; ok {
+			delete(logs.subscribers, ch)
+			close(ch)
+		}
+		// A job that never started keeps no entry once nobody waits for it
+		if current, ok := h.jobs[jobID]; ok && current == logs && !logs.started && len(logs.subscribers) == 0 {
+			delete(h.jobs, jobID)
+		}
+	}
+	return backlog, ch, cancel
+}
@@ -0,0 +1,95 @@
+package executor
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+func TestLogHubLateSubscriber(t *testing.T) {
+	hub := NewLogHub()
+	hub.Publish(7, models.LogLine{Content: "first"})
+	hub.Publish(7, models.LogLine{Content: "second"})
+
+	// A late subscriber gets the buffered lines, then the live ones
+	backlog, lines, cancel := hub.Subscribe(7)
+	defer cancel()
+	if len(backlog) != 2 || backlog[0].Content != "first" || backlog[1].LineNumber != 2 {
+		t.Fatalf("Expected the buffered lines, got %v", backlog)
+	}
+
+	hub.Publish(7, models.LogLine{Content: "third"})
+	if line := <-lines; line.Content != "third" || line.LineNumber != 3 || line.JobID != 7 {
+		t.Errorf("Expected the live line, got %+v", line)
+	}
+
+	// The subscription ends with the job
+	hub.Finish(7)
+	if _, ok := <-lines; ok {
+		t.Error("Expected the channel to be closed once the job is over")
+	}
+	if _, ok := hub.jobs[7]; ok {
+		t.Error("Expected the lines of a finished job to be forgotten")
+	}
+}
+
+func TestLogHubFanOut(t *testing.T) {
+	hub := NewLogHub()
+	_, first, cancelFirst := hub.Subscribe(1)
+	_, second, cancelSecond := hub.Subscribe(1)
+	defer cancelSecond()
+
+	hub.Publish(1, models.LogLine{Content: "hello"})
+	if (<-first).Content != "hello" || (<-second).Content != "hello" {
+		t.Error("Expected every subscriber to receive the line")
+	}
+
+	// A subscriber leaving does not affect the others
+	cancelFirst()
+	hub.Publish(1, models.LogLine{Content: "again"})
+	if (<-second).Content != "again" {
+		t.Error("Expected the remaining subscriber to keep receiving lines")
+	}
+}
+
+func TestLogHubSubscriberWithoutJob(t *testing.T) {
+	hub := NewLogHub()
+	_, _, cancel := hub.Subscribe(3)
+	cancel()
+	if len(hub.jobs) != 0 {
+		t.Errorf("Expected no entry left for a job that never started, got %v", hub.jobs)
+	}
+}
+
+func TestLogHubKeepsStoredNumbers(t *testing.T) {
+	hub := NewLogHub()
+	_, lines, cancel := hub.Subscribe(4)
+	defer cancel()
+
+	// Stored lines keep the number of the job logs, a line without number follows the last one
+	hub.Publish(4, models.LogLine{Content: "stored", LineNumber: 12})
+	hub.Publish(4, models.LogLine{Content: "next"})
+	if line := <-lines; line.LineNumber != 12 {
+		t.Errorf("Expected the stored number, got %+v", line)
+	}
+	if line := <-lines; line.LineNumber != 13 {
+		t.Errorf("Expected the line after the stored one, got %+v", line)
+	}
+}
+
+func TestProcessLogsStoresFilteredLines(t *testing.T) {
+	var stored []string
+	e := &PipelineExecutor{}
+	filterRe, _ := compileLogFilter("/^Downloading/")
+	e.processLogs(slog.Default(), strings.NewReader("Downloading\nbuilding"), strings.NewReader(""), nil, filterRe, nil, func(lines []models.LogLine) {
+		for _, line := range lines {
+			stored = append(stored, line.Content)
+		}
+	})
+
+	if len(stored) != 1 || stored[0] != "building" {
+		t.Errorf("Expected only the kept lines to be stored and published, got %v", stored)
+	}
+}
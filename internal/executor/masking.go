@@ -0,0 +1,63 @@
+package executor
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+// maskPlaceholder replaces the values of masked variables in logs
+const maskPlaceholder = "****"
+
+// logMasker replaces the values of masked variables in log lines
+// A nil masker leaves lines untouched
+type logMasker struct {
+	values   []string
+	replacer *strings.Replacer
+}
+
+// newLogMasker returns the masker of the masked variables, nil if there are none
+func newLogMasker(variables []models.Variable) *logMasker {
+	var values []string
+	for _, v := range variables {
+		if v.Masked {
+			values = append(values, v.Value)
+		}
+	}
+	return maskValues(values)
+}
+
+// maskValues returns the masker of values, nil if there are none
+func maskValues(values []string) *logMasker {
+	values = slices.DeleteFunc(slices.Clone(values), func(v string) bool { return v == "" })
+	if len(values) == 0 {
+		return nil
+	}
+
+	// Longest values first, so that a value containing another one is fully masked
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	pairs := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		pairs = append(pairs, value, maskPlaceholder)
+	}
+	return &logMasker{values: values, replacer: strings.NewReplacer(pairs...)}
+}
+
+// with returns a masker hiding values on top of the values of m
+func (m *logMasker) with(values ...string) *logMasker {
+	if m == nil {
+		return maskValues(values)
+	}
+	return maskValues(append(slices.Clone(m.values), values...))
+}
+
+// mask returns line with the masked values replaced by maskPlaceholder
+func (m *logMasker) mask(line string) string {
+	if m == nil {
+		return line
+	}
+	return m.replacer.Replace(line)
+}
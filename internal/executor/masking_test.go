@@ -0,0 +1,62 @@
+package executor
+
+import (
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+func TestProcessLogsMasksVariables(t *testing.T) {
+	masker := newLogMasker([]models.Variable{
+		{Key: "API_TOKEN", Value: "s3cr3t-token", Masked: true},
+		{Key: "TOKEN_PREFIX", Value: "s3cr3t", Masked: true},
+		{Key: "REGION", Value: "eu-west-1"},
+	})
+
+	var stored []string
+	e := &PipelineExecutor{}
+	e.processLogs(slog.Default(), strings.NewReader("curl -H 'Authorization: s3cr3t-token'\nregion eu-west-1"), strings.NewReader("prefix s3cr3t"), nil, nil, masker, func(lines []models.LogLine) {
+		for _, line := range lines {
+			stored = append(stored, line.Content)
+		}
+	})
+
+	for _, line := range stored {
+		if strings.Contains(line, "s3cr3t") {
+			t.Errorf("Expected masked value to be replaced, got %q", line)
+		}
+	}
+	expected := map[string]bool{"curl -H 'Authorization: ****'": true, "region eu-west-1": true, "prefix ****": true}
+	got := make(map[string]bool)
+	for _, line := range stored {
+		got[line] = true
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected stored lines %v, got %v", expected, got)
+	}
+}
+
+func TestNewLogMaskerWithoutMaskedVariables(t *testing.T) {
+	masker := newLogMasker([]models.Variable{{Key: "REGION", Value: "eu-west-1"}})
+	if masker != nil {
+		t.Fatal("Expected no masker without masked variables")
+	}
+	if got := masker.mask("region eu-west-1"); got != "region eu-west-1" {
+		t.Errorf("Expected a nil masker to leave lines untouched, got %q", got)
+	}
+}
+
+func TestLogMaskerWith(t *testing.T) {
+	var masker *logMasker
+	if masker.with() != nil {
+		t.Error("Expected no masker without values")
+	}
+
+	masker = newLogMasker([]models.Variable{{Key: "API_TOKEN", Value: "abc", Masked: true}}).with("xyz", "")
+	if line := masker.mask("abc xyz"); line != "**** ****" {
+		t.Errorf("Expected both values to be masked, got %q", line)
+	}
+}
@@ -0,0 +1,69 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// pipelineNetwork is the user-defined network the job containers of a pipeline share,
+// so that the helper containers a job starts can reach the containers of the pipeline
+// It is created with the first job container and removed once the pipeline is over
+type pipelineNetwork struct {
+	name    string
+	mu      sync.Mutex
+	created bool
+}
+
+// pipelineNetworkName returns the network name of a pipeline, from its sanitized project name and its ID
+func pipelineNetworkName(prefix, repoName string, pipelineID int) string {
+	if prefix == "" {
+		prefix = "dnd"
+	}
+	name := fmt.Sprintf("%s-%s-%d", prefix, sanitizeProjectName(repoName), pipelineID)
+	return invalidContainerNameChars.ReplaceAllString(name, "-")
+}
+
+// ensure creates the network unless an earlier call did, a failed creation is tried again by the next call
+func (n *pipelineNetwork) ensure(create func(name string) error) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.created {
+		return nil
+	}
+	if err := create(n.name); err != nil {
+		return err
+	}
+	n.created = true
+	return nil
+}
+
+// ensurePipelineNetwork creates the network of the pipeline if no job did yet
+func (e *PipelineExecutor) ensurePipelineNetwork(ctx context.Context, network *pipelineNetwork) error {
+	return network.ensure(func(name string) error {
+		_, err := e.docker.CreateNetwork(ctx, name)
+		return err
+	})
+}
+
+// removePipelineNetwork removes the network of a pipeline once its jobs are over, cancelled or not, failures are only logged
+func (e *PipelineExecutor) removePipelineNetwork(ctx context.Context, log *slog.Logger, network *pipelineNetwork) {
+	network.mu.Lock()
+	created := network.created
+	network.mu.Unlock()
+	if !created {
+		return
+	}
+	if err := e.docker.RemoveNetwork(context.WithoutCancel(ctx), network.name); err != nil {
+		log.Warn("Failed to remove pipeline network", "network", network.name, "error", err)
+	}
+}
+
+// jobNetworks returns the networks of a job container, the pipeline one first, then the one of its services if any
+func jobNetworks(shared, services string) []string {
+	if services == "" {
+		return []string{shared}
+	}
+	return []string{shared, services}
+}
@@ -0,0 +1,45 @@
+package executor
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPipelineNetworkName(t *testing.T) {
+	if name := pipelineNetworkName("dnd", "My App", 42); name != "dnd-my-app-42" {
+		t.Errorf("Unexpected network name %q", name)
+	}
+	if name := pipelineNetworkName("", "api", 7); name != "dnd-api-7" {
+		t.Errorf("Expected the default prefix when job containers are not named, got %q", name)
+	}
+}
+
+func TestPipelineNetworkEnsure(t *testing.T) {
+	calls := 0
+	network := &pipelineNetwork{name: "dnd-app-1"}
+	for i := 0; i < 3; i++ {
+		network.ensure(func(name string) error { calls++; return nil })
+	}
+	if calls != 1 || !network.created {
+		t.Errorf("Expected the network to be created once, got %d call(s)", calls)
+	}
+
+	// A failed creation leaves nothing to remove, the next job tries again
+	failed := &pipelineNetwork{name: "dnd-app-2"}
+	if err := failed.ensure(func(name string) error { return errors.New("daemon unavailable") }); err == nil || failed.created {
+		t.Errorf("Expected the creation to fail, got %v", err)
+	}
+	if err := failed.ensure(func(name string) error { return nil }); err != nil || !failed.created {
+		t.Errorf("Expected the next job to create the network, got %v", err)
+	}
+}
+
+func TestJobNetworks(t *testing.T) {
+	if networks := jobNetworks("dnd-app-1", ""); !reflect.DeepEqual(networks, []string{"dnd-app-1"}) {
+		t.Errorf("Unexpected networks %v", networks)
+	}
+	if networks := jobNetworks("dnd-app-1", "dnd-1-test-services"); !reflect.DeepEqual(networks, []string{"dnd-app-1", "dnd-1-test-services"}) {
+		t.Errorf("Unexpected networks %v", networks)
+	}
+}
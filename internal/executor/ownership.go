@@ -0,0 +1,62 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/docker"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+)
+
+// fixWorkspace hands the files written so far back to the owner of the workspace, when WORKSPACE_CHOWN is enabled
+// Containers often run as root and leave root-owned files the next job or the runner can no longer move or delete
+// It runs before the job container starts, so the job finds the workspace owned by its owner
+func (e *PipelineExecutor) fixWorkspace(ctx context.Context, jobName, workspaceDir, image string) {
+	if !e.workspaceChown {
+		return
+	}
+
+	chown := e.chownWorkspace
+	if chown == nil {
+		chown = func(workspaceDir, image string) error {
+			return e.chownWorkspaceDefault(ctx, workspaceDir, image)
+		}
+	}
+	if err := chown(workspaceDir, image); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to fix workspace ownership before job %s: %v", jobName, err))
+	}
+}
+
+// chownWorkspaceDefault chowns the workspace on the host when the runner is root, through a helper container otherwise
+func (e *PipelineExecutor) chownWorkspaceDefault(ctx context.Context, workspaceDir, image string) error {
+	uid, gid, err := workspaceOwner(workspaceDir)
+	if err != nil {
+		return err
+	}
+	if os.Geteuid() == 0 {
+		return chownTree(workspaceDir, uid, gid)
+	}
+
+	if e.chownImage != "" {
+		image = e.chownImage
+		if err := e.docker.PullImage(ctx, image); err != nil {
+			return fmt.Errorf("failed to pull %s: %w", image, err)
+		}
+	}
+	containerID, err := e.docker.RunJobWithVolume(ctx, image, []string{fmt.Sprintf("chown -R %d:%d /workspace", uid, gid)}, workspaceDir, nil, docker.JobOptions{User: "0"})
+	if containerID != "" {
+		defer e.docker.RemoveContainer(ctx, containerID)
+	}
+	if err != nil {
+		return err
+	}
+	statusCode, err := e.docker.WaitForContainer(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	if statusCode != 0 {
+		return fmt.Errorf("chown exited with code %d", statusCode)
+	}
+	return nil
+}
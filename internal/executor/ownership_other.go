@@ -0,0 +1,18 @@
+//go:build !unix
+
+package executor
+
+import "errors"
+
+// errOwnershipUnsupported is returned where file owners do not exist, WORKSPACE_CHOWN must stay disabled
+var errOwnershipUnsupported = errors.New("file ownership is not supported on this platform")
+
+// workspaceOwner is not implemented on this platform
+func workspaceOwner(workspaceDir string) (int, int, error) {
+	return 0, 0, errOwnershipUnsupported
+}
+
+// chownTree is not implemented on this platform
+func chownTree(dir string, uid, gid int) error {
+	return errOwnershipUnsupported
+}
@@ -0,0 +1,26 @@
+package executor
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFixWorkspaceRunsWhenEnabled(t *testing.T) {
+	var fixed []string
+	chown := func(workspaceDir, image string) error {
+		fixed = append(fixed, workspaceDir+" "+image)
+		return nil
+	}
+
+	e := &PipelineExecutor{chownWorkspace: chown}
+	e.fixWorkspace(context.Background(), "build", "/tmp/workspace", "golang:1.25")
+	if len(fixed) != 0 {
+		t.Fatalf("Expected no fix-up when disabled, got %v", fixed)
+	}
+
+	e.workspaceChown = true
+	e.fixWorkspace(context.Background(), "build", "/tmp/workspace", "golang:1.25")
+	if len(fixed) != 1 || fixed[0] != "/tmp/workspace golang:1.25" {
+		t.Errorf("Expected the fix-up to run on the workspace, got %v", fixed)
+	}
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package executor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// workspaceOwner returns the owner of the workspace directory, created by the runner
+func workspaceOwner(workspaceDir string) (int, int, error) {
+	info, err := os.Stat(workspaceDir)
+	if err != nil {
+		return 0, 0, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, fmt.Errorf("file ownership is not supported on this platform")
+	}
+	return int(stat.Uid), int(stat.Gid), nil
+}
+
+// chownTree changes the owner of every file under dir, symlinks themselves rather than their target
+func chownTree(dir string, uid, gid int) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
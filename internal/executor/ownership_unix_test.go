@@ -0,0 +1,31 @@
+//go:build unix
+
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChownTree(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "build", "out"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "build", "out", "app"), []byte("bin"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	uid, gid, err := workspaceOwner(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if uid != os.Getuid() {
+		t.Errorf("Expected the workspace to be owned by %d, got %d", os.Getuid(), uid)
+	}
+	// Chowning to the current owner is always allowed and walks the whole tree
+	if err := chownTree(dir, uid, gid); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
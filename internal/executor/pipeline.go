@@ -2,9 +2,20 @@ package executor
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/docker/docker/pkg/stdcopy"
 
@@ -12,178 +23,929 @@ import (
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/docker"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/env"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type PipelineExecutor struct {
-	db     *database.DB
-	docker *docker.DockerExecutor
+	db        *database.DB
+	docker    *docker.DockerExecutor
+	sanitizer logSanitizer
+	// echoCommands prints each script command in the job logs before running it (JOB_ECHO_COMMANDS)
+	echoCommands bool
+	// containerPrefix names job containers <prefix>-<pipeline>-<job>, empty keeps random names
+	containerPrefix string
+	// runnerTags are the labels this runner advertises, a job runs only if it has all of its tags; empty runs every job (RUNNER_TAGS)
+	runnerTags []string
+	// sysctlAllowlist holds the sysctl keys jobs may set (JOB_SYSCTL_ALLOWLIST)
+	sysctlAllowlist []string
+	// pullSecrets holds the named registry credentials jobs reference with pull_secret (PULL_SECRETS_FILE)
+	pullSecrets map[string]docker.PullSecret
+	// name identifies this executor node on the jobs it runs (EXECUTOR_NAME, defaults to the hostname)
+	name string
+	// maxParallelJobs bounds the jobs of a stage running at the same time (STAGE_MAX_PARALLEL_JOBS)
+	maxParallelJobs int
+	// secrets holds the server secret store injected into every job and masked in logs (JOB_SECRETS_FILE)
+	secrets map[string]string
+	// readOnlyRootfs runs every job with a read-only root filesystem, whatever its `read_only` (JOB_READ_ONLY_ROOTFS)
+	readOnlyRootfs bool
+	// scratchPaths are the writable tmpfs of read-only jobs (JOB_SCRATCH_PATHS, defaults to /tmp)
+	scratchPaths []string
+	// artifactsDir holds the artifact archives of the running pipelines (ARTIFACTS_DIR)
+	artifactsDir string
+	// workspaceChown gives the workspace back to its owner before each job starts (WORKSPACE_CHOWN)
+	workspaceChown bool
+	// chownImage runs the chown when the runner is not root, the job image when empty (WORKSPACE_CHOWN_IMAGE)
+	chownImage string
+	// chownWorkspace replaces the ownership fix-up, for tests
+	chownWorkspace func(workspaceDir, image string) error
+	// logHub streams the log lines of the running jobs to live subscribers
+	logHub *LogHub
+	// maxLineSize is the longest log line kept in bytes, longer lines are truncated (LOG_MAX_LINE_KB)
+	maxLineSize int
+	// approvalGate holds the stages of the `approval:` config until they are approved
+	approvalGate ApprovalGate
+	// serviceWaitTimeout bounds the wait for the services of a job to be ready (SERVICE_WAIT_TIMEOUT_SECONDS)
+	serviceWaitTimeout time.Duration
+	// cacheLocks holds a *sync.RWMutex per cache volume, see cacheLock
+	cacheLocks sync.Map
+	// cacheUsage records the last use of the cache volumes, see pruneCaches
+	cacheUsage cacheUsage
+	// cacheMaxAge prunes the cache volumes unused for that long, 0 keeps them (CACHE_MAX_AGE_DAYS)
+	cacheMaxAge time.Duration
+	// cacheMaxVolumes prunes the least recently used cache volumes beyond that number, 0 keeps them (CACHE_MAX_VOLUMES)
+	cacheMaxVolumes int
+	// stopTimeout is how long a cancelled or timed out job gets to exit before it is killed (JOB_STOP_TIMEOUT_SECONDS)
+	stopTimeout time.Duration
 }
 
 func NewPipelineExecutor(db *database.DB, docker *docker.DockerExecutor) *PipelineExecutor {
 	return &PipelineExecutor{
 		db:     db,
 		docker: docker,
+		sanitizer: logSanitizer{
+			stripANSI:   env.Bool("LOG_STRIP_ANSI", false),
+			normalizeCR: env.Bool("LOG_NORMALIZE_CR", false),
+		},
+		containerPrefix:    env.String("JOB_CONTAINER_PREFIX", "dnd"),
+		echoCommands:       env.Bool("JOB_ECHO_COMMANDS", true),
+		runnerTags:         env.List("RUNNER_TAGS"),
+		sysctlAllowlist:    env.List("JOB_SYSCTL_ALLOWLIST"),
+		pullSecrets:        loadPullSecrets(env.String("PULL_SECRETS_FILE", "")),
+		name:               executorName(env.String("EXECUTOR_NAME", "")),
+		maxParallelJobs:    env.Int("STAGE_MAX_PARALLEL_JOBS", 1),
+		secrets:            loadJobSecrets(env.String("JOB_SECRETS_FILE", "")),
+		readOnlyRootfs:     env.Bool("JOB_READ_ONLY_ROOTFS", false),
+		scratchPaths:       scratchPaths(env.List("JOB_SCRATCH_PATHS")),
+		artifactsDir:       env.String("ARTIFACTS_DIR", filepath.Join(os.TempDir(), "cicd-artifacts")),
+		workspaceChown:     env.Bool("WORKSPACE_CHOWN", false),
+		chownImage:         env.String("WORKSPACE_CHOWN_IMAGE", ""),
+		logHub:             NewLogHub(),
+		maxLineSize:        env.Int("LOG_MAX_LINE_KB", 1024) << 10,
+		serviceWaitTimeout: time.Duration(env.Int("SERVICE_WAIT_TIMEOUT_SECONDS", 30)) * time.Second,
+		stopTimeout:        time.Duration(env.Int("JOB_STOP_TIMEOUT_SECONDS", 10)) * time.Second,
+		cacheMaxAge:        time.Duration(env.Int("CACHE_MAX_AGE_DAYS", 30)) * 24 * time.Hour,
+		cacheMaxVolumes:    env.Int("CACHE_MAX_VOLUMES", 0),
 	}
 }
 
-// Execute runs all jobs in the pipeline
-func (e *PipelineExecutor) Execute(config *pipeline.PipelineConfig, workspaceDir string, pipelineID int, project *models.Project) bool {
+// LogHub returns the hub streaming the log lines of the running jobs
+func (e *PipelineExecutor) LogHub() *LogHub {
+	return e.logHub
+}
+
+// scratchPaths returns the configured scratch paths of read-only jobs, /tmp by default
+func scratchPaths(configured []string) []string {
+	if len(configured) == 0 {
+		return []string{"/tmp"}
+	}
+	return configured
+}
+
+// readOnlyRootfs reports whether a job runs with a read-only root filesystem
+// A job can ask for it with `read_only: true`, but cannot opt out when the server enforces it
+func readOnlyRootfs(job pipeline.JobConfig, serverPolicy bool) bool {
+	return serverPolicy || (job.ReadOnly != nil && *job.ReadOnly)
+}
+
+// executorName returns the configured executor name, or the hostname of the machine
+func executorName(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return "local"
+}
+
+// loadPullSecrets loads the named pull secrets, none when path is empty
+func loadPullSecrets(path string) map[string]docker.PullSecret {
+	if path == "" {
+		return nil
+	}
+
+	secrets, err := docker.LoadPullSecrets(path)
+	if err != nil {
+		logger.Error("Ignoring pull secrets", "path", path, "error", err)
+		return nil
+	}
+	logger.Info("Loaded pull secrets", "count", len(secrets))
+	return secrets
+}
+
+// Execute runs all jobs in the pipeline, except the ones whose only/except rules exclude the branch or tag of params
+// Cancelling ctx stops the running job and skips the remaining ones
+// Stages requiring an approval wait at the approval gate, a rejection fails the pipeline
+// When the pipeline fails because of the infrastructure, the returned error is an *InfraError
+func (e *PipelineExecutor) Execute(ctx context.Context, config *pipeline.PipelineConfig, workspaceDir string, params models.PipelineRunParams, project *models.Project) (bool, error) {
+	pipelineID := params.PipelineID
+	ref := refOf(params)
 	pipelineSuccess := true
+	var infraErr error
+	scriptFailed := false
+	log := logger.FromContext(ctx)
+
+	// Prepare environment variables (Custom Variables: Secrets/Env Vars), merged per job with the pipeline file ones
+	envVars, masker := projectVariables(e.db, project)
+	masker = masker.with(secretValues(e.secrets)...)
+
+	// Jobs get the predefined CI_* variables, and every variable is expanded in their image and their artifacts and cache paths
+	predefined := predefinedVariables(params)
+	config = e.expandConfig(ctx, config, predefined, envVars, pipelineID)
+
+	// Aggregate the coverage reported by the jobs once the pipeline is over
+	var coverages []float64
+	defer func() {
+		if coverage := aggregateCoverage(config.CoverageAggregation, coverages); coverage != nil && e.db != nil && pipelineID > 0 {
+			if err := e.db.UpdatePipelineCoverage(pipelineID, *coverage); err != nil {
+				log.Error("Failed to store pipeline coverage", "error", err)
+			}
+		}
+	}()
+
+	// Artifacts of a stage are handed to the following stages, they only live as long as the pipeline
+	artifacts := newArtifactStore(e.artifactsDir, pipelineID)
+	defer artifacts.cleanup()
 
-	// Prepare environment variables
-	var envVars []string
+	// The job containers share a network of the pipeline, removed once the jobs are over
+	network := &pipelineNetwork{name: pipelineNetworkName(e.containerPrefix, params.RepoName, pipelineID)}
+	defer e.removePipelineNetwork(ctx, log, network)
+
+	// Job caches are shared by the pipelines of the project
+	projectID := 0
 	if project != nil {
-		// Inject Custom Variables (Secrets/Env Vars)
-		if e.db != nil {
-			variables, err := e.db.GetVariablesByProject(project.ID)
-			if err != nil {
-				logger.Error("Failed to fetch project variables: " + err.Error())
-			} else {
-				for _, v := range variables {
-					envVars = append(envVars, fmt.Sprintf("%s=%s", v.Key, v.Value))
+		projectID = project.ID
+	}
+
+	for i, stageName := range config.Stages {
+		// A stage of the `approval:` config waits for someone to approve it, a rejection skips it and the following ones
+		if config.Approval.RequiresStage(stageName) && ctx.Err() == nil {
+			log.Info("Stage waiting for approval", "stage", stageName)
+			err := e.awaitStageApproval(ctx, pipelineID, stageName, time.Duration(config.Approval.Timeout)*time.Second)
+			if err != nil && ctx.Err() == nil {
+				log.Warn("Stage not approved", "stage", stageName, "error", err)
+				e.skipStages(config, config.Stages[i:], pipelineID)
+				return false, err
+			}
+		}
+
+		log.Info("Running stage", "stage", stageName)
+		stageCtx, stageSpan := tracing.Tracer().Start(ctx, "stage", trace.WithAttributes(attribute.String("stage", stageName)))
+
+		conflicts, err := artifacts.restore(workspaceDir)
+		if len(conflicts) > 0 {
+			log.Warn("Artifacts not restored over files changed in the workspace", "stage", stageName, "paths", strings.Join(conflicts, ", "))
+		}
+		if err != nil {
+			log.Error("Failed to restore artifacts", "stage", stageName, "error", err)
+			stageSpan.End()
+			return false, &InfraError{Op: "restore artifacts", Err: err}
+		}
+
+		// The jobs of a stage are independent, run them side by side, started in declaration order
+		jobNames := config.StageJobs(stageName)
+		outcomes := runStage(jobNames, e.maxParallelJobs, func(jobName string) jobOutcome {
+			job := config.Jobs[jobName]
+			jobEnv := jobEnvironment(append(jobVariables(jobName, job), predefined...), config.Variables, job.Variables, envVars, e.secrets)
+			return e.runStageJob(stageCtx, jobName, job, workspaceDir, pipelineID, projectID, ref, jobEnv, masker, network)
+		})
+
+		// The stage is over once all its jobs completed
+		stop := false
+		for _, outcome := range outcomes {
+			if outcome.coverage != nil {
+				coverages = append(coverages, *outcome.coverage)
+			}
+			if !outcome.success && !outcome.allowedFailure {
+				pipelineSuccess = false
+				if infraErr == nil {
+					infraErr = outcome.infraErr
 				}
+				if outcome.exitCode != nil && *outcome.exitCode != 0 {
+					scriptFailed = true
+				}
+			}
+			if outcome.stop {
+				stop = true
 			}
 		}
+		if !stop && !e.storeJobArtifacts(artifacts, config, jobNames, outcomes, workspaceDir, pipelineID) {
+			pipelineSuccess = false
+			stop = true
+		}
+
+		stageSpan.End()
+		if stop {
+			// Stop pipeline after the first failed stage
+			return false, pipelineInfraError(infraErr, scriptFailed)
+		}
 	}
 
-	for _, stageName := range config.Stages {
-		logger.Info(fmt.Sprintf("Running stage: %s", stageName))
+	return pipelineSuccess, pipelineInfraError(infraErr, scriptFailed)
+}
 
-		for jobName, job := range config.Jobs {
-			if job.Stage != stageName {
-				continue
-			}
+// pipelineInfraError returns the infrastructure error that failed a pipeline
+// It is dropped when a job script exited with a non-zero code, the pipeline failed because of it
+// whatever happened to the infrastructure and running it again would fail the same way
+func pipelineInfraError(infraErr error, scriptFailed bool) error {
+	if scriptFailed {
+		return nil
+	}
+	return infraErr
+}
 
-			logger.Info(fmt.Sprintf("Running job: %s (image: %s)", jobName, job.Image))
+// runStage runs the jobs of a stage, at most maxParallel at a time, and returns their outcomes in order
+func runStage(jobNames []string, maxParallel int, run func(jobName string) jobOutcome) []jobOutcome {
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
 
-			// Update job status in database
-			var jobID int
-			if e.db != nil && pipelineID > 0 {
-				dbJob, err := e.db.GetJobByName(pipelineID, jobName)
-				if err != nil {
-					logger.Warn(fmt.Sprintf("Job not found, creating: %v", err))
-					dbJob, err = e.db.CreateJob(pipelineID, jobName, job.Stage, job.Image)
-				}
+	outcomes := make([]jobOutcome, len(jobNames))
+	slots := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	for i, jobName := range jobNames {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			outcomes[i] = run(jobName)
+		}()
+	}
+	wg.Wait()
+	return outcomes
+}
 
-				if err == nil && dbJob != nil {
-					jobID = dbJob.ID
-					e.db.UpdateJobStatus(jobID, "running", nil)
-				} else {
-					logger.Error(fmt.Sprintf("Failed to get/create job record: %v", err))
-				}
+// runStageJob runs a job of the current stage, unless the pipeline was cancelled or its only/except or exists: rules skip it
+// A job no runner matches the tags of fails the pipeline
+func (e *PipelineExecutor) runStageJob(ctx context.Context, jobName string, job pipeline.JobConfig, workspaceDir string, pipelineID, projectID int, ref pipelineRef, envVars []string, masker *logMasker, network *pipelineNetwork) jobOutcome {
+	// The lines logged for the job carry its name and stage besides the pipeline fields
+	log := logger.FromContext(ctx).With("job_name", jobName, "stage", job.Stage)
+	ctx = logger.NewContext(ctx, log)
+
+	// Skip the remaining jobs once the pipeline has been cancelled
+	if ctx.Err() != nil {
+		log.Warn("Pipeline cancelled, skipping job")
+		if e.db != nil && pipelineID > 0 {
+			if dbJob, err := e.db.GetJobByName(pipelineID, jobName); err == nil {
+				e.db.UpdateJobStatus(dbJob.ID, "cancelled", nil)
 			}
+		}
+		return jobOutcome{}
+	}
 
-			// Pull the image
-			logger.Info(fmt.Sprintf("Pulling image: %s", job.Image))
-			if err := e.docker.PullImage(job.Image); err != nil {
-				logger.Error(fmt.Sprintf("Failed to pull image %s: %v", job.Image, err))
-				if e.db != nil && jobID > 0 {
-					exitCode := 1
-					e.db.UpdateJobStatus(jobID, "failed", &exitCode)
-				}
-				pipelineSuccess = false
-				continue
+	// tags: once RUNNER_TAGS is set, the job only runs on a runner advertising every one of them
+	if missing := missingTags(job.Tags, e.runnerTags); len(e.runnerTags) > 0 && len(missing) > 0 {
+		log.Error("No matching runner for job", "missing_tags", missing)
+		return e.failUnmatchedJob(jobName, pipelineID, missing)
+	}
+
+	// only/except rules, the job only runs for the branches and tags they allow
+	if !refAllowed(job.Only.Refs, job.Except.Refs, ref) {
+		log.Info("Skipping job, its only/except rules exclude the ref", "ref", ref.String())
+		return e.skipJob(jobName, pipelineID)
+	}
+
+	// exists: rule, the job only runs if one of the files is present in the repository
+	if len(job.Exists) > 0 {
+		present, err := filesExist(workspaceDir, job.Exists)
+		if err != nil {
+			log.Warn("Failed to evaluate exists rule", "error", err)
+		}
+		if !present {
+			log.Info("Skipping job, none of its exists files is present", "exists", job.Exists)
+			return e.skipJob(jobName, pipelineID)
+		}
+	}
+
+	outcome := e.runAttempts(ctx, jobName, job, workspaceDir, pipelineID, projectID, envVars, masker, network)
+	if outcome = allowFailure(job, outcome, ctx.Err() != nil); outcome.allowedFailure {
+		log.Warn("Job failed, allow_failure keeps the pipeline going")
+	}
+	return outcome
+}
+
+// runAttempts runs a job, then runs it again after a failure as long as its retry config allows
+// Every attempt gets its own container and a header in the job logs
+func (e *PipelineExecutor) runAttempts(ctx context.Context, jobName string, job pipeline.JobConfig, workspaceDir string, pipelineID, projectID int, envVars []string, masker *logMasker, network *pipelineNetwork) jobOutcome {
+	attempts := job.Retry.Max + 1
+	for attempt := 1; ; attempt++ {
+		if attempts > 1 {
+			e.startAttempt(pipelineID, jobName, attempt, attempts)
+		}
+		outcome := e.runJob(ctx, jobName, job, workspaceDir, pipelineID, projectID, envVars, masker, network)
+		if !shouldRetry(job.Retry, outcome, attempt, ctx.Err() != nil) {
+			// Live log subscribers follow every attempt, they are released once the final status of the job is stored
+			e.logHub.Finish(outcome.jobID)
+			return outcome
+		}
+		logger.FromContext(ctx).Warn("Job failed, retrying", "attempt", attempt+1, "attempts", attempts)
+	}
+}
+
+// startAttempt records the attempt number of a job and opens its section of the job logs
+func (e *PipelineExecutor) startAttempt(pipelineID int, jobName string, attempt, attempts int) {
+	if e.db == nil || pipelineID <= 0 {
+		return
+	}
+	dbJob, err := e.db.GetJobByName(pipelineID, jobName)
+	if err != nil {
+		return
+	}
+	if err := e.db.SetJobAttempt(dbJob.ID, attempt); err != nil {
+		logger.Error("Failed to record job attempt", "job_id", dbJob.ID, "attempt", attempt, "error", err)
+	}
+	e.writeJobLine(dbJob.ID, fmt.Sprintf("=== Attempt %d/%d ===", attempt, attempts))
+}
+
+// shouldRetry tells whether a job is run again after the given attempt
+// Without exit codes, any failure of the container or its start is retried; config errors and cancellations never are
+func shouldRetry(retry pipeline.RetryConfig, outcome jobOutcome, attempt int, cancelled bool) bool {
+	if outcome.success || cancelled || attempt > retry.Max {
+		return false
+	}
+	if outcome.exitCode == nil {
+		return outcome.infraErr != nil && len(retry.ExitCodes) == 0
+	}
+	return len(retry.ExitCodes) == 0 || slices.Contains(retry.ExitCodes, *outcome.exitCode)
+}
+
+// allowFailure turns the failure of a job with allow_failure into an outcome that neither fails nor stops the pipeline
+// A cancelled job still stops it
+func allowFailure(job pipeline.JobConfig, outcome jobOutcome, cancelled bool) jobOutcome {
+	if !job.AllowFailure || outcome.success || cancelled {
+		return outcome
+	}
+	return jobOutcome{allowedFailure: true, coverage: outcome.coverage}
+}
+
+// skipJob records a job its rules keep from running as skipped, it does not fail the pipeline
+func (e *PipelineExecutor) skipJob(jobName string, pipelineID int) jobOutcome {
+	if e.db != nil && pipelineID > 0 {
+		if dbJob, err := e.db.GetJobByName(pipelineID, jobName); err == nil {
+			e.db.UpdateJobStatus(dbJob.ID, "skipped", nil)
+		}
+	}
+	return jobOutcome{success: true, skipped: true}
+}
+
+// errImageNotPresent fails the jobs with the never pull policy whose image is missing
+var errImageNotPresent = errors.New("image not present and pull_policy is never")
+
+// needsPull tells from the pull policy of a job whether its image must be pulled
+// exists is only called for the policies depending on the local image store
+func needsPull(policy string, exists func() (bool, error)) (bool, error) {
+	if policy == "" || policy == pipeline.PullAlways {
+		return true, nil
+	}
+	present, err := exists()
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect image: %w", err)
+	}
+	if !present && policy == pipeline.PullNever {
+		return false, errImageNotPresent
+	}
+	return !present, nil
+}
+
+// pullJobImage pulls the image of a job according to its pull policy, with its named pull secret if any
+// The progress of the pull goes to the job logs and to the live log stream
+// The digest of the image the job runs is returned, whether it was pulled or already present
+func (e *PipelineExecutor) pullJobImage(ctx context.Context, job pipeline.JobConfig, jobID int) (string, error) {
+	log := logger.FromContext(ctx)
+	pull, err := needsPull(job.PullPolicy, func() (bool, error) { return e.docker.ImageExists(ctx, job.Image) })
+	if err != nil {
+		return "", err
+	}
+
+	var pulledDigest string
+	if pull {
+		opts := docker.PullOptions{Progress: e.pullProgress(jobID)}
+		if job.PullSecret != "" {
+			secret, ok := e.pullSecrets[job.PullSecret]
+			if !ok {
+				return "", fmt.Errorf("unknown pull secret: %s", job.PullSecret)
 			}
+			opts.Secret = &secret
+		}
+		if pulledDigest, err = e.docker.Pull(ctx, job.Image, opts); err != nil {
+			return "", err
+		}
+	} else {
+		log.Info("Image already present, not pulling", "image", job.Image)
+	}
+
+	// The local image tells the digest even when the pull stream did not report it or nothing was pulled
+	digest, err := e.docker.ImageDigest(ctx, job.Image)
+	if err != nil || digest == "" {
+		if err != nil {
+			log.Warn("Failed to inspect job image digest", "image", job.Image, "error", err)
+		}
+		return pulledDigest, nil
+	}
+	return digest, nil
+}
+
+// pullProgress returns the function writing the pull progress lines to the logs of a job and to its live subscribers
+func (e *PipelineExecutor) pullProgress(jobID int) func(line string) {
+	if e.db == nil || jobID <= 0 {
+		return nil
+	}
+	return func(line string) { e.writeJobLine(jobID, line) }
+}
+
+// writeJobLog stores lines in the logs of a job, then sends them to its live subscribers with their stored number
+// Every line of a job goes through it, so that the stream and the stored logs number lines alike
+func (e *PipelineExecutor) writeJobLog(jobID int, lines ...models.LogLine) {
+	if e.db == nil || jobID <= 0 || len(lines) == 0 {
+		return
+	}
+	stored, err := e.db.CreateLogBatch(jobID, lines)
+	if err != nil {
+		logger.Error("Failed to store job logs", "job_id", jobID, "error", err)
+		return
+	}
+	for _, line := range stored {
+		e.logHub.Publish(jobID, line)
+	}
+}
+
+// writeJobLine writes a line of the server, on stdout, to the logs of a job, see writeJobLog
+func (e *PipelineExecutor) writeJobLine(jobID int, content string) {
+	e.writeJobLog(jobID, models.LogLine{Content: content, Stream: models.LogStreamStdout})
+}
+
+// timeoutExitCode is the exit code recorded for jobs killed by their timeout, as timeout(1) does
+const timeoutExitCode = 124
+
+// jobOutcome is the result of a job run
+type jobOutcome struct {
+	success  bool
+	stop     bool // the pipeline must stop right away (the job exited with a non-zero code)
+	coverage *float64
+	infraErr error // set when the job failed because of the infrastructure
+	skipped  bool  // the job did not run (only/except or exists: rule)
+	exitCode *int  // exit code of the container, nil when it did not run to the end
+	jobID    int   // job record, 0 without database
+	// allowedFailure is set when the job failed but allow_failure keeps the pipeline going
+	allowedFailure bool
+}
 
-			// Run the job with workspace mounted
-			containerID, err := e.docker.RunJobWithVolume(job.Image, job.Script, workspaceDir, envVars)
-			if err != nil {
-				logger.Error(fmt.Sprintf("Failed to start job %s: %v", jobName, err))
-				if e.db != nil && jobID > 0 {
-					exitCode := 1
-					e.db.UpdateJobStatus(jobID, "failed", &exitCode)
+// runJob runs a single job in its container and records its status
+func (e *PipelineExecutor) runJob(ctx context.Context, jobName string, job pipeline.JobConfig, workspaceDir string, pipelineID, projectID int, envVars []string, masker *logMasker, network *pipelineNetwork) (outcome jobOutcome) {
+	ctx, span := tracing.Tracer().Start(ctx, "job", trace.WithAttributes(
+		attribute.String("job", jobName),
+		attribute.String("image", job.Image),
+	))
+	defer func() {
+		var err error
+		if !outcome.success {
+			err = fmt.Errorf("job %s did not succeed", jobName)
+		}
+		tracing.End(span, err)
+	}()
+
+	log := logger.FromContext(ctx)
+	log.Info("Running job", "image", job.Image)
+
+	// Update job status in database
+	var jobID int
+	if e.db != nil && pipelineID > 0 {
+		dbJob, err := e.db.GetJobByName(pipelineID, jobName)
+		if err != nil {
+			log.Warn("Job not found, creating", "error", err)
+			dbJob, err = e.db.CreateJob(pipelineID, jobName, job.Stage, job.Image)
+		}
+
+		if err == nil && dbJob != nil {
+			jobID = dbJob.ID
+			e.db.UpdateJobStatus(jobID, "running", nil)
+			if err := e.db.SetJobExecutor(jobID, e.name); err != nil {
+				log.Error("Failed to record job executor", "error", err)
+			}
+			if job.AllowFailure {
+				if err := e.db.SetJobAllowFailure(jobID, true); err != nil {
+					log.Error("Failed to record job allow_failure", "error", err)
 				}
-				pipelineSuccess = false
-				continue
 			}
+		} else {
+			log.Error("Failed to get/create job record", "error", err)
+		}
+	}
+	outcome.jobID = jobID
 
-			// Collect and store logs
-			e.collectLogs(containerID, jobID)
+	coverageRe, err := compileCoverage(job.Coverage)
+	if err != nil {
+		log.Warn("Ignoring coverage of job", "error", err)
+	}
+	filterRe, err := compileLogFilter(job.LogFilter)
+	if err != nil {
+		log.Warn("Ignoring log filter of job", "error", err)
+	}
 
-			// Wait for container to finish
-			statusCode, err := e.docker.WaitForContainer(containerID)
-			if err != nil {
-				logger.Error(fmt.Sprintf("Error waiting for container: %v", err))
-			}
+	// Sysctls are only set if the operator allowed them
+	if err := checkSysctls(job.Sysctls, e.sysctlAllowlist); err != nil {
+		log.Error("Job rejected", "error", err)
+		if e.db != nil && jobID > 0 {
+			e.writeJobLine(jobID, err.Error())
+			exitCode := 1
+			e.db.UpdateJobStatus(jobID, "failed", &exitCode)
+		}
+		return outcome
+	}
 
-			// Update job status
-			exitCode := int(statusCode)
-			if e.db != nil && jobID > 0 {
-				status := "success"
-				if statusCode != 0 {
-					status = "failed"
-				}
-				e.db.UpdateJobStatus(jobID, status, &exitCode)
+	// Invalid resource limits fail the job rather than running it unconstrained
+	nanoCPUs, memory, err := parseJobResources(job.CPU, job.Memory)
+	if err != nil {
+		log.Error("Job rejected", "error", err)
+		if e.db != nil && jobID > 0 {
+			e.writeJobLine(jobID, err.Error())
+			exitCode := 1
+			e.db.UpdateJobStatus(jobID, "failed", &exitCode)
+		}
+		return outcome
+	}
+
+	// Pull the image
+	log.Info("Pulling image", "image", job.Image)
+	_, pullSpan := tracing.Tracer().Start(ctx, "pull", trace.WithAttributes(attribute.String("image", job.Image)))
+	digest, err := e.pullJobImage(ctx, job, jobID)
+	tracing.End(pullSpan, err)
+	if err != nil {
+		log.Error("Failed to pull image", "image", job.Image, "error", err)
+		if e.db != nil && jobID > 0 {
+			exitCode := 1
+			e.db.UpdateJobStatus(jobID, "failed", &exitCode)
+		}
+		// A missing image with the never policy is a config error, retrying would not help
+		if !errors.Is(err, errImageNotPresent) {
+			outcome.infraErr = &InfraError{Op: "pull " + job.Image, Err: err}
+		}
+		return outcome
+	}
+	if digest != "" && e.db != nil && jobID > 0 {
+		if err := e.db.SetJobImageDigest(jobID, digest); err != nil {
+			log.Error("Failed to record job image digest", "error", err)
+		}
+	}
+
+	// Start the services of the job, they and their network are removed once the job is over whatever happens
+	services, err := e.startServices(ctx, log, jobName, job, pipelineID)
+	defer e.stopServices(ctx, log, services)
+	if err != nil {
+		log.Error("Failed to start services", "error", err)
+		if e.db != nil && jobID > 0 {
+			e.writeJobLine(jobID, err.Error())
+			exitCode := 1
+			e.db.UpdateJobStatus(jobID, "failed", &exitCode)
+		}
+		outcome.infraErr = &InfraError{Op: "start services of job " + jobName, Err: err}
+		return outcome
+	}
+
+	// The first job of the pipeline creates its network
+	if err := e.ensurePipelineNetwork(ctx, network); err != nil {
+		log.Error("Failed to create pipeline network", "network", network.name, "error", err)
+		if e.db != nil && jobID > 0 {
+			exitCode := 1
+			e.db.UpdateJobStatus(jobID, "failed", &exitCode)
+		}
+		outcome.infraErr = &InfraError{Op: "create network " + network.name, Err: err}
+		return outcome
+	}
+
+	// Restore the cache of the job, it is saved again once the job succeeded
+	cache := e.restoreCache(ctx, log, jobName, job, workspaceDir, pipelineID, projectID)
+
+	// Give the files left by the previous jobs and the restored cache back to the owner before the job sees them
+	e.fixWorkspace(ctx, jobName, workspaceDir, job.Image)
+
+	// Run the job with workspace mounted
+	containerID, err := e.docker.RunJobWithVolume(ctx, job.Image, jobCommands(job), workspaceDir, envVars, docker.JobOptions{
+		Name:           jobContainerName(e.containerPrefix, pipelineID, jobName),
+		Sysctls:        job.Sysctls,
+		AfterScript:    job.AfterScript,
+		ReadOnlyRootfs: readOnlyRootfs(job, e.readOnlyRootfs),
+		ScratchPaths:   e.scratchPaths,
+		NanoCPUs:       nanoCPUs,
+		Memory:         memory,
+		Networks:       jobNetworks(network.name, services.network),
+		Shell:          job.Shell,
+		Entrypoint:     job.Entrypoint,
+		EchoCommands:   e.echoCommands,
+	})
+	if err != nil {
+		log.Error("Failed to start job", "error", err)
+		// A shell missing from the image is a config error, retrying would not help
+		missingShell := errors.Is(err, docker.ErrShellNotFound)
+		if e.db != nil && jobID > 0 {
+			if missingShell {
+				e.writeJobLine(jobID, err.Error())
 			}
+			exitCode := 1
+			e.db.UpdateJobStatus(jobID, "failed", &exitCode)
+		}
+		if missingShell {
+			return outcome
+		}
+		outcome.infraErr = &InfraError{Op: "start job " + jobName, Err: err}
+		return outcome
+	}
 
-			if statusCode != 0 {
-				logger.Error(fmt.Sprintf("Job %s failed with exit code %d", jobName, statusCode))
-				pipelineSuccess = false
-				// Stop pipeline on first failure
-				return false
+	// Remove the container once the job is over, whatever its outcome
+	defer e.removeJobContainer(ctx, containerID)
+
+	// Bound the job by its timeout, if any
+	jobCtx, cancelJob := context.WithCancel(ctx)
+	if job.Timeout > 0 {
+		jobCtx, cancelJob = context.WithTimeout(ctx, time.Duration(job.Timeout)*time.Second)
+	}
+	defer cancelJob()
+
+	// Stop the container if the pipeline is cancelled or the job times out while it runs
+	stopWatching := e.stopOnCancel(jobCtx, containerID)
+
+	// Collect and store logs
+	coverage := e.collectLogs(ctx, log, containerID, jobID, coverageRe, filterRe, masker)
+
+	// Wait for container to finish
+	statusCode, err := e.docker.WaitForContainer(jobCtx, containerID)
+	stopWatching()
+
+	if ctx.Err() != nil {
+		log.Warn("Job cancelled")
+		if e.db != nil && jobID > 0 {
+			e.db.UpdateJobStatus(jobID, "cancelled", nil)
+		}
+		return outcome
+	}
+
+	if jobCtx.Err() == context.DeadlineExceeded {
+		message := fmt.Sprintf("Job %s timed out after %ds, container stopped", jobName, job.Timeout)
+		log.Error("Job timed out, container stopped", "timeout_seconds", job.Timeout)
+		if e.db != nil && jobID > 0 {
+			e.writeJobLine(jobID, message)
+			exitCode := timeoutExitCode
+			e.db.UpdateJobStatus(jobID, "failed", &exitCode)
+		}
+		span.SetAttributes(attribute.Int("exit_code", timeoutExitCode))
+		exitCode := timeoutExitCode
+		outcome.exitCode = &exitCode
+		outcome.stop = true
+		return outcome
+	}
+
+	if err != nil {
+		log.Error("Error waiting for container", "error", err)
+		outcome.infraErr = &InfraError{Op: "wait job " + jobName, Err: err}
+	} else if err := e.docker.CopyWorkspaceBack(ctx, containerID, workspaceDir); err != nil {
+		// Without a bind mount, the files the job wrote only reach the workspace through this copy
+		log.Error("Failed to copy the workspace of the job back", "error", err)
+		outcome.infraErr = &InfraError{Op: "copy workspace of job " + jobName, Err: err}
+	}
+
+	// Update job status
+	exitCode := int(statusCode)
+	outcome.exitCode = &exitCode
+	span.SetAttributes(attribute.Int("exit_code", exitCode))
+	if e.db != nil && jobID > 0 {
+		status := "success"
+		if statusCode != 0 {
+			status = "failed"
+		}
+		e.db.UpdateJobStatus(jobID, status, &exitCode)
+	}
+
+	if coverage != nil {
+		log.Info("Job coverage", "coverage", *coverage)
+		outcome.coverage = coverage
+		if e.db != nil && jobID > 0 {
+			if err := e.db.UpdateJobCoverage(jobID, *coverage); err != nil {
+				log.Error("Failed to store job coverage", "error", err)
 			}
+		}
+	}
+
+	if statusCode != 0 {
+		log.Error("Job failed", "exit_code", statusCode)
+		outcome.stop = true
+		return outcome
+	}
 
-			logger.Info(fmt.Sprintf("Job %s completed successfully", jobName))
+	e.saveCache(ctx, log, jobName, job, workspaceDir, pipelineID, cache)
+
+	log.Info("Job completed successfully")
+	outcome.success = true
+	return outcome
+}
+
+// jobCommands returns the commands of a job, its before script first
+func jobCommands(job pipeline.JobConfig) []string {
+	return append(slices.Clone(job.BeforeScript), job.Script...)
+}
+
+// invalidContainerNameChars matches characters Docker refuses in container names
+var invalidContainerNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// jobContainerName returns the container name of a job, or "" if naming is disabled
+func jobContainerName(prefix string, pipelineID int, jobName string) string {
+	if prefix == "" {
+		return ""
+	}
+	name := fmt.Sprintf("%s-%d-%s", prefix, pipelineID, jobName)
+	return strings.Trim(invalidContainerNameChars.ReplaceAllString(name, "-"), "-._")
+}
+
+// stopOnCancel stops the container as soon as ctx is cancelled, it gets stopTimeout to exit before it is killed
+// The returned function stops watching ctx and must be called once the container has exited,
+// it waits for a stop in progress so that the container is not force-removed before its grace period is over
+func (e *PipelineExecutor) stopOnCancel(ctx context.Context, containerID string) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			log := logger.FromContext(ctx)
+			log.Info("Cancellation requested, stopping container", "container_id", containerID)
+			// ctx is done, the stop itself must not be cancelled
+			if err := e.docker.StopContainer(context.WithoutCancel(ctx), containerID, e.stopTimeout); err != nil {
+				log.Error("Failed to stop cancelled container", "container_id", containerID, "error", err)
+			}
+		case <-done:
 		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
 	}
+}
 
-	return pipelineSuccess
+// removeJobContainer removes a finished job container, cancelled or not, failures are only logged
+func (e *PipelineExecutor) removeJobContainer(ctx context.Context, containerID string) {
+	if err := e.docker.RemoveContainer(context.WithoutCancel(ctx), containerID); err != nil {
+		logger.FromContext(ctx).Warn("Failed to remove job container", "container_id", containerID, "error", err)
+	}
 }
 
 // collectLogs collects logs from the container and stores them in the database
-func (e *PipelineExecutor) collectLogs(containerID string, jobID int) {
-	reader, err := e.docker.GetLogs(containerID)
+// It returns the last coverage matched by coverageRe, or nil
+// The logs are followed until the container exits, even after a cancellation, so that a stopping job is heard to the end
+func (e *PipelineExecutor) collectLogs(ctx context.Context, log *slog.Logger, containerID string, jobID int, coverageRe, filterRe *regexp.Regexp, masker *logMasker) *float64 {
+	reader, err := e.docker.GetLogs(context.WithoutCancel(ctx), containerID)
 	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to get logs: %v", err))
-		return
+		log.Error("Failed to get logs", "error", err)
+		return nil
 	}
 	defer reader.Close()
 
-	// Use a pipe to connect stdcopy (writer) to scanner (reader)
-	pr, pw := io.Pipe()
+	// Use one pipe per stream to connect stdcopy (writer) to the scanners (readers)
+	stdoutReader, stdoutWriter := io.Pipe()
+	stderrReader, stderrWriter := io.Pipe()
 
 	// Run stdcopy in a goroutine to demultiplex the docker stream
 	go func() {
-		// We write both stdout and stderr to the same pipe
-		if _, err := stdcopy.StdCopy(pw, pw, reader); err != nil {
-			logger.Error(fmt.Sprintf("Error demultiplexing logs: %v", err))
+		if _, err := stdcopy.StdCopy(stdoutWriter, stderrWriter, reader); err != nil {
+			log.Error("Error demultiplexing logs", "error", err)
 		}
-		pw.Close()
+		stdoutWriter.Close()
+		stderrWriter.Close()
 	}()
 
-	scanner := bufio.NewScanner(pr)
-	var logBatch []string
+	return e.processLogs(log, stdoutReader, stderrReader, coverageRe, filterRe, masker, func(lines []models.LogLine) {
+		e.writeJobLog(jobID, lines...)
+	})
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
+// defaultMaxLineSize bounds the log lines when the executor sets no limit
+const defaultMaxLineSize = 1 << 20
 
-		// Sanitize line: remove null bytes (Postgres doesn't allow them in text)
-		cleanLine := strings.ReplaceAll(line, "\x00", "")
+// scanStream sends the lines of a job output stream, tagged with the stream name
+// A line longer than maxLineSize bytes is truncated, the rest of it is dropped and the line says so
+func scanStream(r io.Reader, stream string, maxLineSize int, lines chan<- models.LogLine) {
+	if maxLineSize <= 0 {
+		maxLineSize = defaultMaxLineSize
+	}
+	reader := bufio.NewReaderSize(r, maxLineSize)
+	for {
+		data, err := reader.ReadSlice('\n')
+		content := string(data)
 
-		if cleanLine == "" {
-			continue
+		// Skip the rest of a line that does not fit in the buffer
+		dropped := 0
+		for err == bufio.ErrBufferFull {
+			data, err = reader.ReadSlice('\n')
+			dropped += len(bytes.TrimRight(data, "\r\n"))
+		}
+		if dropped > 0 {
+			// The buffer may end in the middle of a multi-byte character, it is dropped with the rest
+			var partial int
+			content, partial = trimPartialRune(content)
+			dropped += partial
+			logger.Warn("Truncated a log line", "stream", stream, "max_bytes", maxLineSize, "dropped_bytes", dropped)
+			content = fmt.Sprintf("%s [line truncated, %d bytes dropped]", strings.TrimRight(content, "\r\n"), dropped)
+		}
+
+		content = strings.TrimSuffix(strings.TrimSuffix(content, "\n"), "\r")
+		if content != "" || err == nil {
+			lines <- models.LogLine{Stream: stream, Content: content}
+		}
+		if err != nil {
+			break
 		}
+	}
+	// Drain the pipe so that stdcopy never blocks on an unread stream
+	io.Copy(io.Discard, r)
+}
+
+// trimPartialRune cuts the incomplete UTF-8 character ending s, it returns the bytes cut
+func trimPartialRune(s string) (string, int) {
+	for i := len(s) - 1; i >= 0 && i >= len(s)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(s[i]) {
+			if !utf8.FullRuneInString(s[i:]) {
+				return s[:i], len(s) - i
+			}
+			break
+		}
+	}
+	return s, 0
+}
 
-		// Print to console
-		fmt.Println(cleanLine)
+// logBatchSize is the most lines of a job stored together
+const logBatchSize = 10
 
-		// Add to batch
-		logBatch = append(logBatch, cleanLine)
+// processLogs reads the stdout and stderr lines of a job and hands them to store in batches
+// Lines matching filterRe (the job's log_filter) are echoed at debug level but not stored, masked values never leave this function
+func (e *PipelineExecutor) processLogs(log *slog.Logger, stdout, stderr io.Reader, coverageRe, filterRe *regexp.Regexp, masker *logMasker, store func(lines []models.LogLine)) *float64 {
+	lines := make(chan models.LogLine, logBatchSize)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); scanStream(stdout, models.LogStreamStdout, e.maxLineSize, lines) }()
+	go func() { defer wg.Done(); scanStream(stderr, models.LogStreamStderr, e.maxLineSize, lines) }()
+	go func() { wg.Wait(); close(lines) }()
 
-		// Store in batches of 10
-		if len(logBatch) >= 10 && e.db != nil && jobID > 0 {
-			if err := e.db.CreateLogBatch(jobID, logBatch); err != nil {
-				logger.Error(fmt.Sprintf("Failed to store logs: %v", err))
+	var logBatch []models.LogLine
+	var coverage *float64
+
+	for line := range lines {
+		// Sanitize line: null bytes, and optionally ANSI codes and carriage returns
+		line.Content = masker.mask(e.sanitizer.sanitize(line.Content))
+
+		if line.Content == "" {
+			continue
+		}
+
+		if coverageRe != nil {
+			if value, ok := coverageFromLine(coverageRe, line.Content); ok {
+				coverage = &value
 			}
+		}
+
+		// Echo to the console, the stream tells stderr lines apart
+		log.Debug("job output", "stream", line.Stream, "line", line.Content)
+
+		// Drop the noise lines from storage
+		if filterRe != nil && filterRe.MatchString(line.Content) {
+			continue
+		}
+
+		logBatch = append(logBatch, line)
+
+		// Store in batches, a burst of lines is stored together and a quiet job does not hold its last lines back
+		if len(logBatch) >= logBatchSize || len(lines) == 0 {
+			store(logBatch)
 			logBatch = nil
 		}
 	}
 
 	// Store remaining logs
-	if len(logBatch) > 0 && e.db != nil && jobID > 0 {
-		if err := e.db.CreateLogBatch(jobID, logBatch); err != nil {
-			logger.Error(fmt.Sprintf("Failed to store remaining logs: %v", err))
-		}
+	if len(logBatch) > 0 {
+		store(logBatch)
 	}
+
+	return coverage
 }
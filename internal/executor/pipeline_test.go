@@ -0,0 +1,239 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+)
+
+func TestJobContainerName(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		jobName  string
+		expected string
+	}{
+		{"dnd", "build-job", "dnd-42-build-job"},
+		{"dnd", "unit tests/go", "dnd-42-unit-tests-go"},
+		{"ci", "lint_1.21", "ci-42-lint_1.21"},
+		{"", "build-job", ""},
+	}
+
+	for _, tt := range tests {
+		if got := jobContainerName(tt.prefix, 42, tt.jobName); got != tt.expected {
+			t.Errorf("jobContainerName(%q, 42, %q) = %q, expected %q", tt.prefix, tt.jobName, got, tt.expected)
+		}
+	}
+}
+
+func TestExecutorName(t *testing.T) {
+	if got := executorName("runner-1"); got != "runner-1" {
+		t.Errorf("Expected configured name 'runner-1', got %q", got)
+	}
+
+	// Without configuration the job records are populated with the hostname
+	expected, err := os.Hostname()
+	if err != nil || expected == "" {
+		expected = "local"
+	}
+	if got := executorName(""); got != expected {
+		t.Errorf("Expected executor name %q, got %q", expected, got)
+	}
+}
+
+func TestRunStageBoundsConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+
+	jobNames := []string{"lint", "unit", "integration", "e2e", "docs"}
+	outcomes := runStage(jobNames, 2, func(jobName string) jobOutcome {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return jobOutcome{success: jobName != "e2e", stop: jobName == "e2e"}
+	})
+
+	if maxRunning != 2 {
+		t.Errorf("Expected 2 jobs running at most, got %d", maxRunning)
+	}
+
+	// Every job completes, outcomes keep the order of the jobs
+	if len(outcomes) != len(jobNames) {
+		t.Fatalf("Expected %d outcomes, got %d", len(jobNames), len(outcomes))
+	}
+	for i, jobName := range jobNames {
+		if outcomes[i].success != (jobName != "e2e") {
+			t.Errorf("Unexpected outcome for job %s: %+v", jobName, outcomes[i])
+		}
+	}
+}
+
+func TestRunStageJobsInParallel(t *testing.T) {
+	// Jobs of a stage share the executor, its log hub and the workspace, each keeps its own outcome
+	e := &PipelineExecutor{runnerTags: []string{"linux"}, logHub: NewLogHub()}
+	jobs := map[string]pipeline.JobConfig{}
+	var jobNames []string
+	for i := range 8 {
+		name := fmt.Sprintf("job-%d", i)
+		job := pipeline.JobConfig{Stage: "test", Only: pipeline.RefRule{Refs: []string{"main"}}}
+		if i%2 == 0 {
+			job.Tags = []string{"gpu"}
+		}
+		jobs[name] = job
+		jobNames = append(jobNames, name)
+	}
+
+	workspaceDir := t.TempDir()
+	outcomes := runStage(jobNames, 4, func(jobName string) jobOutcome {
+		return e.runStageJob(t.Context(), jobName, jobs[jobName], workspaceDir, 0, 0, pipelineRef{name: "dev"}, nil, nil, nil)
+	})
+
+	for i, jobName := range jobNames {
+		unmatched := len(jobs[jobName].Tags) > 0
+		if got := outcomes[i]; got.stop != unmatched || got.skipped == unmatched || got.success == unmatched {
+			t.Errorf("Unexpected outcome for job %s: %+v", jobName, got)
+		}
+	}
+}
+
+func TestRunStageSequential(t *testing.T) {
+	var order []string
+	runStage([]string{"build", "test"}, 0, func(jobName string) jobOutcome {
+		// A single slot runs the jobs one after the other, so order needs no lock
+		order = append(order, jobName)
+		return jobOutcome{success: true}
+	})
+
+	if len(order) != 2 || order[0] != "build" || order[1] != "test" {
+		t.Errorf("Expected jobs to run one after the other, got %v", order)
+	}
+}
+
+func TestReadOnlyRootfs(t *testing.T) {
+	enabled, disabled := true, false
+	if !readOnlyRootfs(pipeline.JobConfig{}, true) || readOnlyRootfs(pipeline.JobConfig{}, false) {
+		t.Error("Expected jobs without read_only to follow the server default")
+	}
+	if !readOnlyRootfs(pipeline.JobConfig{ReadOnly: &enabled}, false) {
+		t.Error("Expected read_only: true to tighten the server default")
+	}
+	if !readOnlyRootfs(pipeline.JobConfig{ReadOnly: &disabled}, true) {
+		t.Error("Expected read_only: false not to weaken the server policy")
+	}
+	if paths := scratchPaths(nil); !reflect.DeepEqual(paths, []string{"/tmp"}) {
+		t.Errorf("Expected /tmp scratch path by default, got %v", paths)
+	}
+}
+
+func TestPipelineInfraError(t *testing.T) {
+	infraErr := &InfraError{Op: "copy workspace of job build", Err: errors.New("daemon unreachable")}
+
+	if err := pipelineInfraError(infraErr, false); !IsInfraError(err) {
+		t.Errorf("Expected the infrastructure error of the pipeline, got %v", err)
+	}
+	// A job that exited with a non-zero code failed the pipeline, it is never retried
+	if err := pipelineInfraError(infraErr, true); err != nil {
+		t.Errorf("Expected no infrastructure error once a job script failed, got %v", err)
+	}
+}
+
+func TestNeedsPull(t *testing.T) {
+	present := func() (bool, error) { return true, nil }
+	missing := func() (bool, error) { return false, nil }
+	inspected := false
+	spy := func() (bool, error) { inspected = true; return true, nil }
+
+	tests := []struct {
+		name   string
+		policy string
+		exists func() (bool, error)
+		pull   bool
+		err    error
+	}{
+		{"DefaultAlwaysPulls", "", spy, true, nil},
+		{"Always", pipeline.PullAlways, spy, true, nil},
+		{"IfNotPresentPresent", pipeline.PullIfNotPresent, present, false, nil},
+		{"IfNotPresentMissing", pipeline.PullIfNotPresent, missing, true, nil},
+		{"NeverPresent", pipeline.PullNever, present, false, nil},
+		{"NeverMissing", pipeline.PullNever, missing, false, errImageNotPresent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pull, err := needsPull(tt.policy, tt.exists)
+			if pull != tt.pull || !errors.Is(err, tt.err) {
+				t.Errorf("Expected pull=%v err=%v, got pull=%v err=%v", tt.pull, tt.err, pull, err)
+			}
+		})
+	}
+	if inspected {
+		t.Error("Expected the always policy not to inspect the image")
+	}
+}
+
+func TestAllowFailure(t *testing.T) {
+	coverage := 42.0
+	failed := jobOutcome{stop: true, coverage: &coverage, infraErr: errors.New("pull failed")}
+
+	outcome := allowFailure(pipeline.JobConfig{AllowFailure: true}, failed, false)
+	if !outcome.allowedFailure || outcome.stop || outcome.success || outcome.infraErr != nil {
+		t.Errorf("Expected an allowed failure that does not stop the pipeline, got %+v", outcome)
+	}
+	if outcome.coverage != &coverage {
+		t.Error("Expected the coverage of the job to be kept")
+	}
+
+	if outcome := allowFailure(pipeline.JobConfig{}, failed, false); outcome.allowedFailure || !outcome.stop {
+		t.Errorf("Expected the failure to stop the pipeline without allow_failure, got %+v", outcome)
+	}
+	if outcome := allowFailure(pipeline.JobConfig{AllowFailure: true}, jobOutcome{}, true); outcome.allowedFailure {
+		t.Error("Expected a cancelled job not to be an allowed failure")
+	}
+	if outcome := allowFailure(pipeline.JobConfig{AllowFailure: true}, jobOutcome{success: true}, false); outcome.allowedFailure || !outcome.success {
+		t.Errorf("Expected a successful job to stay successful, got %+v", outcome)
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	exit := func(code int) *int { return &code }
+	retry := pipeline.RetryConfig{Max: 2}
+
+	tests := []struct {
+		name      string
+		retry     pipeline.RetryConfig
+		outcome   jobOutcome
+		attempt   int
+		cancelled bool
+		expected  bool
+	}{
+		{"non-zero exit", retry, jobOutcome{exitCode: exit(1)}, 1, false, true},
+		{"last retry", retry, jobOutcome{exitCode: exit(1)}, 2, false, true},
+		{"retries exhausted", retry, jobOutcome{exitCode: exit(1)}, 3, false, false},
+		{"no retry", pipeline.RetryConfig{}, jobOutcome{exitCode: exit(1)}, 1, false, false},
+		{"success", retry, jobOutcome{success: true, exitCode: exit(0)}, 1, false, false},
+		{"cancelled", retry, jobOutcome{exitCode: exit(137)}, 1, true, false},
+		{"container start error", retry, jobOutcome{infraErr: errors.New("start failed")}, 1, false, true},
+		{"config error", retry, jobOutcome{}, 1, false, false},
+		{"listed exit code", pipeline.RetryConfig{Max: 1, ExitCodes: []int{137}}, jobOutcome{exitCode: exit(137)}, 1, false, true},
+		{"other exit code", pipeline.RetryConfig{Max: 1, ExitCodes: []int{137}}, jobOutcome{exitCode: exit(1)}, 1, false, false},
+		{"start error with exit codes", pipeline.RetryConfig{Max: 1, ExitCodes: []int{137}}, jobOutcome{infraErr: errors.New("start failed")}, 1, false, false},
+	}
+	for _, tt := range tests {
+		if retried := shouldRetry(tt.retry, tt.outcome, tt.attempt, tt.cancelled); retried != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, retried)
+		}
+	}
+}
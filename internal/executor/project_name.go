@@ -0,0 +1,98 @@
+package executor
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+// Strategies naming the compose project of a deployment (DEPLOY_PROJECT_NAME_STRATEGY)
+// Two repositories can sanitize to the same name ("my-app" and "My App"), making their stacks collide
+const (
+	// ProjectNameStrategyName uses the sanitized repository name (historical behaviour)
+	ProjectNameStrategyName = "name"
+	// ProjectNameStrategyNameID suffixes the name with the project ID, which is always unique
+	ProjectNameStrategyNameID = "name-id"
+	// ProjectNameStrategyReject keeps the plain name and rejects colliding projects at creation
+	ProjectNameStrategyReject = "reject"
+)
+
+// validProjectNameStrategy reports whether strategy is a known DEPLOY_PROJECT_NAME_STRATEGY
+func validProjectNameStrategy(strategy string) bool {
+	switch strategy {
+	case ProjectNameStrategyName, ProjectNameStrategyNameID, ProjectNameStrategyReject:
+		return true
+	}
+	return false
+}
+
+// deployProjectName returns the compose project name of a deployment
+func deployProjectName(strategy, repoName string, projectID int) string {
+	name := sanitizeProjectName(repoName)
+	if strategy == ProjectNameStrategyNameID && projectID > 0 {
+		return fmt.Sprintf("%s-%d", name, projectID)
+	}
+	return name
+}
+
+// composeProjectName returns the compose project name of a deployment, every stack of a project gets its own
+func (e *DeploymentExecutor) composeProjectName(params models.PipelineRunParams) string {
+	name := deployProjectName(e.nameStrategy, params.RepoName, params.ProjectID)
+	if params.DeploymentStack != "" {
+		name += "-" + sanitizeProjectName(params.DeploymentStack)
+	}
+	return name
+}
+
+// RejectsNameCollisions reports whether projects with colliding deployment names must be refused
+func (e *DeploymentExecutor) RejectsNameCollisions() bool {
+	return e.nameStrategy == ProjectNameStrategyReject
+}
+
+// CheckNameStrategy returns an error when DEPLOY_PROJECT_NAME_STRATEGY is not a known strategy
+func (e *DeploymentExecutor) CheckNameStrategy() error {
+	if !validProjectNameStrategy(e.nameStrategy) {
+		return fmt.Errorf("unknown DEPLOY_PROJECT_NAME_STRATEGY %q (name, name-id or reject)", e.nameStrategy)
+	}
+	return nil
+}
+
+// repoNameFromURL returns the name of the repository of a clone URL, as webhooks report it
+// (https://github.com/org/my-app.git and git@github.com:org/my-app.git give my-app)
+func repoNameFromURL(repoURL string) string {
+	trimmed := strings.TrimSuffix(strings.TrimRight(repoURL, "/"), ".git")
+	return path.Base(strings.ReplaceAll(trimmed, ":", "/"))
+}
+
+// deployNames returns the names a project deploys under, sanitized: manual and scheduled pipelines
+// use the project name, webhook pipelines the name of the repository
+func deployNames(name, repoURL string) []string {
+	names := []string{sanitizeProjectName(name)}
+	if repoURL != "" {
+		if repoName := sanitizeProjectName(repoNameFromURL(repoURL)); repoName != names[0] {
+			names = append(names, repoName)
+		}
+	}
+	return names
+}
+
+// FindProjectNameCollision returns the project, other than projectID, sharing a deployment name with a project
+// named name with the repository repoURL, see deployNames
+func FindProjectNameCollision(name, repoURL string, projectID int, projects []models.Project) *models.Project {
+	names := deployNames(name, repoURL)
+	for i := range projects {
+		if projects[i].ID == projectID {
+			continue
+		}
+		for _, other := range deployNames(projects[i].Name, projects[i].RepoURL) {
+			for _, own := range names {
+				if own == other {
+					return &projects[i]
+				}
+			}
+		}
+	}
+	return nil
+}
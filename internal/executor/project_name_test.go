@@ -0,0 +1,109 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+func TestDeployProjectNameCollision(t *testing.T) {
+	// "my-app" and "My App" sanitize to the same compose project name
+	if a, b := deployProjectName(ProjectNameStrategyName, "my-app", 1), deployProjectName(ProjectNameStrategyName, "My App", 2); a != b {
+		t.Fatalf("Expected both repositories to collide with the name strategy, got %q and %q", a, b)
+	}
+
+	t.Run("NameID", func(t *testing.T) {
+		a := deployProjectName(ProjectNameStrategyNameID, "my-app", 1)
+		b := deployProjectName(ProjectNameStrategyNameID, "My App", 2)
+		if a != "my-app-1" || b != "my-app-2" {
+			t.Errorf("Expected distinct names my-app-1 and my-app-2, got %q and %q", a, b)
+		}
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		projects := []models.Project{
+			{ID: 1, Name: "my-app"},
+			{ID: 3, Name: "other"},
+		}
+
+		other := FindProjectNameCollision("My App", "", 0, projects)
+		if other == nil || other.ID != 1 {
+			t.Fatalf("Expected collision with project 1, got %v", other)
+		}
+
+		// Renaming a project to its own name is not a collision
+		if other := FindProjectNameCollision("My-App", "", 1, projects); other != nil {
+			t.Errorf("Expected no collision with itself, got project %d", other.ID)
+		}
+		if other := FindProjectNameCollision("new-app", "", 0, projects); other != nil {
+			t.Errorf("Expected no collision, got project %d", other.ID)
+		}
+
+		e := &DeploymentExecutor{nameStrategy: ProjectNameStrategyReject}
+		if !e.RejectsNameCollisions() {
+			t.Error("Expected the reject strategy to refuse collisions")
+		}
+	})
+
+	t.Run("RepositoryName", func(t *testing.T) {
+		// Webhook pipelines deploy under the name of the repository
+		projects := []models.Project{{ID: 1, Name: "Frontend", RepoURL: "https://github.com/org/shop.git"}}
+		if other := FindProjectNameCollision("shop", "https://github.com/org/shop-api.git", 0, projects); other == nil || other.ID != 1 {
+			t.Errorf("Expected the name to collide with the repository of project 1, got %v", other)
+		}
+		if other := FindProjectNameCollision("Storefront", "git@github.com:other/frontend.git", 0, projects); other == nil || other.ID != 1 {
+			t.Errorf("Expected the repository to collide with the name of project 1, got %v", other)
+		}
+		if other := FindProjectNameCollision("Backend", "https://github.com/org/backend.git", 0, projects); other != nil {
+			t.Errorf("Expected no collision, got project %d", other.ID)
+		}
+	})
+
+	t.Run("UnknownStrategy", func(t *testing.T) {
+		if err := (&DeploymentExecutor{nameStrategy: "nmae"}).CheckNameStrategy(); err == nil {
+			t.Error("Expected an unknown strategy to be refused")
+		}
+		for _, strategy := range []string{ProjectNameStrategyName, ProjectNameStrategyNameID, ProjectNameStrategyReject} {
+			if err := (&DeploymentExecutor{nameStrategy: strategy}).CheckNameStrategy(); err != nil {
+				t.Errorf("Expected %s to be accepted, got %v", strategy, err)
+			}
+		}
+	})
+}
+
+func TestComposeProjectNameStack(t *testing.T) {
+	e := &DeploymentExecutor{nameStrategy: ProjectNameStrategyName}
+	params := models.PipelineRunParams{RepoName: "my-app", ProjectID: 1}
+	if name := e.composeProjectName(params); name != "my-app" {
+		t.Errorf("Expected my-app, got %q", name)
+	}
+
+	// Stacks of a project must not tear each other down
+	params.DeploymentStack = "db"
+	if name := e.composeProjectName(params); name != "my-app-db" {
+		t.Errorf("Expected my-app-db, got %q", name)
+	}
+}
+
+func TestHealthCheckURLLastStack(t *testing.T) {
+	project := &models.Project{HealthCheckURL: "http://localhost:8080/healthz"}
+	params := models.PipelineRunParams{DeploymentFilename: "docker-compose.yml"}
+	if url := healthCheckURL(project, params); url != project.HealthCheckURL {
+		t.Errorf("Expected the health check URL, got %q", url)
+	}
+
+	// The application only answers once its last stack is deployed
+	params.DeploymentStacks = []string{"db.compose.yml", "app.compose.yml"}
+	params.DeploymentFilename, params.DeploymentStack = "db.compose.yml", "db"
+	if url := healthCheckURL(project, params); url != "" {
+		t.Errorf("Expected no health check for the first stack, got %q", url)
+	}
+	params.DeploymentFilename, params.DeploymentStack = "app.compose.yml", "app"
+	if url := healthCheckURL(project, params); url != project.HealthCheckURL {
+		t.Errorf("Expected the health check URL for the last stack, got %q", url)
+	}
+
+	if url := healthCheckURL(nil, params); url != "" {
+		t.Errorf("Expected no health check without project, got %q", url)
+	}
+}
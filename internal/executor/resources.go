@@ -0,0 +1,31 @@
+package executor
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+)
+
+// parseJobResources converts the cpu and memory limits of a job for Docker
+// cpu is a number of CPUs ("0.5", "2"), memory a size with unit ("512m", "1g", bytes without unit)
+// Empty values leave the resource unconstrained (0)
+func parseJobResources(cpu, memory string) (nanoCPUs, memoryBytes int64, err error) {
+	if cpu = strings.TrimSpace(cpu); cpu != "" {
+		cpus, err := strconv.ParseFloat(cpu, 64)
+		if err != nil || cpus <= 0 || math.IsInf(cpus, 0) || math.IsNaN(cpus) {
+			return 0, 0, fmt.Errorf("invalid cpu limit %q: expected a positive number of CPUs (e.g. 0.5)", cpu)
+		}
+		nanoCPUs = int64(cpus * 1e9)
+	}
+
+	if memory = strings.TrimSpace(memory); memory != "" {
+		memoryBytes, err = units.RAMInBytes(memory)
+		if err != nil || memoryBytes <= 0 {
+			return 0, 0, fmt.Errorf("invalid memory limit %q: expected a positive size (e.g. 512m, 2g)", memory)
+		}
+	}
+	return nanoCPUs, memoryBytes, nil
+}
@@ -0,0 +1,35 @@
+package executor
+
+import "testing"
+
+func TestParseJobResources(t *testing.T) {
+	tests := []struct {
+		cpu, memory string
+		nanoCPUs    int64
+		memoryBytes int64
+	}{
+		{"", "", 0, 0},
+		{"1.5", "", 1500000000, 0},
+		{"2", "512m", 2000000000, 512 * 1024 * 1024},
+		{"", "1g", 0, 1024 * 1024 * 1024},
+		{"0.25", "1048576", 250000000, 1048576},
+	}
+	for _, tt := range tests {
+		nanoCPUs, memoryBytes, err := parseJobResources(tt.cpu, tt.memory)
+		if err != nil {
+			t.Errorf("parseJobResources(%q, %q) failed: %v", tt.cpu, tt.memory, err)
+			continue
+		}
+		if nanoCPUs != tt.nanoCPUs || memoryBytes != tt.memoryBytes {
+			t.Errorf("parseJobResources(%q, %q) = %d, %d, expected %d, %d", tt.cpu, tt.memory, nanoCPUs, memoryBytes, tt.nanoCPUs, tt.memoryBytes)
+		}
+	}
+}
+
+func TestParseJobResourcesInvalid(t *testing.T) {
+	for _, limits := range [][2]string{{"-1", ""}, {"0", ""}, {"two", ""}, {"", "-512m"}, {"", "lots"}, {"", "0"}} {
+		if _, _, err := parseJobResources(limits[0], limits[1]); err == nil {
+			t.Errorf("Expected cpu %q / memory %q to be rejected", limits[0], limits[1])
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package executor
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+)
+
+// pipelineRef is the branch or tag a pipeline runs for
+type pipelineRef struct {
+	name string
+	tag  bool
+}
+
+func (r pipelineRef) String() string {
+	if r.tag {
+		return "tag " + r.name
+	}
+	return "branch " + r.name
+}
+
+// refOf returns the ref of a pipeline, its tag for tag pipelines
+func refOf(params models.PipelineRunParams) pipelineRef {
+	if params.Tag != "" {
+		return pipelineRef{name: params.Tag, tag: true}
+	}
+	return pipelineRef{name: params.Branch}
+}
+
+// refAllowed reports whether a job with these only/except refs runs for ref
+// A job without only runs for every ref, except wins over only
+func refAllowed(only, except []string, ref pipelineRef) bool {
+	if len(only) > 0 && !refMatchesAny(only, ref) {
+		return false
+	}
+	return !refMatchesAny(except, ref)
+}
+
+// refMatchesAny reports whether one of the refs (names, /regex/, branches or tags) matches ref
+func refMatchesAny(refs []string, ref pipelineRef) bool {
+	for _, r := range refs {
+		switch r {
+		case pipeline.RefBranches:
+			if !ref.tag {
+				return true
+			}
+			continue
+		case pipeline.RefTags:
+			if ref.tag {
+				return true
+			}
+			continue
+		}
+		// Invalid regexes are rejected by the parser
+		if re, err := pipeline.RefPattern(r); err == nil && re != nil {
+			if re.MatchString(ref.name) {
+				return true
+			}
+		} else if r == ref.name {
+			return true
+		}
+	}
+	return false
+}
+
+// filesExist reports whether at least one file of the workspace matches one of the patterns
+// Patterns are relative to the repository root and support globs, including ** for any depth
+func filesExist(workspaceDir string, patterns []string) (bool, error) {
+	for _, pattern := range patterns {
+		pattern = strings.TrimPrefix(path.Clean(filepath.ToSlash(pattern)), "/")
+
+		// Plain globs are resolved directly
+		if !strings.Contains(pattern, "**") {
+			matches, err := filepath.Glob(filepath.Join(workspaceDir, filepath.FromSlash(pattern)))
+			if err != nil {
+				return false, err
+			}
+			if len(matches) > 0 {
+				return true, nil
+			}
+			continue
+		}
+
+		found := false
+		err := filepath.WalkDir(workspaceDir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			rel, err := filepath.Rel(workspaceDir, p)
+			if err != nil || rel == "." {
+				return nil
+			}
+			if matchSegments(strings.Split(pattern, "/"), strings.Split(filepath.ToSlash(rel), "/")) {
+				found = true
+				return fs.SkipAll
+			}
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+		if found {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matchSegments matches a path against a glob split on "/", where a ** segment matches zero or more segments
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], name[1:])
+}
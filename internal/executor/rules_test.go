@@ -0,0 +1,78 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+)
+
+func TestFilesExist(t *testing.T) {
+	workspace := t.TempDir()
+	for _, file := range []string{"package.json", "cmd/server/main.go", "docs/README.md"} {
+		path := filepath.Join(workspace, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		expected bool
+	}{
+		{"PresentFile", []string{"package.json"}, true},
+		{"AbsentFile", []string{"go.mod"}, false},
+		{"AnyPatternMatches", []string{"go.mod", "package.json"}, true},
+		{"Glob", []string{"*.json"}, true},
+		{"GlobAbsent", []string{"*.lock"}, false},
+		{"RecursiveGlob", []string{"**/*.go"}, true},
+		{"RecursiveGlobInDirectory", []string{"docs/**/*.md"}, true},
+		{"RecursiveGlobAbsent", []string{"**/*.py"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := filesExist(workspace, tt.patterns)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("filesExist(%v) = %t, expected %t", tt.patterns, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRefAllowed(t *testing.T) {
+	main := refOf(models.PipelineRunParams{Branch: "main"})
+	release := refOf(models.PipelineRunParams{Branch: "release-1.2"})
+	tag := refOf(models.PipelineRunParams{Branch: "main", Tag: "v1.0.0"})
+
+	tests := []struct {
+		name         string
+		only, except []string
+		ref          pipelineRef
+		expected     bool
+	}{
+		{"no rules", nil, nil, main, true},
+		{"only branch name", []string{"main", "develop"}, nil, main, true},
+		{"only other branch", []string{"develop"}, nil, main, false},
+		{"only regex", []string{"/^release-.*$/"}, nil, release, true},
+		{"only branches keyword on tag", []string{"branches"}, nil, tag, false},
+		{"only tags keyword", []string{"tags"}, nil, tag, true},
+		{"except tags on branch", nil, []string{"tags"}, main, true},
+		{"except tags on tag", nil, []string{"tags"}, tag, false},
+		{"except wins over only", []string{"branches"}, []string{"main"}, main, false},
+		{"tag name", []string{"/^v\\d+/"}, nil, tag, true},
+	}
+	for _, tt := range tests {
+		if allowed := refAllowed(tt.only, tt.except, tt.ref); allowed != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, allowed)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package executor
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ansiEscape matches ANSI CSI sequences (colors, cursor moves) and OSC sequences (window titles, links)
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)`)
+
+// logSanitizer cleans container output lines before they are stored
+type logSanitizer struct {
+	stripANSI   bool // remove ANSI escape codes
+	normalizeCR bool // keep only what a terminal would display after carriage returns
+}
+
+// sanitize applies the configured rules to a single log line
+// Null bytes are always removed since Postgres doesn't allow them in text
+func (s logSanitizer) sanitize(line string) string {
+	line = strings.ReplaceAll(line, "\x00", "")
+
+	if s.stripANSI {
+		line = ansiEscape.ReplaceAllString(line, "")
+	}
+
+	if s.normalizeCR {
+		// "\r\n" line endings leave a trailing "\r"
+		line = strings.TrimRight(line, "\r")
+		// Progress bars rewrite the line with "\r": keep the last rendered state
+		if idx := strings.LastIndex(line, "\r"); idx >= 0 {
+			line = line[idx+1:]
+		}
+	}
+
+	return line
+}
@@ -0,0 +1,30 @@
+package executor
+
+import "testing"
+
+func TestLogSanitizer(t *testing.T) {
+	tests := []struct {
+		name      string
+		sanitizer logSanitizer
+		line      string
+		expected  string
+	}{
+		{"NullBytesAlwaysStripped", logSanitizer{}, "ok\x00done", "okdone"},
+		{"ANSIKeptByDefault", logSanitizer{}, "\x1b[32mPASS\x1b[0m", "\x1b[32mPASS\x1b[0m"},
+		{"ANSIColors", logSanitizer{stripANSI: true}, "\x1b[1;32mPASS\x1b[0m main_test.go", "PASS main_test.go"},
+		{"ANSICursor", logSanitizer{stripANSI: true}, "\x1b[2K\x1b[1Gbuilding", "building"},
+		{"ANSIHyperlink", logSanitizer{stripANSI: true}, "\x1b]8;;https://example.com\x07link\x1b]8;;\x07", "link"},
+		{"CRKeptByDefault", logSanitizer{}, "10%\r100%", "10%\r100%"},
+		{"CRProgress", logSanitizer{normalizeCR: true}, "10%\r50%\r100%", "100%"},
+		{"CRLF", logSanitizer{normalizeCR: true}, "Windows line\r", "Windows line"},
+		{"Combined", logSanitizer{stripANSI: true, normalizeCR: true}, "\x1b[33m10%\x1b[0m\r\x1b[32m100%\x1b[0m\r", "100%"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sanitizer.sanitize(tt.line); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/docker"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+)
+
+// jobServices are the service containers of a running job and the network they share with it
+type jobServices struct {
+	network    string
+	containers []string
+}
+
+// servicesNetworkName returns the name of the network of the services of a job
+func servicesNetworkName(prefix string, pipelineID int, jobName string) string {
+	if prefix == "" {
+		prefix = "dnd"
+	}
+	return jobContainerName(prefix, pipelineID, jobName) + "-services"
+}
+
+// serviceEnv returns the environment of a service container, sorted by name
+func serviceEnv(variables map[string]string) []string {
+	env := make([]string, 0, len(variables))
+	for key, value := range variables {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// startServices starts the services of a job on a network of their own, the job joins it to reach them by alias
+// A service that is not ready in time only gets a warning in the job logs, as the job may still work without it
+// The returned services must be stopped once the job is over, including when an error is returned
+func (e *PipelineExecutor) startServices(ctx context.Context, log *slog.Logger, jobName string, job pipeline.JobConfig, pipelineID int) (*jobServices, error) {
+	services := &jobServices{}
+	if len(job.Services) == 0 {
+		return services, nil
+	}
+
+	network := servicesNetworkName(e.containerPrefix, pipelineID, jobName)
+	if _, err := e.docker.CreateNetwork(ctx, network); err != nil {
+		return services, err
+	}
+	services.network = network
+
+	for _, service := range job.Services {
+		alias := service.HostAlias()
+		log.Info("Starting service", "image", service.Name, "alias", alias)
+		if err := e.docker.PullImage(ctx, service.Name); err != nil {
+			return services, fmt.Errorf("failed to pull service image %s: %w", service.Name, err)
+		}
+
+		name := ""
+		if e.containerPrefix != "" {
+			name = jobContainerName(e.containerPrefix, pipelineID, jobName) + "-" + alias
+		}
+		containerID, err := e.docker.StartService(ctx, service.Name, docker.ServiceOptions{
+			Name:       name,
+			Network:    network,
+			Aliases:    []string{alias},
+			Env:        serviceEnv(service.Variables),
+			Command:    service.Command,
+			Entrypoint: service.Entrypoint,
+		})
+		if err != nil {
+			return services, fmt.Errorf("failed to start service %s: %w", service.Name, err)
+		}
+		services.containers = append(services.containers, containerID)
+
+		if err := e.docker.WaitForService(ctx, containerID, network, e.serviceWaitTimeout); err != nil {
+			warning := fmt.Sprintf("Service %s (%s) probably did not start properly: %v", alias, service.Name, err)
+			log.Warn("Service not ready", "alias", alias, "error", err)
+			e.jobLog(pipelineID, jobName, warning)
+		}
+	}
+	return services, nil
+}
+
+// stopServices removes the service containers of a job, then their network, whatever the outcome of the job
+func (e *PipelineExecutor) stopServices(ctx context.Context, log *slog.Logger, services *jobServices) {
+	ctx = context.WithoutCancel(ctx)
+	for _, containerID := range services.containers {
+		if err := e.docker.RemoveContainer(ctx, containerID); err != nil {
+			log.Warn("Failed to remove service container", "container", containerID, "error", err)
+		}
+	}
+	if services.network == "" {
+		return
+	}
+	if err := e.docker.RemoveNetwork(ctx, services.network); err != nil {
+		log.Warn("Failed to remove services network", "network", services.network, "error", err)
+	}
+}
@@ -0,0 +1,23 @@
+package executor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServicesNetworkName(t *testing.T) {
+	if name := servicesNetworkName("ci", 7, "integration tests"); name != "ci-7-integration-tests-services" {
+		t.Errorf("Unexpected network name %q", name)
+	}
+	// Networks are always named, even when job containers are not
+	if name := servicesNetworkName("", 7, "test"); name != "dnd-7-test-services" {
+		t.Errorf("Unexpected network name %q", name)
+	}
+}
+
+func TestServiceEnv(t *testing.T) {
+	env := serviceEnv(map[string]string{"POSTGRES_USER": "ci", "POSTGRES_DB": "app"})
+	if !reflect.DeepEqual(env, []string{"POSTGRES_DB=app", "POSTGRES_USER=ci"}) {
+		t.Errorf("Unexpected service environment %v", env)
+	}
+}
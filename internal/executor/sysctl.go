@@ -0,0 +1,37 @@
+package executor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// checkSysctls verifies that every sysctl requested by a job is allowed by the operator
+// An allowlist entry ending with "*" allows every key starting with what precedes the "*" (e.g. net.ipv4.*)
+func checkSysctls(requested map[string]string, allowlist []string) error {
+	var rejected []string
+	for key := range requested {
+		if !sysctlAllowed(key, allowlist) {
+			rejected = append(rejected, key)
+		}
+	}
+	if len(rejected) > 0 {
+		sort.Strings(rejected)
+		return fmt.Errorf("sysctls not allowed: %s (see JOB_SYSCTL_ALLOWLIST)", strings.Join(rejected, ", "))
+	}
+	return nil
+}
+
+// sysctlAllowed reports whether key is listed in the allowlist or starts with the prefix of a "*" entry
+func sysctlAllowed(key string, allowlist []string) bool {
+	for _, allowed := range allowlist {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package executor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckSysctls(t *testing.T) {
+	allowlist := []string{"net.core.somaxconn", "net.ipv4.*"}
+
+	t.Run("Allowed", func(t *testing.T) {
+		requested := map[string]string{
+			"net.core.somaxconn":           "1024",
+			"net.ipv4.ip_local_port_range": "1024 65000",
+		}
+		if err := checkSysctls(requested, allowlist); err != nil {
+			t.Errorf("Expected sysctls to be allowed, got %v", err)
+		}
+	})
+
+	t.Run("Disallowed", func(t *testing.T) {
+		requested := map[string]string{
+			"net.core.somaxconn": "1024",
+			"kernel.shmmax":      "68719476736",
+		}
+		err := checkSysctls(requested, allowlist)
+		if err == nil {
+			t.Fatal("Expected error for a sysctl outside the allowlist, got nil")
+		}
+		if !strings.Contains(err.Error(), "kernel.shmmax") || strings.Contains(err.Error(), "somaxconn") {
+			t.Errorf("Expected only kernel.shmmax to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("EmptyAllowlist", func(t *testing.T) {
+		if err := checkSysctls(map[string]string{"net.core.somaxconn": "1024"}, nil); err == nil {
+			t.Error("Expected every sysctl to be rejected without allowlist")
+		}
+		if err := checkSysctls(nil, nil); err != nil {
+			t.Errorf("Expected jobs without sysctls to pass, got %v", err)
+		}
+	})
+}
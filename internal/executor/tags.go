@@ -0,0 +1,33 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// missingTags returns the tags of a job the runner does not advertise, in the order of the job
+func missingTags(required, available []string) []string {
+	advertised := make(map[string]bool, len(available))
+	for _, tag := range available {
+		advertised[strings.TrimSpace(tag)] = true
+	}
+	var missing []string
+	for _, tag := range required {
+		if tag = strings.TrimSpace(tag); tag != "" && !advertised[tag] {
+			missing = append(missing, tag)
+		}
+	}
+	return missing
+}
+
+// failUnmatchedJob records a job no runner can take as failed, with the missing tags in its logs
+// The job never ran, the pipeline stops and is not deployed
+func (e *PipelineExecutor) failUnmatchedJob(jobName string, pipelineID int, missing []string) jobOutcome {
+	if e.db != nil && pipelineID > 0 {
+		if dbJob, err := e.db.GetJobByName(pipelineID, jobName); err == nil {
+			e.writeJobLine(dbJob.ID, fmt.Sprintf("Stuck: no matching runner, missing tags: %s (see RUNNER_TAGS)", strings.Join(missing, ", ")))
+			e.db.UpdateJobStatus(dbJob.ID, "failed", nil)
+		}
+	}
+	return jobOutcome{stop: true}
+}
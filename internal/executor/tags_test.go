@@ -0,0 +1,69 @@
+package executor
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+)
+
+func TestMissingTags(t *testing.T) {
+	runner := []string{"linux", "amd64", "docker"}
+
+	tests := []struct {
+		name     string
+		required []string
+		want     []string
+	}{
+		{"NoTags", nil, nil},
+		{"Satisfied", []string{"docker", "linux"}, nil},
+		{"Missing", []string{"docker", "arm64", "gpu"}, []string{"arm64", "gpu"}},
+		{"Blank", []string{" linux ", ""}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := missingTags(tt.required, runner); !slices.Equal(got, tt.want) {
+				t.Errorf("missingTags(%v) = %v, want %v", tt.required, got, tt.want)
+			}
+		})
+	}
+
+	if got := missingTags([]string{"docker"}, nil); !slices.Equal(got, []string{"docker"}) {
+		t.Errorf("Expected a runner without tags to match no tagged job, got %v", got)
+	}
+}
+
+func TestRunStageJobNoMatchingRunner(t *testing.T) {
+	e := &PipelineExecutor{runnerTags: []string{"linux"}}
+	outcome := e.runStageJob(t.Context(), "gpu-test", pipeline.JobConfig{Tags: []string{"gpu"}}, t.TempDir(), 0, 0, pipelineRef{}, nil, nil, nil)
+	if outcome.success || outcome.skipped || !outcome.stop {
+		t.Errorf("Expected the job to fail and stop the pipeline, got %+v", outcome)
+	}
+}
+
+func TestExecuteNoMatchingRunnerBlocksDeploy(t *testing.T) {
+	// The deploy job would need a Docker client, it must never be reached
+	config := &pipeline.PipelineConfig{
+		Stages: []string{"test", "deploy"},
+		Jobs: map[string]pipeline.JobConfig{
+			"gpu-test": {Stage: "test", Image: "alpine", Tags: []string{"gpu"}},
+			"release":  {Stage: "deploy", Image: "alpine"},
+		},
+	}
+	e := &PipelineExecutor{runnerTags: []string{"linux", "docker"}, logHub: NewLogHub(), artifactsDir: t.TempDir()}
+	success, err := e.Execute(t.Context(), config, t.TempDir(), models.PipelineRunParams{CommitHash: "0123456789abcdef"}, nil)
+	if success || err != nil {
+		t.Errorf("Expected the pipeline to fail without infrastructure error, got success=%v err=%v", success, err)
+	}
+}
+
+func TestNoRunnerTagsRunsTaggedJobs(t *testing.T) {
+	// Without RUNNER_TAGS, tags are not enforced and the job gets to its rules
+	e := &PipelineExecutor{}
+	job := pipeline.JobConfig{Tags: []string{"docker"}, Only: pipeline.RefRule{Refs: []string{"main"}}}
+	outcome := e.runStageJob(t.Context(), "build", job, t.TempDir(), 0, 0, pipelineRef{name: "dev"}, nil, nil, nil)
+	if !outcome.skipped {
+		t.Errorf("Expected the tags to be ignored and the only rule to skip the job, got %+v", outcome)
+	}
+}
@@ -0,0 +1,54 @@
+package executor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestExecuteCreatesSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	// The sysctl is not allowlisted: the job fails before any container is started
+	config := &pipeline.PipelineConfig{
+		Stages: []string{"build"},
+		Jobs: map[string]pipeline.JobConfig{
+			"compile": {Stage: "build", Image: "golang:1.25", Sysctls: map[string]string{"net.core.somaxconn": "1024"}},
+		},
+	}
+	e := &PipelineExecutor{}
+	success, err := e.Execute(context.Background(), config, t.TempDir(), models.PipelineRunParams{}, nil)
+	if success {
+		t.Fatal("Expected pipeline to fail")
+	}
+	// A rejected sysctl is a configuration error, not an infrastructure failure
+	if IsInfraError(err) {
+		t.Errorf("Expected no infrastructure error, got %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+
+	job, stage := spans[0], spans[1]
+	if stage.Name != "stage" || job.Name != "job" {
+		t.Fatalf("Expected job then stage spans, got %q and %q", job.Name, stage.Name)
+	}
+	if job.Parent.SpanID() != stage.SpanContext.SpanID() {
+		t.Errorf("Expected job span to be a child of the stage span")
+	}
+	if job.Status.Code != codes.Error {
+		t.Errorf("Expected failed job span to have an error status, got %v", job.Status.Code)
+	}
+}
@@ -0,0 +1,253 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/database"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+)
+
+// predefinedVariables returns the variables describing the current pipeline:
+//
+//	CI                   always "true", tells tools they run in CI
+//	CI_COMMIT_SHA        full hash of the commit being built
+//	CI_COMMIT_SHORT_SHA  first 8 characters of CI_COMMIT_SHA
+//	CI_COMMIT_BRANCH     branch that triggered the pipeline, only set for branch pipelines
+//	CI_COMMIT_TAG        tag that triggered the pipeline, only set for tag pipelines
+//	CI_COMMIT_REF_NAME   tag or branch the pipeline runs for
+//	CI_PROJECT_ID        database ID of the project
+//	CI_PROJECT_NAME      repository name
+//	CI_PIPELINE_ID       database ID of the pipeline
+//
+// Job containers also get the variables of jobVariables, and CI_PROJECT_DIR (the workspace in the container)
+func predefinedVariables(params models.PipelineRunParams) []string {
+	shortSHA := params.CommitHash
+	if len(shortSHA) > 8 {
+		shortSHA = shortSHA[:8]
+	}
+
+	vars := []string{
+		"CI=true",
+		"CI_COMMIT_SHA=" + params.CommitHash,
+		"CI_COMMIT_SHORT_SHA=" + shortSHA,
+		"CI_COMMIT_REF_NAME=" + refOf(params).name,
+		"CI_PROJECT_ID=" + strconv.Itoa(params.ProjectID),
+		"CI_PROJECT_NAME=" + params.RepoName,
+		"CI_PIPELINE_ID=" + strconv.Itoa(params.PipelineID),
+	}
+	// Like GitLab, a pipeline runs either a branch or a tag
+	if params.Tag != "" {
+		vars = append(vars, "CI_COMMIT_TAG="+params.Tag)
+	} else {
+		vars = append(vars, "CI_COMMIT_BRANCH="+params.Branch)
+	}
+	return vars
+}
+
+// jobVariables returns the variables describing a job, added to the predefined ones of its pipeline:
+//
+//	CI_JOB_NAME   name of the job
+//	CI_JOB_STAGE  stage of the job
+//	CI_JOB_IMAGE  image of the job
+func jobVariables(jobName string, job pipeline.JobConfig) []string {
+	return []string{
+		"CI_JOB_NAME=" + jobName,
+		"CI_JOB_STAGE=" + job.Stage,
+		"CI_JOB_IMAGE=" + job.Image,
+	}
+}
+
+// projectVariables returns the custom variables (secrets/env vars) of a project as KEY=VALUE pairs,
+// along with the masker hiding the values of its masked variables in logs
+func projectVariables(db *database.DB, project *models.Project) ([]string, *logMasker) {
+	if db == nil || project == nil {
+		return nil, nil
+	}
+
+	variables, err := db.GetVariablesByProject(project.ID)
+	if err != nil {
+		logger.Error("Failed to fetch project variables: " + err.Error())
+		return nil, nil
+	}
+
+	var envVars []string
+	for _, v := range variables {
+		envVars = append(envVars, fmt.Sprintf("%s=%s", v.Key, v.Value))
+	}
+	return envVars, newLogMasker(variables)
+}
+
+// loadJobSecrets reads the server secret store, a JSON object of KEY: value injected into every job
+// None when path is empty
+func loadJobSecrets(path string) map[string]string {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Ignoring job secrets: %v", err))
+		return nil
+	}
+	var secrets map[string]string
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		logger.Error(fmt.Sprintf("Ignoring job secrets: failed to parse %s: %v", path, err))
+		return nil
+	}
+	logger.Info(fmt.Sprintf("Loaded %d job secret(s)", len(secrets)))
+	return secrets
+}
+
+// jobEnvironment merges the variables of a job container as KEY=VALUE pairs sorted by key
+// When a key is set more than once, the later source wins:
+//
+//  1. predefined variables of the pipeline (KEY=VALUE pairs), see predefinedVariables
+//  2. global `variables:` of the pipeline file
+//  3. `variables:` of the job
+//  4. project variables (KEY=VALUE pairs), set through the API
+//  5. server secret store (JOB_SECRETS_FILE), so that a commit can never shadow them
+func jobEnvironment(predefined []string, global, job map[string]string, project []string, secrets map[string]string) []string {
+	merged := make(map[string]string, len(predefined)+len(global)+len(job)+len(project)+len(secrets))
+	for _, pair := range predefined {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			merged[key] = value
+		}
+	}
+	for key, value := range global {
+		merged[key] = value
+	}
+	for key, value := range job {
+		merged[key] = value
+	}
+	for _, pair := range project {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			merged[key] = value
+		}
+	}
+	for key, value := range secrets {
+		merged[key] = value
+	}
+
+	envVars := make([]string, 0, len(merged))
+	for key, value := range merged {
+		envVars = append(envVars, key+"="+value)
+	}
+	sort.Strings(envVars)
+	return envVars
+}
+
+// secretValues returns the values of the secret store, masked in job logs
+func secretValues(secrets map[string]string) []string {
+	values := make([]string, 0, len(secrets))
+	for _, value := range secrets {
+		values = append(values, value)
+	}
+	return values
+}
+
+// expandVariables replaces $VAR and ${VAR} in s with the values of vars, $$ gives a literal $
+// The value of a variable is expanded once more, so that `TAG: $CI_COMMIT_SHORT_SHA` can be used in an image
+// An undefined variable expands to empty, its name is passed to undefined
+func expandVariables(s string, vars map[string]string, undefined func(name string)) string {
+	return os.Expand(s, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		value, ok := vars[name]
+		if !ok {
+			undefined(name)
+			return ""
+		}
+		return os.Expand(value, func(inner string) string {
+			return vars[inner]
+		})
+	})
+}
+
+// expandJob returns job with the variables of envVars (KEY=VALUE pairs) expanded in its image and its artifacts and cache paths,
+// along with the sorted names of the undefined variables
+// The script is left to the shell of the job, which gets the same variables: substituting them in the text
+// would let a value such as a branch name inject commands
+func expandJob(job pipeline.JobConfig, envVars []string) (pipeline.JobConfig, []string) {
+	vars := make(map[string]string, len(envVars))
+	for _, pair := range envVars {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			vars[key] = value
+		}
+	}
+	seen := make(map[string]bool)
+	expand := func(s string) string {
+		return expandVariables(s, vars, func(name string) { seen[name] = true })
+	}
+	expandAll := func(values []string) []string {
+		if values == nil {
+			return nil
+		}
+		expanded := make([]string, len(values))
+		for i, value := range values {
+			expanded[i] = expand(value)
+		}
+		return expanded
+	}
+
+	job.Image = expand(job.Image)
+	job.Artifacts.Paths = expandAll(job.Artifacts.Paths)
+	if job.Cache != nil {
+		// The global cache is shared by the jobs, expand a copy
+		cache := *job.Cache
+		cache.Key.Name = expand(cache.Key.Name)
+		cache.Key.Prefix = expand(cache.Key.Prefix)
+		cache.Key.Files = expandAll(cache.Key.Files)
+		cache.Paths = expandAll(cache.Paths)
+		job.Cache = &cache
+	}
+
+	undefined := make([]string, 0, len(seen))
+	for name := range seen {
+		undefined = append(undefined, name)
+	}
+	sort.Strings(undefined)
+	return job, undefined
+}
+
+// expandConfig returns a copy of config whose jobs have their variables expanded, see expandJob
+// An undefined variable is reported as a warning in the logs of the pipeline and of the job
+func (e *PipelineExecutor) expandConfig(ctx context.Context, config *pipeline.PipelineConfig, predefined, project []string, pipelineID int) *pipeline.PipelineConfig {
+	expanded := *config
+	expanded.Jobs = make(map[string]pipeline.JobConfig, len(config.Jobs))
+	for jobName, job := range config.Jobs {
+		job, undefined := expandJob(job, jobEnvironment(append(jobVariables(jobName, job), predefined...), config.Variables, job.Variables, project, e.secrets))
+		for _, name := range undefined {
+			logger.FromContext(ctx).Warn("Undefined variable expands to empty", "job_name", jobName, "variable", name)
+			e.jobLog(pipelineID, jobName, fmt.Sprintf("WARNING: undefined variable $%s expands to empty", name))
+		}
+		// The parser only checked the paths before expansion
+		if job.Cache != nil && !cacheInWorkspace(job.Cache) {
+			logger.FromContext(ctx).Warn("Cache disabled, a path is outside the workspace once expanded", "job_name", jobName)
+			e.jobLog(pipelineID, jobName, "WARNING: cache disabled, a path is outside the workspace once expanded")
+			job.Cache = nil
+		}
+		expanded.Jobs[jobName] = job
+	}
+	return &expanded
+}
+
+// cacheInWorkspace reports whether the paths and key files of a cache stay in the workspace
+func cacheInWorkspace(cache *pipeline.CacheConfig) bool {
+	for _, path := range append(slices.Clone(cache.Paths), cache.Key.Files...) {
+		if !filepath.IsLocal(filepath.Clean(path)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/models"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/parser/pipeline"
+)
+
+func TestPredefinedVariables(t *testing.T) {
+	params := models.PipelineRunParams{
+		RepoName:   "my-app",
+		Branch:     "main",
+		CommitHash: "0123456789abcdef",
+		PipelineID: 42,
+		ProjectID:  7,
+	}
+
+	expected := map[string]bool{
+		"CI=true":                        true,
+		"CI_COMMIT_SHA=0123456789abcdef": true,
+		"CI_COMMIT_SHORT_SHA=01234567":   true,
+		"CI_COMMIT_BRANCH=main":          true,
+		"CI_COMMIT_REF_NAME=main":        true,
+		"CI_PROJECT_ID=7":                true,
+		"CI_PROJECT_NAME=my-app":         true,
+		"CI_PIPELINE_ID=42":              true,
+	}
+
+	vars := predefinedVariables(params)
+	if len(vars) != len(expected) {
+		t.Fatalf("Expected %d variables, got %d: %v", len(expected), len(vars), vars)
+	}
+	for _, v := range vars {
+		if !expected[v] {
+			t.Errorf("Unexpected variable %s", v)
+		}
+	}
+}
+
+func TestPredefinedVariablesTag(t *testing.T) {
+	vars := predefinedVariables(models.PipelineRunParams{Branch: "v1.0", Tag: "v1.0", CommitHash: "0123456789abcdef"})
+	if !slices.Contains(vars, "CI_COMMIT_TAG=v1.0") || !slices.Contains(vars, "CI_COMMIT_REF_NAME=v1.0") {
+		t.Errorf("Expected the tag variables, got %v", vars)
+	}
+	// Tag pipelines have no branch, jobs tell them apart by the variable being set
+	for _, v := range vars {
+		if strings.HasPrefix(v, "CI_COMMIT_BRANCH=") {
+			t.Errorf("Expected no CI_COMMIT_BRANCH in a tag pipeline, got %s", v)
+		}
+	}
+}
+
+func TestJobVariables(t *testing.T) {
+	expected := []string{"CI_JOB_NAME=unit", "CI_JOB_STAGE=test", "CI_JOB_IMAGE=golang:1.25"}
+	if vars := jobVariables("unit", pipeline.JobConfig{Stage: "test", Image: "golang:1.25"}); !reflect.DeepEqual(vars, expected) {
+		t.Errorf("Expected %v, got %v", expected, vars)
+	}
+}
+
+func TestJobEnvironmentPrecedence(t *testing.T) {
+	global := map[string]string{"LEVEL": "global", "GLOBAL_ONLY": "1", "TOKEN": "from-file"}
+	job := map[string]string{"LEVEL": "job", "JOB_ONLY": "1"}
+	project := []string{"PROJECT_ONLY=a=b", "TOKEN=from-project"}
+	secrets := map[string]string{"TOKEN": "from-store"}
+	predefined := []string{"CI_PIPELINE_ID=42", "LEVEL=predefined"}
+
+	expected := []string{
+		"CI_PIPELINE_ID=42",
+		"GLOBAL_ONLY=1",
+		"JOB_ONLY=1",
+		"LEVEL=job",
+		"PROJECT_ONLY=a=b",
+		"TOKEN=from-store",
+	}
+	if env := jobEnvironment(predefined, global, job, project, secrets); !reflect.DeepEqual(env, expected) {
+		t.Errorf("Expected %v, got %v", expected, env)
+	}
+}
+
+func TestLoadJobSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	if err := os.WriteFile(path, []byte(`{"DEPLOY_TOKEN": "s3cr3t"}`), 0600); err != nil {
+		t.Fatalf("Failed to write secrets: %v", err)
+	}
+
+	secrets := loadJobSecrets(path)
+	if secrets["DEPLOY_TOKEN"] != "s3cr3t" {
+		t.Errorf("Expected secret to be loaded, got %v", secrets)
+	}
+	if loadJobSecrets("") != nil {
+		t.Error("Expected no secrets without a file")
+	}
+
+	// Secret values are masked in job logs
+	if line := maskValues(secretValues(secrets)).mask("token s3cr3t"); line != "token ****" {
+		t.Errorf("Expected secret to be masked, got %q", line)
+	}
+}
+
+func TestExpandJob(t *testing.T) {
+	envVars := []string{
+		"CI_PROJECT_NAME=my-app",
+		"CI_COMMIT_SHORT_SHA=01234567",
+		"CI_COMMIT_BRANCH=main",
+		"TAG=$CI_COMMIT_SHORT_SHA",
+	}
+	shared := &pipeline.CacheConfig{Key: pipeline.CacheKey{Name: "deps-${CI_COMMIT_BRANCH}"}, Paths: []string{"$CI_PROJECT_NAME/vendor"}}
+	job := pipeline.JobConfig{
+		Image:     "registry.example.com/$CI_PROJECT_NAME/builder:$TAG",
+		Script:    []string{"echo $CI_COMMIT_SHA"},
+		Artifacts: pipeline.ArtifactsConfig{Paths: []string{"dist/$CI_PROJECT_NAME-$MISSING.tar.gz", "cost-$$.txt"}},
+		Cache:     shared,
+	}
+
+	expanded, undefined := expandJob(job, envVars)
+	if expanded.Image != "registry.example.com/my-app/builder:01234567" {
+		t.Errorf("Unexpected image %q", expanded.Image)
+	}
+	if !reflect.DeepEqual(expanded.Artifacts.Paths, []string{"dist/my-app-.tar.gz", "cost-$.txt"}) {
+		t.Errorf("Unexpected artifacts paths %q", expanded.Artifacts.Paths)
+	}
+	if expanded.Cache.Key.Name != "deps-main" || !reflect.DeepEqual(expanded.Cache.Paths, []string{"my-app/vendor"}) {
+		t.Errorf("Unexpected cache %+v", *expanded.Cache)
+	}
+	if !reflect.DeepEqual(undefined, []string{"MISSING"}) {
+		t.Errorf("Expected MISSING to be reported as undefined, got %v", undefined)
+	}
+
+	// The script is expanded by the shell of the job, the shared configs are left untouched
+	if expanded.Script[0] != "echo $CI_COMMIT_SHA" {
+		t.Errorf("Expected the script to be left as is, got %q", expanded.Script[0])
+	}
+	if shared.Key.Name != "deps-${CI_COMMIT_BRANCH}" || job.Artifacts.Paths[0] != "dist/$CI_PROJECT_NAME-$MISSING.tar.gz" {
+		t.Error("Expected the original job config to be left untouched")
+	}
+}
+
+func TestExpandConfigCacheOutsideWorkspace(t *testing.T) {
+	config := &pipeline.PipelineConfig{
+		Variables: map[string]string{"DIR": "../.."},
+		Jobs: map[string]pipeline.JobConfig{
+			"build": {Image: "alpine", Cache: &pipeline.CacheConfig{Paths: []string{"$DIR/etc"}}},
+		},
+	}
+	expanded := (&PipelineExecutor{}).expandConfig(t.Context(), config, nil, nil, 0)
+	if expanded.Jobs["build"].Cache != nil {
+		t.Error("Expected the cache leaving the workspace to be disabled")
+	}
+	if config.Jobs["build"].Cache == nil {
+		t.Error("Expected the original config to be left untouched")
+	}
+}
@@ -1,57 +1,291 @@
 package git
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
 )
 
+// CloneOptions tunes how a repository is cloned
+type CloneOptions struct {
+	// MaxSize aborts the clone once the destination holds more than MaxSize bytes, 0 disables the limit
+	MaxSize int64
+	// SSHKey authenticates SSH remotes (git@host:repo or ssh://), either the key itself or a key path under SSHKeyDir
+	SSHKey string
+	// SSHKeyDir is the directory key paths must be in, only key material is accepted when empty
+	SSHKeyDir string
+	// KnownHostsFile holds the SSH host keys, see HostKeyChecking
+	KnownHostsFile string
+	// HostKeyChecking is how SSH host keys are verified: HostKeyAcceptNew (the default), HostKeyStrict or HostKeyOff
+	HostKeyChecking string
+	// LFS fetches the Git LFS files after the checkout
+	LFS bool
+	// Submodules clones the submodules of the repository, with the token or SSH key of the clone
+	Submodules bool
+	// ReferenceDir keeps a mirror per repository, clones borrow its objects (--reference --dissociate)
+	ReferenceDir string
+	// ReferenceRefresh is the age after which a mirror is fetched again before a clone
+	ReferenceRefresh time.Duration
+	// Timeout bounds the whole clone, fetches, submodules and LFS included, 0 disables it
+	Timeout time.Duration
+	// Depth is the history fetched for the branch and for a specific commit, 1 when 0; a negative depth fetches the full history
+	Depth int
+	// Output receives the output of git clone, token redacted, when set
+	Output io.Writer
+}
+
+// depth returns the --depth of the clone and fetches, 0 for the full history
+func (o CloneOptions) depth() int {
+	if o.Depth == 0 {
+		return 1
+	}
+	return max(o.Depth, 0)
+}
+
+// ErrCommitNotFound is returned when the commit of a pipeline does not exist in the repository
+var ErrCommitNotFound = errors.New("commit not found")
+
+// ErrRefNotFound is returned when the branch or tag of a pipeline does not exist in the repository
+var ErrRefNotFound = errors.New("ref not found")
+
+// ErrCloneTimeout is returned when a clone takes longer than CloneOptions.Timeout
+var ErrCloneTimeout = errors.New("clone timed out")
+
 // Clone clones a repository to the destination path and checks out a specific commit
 // If token is provided, it's used for authentication (HTTPS)
 // If commitHash is provided, it checks out that specific commit after cloning
-func Clone(repoURL, branch, destPath, token, commitHash string) error {
+func Clone(repoURL, branch, destPath, token, commitHash string, opts CloneOptions) error {
+	return CloneWithContext(context.Background(), repoURL, branch, destPath, token, commitHash, opts)
+}
+
+// CloneWithContext is Clone aborted once ctx is done or opts.Timeout elapses, git and its helpers are killed
+// A timeout returns ErrCloneTimeout, a cancellation the error of ctx, so that neither is mistaken for
+// an authentication or ref failure
+func CloneWithContext(ctx context.Context, repoURL, branch, destPath, token, commitHash string, opts CloneOptions) error {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, opts.Timeout, ErrCloneTimeout)
+		defer cancel()
+	}
+
+	err := clone(ctx, repoURL, branch, destPath, token, commitHash, opts)
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	if errors.Is(context.Cause(ctx), ErrCloneTimeout) {
+		return fmt.Errorf("%w after %s", ErrCloneTimeout, opts.Timeout)
+	}
+	return fmt.Errorf("clone cancelled: %w", ctx.Err())
+}
+
+func clone(ctx context.Context, repoURL, branch, destPath, token, commitHash string, opts CloneOptions) error {
+	run := contextRunner(ctx)
+	branch = NormalizeRef(branch)
+	mirror := ""
+	if opts.ReferenceDir != "" {
+		mirror = referencePath(opts.ReferenceDir, repoURL)
+	}
+
+	// Submodules on the host of the repository are fetched with the same token
+	var submoduleEnv []string
+	if opts.Submodules {
+		submoduleEnv = submoduleAuthEnv(repoURL, token)
+	}
+
 	// If token provided, inject it into the URL for auth
 	// https://github.com/user/repo.git -> https://token@github.com/user/repo.git
 	if token != "" {
 		repoURL = injectToken(repoURL, token)
 	}
 
-	// If we need a specific commit, we can't use shallow clone
-	// because the commit might not be the latest on the branch
-	var args []string
-	if commitHash != "" {
-		// Full clone to ensure we have the commit
-		args = []string{"clone", "--branch", branch, repoURL, destPath}
-	} else {
-		// Shallow clone if no specific commit needed
-		args = []string{"clone", "--depth", "1", "--branch", branch, repoURL, destPath}
+	sshEnv, cleanup, err := sshEnvironment(repoURL, opts)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	env := append(append(os.Environ(), sshEnv...), submoduleEnv...)
+
+	// The mirror only speeds the clone up, the clone goes on without it
+	if mirror != "" {
+		if err := referenceMirror(mirror, repoURL, env, opts.ReferenceRefresh, run); err != nil {
+			logger.Warn(fmt.Sprintf("Cloning without reference: %s", redactToken(err.Error(), token)))
+			mirror = ""
+		}
 	}
 
-	cmd := exec.Command("git", args...)
-	output, err := cmd.CombinedOutput()
+	// --branch takes a branch or a tag, slashes included
+	cmd := gitCommand(ctx, "", env, cloneArgs(repoURL, branch, destPath, mirror, opts.Submodules, opts.depth())...)
+	output, err := runWithSizeLimit(cmd, destPath, opts.MaxSize, sizeCheckInterval)
+	writeCloneOutput(opts.Output, output, token)
+	if err != nil && branch != "" && missingRef(string(output)) {
+		if commitHash == "" {
+			return fmt.Errorf("%w: no branch or tag %s in the repository", ErrRefNotFound, branch)
+		}
+		// The ref is gone (deleted branch, moved tag) or the trigger only knows the commit:
+		// the default branch is cloned and the commit fetched by hash
+		logger.Warn(fmt.Sprintf("No branch or tag %s, cloning the default branch to fetch commit %s", branch, commitHash))
+		os.RemoveAll(destPath)
+		cmd = gitCommand(ctx, "", env, cloneArgs(repoURL, "", destPath, mirror, opts.Submodules, opts.depth())...)
+		output, err = runWithSizeLimit(cmd, destPath, opts.MaxSize, sizeCheckInterval)
+		writeCloneOutput(opts.Output, output, token)
+	}
+	if errors.Is(err, ErrRepositoryTooLarge) {
+		return err
+	}
 	if err != nil {
-		return fmt.Errorf("git clone failed: %s - %w", string(output), err)
+		return fmt.Errorf("git clone failed: %s - %w", redactToken(string(output), token), err)
 	}
 
 	// Checkout specific commit if provided
 	if commitHash != "" {
-		if err := Checkout(destPath, commitHash); err != nil {
+		if err := fetchCommit(destPath, commitHash, token, opts.depth(), env, run); err != nil {
+			return err
+		}
+		if err := checkout(destPath, commitHash, run); err != nil {
 			return fmt.Errorf("failed to checkout commit %s: %w", commitHash, err)
 		}
+		// The submodules must match the checked out commit before the working tree is verified
+		if opts.Submodules {
+			if err := updateSubmodules(destPath, token, env, run); err != nil {
+				return err
+			}
+		}
+		if err := VerifyCheckout(destPath, commitHash); err != nil {
+			return err
+		}
+	}
+
+	// Replace the LFS pointer files by their content, with the auth of the clone
+	if opts.LFS {
+		if err := fetchLFS(destPath, env, run); err != nil {
+			return err
+		}
+	}
+
+	// The checkout may have grown the working tree past the limit
+	return checkSize(destPath, opts.MaxSize)
+}
+
+// writeCloneOutput copies the output of a git clone to w, token redacted
+func writeCloneOutput(w io.Writer, output []byte, token string) {
+	if w == nil || len(output) == 0 {
+		return
+	}
+	io.WriteString(w, redactToken(string(output), token))
+}
+
+// cloneArgs returns the arguments of a git clone, shallow unless depth is 0, borrowing the objects of the reference mirror when set
+// A specific commit is fetched afterwards, see fetchCommit
+func cloneArgs(repoURL, branch, destPath, reference string, submodules bool, depth int) []string {
+	args := []string{"clone"}
+	if depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(depth))
+	}
+	// --dissociate copies the borrowed objects, the workspace does not depend on the mirror
+	if reference != "" {
+		args = append(args, "--reference", reference, "--dissociate")
+	}
+	if submodules {
+		args = append(args, "--recurse-submodules")
+	}
+	// Without branch, the default branch of the remote is cloned
+	if branch != "" {
+		args = append(args, "--branch", branch)
+	}
+	return append(args, repoURL, destPath)
+}
+
+// NormalizeRef returns the short name of a branch or tag ref, refs/heads/feature/x gives feature/x
+func NormalizeRef(ref string) string {
+	ref = strings.TrimSpace(ref)
+	for _, prefix := range []string{"refs/heads/", "refs/tags/"} {
+		if name, ok := strings.CutPrefix(ref, prefix); ok {
+			return name
+		}
 	}
+	return ref
+}
+
+// missingRef reports whether a failed clone output says the --branch ref does not exist on the remote
+func missingRef(output string) bool {
+	return strings.Contains(output, "not found in upstream") || strings.Contains(output, "Could not find remote branch")
+}
 
+// fetchCommit makes a commit available in a clone of its branch, shallow unless depth is 0
+// The commit alone (with depth commits of history) is fetched when the server allows it, otherwise the full history of the branch
+// It fails with ErrCommitNotFound when the commit is not part of it
+func fetchCommit(repoPath, commitHash, token string, depth int, env []string, run gitRunner) error {
+	hasCommit := func() bool {
+		_, err := run(repoPath, env, "cat-file", "-e", commitHash+"^{commit}")
+		return err == nil
+	}
+	// The commit is usually the head of the branch
+	if hasCommit() {
+		return nil
+	}
+	fetch := []string{"fetch", "--quiet"}
+	if depth > 0 {
+		fetch = append(fetch, "--depth", strconv.Itoa(depth))
+	}
+	if _, err := run(repoPath, env, append(fetch, "origin", commitHash)...); err == nil && hasCommit() {
+		return nil
+	}
+
+	// Some servers refuse to serve a commit by hash, abbreviated hashes cannot be fetched either
+	if depth > 0 {
+		if output, err := run(repoPath, env, "fetch", "--quiet", "--unshallow", "origin"); err != nil {
+			return fmt.Errorf("git fetch failed: %s - %w", redactToken(string(output), token), err)
+		}
+	}
+	if !hasCommit() {
+		return fmt.Errorf("%w: %s", ErrCommitNotFound, commitHash)
+	}
 	return nil
 }
 
 // Checkout checks out a specific commit in the repository
 func Checkout(repoPath, commitHash string) error {
-	cmd := exec.Command("git", "checkout", commitHash)
+	return checkout(repoPath, commitHash, runGitCommand)
+}
+
+func checkout(repoPath, commitHash string, run gitRunner) error {
+	output, err := run(repoPath, os.Environ(), "checkout", commitHash)
+	if err != nil {
+		return fmt.Errorf("git checkout failed: %s - %w", redactToken(string(output), ""), err)
+	}
+	return nil
+}
+
+// VerifyCheckout ensures the working tree is clean and at the expected commit
+// A failed or partial checkout must not be silently built on by the jobs
+func VerifyCheckout(repoPath, commitHash string) error {
+	head, err := GetLatestCommitHash(repoPath)
+	if err != nil {
+		return fmt.Errorf("checkout verification failed: cannot resolve HEAD: %w", err)
+	}
+	if !strings.HasPrefix(head, commitHash) {
+		return fmt.Errorf("checkout verification failed: HEAD is at %s, expected %s", head, commitHash)
+	}
+
+	cmd := exec.Command("git", "status", "--porcelain")
 	cmd.Dir = repoPath
-	output, err := cmd.CombinedOutput()
+	output, err := cmd.Output()
 	if err != nil {
-		return fmt.Errorf("git checkout failed: %s - %w", string(output), err)
+		return fmt.Errorf("checkout verification failed: git status failed: %w", err)
 	}
+	if dirty := strings.TrimSpace(string(output)); dirty != "" {
+		return fmt.Errorf("checkout verification failed: working tree is not clean:\n%s", dirty)
+	}
+
 	return nil
 }
 
@@ -69,25 +303,61 @@ func injectToken(repoURL, token string) string {
 	return repoURL
 }
 
+// urlCredentials matches the credentials embedded in an HTTPS URL
+var urlCredentials = regexp.MustCompile(`https://[^@/\s]+@`)
+
+// redactToken hides the access token, and any credentials embedded in an HTTPS URL, in git output
+// The token is injected in the clone URL, which git echoes back in its errors
+func redactToken(output, token string) string {
+	if token != "" {
+		output = strings.ReplaceAll(output, token, "***")
+	}
+	return urlCredentials.ReplaceAllString(output, "https://***@")
+}
+
 // GetRemoteHeadHash fetches the latest commit hash from the remote repository for a given branch
-func GetRemoteHeadHash(repoURL, branch, token string) (string, error) {
+// The ref may also be a tag, a branch of the same name wins; it fails with ErrRefNotFound when neither exists
+func GetRemoteHeadHash(repoURL, branch, token string, opts CloneOptions) (string, error) {
 	if token != "" {
 		repoURL = injectToken(repoURL, token)
 	}
 
-	cmd := exec.Command("git", "ls-remote", repoURL, branch)
+	// Full refs, ls-remote would otherwise also match feature/main for main
+	branch = NormalizeRef(branch)
+	cmd := exec.Command("git", "ls-remote", repoURL, "refs/heads/"+branch, "refs/tags/"+branch, "refs/tags/"+branch+"^{}")
+	sshEnv, cleanup, err := sshEnvironment(repoURL, opts)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	cmd.Env = append(os.Environ(), sshEnv...)
 	output, err := cmd.Output()
 	if err != nil {
 		return "", fmt.Errorf("failed to get remote head hash: %w", err)
 	}
 
-	// Output format: <hash>\trefs/heads/<branch>\n
-	parts := strings.Fields(string(output))
-	if len(parts) == 0 {
-		return "", fmt.Errorf("branch %s not found in remote", branch)
+	hash, ok := resolveRemoteRef(string(output), branch)
+	if !ok {
+		return "", fmt.Errorf("%w: no branch or tag %s in the remote", ErrRefNotFound, branch)
 	}
+	return hash, nil
+}
 
-	return parts[0], nil
+// resolveRemoteRef returns the commit of a ref in ls-remote output (<hash>\t<ref> lines): the branch,
+// else the commit an annotated tag points to, else the tag
+func resolveRemoteRef(output, name string) (string, bool) {
+	refs := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			refs[fields[1]] = fields[0]
+		}
+	}
+	for _, ref := range []string{"refs/heads/" + name, "refs/tags/" + name + "^{}", "refs/tags/" + name} {
+		if hash, ok := refs[ref]; ok {
+			return hash, true
+		}
+	}
+	return "", false
 }
 
 // GetLatestCommitHash returns the HEAD commit hash (optional but useful)
@@ -99,4 +369,34 @@ func GetLatestCommitHash(repoPath string) (string, error) {
 		return "", err
 	}
 	return strings.TrimSpace(string(output)), nil
-}
\ No newline at end of file
+}
+
+// GetCommitMessage returns the full message of a commit of the repository
+func GetCommitMessage(repoPath, hash string) (string, error) {
+	output, err := commitField(repoPath, hash, "%B")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// GetCommitAuthor returns the name and email of the author of a commit of the repository
+func GetCommitAuthor(repoPath, hash string) (string, string, error) {
+	output, err := commitField(repoPath, hash, "%an%x00%ae")
+	if err != nil {
+		return "", "", err
+	}
+	name, email, _ := strings.Cut(strings.TrimSpace(output), "\x00")
+	return name, email, nil
+}
+
+// commitField formats a commit of the repository with a git log format
+func commitField(repoPath, hash, format string) (string, error) {
+	cmd := exec.Command("git", "log", "-1", "--format="+format, hash, "--")
+	cmd.Dir = repoPath
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git log %s failed: %w", hash, err)
+	}
+	return string(output), nil
+}
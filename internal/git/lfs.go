@@ -0,0 +1,52 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// ErrLFSNotInstalled is returned when a project enables LFS on a runner without git-lfs
+var ErrLFSNotInstalled = errors.New("git-lfs is not installed on the runner")
+
+// gitRunner runs a git command in dir with the given environment and returns its combined output
+type gitRunner func(dir string, env []string, args ...string) ([]byte, error)
+
+// runGitCommand is the gitRunner executing git
+func runGitCommand(dir string, env []string, args ...string) ([]byte, error) {
+	return contextRunner(context.Background())(dir, env, args...)
+}
+
+// contextRunner returns the gitRunner executing git until ctx is done, git and its helpers are then killed
+func contextRunner(ctx context.Context) gitRunner {
+	return func(dir string, env []string, args ...string) ([]byte, error) {
+		return gitCommand(ctx, dir, env, args...).CombinedOutput()
+	}
+}
+
+// gitCommand returns a git command killed along with its process group once ctx is done
+func gitCommand(ctx context.Context, dir string, env []string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	setProcessGroup(cmd)
+	// Helpers that escaped the kill may keep the output open
+	cmd.WaitDelay = killWaitDelay
+	return cmd
+}
+
+// fetchLFS installs the LFS hooks in the repository and pulls the LFS files of the checked out commit
+// The origin remote keeps the token injected at clone time, so LFS uses the same auth
+func fetchLFS(repoPath string, env []string, run gitRunner) error {
+	if _, err := run(repoPath, env, "lfs", "version"); err != nil {
+		return ErrLFSNotInstalled
+	}
+
+	for _, args := range [][]string{{"lfs", "install", "--local"}, {"lfs", "pull"}} {
+		if output, err := run(repoPath, env, args...); err != nil {
+			return fmt.Errorf("git %s failed: %s - %w", args[1], redactToken(string(output), ""), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package git
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFetchLFS(t *testing.T) {
+	var commands []string
+	run := func(dir string, env []string, args ...string) ([]byte, error) {
+		if dir != "/workspace" {
+			t.Errorf("Expected commands to run in the repository, got %s", dir)
+		}
+		commands = append(commands, strings.Join(args, " "))
+		return nil, nil
+	}
+
+	if err := fetchLFS("/workspace", nil, run); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []string{"lfs version", "lfs install --local", "lfs pull"}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Expected commands %v, got %v", expected, commands)
+	}
+}
+
+func TestFetchLFSNotInstalled(t *testing.T) {
+	run := func(dir string, env []string, args ...string) ([]byte, error) {
+		if args[1] != "version" {
+			t.Errorf("Unexpected command %v without git-lfs", args)
+		}
+		return []byte("git: 'lfs' is not a git command"), errors.New("exit status 1")
+	}
+
+	if err := fetchLFS("/workspace", nil, run); !errors.Is(err, ErrLFSNotInstalled) {
+		t.Errorf("Expected ErrLFSNotInstalled, got %v", err)
+	}
+}
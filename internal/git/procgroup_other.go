@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package git
+
+import "os/exec"
+
+// setProcessGroup is not supported on this platform, only git itself is killed
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the command, its helpers are left to exit on their own
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,118 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeRef(t *testing.T) {
+	tests := map[string]string{
+		"main":                       "main",
+		"refs/heads/main":            "main",
+		"refs/heads/feature/foo/bar": "feature/foo/bar",
+		"refs/tags/v1.2.0":           "v1.2.0",
+		"feature/foo":                "feature/foo",
+		" refs/heads/main\n":         "main",
+	}
+	for ref, want := range tests {
+		if got := NormalizeRef(ref); got != want {
+			t.Errorf("NormalizeRef(%q) = %q, want %q", ref, got, want)
+		}
+	}
+}
+
+func TestResolveRemoteRef(t *testing.T) {
+	output := "1111\trefs/heads/release/v1\n" +
+		"2222\trefs/tags/v1\n" +
+		"3333\trefs/tags/v1^{}\n" +
+		"4444\trefs/heads/v2\n" +
+		"5555\trefs/tags/v2\n"
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"release/v1", "1111"},
+		{"v1", "3333"}, // the commit of the annotated tag
+		{"v2", "4444"}, // a branch wins over a tag of the same name
+	}
+	for _, tt := range tests {
+		if hash, ok := resolveRemoteRef(output, tt.name); !ok || hash != tt.hash {
+			t.Errorf("resolveRemoteRef(%q) = %q, want %q", tt.name, hash, tt.hash)
+		}
+	}
+	// The tail of a slashed branch is not a match
+	if hash, ok := resolveRemoteRef(output, "release"); ok {
+		t.Errorf("Expected no ref for a partial name, got %q", hash)
+	}
+}
+
+func TestCloneRefs(t *testing.T) {
+	repo, first := initRepo(t)
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %s", args, output)
+		}
+	}
+	git("tag", "-a", "v1.0.0", "-m", "release")
+	defaultBranch := currentBranch(t, repo)
+	git("checkout", "-q", "-b", "feature/foo/bar")
+	git("commit", "-q", "--allow-empty", "-m", "feature commit")
+	feature, err := GetLatestCommitHash(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The feature commit is not on the default branch of the remote
+	git("checkout", "-q", defaultBranch)
+
+	clone := func(ref, commit string) (string, error) {
+		dest := filepath.Join(t.TempDir(), "repo")
+		return dest, Clone("file://"+repo, ref, dest, "", commit, CloneOptions{})
+	}
+
+	t.Run("SlashedBranch", func(t *testing.T) {
+		dest, err := clone("refs/heads/feature/foo/bar", feature)
+		if err != nil {
+			t.Fatalf("Expected the slashed branch to be cloned, got %v", err)
+		}
+		if head, _ := GetLatestCommitHash(dest); head != feature {
+			t.Errorf("Expected HEAD at %s, got %s", feature, head)
+		}
+	})
+
+	t.Run("TagRef", func(t *testing.T) {
+		dest, err := clone("refs/tags/v1.0.0", "")
+		if err != nil {
+			t.Fatalf("Expected the tag to be cloned, got %v", err)
+		}
+		if head, _ := GetLatestCommitHash(dest); head != first {
+			t.Errorf("Expected HEAD at the tagged commit %s, got %s", first, head)
+		}
+	})
+
+	t.Run("CommitOnly", func(t *testing.T) {
+		// The branch of the trigger is gone, the commit is still reachable
+		dest, err := clone("deleted-branch", feature)
+		if err != nil {
+			t.Fatalf("Expected the commit to be fetched without its branch, got %v", err)
+		}
+		if head, _ := GetLatestCommitHash(dest); head != feature {
+			t.Errorf("Expected HEAD at %s, got %s", feature, head)
+		}
+	})
+
+	t.Run("MissingRef", func(t *testing.T) {
+		if _, err := clone("no/such/branch", ""); !errors.Is(err, ErrRefNotFound) {
+			t.Errorf("Expected ErrRefNotFound, got %v", err)
+		}
+	})
+}
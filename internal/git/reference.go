@@ -0,0 +1,67 @@
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var invalidReferenceChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// referencePath returns the mirror of a repository in the reference directory
+// The name is derived from the URL without credentials, so that a new token reuses the same mirror
+func referencePath(root, repoURL string) string {
+	key := repoURL
+	if u, err := url.Parse(repoURL); err == nil && u.User != nil {
+		u.User = nil
+		key = u.String()
+	}
+	sum := sha256.Sum256([]byte(key))
+
+	name := strings.TrimSuffix(path.Base(strings.ReplaceAll(key, ":", "/")), ".git")
+	name = invalidReferenceChars.ReplaceAllString(name, "_")
+	return filepath.Join(root, fmt.Sprintf("%s-%s.git", name, hex.EncodeToString(sum[:6])))
+}
+
+// referenceMirror returns the reference mirror of a repository, creating it on first use
+// The mirror is fetched again once older than refresh. It is locked while updated, so that
+// concurrent clones of the same repository fetch it only once
+func referenceMirror(mirror, fetchURL string, env []string, refresh time.Duration, run gitRunner) error {
+	if err := os.MkdirAll(filepath.Dir(mirror), 0755); err != nil {
+		return fmt.Errorf("failed to create reference directory: %w", err)
+	}
+	unlock, err := lockFile(mirror + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// FETCH_HEAD is written by every successful fetch
+	if info, err := os.Stat(filepath.Join(mirror, "FETCH_HEAD")); err == nil && time.Since(info.ModTime()) < refresh {
+		return nil
+	}
+
+	if _, err := os.Stat(mirror); os.IsNotExist(err) {
+		// Objects are never garbage collected, a clone may be reading them while the mirror is fetched
+		for _, args := range [][]string{{"init", "--bare", "--quiet", mirror}, {"--git-dir", mirror, "config", "gc.auto", "0"}} {
+			if output, err := run("", env, args...); err != nil {
+				os.RemoveAll(mirror)
+				return fmt.Errorf("failed to create reference mirror: %s - %w", redactToken(string(output), ""), err)
+			}
+		}
+	}
+
+	// The URL is not stored in the mirror, it may hold a token
+	output, err := run("", env, "--git-dir", mirror, "fetch", "--prune", "--quiet", fetchURL, "+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*")
+	if err != nil {
+		return fmt.Errorf("failed to update reference mirror: %s - %w", redactToken(string(output), ""), err)
+	}
+	return nil
+}
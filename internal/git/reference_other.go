@@ -0,0 +1,16 @@
+//go:build !unix || solaris || aix
+
+package git
+
+import "sync"
+
+// referenceLocks serializes the updates of a mirror within this process
+var referenceLocks sync.Map
+
+// lockFile takes an exclusive lock on path, flock is not available on this platform
+// so the lock is only shared by the clones of this process
+func lockFile(path string) (func(), error) {
+	mu, _ := referenceLocks.LoadOrStore(path, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock, nil
+}
@@ -0,0 +1,71 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCloneArgsReference(t *testing.T) {
+	args := cloneArgs("https://example.com/repo.git", "main", "/ws", "/mirrors/repo.git", false, 1)
+	expected := []string{"clone", "--depth", "1", "--reference", "/mirrors/repo.git", "--dissociate", "--branch", "main", "https://example.com/repo.git", "/ws"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %v, got %v", expected, args)
+	}
+
+	args = cloneArgs("https://example.com/repo.git", "main", "/ws", "", false, 1)
+	expected = []string{"clone", "--depth", "1", "--branch", "main", "https://example.com/repo.git", "/ws"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %v without mirror, got %v", expected, args)
+	}
+}
+
+func TestReferencePath(t *testing.T) {
+	withToken := referencePath("/mirrors", "https://s3cr3t@github.com/user/app.git")
+	withoutToken := referencePath("/mirrors", "https://github.com/user/app.git")
+	if withToken != withoutToken {
+		t.Errorf("Expected the token not to change the mirror, got %s and %s", withToken, withoutToken)
+	}
+	if filepath.Dir(withoutToken) != "/mirrors" || filepath.Ext(withoutToken) != ".git" {
+		t.Errorf("Unexpected mirror path %s", withoutToken)
+	}
+	if other := referencePath("/mirrors", "https://gitlab.com/user/app.git"); other == withoutToken {
+		t.Error("Expected repositories with the same name to get distinct mirrors")
+	}
+}
+
+func TestCloneWithReference(t *testing.T) {
+	repo, hash := initRepo(t)
+	opts := CloneOptions{ReferenceDir: t.TempDir(), ReferenceRefresh: time.Hour}
+	branch := currentBranch(t, repo)
+
+	dest := filepath.Join(t.TempDir(), "repo")
+	if err := Clone(repo, branch, dest, "", hash, opts); err != nil {
+		t.Fatalf("Expected clone to succeed, got %v", err)
+	}
+
+	mirror := referencePath(opts.ReferenceDir, repo)
+	if _, err := os.Stat(filepath.Join(mirror, "FETCH_HEAD")); err != nil {
+		t.Fatalf("Expected the mirror to be created, got %v", err)
+	}
+	// --dissociate leaves no link to the mirror in the workspace
+	if _, err := os.Stat(filepath.Join(dest, ".git", "objects", "info", "alternates")); !os.IsNotExist(err) {
+		t.Errorf("Expected the clone not to depend on the mirror, got %v", err)
+	}
+
+	// A second clone reuses the existing mirror
+	if err := Clone(repo, branch, filepath.Join(t.TempDir(), "repo"), "", hash, opts); err != nil {
+		t.Errorf("Expected clone with an existing mirror to succeed, got %v", err)
+	}
+}
+
+func currentBranch(t *testing.T, repo string) string {
+	t.Helper()
+	output, err := runGitCommand(repo, os.Environ(), "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		t.Fatalf("Failed to get branch: %s", output)
+	}
+	return string(output[:len(output)-1])
+}
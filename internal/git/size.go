@@ -0,0 +1,102 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRepositoryTooLarge is returned when a clone exceeds CloneOptions.MaxSize
+var ErrRepositoryTooLarge = errors.New("repository too large")
+
+// sizeCheckInterval is how often the destination of a clone is measured while it runs
+const sizeCheckInterval = time.Second
+
+// killWaitDelay bounds the wait for the output of a killed clone
+const killWaitDelay = 2 * time.Second
+
+// DirSize returns the total size in bytes of the regular files under dir
+func DirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Files may disappear or be renamed while git writes them
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// checkSize fails with ErrRepositoryTooLarge if dir holds more than maxSize bytes
+func checkSize(dir string, maxSize int64) error {
+	if maxSize <= 0 {
+		return nil
+	}
+	size, err := DirSize(dir)
+	if err != nil {
+		return fmt.Errorf("failed to measure repository size: %w", err)
+	}
+	if size > maxSize {
+		return fmt.Errorf("%w: %d bytes exceed the limit of %d bytes", ErrRepositoryTooLarge, size, maxSize)
+	}
+	return nil
+}
+
+// runWithSizeLimit runs cmd and kills it as soon as dir holds more than maxSize bytes
+// It returns the combined output of the command
+func runWithSizeLimit(cmd *exec.Cmd, dir string, maxSize int64, interval time.Duration) ([]byte, error) {
+	if maxSize <= 0 {
+		return cmd.CombinedOutput()
+	}
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// Helpers spawned by git may keep the output open after the clone is killed
+	cmd.WaitDelay = killWaitDelay
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	var sizeErr atomic.Value
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := checkSize(dir, maxSize); errors.Is(err, ErrRepositoryTooLarge) {
+					sizeErr.Store(err)
+					killProcessGroup(cmd)
+					return
+				}
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	close(done)
+	if tooLarge, ok := sizeErr.Load().(error); ok {
+		return output.Bytes(), tooLarge
+	}
+	return output.Bytes(), err
+}
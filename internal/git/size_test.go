@@ -0,0 +1,59 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckSize(t *testing.T) {
+	// A fake repository holding 2 KiB of content
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "assets"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"main.go", "assets/video.bin"} {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, 1024), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if size, err := DirSize(dir); err != nil || size != 2048 {
+		t.Errorf("Expected size 2048, got %d (err: %v)", size, err)
+	}
+
+	err := checkSize(dir, 1024)
+	if !errors.Is(err, ErrRepositoryTooLarge) {
+		t.Errorf("Expected ErrRepositoryTooLarge, got %v", err)
+	}
+	if err := checkSize(dir, 4096); err != nil {
+		t.Errorf("Expected no error under the limit, got %v", err)
+	}
+	if err := checkSize(dir, 0); err != nil {
+		t.Errorf("Expected no limit with a zero size, got %v", err)
+	}
+}
+
+func TestRunWithSizeLimitAbortsOversizedClone(t *testing.T) {
+	shell, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+
+	// Simulates a clone writing 64 KiB then hanging
+	dir := t.TempDir()
+	cmd := exec.Command(shell, "-c", "head -c 65536 /dev/zero > pack.bin && exec sleep 10")
+	cmd.Dir = dir
+
+	start := time.Now()
+	_, err = runWithSizeLimit(cmd, dir, 1024, 10*time.Millisecond)
+	if !errors.Is(err, ErrRepositoryTooLarge) {
+		t.Fatalf("Expected ErrRepositoryTooLarge, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the clone to be killed early, took %s", elapsed)
+	}
+}
@@ -0,0 +1,143 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SSH host key checking modes (GIT_SSH_HOST_KEY_CHECKING)
+const (
+	// HostKeyAcceptNew records the key of a host seen for the first time and rejects a host whose key changed
+	HostKeyAcceptNew = "accept-new"
+	// HostKeyStrict only accepts the hosts already in the known_hosts file
+	HostKeyStrict = "yes"
+	// HostKeyOff does not check host keys, an explicit opt-in
+	HostKeyOff = "no"
+)
+
+// isSSHURL reports whether a remote is reached over SSH (ssh://host/repo or the scp-like git@host:repo)
+func isSSHURL(repoURL string) bool {
+	if strings.HasPrefix(repoURL, "ssh://") {
+		return true
+	}
+	if strings.Contains(repoURL, "://") {
+		return false
+	}
+	at := strings.Index(repoURL, "@")
+	return at > 0 && strings.Index(repoURL[at:], ":") > 0
+}
+
+// sshEnvironment returns the environment making git use the SSH key of the options for SSH remotes
+// Key material is written to a temporary file, removed by the returned cleanup function
+// A key path is only read under SSHKeyDir, a project must not make the server use any file of its host
+func sshEnvironment(repoURL string, opts CloneOptions) ([]string, func(), error) {
+	noop := func() {}
+	if opts.SSHKey == "" || !isSSHURL(repoURL) {
+		return nil, noop, nil
+	}
+
+	keyPath := opts.SSHKey
+	cleanup := noop
+	if !strings.Contains(opts.SSHKey, "PRIVATE KEY") {
+		resolved, err := sshKeyPath(opts.SSHKey, opts.SSHKeyDir)
+		if err != nil {
+			return nil, noop, err
+		}
+		keyPath = resolved
+	} else {
+		file, err := os.CreateTemp("", "cicd-ssh-key-*")
+		if err != nil {
+			return nil, noop, fmt.Errorf("failed to write ssh key: %w", err)
+		}
+		keyPath = file.Name()
+		cleanup = func() { os.Remove(keyPath) }
+
+		// ssh requires a trailing newline and refuses keys readable by others
+		key := strings.TrimSpace(opts.SSHKey) + "\n"
+		_, err = file.WriteString(key)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Chmod(keyPath, 0600)
+		}
+		if err != nil {
+			cleanup()
+			return nil, noop, fmt.Errorf("failed to write ssh key: %w", err)
+		}
+	}
+
+	checking := hostKeyChecking(opts.HostKeyChecking)
+	if checking != HostKeyOff && opts.KnownHostsFile == "" {
+		cleanup()
+		return nil, noop, fmt.Errorf("no known_hosts file to check the host key of %s, set GIT_SSH_KNOWN_HOSTS", repoURL)
+	}
+	if checking == HostKeyAcceptNew {
+		// ssh adds the hosts it accepts to the file, its directory must exist
+		if err := os.MkdirAll(filepath.Dir(opts.KnownHostsFile), 0700); err != nil {
+			cleanup()
+			return nil, noop, fmt.Errorf("failed to create known_hosts directory: %w", err)
+		}
+	}
+
+	return []string{"GIT_SSH_COMMAND=" + sshCommand(keyPath, opts.KnownHostsFile, checking)}, cleanup, nil
+}
+
+// DefaultKnownHostsFile is the known_hosts file of the user running the server, it persists across runs
+func DefaultKnownHostsFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.TempDir()
+	}
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// sshKeyPath resolves a key path of a project, it must be a file under keyDir once symlinks are followed
+func sshKeyPath(keyPath, keyDir string) (string, error) {
+	if keyDir == "" {
+		return "", fmt.Errorf("ssh key paths are disabled, set the key itself or GIT_SSH_KEY_DIR")
+	}
+	dir, err := filepath.EvalSymlinks(keyDir)
+	if err != nil {
+		return "", fmt.Errorf("invalid GIT_SSH_KEY_DIR: %w", err)
+	}
+	if !filepath.IsAbs(keyPath) {
+		keyPath = filepath.Join(dir, keyPath)
+	}
+	resolved, err := filepath.EvalSymlinks(keyPath)
+	if err != nil {
+		return "", fmt.Errorf("ssh key %s not found", keyPath)
+	}
+	if rel, err := filepath.Rel(dir, resolved); err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("ssh key %s is outside of GIT_SSH_KEY_DIR", keyPath)
+	}
+	return resolved, nil
+}
+
+// hostKeyChecking returns the checking mode to use, unknown modes check with HostKeyAcceptNew
+func hostKeyChecking(mode string) string {
+	switch mode {
+	case HostKeyStrict, HostKeyOff:
+		return mode
+	default:
+		return HostKeyAcceptNew
+	}
+}
+
+// sshCommand builds the ssh command git runs to reach the remote with the given key
+func sshCommand(keyPath, knownHostsFile, checking string) string {
+	args := []string{"ssh", "-i", shellQuote(keyPath), "-o", "IdentitiesOnly=yes", "-o", "BatchMode=yes"}
+	if checking == HostKeyOff {
+		args = append(args, "-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null")
+	} else {
+		args = append(args, "-o", "StrictHostKeyChecking="+checking, "-o", "UserKnownHostsFile="+shellQuote(knownHostsFile))
+	}
+	return strings.Join(args, " ")
+}
+
+// shellQuote quotes a value for the shell git runs GIT_SSH_COMMAND with
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
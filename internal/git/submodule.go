@@ -0,0 +1,35 @@
+package git
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// updateSubmodules checks out the submodules recorded by the current commit, recursively
+// A clone with --recurse-submodules only covers the commit of the branch head, not a commit checked out afterwards
+func updateSubmodules(repoPath, token string, env []string, run gitRunner) error {
+	if output, err := run(repoPath, env, "submodule", "update", "--init", "--recursive"); err != nil {
+		return fmt.Errorf("git submodule update failed: %s - %w", redactToken(string(output), token), err)
+	}
+	return nil
+}
+
+// submoduleAuthEnv returns the environment giving the token to the submodules hosted with the repository
+// .gitmodules holds URLs without credentials, git rewrites them to embed the token (url.<base>.insteadOf)
+// Submodules over SSH use the SSH key of the clone through GIT_SSH_COMMAND
+func submoduleAuthEnv(repoURL, token string) []string {
+	if token == "" || !strings.HasPrefix(repoURL, "https://") {
+		return nil
+	}
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	base := "https://" + u.Host + "/"
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=url." + injectToken(base, token) + ".insteadOf",
+		"GIT_CONFIG_VALUE_0=" + base,
+	}
+}
@@ -0,0 +1,58 @@
+package git
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCloneArgsSubmodules(t *testing.T) {
+	args := cloneArgs("https://example.com/repo.git", "main", "/ws", "", true, 1)
+	expected := []string{"clone", "--depth", "1", "--recurse-submodules", "--branch", "main", "https://example.com/repo.git", "/ws"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %v, got %v", expected, args)
+	}
+}
+
+func TestUpdateSubmodules(t *testing.T) {
+	var commands []string
+	run := func(dir string, env []string, args ...string) ([]byte, error) {
+		commands = append(commands, strings.Join(args, " "))
+		return nil, nil
+	}
+	if err := updateSubmodules("/workspace", "", nil, run); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := []string{"submodule update --init --recursive"}; !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Expected commands %v, got %v", expected, commands)
+	}
+
+	failing := func(dir string, env []string, args ...string) ([]byte, error) {
+		return []byte("fatal: could not read from https://s3cr3t@github.com/user/lib.git"), errors.New("exit status 128")
+	}
+	err := updateSubmodules("/workspace", "s3cr3t", nil, failing)
+	if err == nil || strings.Contains(err.Error(), "s3cr3t") {
+		t.Errorf("Expected a redacted error, got %v", err)
+	}
+}
+
+func TestSubmoduleAuthEnv(t *testing.T) {
+	env := submoduleAuthEnv("https://github.com/user/app.git", "s3cr3t")
+	expected := []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=url.https://s3cr3t@github.com/.insteadOf",
+		"GIT_CONFIG_VALUE_0=https://github.com/",
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("Expected %v, got %v", expected, env)
+	}
+
+	// Without token, or over SSH, nothing is rewritten
+	if env := submoduleAuthEnv("https://github.com/user/app.git", ""); env != nil {
+		t.Errorf("Expected no environment without token, got %v", env)
+	}
+	if env := submoduleAuthEnv("git@github.com:user/app.git", "s3cr3t"); env != nil {
+		t.Errorf("Expected no environment for an SSH remote, got %v", env)
+	}
+}
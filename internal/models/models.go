@@ -18,38 +18,71 @@ type Variable struct {
 	Key       string    `json:"key"`
 	Value     string    `json:"value"`
 	IsSecret  bool      `json:"is_secret"`
+	Masked    bool      `json:"masked"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
 type Project struct {
-	ID        int       `json:"id"`
-	OwnerID   int       `json:"owner_id"`
-	Name      string    `json:"name"`
-	RepoURL            string    `json:"repo_url"`
-	AccessToken        string    `json:"access_token"`
-	PipelineFilename   string    `json:"pipeline_filename"`
-	DeploymentFilename string    `json:"deployment_filename"`
-	SSHHost            string    `json:"ssh_host"`
-	SSHUser            string    `json:"ssh_user"`
-	SSHPrivateKey      string    `json:"ssh_private_key"`
-	RegistryUser       string    `json:"registry_user"`
-	RegistryToken   string    `json:"registry_token"`
-	Variables       []Variable `json:"variables,omitempty"`
-	CreatedAt       time.Time  `json:"created_at"`
+	ID                 int        `json:"id"`
+	OwnerID            int        `json:"owner_id"`
+	Name               string     `json:"name"`
+	RepoURL            string     `json:"repo_url"`
+	AccessToken        string     `json:"access_token"`
+	PipelineFilename   string     `json:"pipeline_filename"`
+	DeploymentFilename string     `json:"deployment_filename"`
+	SSHHost            string     `json:"ssh_host"`
+	SSHUser            string     `json:"ssh_user"`
+	SSHPrivateKey      string     `json:"ssh_private_key"`
+	RegistryUser       string     `json:"registry_user"`
+	RegistryToken      string     `json:"registry_token"`
+	ComposeProfiles    []string   `json:"compose_profiles"`
+	Paused             bool       `json:"paused"`
+	DeployTags         []string   `json:"deploy_tags"`
+	PostCloneCommand   string     `json:"post_clone_command"`
+	CloneSSHKey        string     `json:"clone_ssh_key"`
+	GitLFS             bool       `json:"git_lfs"`
+	GitSubmodules      bool       `json:"git_submodules"`
+	RequireDeployment  bool       `json:"require_deployment"`
+	WebhookSecret      string     `json:"webhook_secret"`
+	SecretScan         string     `json:"secret_scan"`
+	DeploymentStacks   []string   `json:"deployment_stacks"`
+	HealthCheckURL     string     `json:"health_check_url"`
+	SlackWebhookURL    string     `json:"slack_webhook_url"`
+	NotifyWebhookURL   string     `json:"notify_webhook_url"`
+	ScheduleCron       string     `json:"schedule_cron"`
+	ScheduleBranch     string     `json:"schedule_branch"`
+	ScheduleLastRun    *time.Time `json:"schedule_last_run,omitempty"` // Time of the last scheduled run, set by the scheduler
+	Variables          []Variable `json:"variables,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
 }
 
 type NewProject struct {
-	OwnerID            int    `json:"owner_id"`
-	Name               string `json:"name"`
-	RepoURL            string `json:"repo_url"`
-	AccessToken        string `json:"access_token"`
-	PipelineFilename   string `json:"pipeline_filename"`
-	DeploymentFilename string `json:"deployment_filename"`
-	SSHHost            string `json:"ssh_host"`
-	SSHUser            string `json:"ssh_user"`
-	SSHPrivateKey      string `json:"ssh_private_key"`
-	RegistryUser       string `json:"registry_user"`
-	RegistryToken   string `json:"registry_token"`
+	OwnerID            int      `json:"owner_id"`
+	Name               string   `json:"name"`
+	RepoURL            string   `json:"repo_url"`
+	AccessToken        string   `json:"access_token"`
+	PipelineFilename   string   `json:"pipeline_filename"`
+	DeploymentFilename string   `json:"deployment_filename"`
+	SSHHost            string   `json:"ssh_host"`
+	SSHUser            string   `json:"ssh_user"`
+	SSHPrivateKey      string   `json:"ssh_private_key"`
+	RegistryUser       string   `json:"registry_user"`
+	RegistryToken      string   `json:"registry_token"`
+	ComposeProfiles    []string `json:"compose_profiles"`
+	DeployTags         []string `json:"deploy_tags"`
+	PostCloneCommand   string   `json:"post_clone_command"`
+	CloneSSHKey        string   `json:"clone_ssh_key"`
+	GitLFS             bool     `json:"git_lfs"`
+	GitSubmodules      bool     `json:"git_submodules"`
+	RequireDeployment  bool     `json:"require_deployment"`
+	WebhookSecret      string   `json:"webhook_secret"`
+	SecretScan         string   `json:"secret_scan"`
+	DeploymentStacks   []string `json:"deployment_stacks"`
+	HealthCheckURL     string   `json:"health_check_url"`
+	SlackWebhookURL    string   `json:"slack_webhook_url"`
+	NotifyWebhookURL   string   `json:"notify_webhook_url"`
+	ScheduleCron       string   `json:"schedule_cron"`
+	ScheduleBranch     string   `json:"schedule_branch"`
 }
 
 type ProjectMember struct {
@@ -61,40 +94,104 @@ type ProjectMember struct {
 }
 
 type Pipeline struct {
-	ID         int        `json:"id"`
-	ProjectID  int        `json:"project_id"`
-	Status     string     `json:"status"`
-	CommitHash string     `json:"commit_hash,omitempty"`
-	Branch     string     `json:"branch,omitempty"`
-	CreatedAt  time.Time  `json:"created_at"`
-	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ID         int           `json:"id"`
+	ProjectID  int           `json:"project_id"`
+	Status     string        `json:"status"`
+	CommitHash string        `json:"commit_hash,omitempty"`
+	Branch     string        `json:"branch,omitempty"`
+	Labels     []string      `json:"labels,omitempty"`
+	Coverage   *float64      `json:"coverage,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	StartedAt  *time.Time    `json:"started_at,omitempty"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+	Duration   *float64      `json:"duration_seconds,omitempty"` // From started_at to finished_at
+	Stages     []StageTiming `json:"stages,omitempty"`           // Timing of the stages, only for a single pipeline
+	Jobs       []Job         `json:"jobs,omitempty"`             // Jobs with their status and exit code, only for a single pipeline
+	// Gate the pipeline waits at with the waiting_for_approval status, a stage or "deployment"
+	ApprovalStage    string     `json:"approval_stage,omitempty"`
+	ApprovalDeadline *time.Time `json:"approval_deadline,omitempty"` // The gate is rejected past this time
+	// Commit that triggered the pipeline, from the webhook payload or read from the clone
+	CommitMessage     string `json:"commit_message,omitempty"`
+	CommitAuthorName  string `json:"commit_author_name,omitempty"`
+	CommitAuthorEmail string `json:"commit_author_email,omitempty"`
+	CloneLog          string `json:"-"` // Output of the clone, shown in the pipeline log
+	Tag               string `json:"-"` // Tag the pipeline runs, empty for branch pipelines
+	// CI config given with a manual trigger, kept so that resumed runs use it too
+	InlineConfig string `json:"-"`
+	InlineDeploy bool   `json:"-"` // The inline config pipeline deploys, only when requested
+	// Files changed by the pushed commits, kept so that a resumed run only redeploys the affected services
+	ChangedFiles []string `json:"-"`
 }
 
-type Job struct {
-	ID         int        `json:"id"`
-	PipelineID int        `json:"pipeline_id"`
+// StageTiming spans the jobs of a stage, from the first start to the last finish
+type StageTiming struct {
 	Name       string     `json:"name"`
-	Stage      string     `json:"stage"`
-	Image      string     `json:"image"`
-	Status     string     `json:"status"`
-	ExitCode   int        `json:"exit_code"`
 	StartedAt  *time.Time `json:"started_at,omitempty"`
 	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Duration   *float64   `json:"duration_seconds,omitempty"`
+}
+
+// DurationSeconds returns the seconds between two timestamps, nil unless both are set
+func DurationSeconds(start, end *time.Time) *float64 {
+	if start == nil || end == nil {
+		return nil
+	}
+	seconds := end.Sub(*start).Seconds()
+	return &seconds
+}
+
+// PipelineFilter narrows down the pipelines returned by a listing
+type PipelineFilter struct {
+	Label  string
+	Status string
+	Branch string
+	Limit  int // 0 returns every pipeline
+	Offset int
+}
+
+type Job struct {
+	ID           int        `json:"id"`
+	PipelineID   int        `json:"pipeline_id"`
+	Name         string     `json:"name"`
+	Stage        string     `json:"stage"`
+	Image        string     `json:"image"`
+	Status       string     `json:"status"`
+	ExitCode     int        `json:"exit_code"`
+	Coverage     *float64   `json:"coverage,omitempty"`
+	Executor     string     `json:"executor"`
+	AllowFailure bool       `json:"allow_failure"`          // The failure of the job does not fail the pipeline
+	Attempt      int        `json:"attempt"`                // Run of the job, above 1 when it was retried
+	ImageDigest  string     `json:"image_digest,omitempty"` // Digest of the image pulled for the job
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	Duration     *float64   `json:"duration_seconds,omitempty"`
 }
 
+// Output streams of a job log line
+const (
+	LogStreamStdout = "stdout"
+	LogStreamStderr = "stderr"
+)
+
 type LogLine struct {
-	ID        int       `json:"id"`
-	JobID     int       `json:"job_id"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         int       `json:"id"`
+	JobID      int       `json:"job_id"`
+	LineNumber int       `json:"line_number"`
+	Stream     string    `json:"stream"`
+	Content    string    `json:"content"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 type Deployment struct {
-	ID         int        `json:"id"`
-	PipelineID int        `json:"pipeline_id"`
-	Status     string     `json:"status"`
-	StartedAt  *time.Time `json:"started_at,omitempty"`
-	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ID         int          `json:"id"`
+	PipelineID int          `json:"pipeline_id"`
+	Status     string       `json:"status"`
+	StartedAt  *time.Time   `json:"started_at,omitempty"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	Duration   *float64     `json:"duration_seconds,omitempty"`
+	ExitCode   *int         `json:"exit_code,omitempty"` // Exit code of the compose command, nil if it did not run
+	Stack      string       `json:"stack,omitempty"`     // Compose file of a stack, empty for the deployment of the whole pipeline
+	Stacks     []Deployment `json:"stacks,omitempty"`    // Deployments of the stacks, in the order they ran
 }
 
 type DeploymentLog struct {
@@ -110,17 +207,29 @@ type PipelineRunParams struct {
 	RepoName           string
 	Branch             string
 	CommitHash         string
+	CommitMessage      string // Message of the commit, empty until known from the webhook payload or the clone
+	CommitAuthorName   string
+	CommitAuthorEmail  string
 	AccessToken        string
+	SSHKey             string // Key (path or material) used to clone SSH remotes
+	GitLFS             bool   // Fetch the Git LFS files after the checkout
+	Submodules         bool   // Clone the submodules of the repository
 	PipelineFilename   string
 	DeploymentFilename string
 	SSHHost            string
 	SSHUser            string
 	SSHPrivateKey      string
 	RegistryUser       string
-	RegistryToken   string
-	Variables       []Variable
+	RegistryToken      string
+	Variables          []Variable
 	ProjectID          int
 	PipelineID         int
+	ChangedFiles       []string // Files changed by the pushed commits, nil when unknown
+	Tag                string   // Tag that triggered the pipeline, empty for branch pipelines
+	InlineConfig       string   // CI config given with a manual trigger, replaces the committed one
+	InlineDeploy       bool     // Deploy a pipeline run with an InlineConfig, skipped otherwise
+	DeploymentStacks   []string // Compose files deployed one after the other, replace DeploymentFilename
+	DeploymentStack    string   // Name of the stack being deployed, suffixes its compose project
 }
 
 // PushEvent represents a GitHub push webhook payload
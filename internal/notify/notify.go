@@ -0,0 +1,137 @@
+// Package notify sends the result of finished pipelines to external services
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/safehttp"
+)
+
+// Summary is the result of a finished pipeline, as sent to notifiers
+type Summary struct {
+	ProjectID  int      `json:"project_id"`
+	Project    string   `json:"project"`
+	PipelineID int      `json:"pipeline_id"`
+	Branch     string   `json:"branch"`
+	Commit     string   `json:"commit"`
+	Status     string   `json:"status"`
+	Duration   float64  `json:"duration_seconds"`
+	FailedJobs []string `json:"failed_jobs"`
+}
+
+// Notifier sends the summary of a finished pipeline somewhere
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, summary Summary) error
+}
+
+// Retry settings of the HTTP notifiers, a failed POST is retried with an exponential backoff
+var (
+	maxAttempts    = 3
+	initialBackoff = time.Second
+	requestTimeout = 10 * time.Second
+)
+
+// newHTTPClient returns the client of the HTTP notifiers, it only reaches public addresses as the URLs are set by projects
+var newHTTPClient = safehttp.NewClient
+
+// errSlackInsecureURL is returned for a Slack webhook URL that is not https
+var errSlackInsecureURL = errors.New("slack webhook URL must use https")
+
+// SlackNotifier posts a message to a Slack incoming webhook
+type SlackNotifier struct {
+	URL string
+}
+
+func (n SlackNotifier) Name() string { return "slack" }
+
+func (n SlackNotifier) Notify(ctx context.Context, summary Summary) error {
+	if u, err := url.Parse(n.URL); err != nil || u.Scheme != "https" {
+		return errSlackInsecureURL
+	}
+	return postJSON(ctx, n.URL, map[string]string{"text": slackText(summary)})
+}
+
+// slackText formats the summary as a one-message Slack text
+func slackText(summary Summary) string {
+	commit := summary.Commit
+	if len(commit) > 8 {
+		commit = commit[:8]
+	}
+	text := fmt.Sprintf("Pipeline #%d of *%s* (%s@%s): *%s* in %s",
+		summary.PipelineID, summary.Project, summary.Branch, commit, summary.Status,
+		(time.Duration(summary.Duration) * time.Second).String())
+	if len(summary.FailedJobs) > 0 {
+		text += "\nFailed jobs: " + strings.Join(summary.FailedJobs, ", ")
+	}
+	return text
+}
+
+// WebhookNotifier posts the summary as JSON to any URL
+type WebhookNotifier struct {
+	URL string
+}
+
+func (n WebhookNotifier) Name() string { return "webhook" }
+
+func (n WebhookNotifier) Notify(ctx context.Context, summary Summary) error {
+	return postJSON(ctx, n.URL, summary)
+}
+
+// postJSON posts payload to url, retrying network errors, 429 and 5xx responses
+func postJSON(ctx context.Context, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	client := newHTTPClient(requestTimeout)
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		retryable, err := post(ctx, client, url, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= maxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends a single request and reports whether a failure is worth retrying
+func post(ctx context.Context, client *http.Client, url string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("invalid notification URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// A URL resolving to a private address is refused for good
+		retryable := ctx.Err() == nil && !errors.Is(err, safehttp.ErrBlockedAddress)
+		return retryable, fmt.Errorf("notification request failed: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retryable, fmt.Errorf("notification rejected with status %d", resp.StatusCode)
+}
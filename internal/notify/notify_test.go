@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/safehttp"
+)
+
+func init() {
+	initialBackoff = time.Millisecond
+	// The test servers listen on loopback
+	newHTTPClient = func(timeout time.Duration) *http.Client { return &http.Client{Timeout: timeout} }
+}
+
+var summary = Summary{
+	ProjectID:  3,
+	Project:    "my-app",
+	PipelineID: 7,
+	Branch:     "main",
+	Commit:     "0123456789abcdef",
+	Status:     "failed",
+	Duration:   95,
+	FailedJobs: []string{"test", "lint"},
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var received Summary
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected a JSON request, got %q", r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	if err := (WebhookNotifier{URL: server.URL}).Notify(context.Background(), summary); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if received.PipelineID != 7 || received.Status != "failed" || len(received.FailedJobs) != 2 {
+		t.Errorf("Unexpected summary received: %+v", received)
+	}
+}
+
+func TestSlackNotifier(t *testing.T) {
+	var message map[string]string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&message)
+	}))
+	defer server.Close()
+	defer func(previous func(time.Duration) *http.Client) { newHTTPClient = previous }(newHTTPClient)
+	newHTTPClient = func(time.Duration) *http.Client { return server.Client() }
+
+	if err := (SlackNotifier{URL: server.URL}).Notify(context.Background(), summary); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	text := message["text"]
+	for _, expected := range []string{"#7", "my-app", "main@01234567", "failed", "1m35s", "Failed jobs: test, lint"} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("Expected %q in the Slack message, got %q", expected, text)
+		}
+	}
+}
+
+func TestPostJSONRetries(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	if err := postJSON(context.Background(), server.URL, summary); err != nil {
+		t.Fatalf("Expected the third attempt to succeed, got %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls.Load())
+	}
+}
+
+func TestPostJSONGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	if err := postJSON(context.Background(), server.URL, summary); err == nil {
+		t.Fatal("Expected an error once the attempts are exhausted")
+	}
+	if calls.Load() != int32(maxAttempts) {
+		t.Errorf("Expected %d attempts, got %d", maxAttempts, calls.Load())
+	}
+}
+
+func TestPostJSONClientError(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	if err := postJSON(context.Background(), server.URL, summary); err == nil {
+		t.Fatal("Expected an error for a 404")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Expected a client error not to be retried, got %d attempts", calls.Load())
+	}
+}
+
+func TestSlackNotifierRequiresHTTPS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected no request to a plain http Slack URL")
+	}))
+	defer server.Close()
+
+	if err := (SlackNotifier{URL: server.URL}).Notify(context.Background(), summary); !errors.Is(err, errSlackInsecureURL) {
+		t.Errorf("Expected the http URL to be rejected, got %v", err)
+	}
+}
+
+func TestPostJSONBlocksPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected the loopback address not to be reached")
+	}))
+	defer server.Close()
+	defer func(previous func(time.Duration) *http.Client) { newHTTPClient = previous }(newHTTPClient)
+	newHTTPClient = safehttp.NewClient
+
+	if err := postJSON(context.Background(), server.URL, summary); !errors.Is(err, safehttp.ErrBlockedAddress) {
+		t.Errorf("Expected the loopback address to be blocked, got %v", err)
+	}
+}
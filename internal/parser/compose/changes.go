@@ -0,0 +1,84 @@
+package compose
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ParseBuildContexts reads a docker-compose file and returns the build context of every buildable service
+// Contexts are relative to the directory of the compose file, as written in the file
+func ParseBuildContexts(composePath string) (map[string]string, error) {
+	data, err := os.ReadFile(composePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compose file: %w", err)
+	}
+
+	var config ComposeConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse compose file: %w", err)
+	}
+
+	contexts := make(map[string]string)
+	for name, serviceBody := range config.Services {
+		serviceMap, ok := serviceBody.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch build := serviceMap["build"].(type) {
+		case string:
+			// build: ./backend
+			contexts[name] = build
+		case map[string]interface{}:
+			// build: {context: ./backend, dockerfile: Dockerfile}
+			context, _ := build["context"].(string)
+			if context == "" {
+				context = "."
+			}
+			contexts[name] = context
+		}
+	}
+	return contexts, nil
+}
+
+// AffectedServices returns the services whose build context contains one of the changed files
+// Paths are relative to the repository root, composeFile included
+// It returns nil, meaning every service, when the changes are unknown, touch the compose file
+// or touch a file outside every build context (env files, mounted configs, ...)
+func AffectedServices(composeFile string, contexts map[string]string, changedFiles []string) []string {
+	if len(changedFiles) == 0 {
+		return nil
+	}
+
+	composeDir := path.Dir(path.Clean(composeFile))
+	affected := make(map[string]bool)
+	for _, file := range changedFiles {
+		file = path.Clean(file)
+		if file == path.Clean(composeFile) {
+			return nil
+		}
+
+		matched := false
+		for service, context := range contexts {
+			dir := path.Join(composeDir, context)
+			if dir == "." || strings.HasPrefix(file, dir+"/") {
+				affected[service] = true
+				matched = true
+			}
+		}
+		if !matched {
+			return nil
+		}
+	}
+
+	services := make([]string, 0, len(affected))
+	for service := range affected {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
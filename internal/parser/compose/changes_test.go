@@ -0,0 +1,68 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseBuildContexts(t *testing.T) {
+	content := `
+services:
+  backend:
+    build: ./backend
+  frontend:
+    build:
+      context: ./frontend
+      dockerfile: Dockerfile.prod
+  database:
+    image: postgres
+`
+	composePath := filepath.Join(t.TempDir(), "docker-compose.yml")
+	if err := os.WriteFile(composePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	contexts, err := ParseBuildContexts(composePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := map[string]string{"backend": "./backend", "frontend": "./frontend"}
+	if !reflect.DeepEqual(contexts, expected) {
+		t.Errorf("Expected contexts %v, got %v", expected, contexts)
+	}
+}
+
+func TestAffectedServices(t *testing.T) {
+	contexts := map[string]string{"backend": "./backend", "frontend": "./frontend", "worker": "./backend/worker"}
+
+	tests := []struct {
+		name     string
+		changed  []string
+		expected []string
+	}{
+		{"SingleService", []string{"frontend/src/App.tsx"}, []string{"frontend"}},
+		{"NestedContexts", []string{"backend/worker/main.go"}, []string{"backend", "worker"}},
+		{"SeveralServices", []string{"backend/go.mod", "frontend/package.json"}, []string{"backend", "frontend"}},
+		{"UnknownChanges", nil, nil},
+		{"ComposeFileChanged", []string{"docker-compose.yml"}, nil},
+		{"FileOutsideContexts", []string{"frontend/index.html", ".env.production"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := AffectedServices("docker-compose.yml", contexts, tt.changed)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected services %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	// A service built from the repository root is affected by every change
+	rootContexts := map[string]string{"app": "."}
+	if got := AffectedServices("docker-compose.yml", rootContexts, []string{"README.md"}); !reflect.DeepEqual(got, []string{"app"}) {
+		t.Errorf("Expected root service to be affected, got %v", got)
+	}
+}
@@ -0,0 +1,120 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// resolveExtends merges the jobs named by `extends:` into the jobs extending them, as in GitLab
+// Mappings (variables, artifacts, ...) are merged recursively, other values of the job win
+// Anchors and merge keys (<<: *base) are resolved by the YAML decoder beforehand
+// The data is returned unchanged when no job extends another one
+func resolveExtends(data []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("erreur lors du décodage YAML : %w", err)
+	}
+
+	lookup := func(name string) (map[string]interface{}, bool) {
+		job, ok := doc[name].(map[string]interface{})
+		return job, ok
+	}
+
+	found := false
+	for _, value := range doc {
+		if job, ok := value.(map[string]interface{}); ok && job["extends"] != nil {
+			found = true
+		}
+	}
+	if !found {
+		return data, nil
+	}
+
+	resolved := make(map[string]map[string]interface{})
+	var resolve func(name string, chain []string) (map[string]interface{}, error)
+	resolve = func(name string, chain []string) (map[string]interface{}, error) {
+		if job, ok := resolved[name]; ok {
+			return job, nil
+		}
+		for _, visited := range chain {
+			if visited == name {
+				return nil, fmt.Errorf("cycle d'extends : %s", strings.Join(append(chain, name), " -> "))
+			}
+		}
+		job, ok := lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("extends inconnu pour le job %s : %s", chain[len(chain)-1], name)
+		}
+
+		parents, err := extendsNames(name, job["extends"])
+		if err != nil {
+			return nil, err
+		}
+		merged := make(map[string]interface{})
+		for _, parent := range parents {
+			base, err := resolve(parent, append(chain, name))
+			if err != nil {
+				return nil, err
+			}
+			merged = mergeMaps(merged, base)
+		}
+		merged = mergeMaps(merged, job)
+		delete(merged, "extends")
+
+		resolved[name] = merged
+		return merged, nil
+	}
+
+	for name, value := range doc {
+		if job, ok := value.(map[string]interface{}); ok && job["extends"] != nil {
+			merged, err := resolve(name, nil)
+			if err != nil {
+				return nil, err
+			}
+			doc[name] = merged
+		}
+	}
+
+	return yaml.Marshal(doc)
+}
+
+// extendsNames returns the jobs named by `extends:`, a name or a list of names
+func extendsNames(job string, value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		names := make([]string, 0, len(v))
+		for _, item := range v {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("extends invalide pour le job %s : %v", job, item)
+			}
+			names = append(names, name)
+		}
+		return names, nil
+	}
+	return nil, fmt.Errorf("extends invalide pour le job %s : %v", job, value)
+}
+
+// mergeMaps returns base overridden by override, nested mappings are merged recursively
+func mergeMaps(base, override map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range override {
+		baseMap, baseIsMap := merged[key].(map[string]interface{})
+		overrideMap, overrideIsMap := value.(map[string]interface{})
+		if baseIsMap && overrideIsMap {
+			merged[key] = mergeMaps(baseMap, overrideMap)
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,76 @@
+package pipeline
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats de configuration de pipeline
+const (
+	FormatGitLab = "gitlab" // .gitlab-ci.yml, le format natif (défaut)
+	FormatGitHub = "github" // workflow GitHub Actions (.github/workflows/*.yml)
+)
+
+// ConfigParser décode une configuration d'un format dans le modèle du pipeline
+type ConfigParser interface {
+	ParseBytes(data []byte) (*PipelineConfig, error)
+}
+
+// GitLabParser décode le format GitLab CI
+type GitLabParser struct{}
+
+func (GitLabParser) ParseBytes(data []byte) (*PipelineConfig, error) {
+	return ParseBytes(data)
+}
+
+// GitHubParser décode les workflows GitHub Actions
+type GitHubParser struct{}
+
+func (GitHubParser) ParseBytes(data []byte) (*PipelineConfig, error) {
+	return ParseGitHubBytes(data)
+}
+
+// ParserFor returns the parser of a format, GitLab when the format is empty
+func ParserFor(format string) (ConfigParser, error) {
+	switch format {
+	case "", FormatGitLab:
+		return GitLabParser{}, nil
+	case FormatGitHub:
+		return GitHubParser{}, nil
+	}
+	return nil, fmt.Errorf("format de configuration inconnu : %s (gitlab ou github)", format)
+}
+
+// DetectFormat returns the format of a config, GitHub for a file of .github/workflows
+// Elsewhere (or for an inline config without path), a config with the `on:` and `jobs:` keys of a workflow is a GitHub one
+func DetectFormat(path string, data []byte) string {
+	if strings.Contains(filepath.ToSlash(path), ".github/workflows/") {
+		return FormatGitHub
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return FormatGitLab
+	}
+	keys := make(map[string]bool)
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		keys[root.Content[i].Value] = true
+	}
+	if keys["on"] && keys["jobs"] {
+		return FormatGitHub
+	}
+	return FormatGitLab
+}
+
+// ParseConfig décode une configuration dans le format détecté depuis son chemin et son contenu
+func ParseConfig(path string, data []byte) (*PipelineConfig, error) {
+	parser, err := ParserFor(DetectFormat(path, data))
+	if err != nil {
+		return nil, err
+	}
+	return parser.ParseBytes(data)
+}
@@ -0,0 +1,683 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnsupported est renvoyée pour une construction GitHub Actions que le serveur ne sait pas exécuter
+// Le workflow est refusé plutôt que d'ignorer une partie de ses steps
+var ErrUnsupported = errors.New("construction GitHub Actions non supportée")
+
+// maxMatrixJobs borne le nombre de jobs d'une matrice, comme GitHub
+const maxMatrixJobs = 256
+
+// githubRunners associe les runners hébergés par GitHub à l'image qui les remplace
+var githubRunners = map[string]string{
+	"ubuntu-latest": "ubuntu:latest",
+	"ubuntu-24.04":  "ubuntu:24.04",
+	"ubuntu-22.04":  "ubuntu:22.04",
+	"ubuntu-20.04":  "ubuntu:20.04",
+}
+
+// githubSetupAction est une action d'installation d'un outil, remplacée par l'image officielle de l'outil
+type githubSetupAction struct {
+	Input string // Entrée `with:` donnant la version, le tag de l'image
+	Image string
+}
+
+// githubSetupActions liste les actions supportées en plus d'actions/checkout (le dépôt est déjà cloné)
+var githubSetupActions = map[string]githubSetupAction{
+	"actions/setup-go":     {Input: "go-version", Image: "golang"},
+	"actions/setup-node":   {Input: "node-version", Image: "node"},
+	"actions/setup-python": {Input: "python-version", Image: "python"},
+}
+
+// Clés supportées à chaque niveau d'un workflow, `on:`, `permissions:` et `name:` n'ont pas d'effet
+// (les déclencheurs sont ceux du projet)
+var (
+	githubWorkflowKeys  = []string{"name", "run-name", "on", "permissions", "env", "defaults", "concurrency", "jobs"}
+	githubJobKeys       = []string{"name", "permissions", "runs-on", "container", "services", "needs", "env", "defaults", "timeout-minutes", "continue-on-error", "strategy", "steps"}
+	githubStepKeys      = []string{"name", "id", "run", "uses", "with", "env", "working-directory", "shell"}
+	githubContainerKeys = []string{"image", "env", "ports"}
+	githubStrategyKeys  = []string{"matrix"} // fail-fast et max-parallel ne sont pas appliqués, ils sont refusés
+	githubDefaultsKeys  = []string{"run"}
+	githubRunKeys       = []string{"shell", "working-directory"}
+)
+
+type githubWorkflow struct {
+	Env         map[string]string `yaml:"env"`
+	Defaults    githubDefaults    `yaml:"defaults"`
+	Concurrency githubConcurrency `yaml:"concurrency"`
+	Jobs        yaml.Node         `yaml:"jobs"`
+}
+
+type githubDefaults struct {
+	Run struct {
+		Shell            string `yaml:"shell"`
+		WorkingDirectory string `yaml:"working-directory"`
+	} `yaml:"run"`
+}
+
+// githubConcurrency s'écrit `concurrency: deploy` ou `concurrency: {group: deploy, cancel-in-progress: true}`
+type githubConcurrency struct {
+	Group            string `yaml:"group"`
+	CancelInProgress bool   `yaml:"cancel-in-progress"`
+}
+
+func (c *githubConcurrency) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&c.Group)
+	}
+	type plain githubConcurrency
+	return value.Decode((*plain)(c))
+}
+
+type githubJob struct {
+	RunsOn          githubStrings              `yaml:"runs-on"`
+	Container       githubContainer            `yaml:"container"`
+	Services        map[string]githubContainer `yaml:"services"`
+	Needs           githubStrings              `yaml:"needs"`
+	Env             map[string]string          `yaml:"env"`
+	Defaults        githubDefaults             `yaml:"defaults"`
+	TimeoutMinutes  int                        `yaml:"timeout-minutes"`
+	ContinueOnError bool                       `yaml:"continue-on-error"`
+	Strategy        struct {
+		Matrix yaml.Node `yaml:"matrix"`
+	} `yaml:"strategy"`
+	Steps []githubStep `yaml:"steps"`
+}
+
+// githubContainer s'écrit `container: node:22` ou `container: {image: node:22, env: {...}}`
+type githubContainer struct {
+	Image string            `yaml:"image"`
+	Env   map[string]string `yaml:"env"`
+}
+
+func (c *githubContainer) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&c.Image)
+	}
+	type plain githubContainer
+	return value.Decode((*plain)(c))
+}
+
+// githubStrings accepte une valeur seule ou une liste (`needs: build` ou `needs: [build, lint]`)
+type githubStrings []string
+
+func (s *githubStrings) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*s = githubStrings{value.Value}
+		return nil
+	}
+	return value.Decode((*[]string)(s))
+}
+
+type githubStep struct {
+	Run              string            `yaml:"run"`
+	Uses             string            `yaml:"uses"`
+	With             map[string]string `yaml:"with"`
+	Env              map[string]string `yaml:"env"`
+	WorkingDirectory string            `yaml:"working-directory"`
+	Shell            string            `yaml:"shell"`
+}
+
+// ParseGitHubBytes décode un workflow GitHub Actions dans le modèle du pipeline
+// Les jobs sont répartis en stages selon leurs `needs:` et chaque combinaison d'une matrice devient un job
+// Une construction qui ne peut pas être exécutée fidèlement renvoie une erreur ErrUnsupported
+func ParseGitHubBytes(data []byte) (*PipelineConfig, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("erreur lors du décodage YAML : %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return &PipelineConfig{Jobs: make(map[string]JobConfig)}, nil
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("le workflow doit être un mapping")
+	}
+	root := doc.Content[0]
+	if err := checkGitHubKeys(root, "du workflow", githubWorkflowKeys); err != nil {
+		return nil, err
+	}
+	if err := checkGitHubDefaults(mappingValue(root, "defaults"), "du workflow"); err != nil {
+		return nil, err
+	}
+
+	var workflow githubWorkflow
+	if err := root.Decode(&workflow); err != nil {
+		return nil, fmt.Errorf("erreur lors du décodage YAML : %w", err)
+	}
+	if workflow.Jobs.Kind != 0 && workflow.Jobs.Kind != yaml.MappingNode && workflow.Jobs.Tag != "!!null" {
+		return nil, fmt.Errorf("`jobs:` du workflow doit être un mapping")
+	}
+
+	// Jobs in declaration order
+	var ids []string
+	jobs := make(map[string]githubJob)
+	for i := 0; i+1 < len(workflow.Jobs.Content); i += 2 {
+		id, node := workflow.Jobs.Content[i].Value, workflow.Jobs.Content[i+1]
+		if err := checkGitHubJob(id, node); err != nil {
+			return nil, err
+		}
+		var job githubJob
+		if err := node.Decode(&job); err != nil {
+			return nil, fmt.Errorf("job %s invalide : %w", id, err)
+		}
+		ids = append(ids, id)
+		jobs[id] = job
+	}
+
+	levels, err := githubLevels(ids, jobs)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &PipelineConfig{Jobs: make(map[string]JobConfig)}
+	depth := 0
+	for _, level := range levels {
+		depth = max(depth, level+1)
+	}
+	for i := 1; i <= depth; i++ {
+		config.Stages = append(config.Stages, fmt.Sprintf("stage-%d", i))
+	}
+
+	variables, exports, err := githubEnv(workflow.Env, nil)
+	if err != nil {
+		return nil, fmt.Errorf("env du workflow : %w", err)
+	}
+	config.Variables = variables
+
+	if workflow.Concurrency.Group != "" {
+		group, err := githubExpand(workflow.Concurrency.Group, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("concurrency du workflow : %w", err)
+		}
+		config.Concurrency.Group = group
+		if workflow.Concurrency.CancelInProgress {
+			config.Concurrency.Policy = ConcurrencyCancelRunning
+		}
+	}
+
+	for _, id := range ids {
+		source := jobs[id]
+		combinations, err := githubMatrix(&source.Strategy.Matrix)
+		if err != nil {
+			return nil, fmt.Errorf("matrice du job %s : %w", id, err)
+		}
+		for _, matrix := range combinations {
+			name := id
+			if len(matrix.values) > 0 {
+				name = fmt.Sprintf("%s (%s)", id, strings.Join(matrix.values, ", "))
+			}
+			job, err := githubJobConfig(source, workflow.Defaults, exports, matrix.vars)
+			if err != nil {
+				return nil, fmt.Errorf("job %s : %w", name, err)
+			}
+			job.Stage = config.Stages[levels[id]]
+			config.Jobs[name] = job
+			config.JobOrder = append(config.JobOrder, name)
+		}
+	}
+
+	if err := checkConfig(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// githubJobConfig converts a job of a workflow for one combination of its matrix
+// exports are the commands setting the workflow variables that refer to other variables
+func githubJobConfig(job githubJob, defaults githubDefaults, exports []string, matrix map[string]string) (JobConfig, error) {
+	var config JobConfig
+	config.AllowFailure = job.ContinueOnError
+	config.Timeout = job.TimeoutMinutes * 60
+	if job.TimeoutMinutes < 0 {
+		config.Timeout = -1
+	}
+
+	image, err := githubImage(job, matrix)
+	if err != nil {
+		return config, err
+	}
+	config.Image = image
+
+	variables, jobExports, err := githubEnv(job.Container.Env, matrix)
+	if err != nil {
+		return config, fmt.Errorf("env du container : %w", err)
+	}
+	env, envExports, err := githubEnv(job.Env, matrix)
+	if err != nil {
+		return config, fmt.Errorf("env : %w", err)
+	}
+	for key, value := range env {
+		if variables == nil {
+			variables = make(map[string]string)
+		}
+		variables[key] = value
+	}
+	config.Variables = variables
+	config.Script = append(append(slices.Clone(exports), jobExports...), envExports...)
+
+	aliases := make([]string, 0, len(job.Services))
+	for alias := range job.Services {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		service := job.Services[alias]
+		name, err := githubExpand(service.Image, matrix, nil)
+		if err != nil {
+			return config, fmt.Errorf("service %s : %w", alias, err)
+		}
+		serviceEnv, serviceExports, err := githubEnv(service.Env, matrix)
+		if err != nil || len(serviceExports) > 0 {
+			return config, fmt.Errorf("%w : variable d'un secret dans l'env du service %s", ErrUnsupported, alias)
+		}
+		config.Services = append(config.Services, ServiceConfig{Name: name, Alias: alias, Variables: serviceEnv})
+	}
+
+	shell := firstNonEmpty(job.Defaults.Run.Shell, defaults.Run.Shell)
+	workingDirectory := firstNonEmpty(job.Defaults.Run.WorkingDirectory, defaults.Run.WorkingDirectory)
+	for i, step := range job.Steps {
+		switch {
+		case step.Uses != "" && step.Run != "":
+			return config, fmt.Errorf("step %d : uses et run sont exclusifs", i+1)
+		case step.Uses != "":
+			continue
+		case step.Run == "":
+			return config, fmt.Errorf("step %d : ni run ni uses", i+1)
+		}
+
+		if step.Shell != "" {
+			if shell != "" && shell != step.Shell {
+				return config, fmt.Errorf("%w : shells différents dans le job (%s et %s)", ErrUnsupported, shell, step.Shell)
+			}
+			shell = step.Shell
+		}
+		command, err := githubStepCommand(step, firstNonEmpty(step.WorkingDirectory, workingDirectory), matrix)
+		if err != nil {
+			return config, fmt.Errorf("step %d : %w", i+1, err)
+		}
+		config.Script = append(config.Script, command)
+	}
+	switch shell {
+	case "", "sh", "bash":
+		config.Shell = shell
+	default:
+		return config, fmt.Errorf("%w : shell %s", ErrUnsupported, shell)
+	}
+	return config, nil
+}
+
+// githubImage returns the image of a job: its container, the image of its setup action or the image of its runner
+func githubImage(job githubJob, matrix map[string]string) (string, error) {
+	var setup string
+	for i, step := range job.Steps {
+		if step.Uses == "" {
+			continue
+		}
+		action, _, _ := strings.Cut(step.Uses, "@")
+		if action == "actions/checkout" {
+			continue
+		}
+		tool, ok := githubSetupActions[action]
+		if !ok {
+			return "", fmt.Errorf("%w : action %s (step %d)", ErrUnsupported, step.Uses, i+1)
+		}
+		if setup != "" || job.Container.Image != "" {
+			return "", fmt.Errorf("%w : %s avec un container ou une autre action de setup (step %d)", ErrUnsupported, action, i+1)
+		}
+		version, err := githubExpand(step.With[tool.Input], matrix, nil)
+		if err != nil {
+			return "", fmt.Errorf("step %d : %w", i+1, err)
+		}
+		if version == "" {
+			version = "latest"
+		}
+		setup = tool.Image + ":" + version
+	}
+	if setup != "" {
+		return setup, nil
+	}
+
+	if job.Container.Image != "" {
+		return githubExpand(job.Container.Image, matrix, nil)
+	}
+	if len(job.RunsOn) != 1 {
+		return "", fmt.Errorf("%w : runs-on %v (un seul label attendu)", ErrUnsupported, []string(job.RunsOn))
+	}
+	label, err := githubExpand(job.RunsOn[0], matrix, nil)
+	if err != nil {
+		return "", err
+	}
+	image, ok := githubRunners[label]
+	if !ok {
+		return "", fmt.Errorf("%w : runs-on %s sans container (runners ubuntu uniquement)", ErrUnsupported, label)
+	}
+	return image, nil
+}
+
+// githubStepCommand returns the script line of a run step, in a subshell when the step has its own
+// working directory or variables so that they do not leak into the next steps
+func githubStepCommand(step githubStep, workingDirectory string, matrix map[string]string) (string, error) {
+	run, err := githubExpand(strings.TrimRight(step.Run, "\n"), matrix, func(name string) string { return "${" + name + "}" })
+	if err != nil {
+		return "", err
+	}
+
+	var prefix []string
+	if workingDirectory != "" {
+		dir, err := githubExpand(workingDirectory, matrix, nil)
+		if err != nil {
+			return "", err
+		}
+		prefix = append(prefix, "cd "+shellQuote(dir))
+	}
+	keys := make([]string, 0, len(step.Env))
+	for key := range step.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, err := githubShellWord(step.Env[key], matrix)
+		if err != nil {
+			return "", err
+		}
+		prefix = append(prefix, fmt.Sprintf("export %s=%s", key, value))
+	}
+	if len(prefix) == 0 {
+		return run, nil
+	}
+	return fmt.Sprintf("(%s && %s)", strings.Join(prefix, " && "), run), nil
+}
+
+// githubEnv splits an env mapping into the variables with a fixed value and the export commands
+// of the variables referring to others (env.X, secrets.X, vars.X), which only the shell can resolve
+func githubEnv(env map[string]string, matrix map[string]string) (map[string]string, []string, error) {
+	var variables map[string]string
+	var exports []string
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		refers := false
+		value, err := githubExpand(env[key], matrix, func(string) string { refers = true; return "" })
+		if err != nil {
+			return nil, nil, err
+		}
+		if !refers {
+			if variables == nil {
+				variables = make(map[string]string)
+			}
+			variables[key] = value
+			continue
+		}
+		word, err := githubShellWord(env[key], matrix)
+		if err != nil {
+			return nil, nil, err
+		}
+		exports = append(exports, fmt.Sprintf("export %s=%s", key, word))
+	}
+	return variables, exports, nil
+}
+
+// githubExpressionPattern matches the ${{ }} expressions of a workflow
+var githubExpressionPattern = regexp.MustCompile(`\$\{\{\s*(.*?)\s*\}\}`)
+
+// githubVariablePattern matches the expressions refering to a variable: env.X, secrets.X, vars.X
+var githubVariablePattern = regexp.MustCompile(`^(?:env|secrets|vars)\.([A-Za-z_][A-Za-z0-9_]*)$`)
+
+// githubExpand replaces the expressions of s: matrix.X by the value of the matrix, env.X, secrets.X and vars.X
+// by variable(X), they are unsupported where variable is nil; any other expression is unsupported
+func githubExpand(s string, matrix map[string]string, variable func(name string) string) (string, error) {
+	var expandErr error
+	expanded := githubExpressionPattern.ReplaceAllStringFunc(s, func(match string) string {
+		value, err := githubExpression(githubExpressionPattern.FindStringSubmatch(match)[1], matrix, variable)
+		if err != nil && expandErr == nil {
+			expandErr = err
+		}
+		return value
+	})
+	return expanded, expandErr
+}
+
+func githubExpression(expression string, matrix map[string]string, variable func(name string) string) (string, error) {
+	if key, ok := strings.CutPrefix(expression, "matrix."); ok {
+		value, found := matrix[key]
+		if !found {
+			return "", fmt.Errorf("clé de matrice inconnue : %s", key)
+		}
+		return value, nil
+	}
+	if m := githubVariablePattern.FindStringSubmatch(expression); m != nil && variable != nil {
+		return variable(m[1]), nil
+	}
+	return "", fmt.Errorf("%w : expression ${{ %s }}", ErrUnsupported, expression)
+}
+
+// githubShellWord returns s as a shell word, the literal parts quoted and the variables left to the shell
+func githubShellWord(s string, matrix map[string]string) (string, error) {
+	var word strings.Builder
+	last := 0
+	for _, loc := range githubExpressionPattern.FindAllStringSubmatchIndex(s, -1) {
+		if loc[0] > last {
+			word.WriteString(shellQuote(s[last:loc[0]]))
+		}
+		value, err := githubExpression(s[loc[2]:loc[3]], matrix, func(name string) string { return `"${` + name + `}"` })
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(s[loc[2]:loc[3]], "matrix.") {
+			value = shellQuote(value)
+		}
+		word.WriteString(value)
+		last = loc[1]
+	}
+	if last < len(s) || word.Len() == 0 {
+		word.WriteString(shellQuote(s[last:]))
+	}
+	return word.String(), nil
+}
+
+// githubCombination is one combination of a matrix, its values in the order of the keys
+type githubCombination struct {
+	vars   map[string]string
+	values []string
+}
+
+// githubMatrix returns the combinations of a matrix, in the order GitHub runs them (the first key varies the least)
+// A job without matrix has a single empty combination
+func githubMatrix(node *yaml.Node) ([]githubCombination, error) {
+	combinations := []githubCombination{{}}
+	if node.Kind == 0 {
+		return combinations, nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%w : matrice %s", ErrUnsupported, node.Value)
+	}
+
+	var exclude []map[string]string
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, values := node.Content[i].Value, node.Content[i+1]
+		switch {
+		case key == "exclude":
+			if err := values.Decode(&exclude); err != nil {
+				return nil, fmt.Errorf("exclude invalide : %w", err)
+			}
+			continue
+		case key == "include":
+			return nil, fmt.Errorf("%w : include", ErrUnsupported)
+		case values.Kind != yaml.SequenceNode:
+			return nil, fmt.Errorf("%w : valeurs de %s", ErrUnsupported, key)
+		}
+
+		var next []githubCombination
+		for _, combination := range combinations {
+			for _, value := range values.Content {
+				if value.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("%w : valeur non scalaire pour %s", ErrUnsupported, key)
+				}
+				vars := make(map[string]string, len(combination.vars)+1)
+				for k, v := range combination.vars {
+					vars[k] = v
+				}
+				vars[key] = value.Value
+				next = append(next, githubCombination{vars: vars, values: append(slices.Clone(combination.values), value.Value)})
+			}
+		}
+		if len(next) > maxMatrixJobs {
+			return nil, fmt.Errorf("plus de %d combinaisons", maxMatrixJobs)
+		}
+		combinations = next
+	}
+
+	kept := combinations[:0]
+	for _, combination := range combinations {
+		if !slices.ContainsFunc(exclude, func(rule map[string]string) bool { return githubMatches(combination.vars, rule) }) {
+			kept = append(kept, combination)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("toutes les combinaisons sont exclues")
+	}
+	return kept, nil
+}
+
+// githubMatches reports whether a combination has all the values of an exclude rule
+func githubMatches(vars, rule map[string]string) bool {
+	for key, value := range rule {
+		if vars[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// githubLevels returns the depth of each job in the graph of its needs, which gives its stage
+func githubLevels(ids []string, jobs map[string]githubJob) (map[string]int, error) {
+	levels := make(map[string]int, len(ids))
+	visiting := make(map[string]bool)
+	var level func(id string) (int, error)
+	level = func(id string) (int, error) {
+		if l, ok := levels[id]; ok {
+			return l, nil
+		}
+		if visiting[id] {
+			return 0, fmt.Errorf("dépendance circulaire entre les jobs via %s", id)
+		}
+		visiting[id] = true
+		depth := 0
+		for _, need := range jobs[id].Needs {
+			if _, ok := jobs[need]; !ok {
+				return 0, fmt.Errorf("le job %s dépend d'un job inconnu : %s", id, need)
+			}
+			l, err := level(need)
+			if err != nil {
+				return 0, err
+			}
+			depth = max(depth, l+1)
+		}
+		levels[id] = depth
+		return depth, nil
+	}
+	for _, id := range ids {
+		if _, err := level(id); err != nil {
+			return nil, err
+		}
+	}
+	return levels, nil
+}
+
+// checkGitHubJob rejects the keys of a job, its steps, containers and strategy that cannot be run
+func checkGitHubJob(id string, node *yaml.Node) error {
+	where := "du job " + id
+	if err := checkGitHubKeys(node, where, githubJobKeys); err != nil {
+		return err
+	}
+	if err := checkGitHubDefaults(mappingValue(node, "defaults"), where); err != nil {
+		return err
+	}
+	if err := checkGitHubKeys(mappingValue(node, "container"), "du container "+where, githubContainerKeys); err != nil {
+		return err
+	}
+	if err := checkGitHubKeys(mappingValue(node, "strategy"), "de la stratégie "+where, githubStrategyKeys); err != nil {
+		return err
+	}
+	if services := mappingValue(node, "services"); services != nil && services.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(services.Content); i += 2 {
+			if err := checkGitHubKeys(services.Content[i+1], "du service "+services.Content[i].Value+" "+where, githubContainerKeys); err != nil {
+				return err
+			}
+		}
+	}
+	steps := mappingValue(node, "steps")
+	if steps == nil || steps.Kind != yaml.SequenceNode || len(steps.Content) == 0 {
+		return fmt.Errorf("le job %s n'a aucun step", id)
+	}
+	for i, step := range steps.Content {
+		if err := checkGitHubKeys(step, fmt.Sprintf("du step %d %s", i+1, where), githubStepKeys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkGitHubDefaults rejects the defaults other than the shell and the working directory of run steps
+func checkGitHubDefaults(node *yaml.Node, where string) error {
+	if err := checkGitHubKeys(node, "des defaults "+where, githubDefaultsKeys); err != nil {
+		return err
+	}
+	if node == nil {
+		return nil
+	}
+	return checkGitHubKeys(mappingValue(node, "run"), "des defaults "+where, githubRunKeys)
+}
+
+// checkGitHubKeys returns an ErrUnsupported error for the first key of a mapping missing from allowed
+func checkGitHubKeys(node *yaml.Node, where string, allowed []string) error {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if key := node.Content[i].Value; !slices.Contains(allowed, key) {
+			return fmt.Errorf("%w : clé %s %s", ErrUnsupported, key, where)
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the value of a key of a mapping, nil when it is absent
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// shellQuote quotes a value for sh
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
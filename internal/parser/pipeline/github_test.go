@@ -0,0 +1,177 @@
+package pipeline
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const sampleWorkflow = `
+name: CI
+on: [push, pull_request]
+env:
+  CGO_ENABLED: "0"
+  TOKEN: ${{ secrets.DEPLOY_TOKEN }}
+jobs:
+  lint:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v4
+      - run: ./lint.sh
+  test:
+    runs-on: ubuntu-latest
+    needs: lint
+    timeout-minutes: 10
+    strategy:
+      matrix:
+        go: ["1.22", "1.23"]
+        db: [postgres, sqlite]
+        exclude:
+          - go: "1.22"
+            db: sqlite
+    services:
+      postgres:
+        image: postgres:16
+        env:
+          POSTGRES_PASSWORD: test
+    steps:
+      - uses: actions/checkout@v4
+      - uses: actions/setup-go@v5
+        with:
+          go-version: ${{ matrix.go }}
+      - name: Test
+        run: |
+          go vet ./...
+          go test -tags ${{ matrix.db }} ./...
+        working-directory: backend
+        env:
+          DB: ${{ matrix.db }}
+  deploy:
+    runs-on: ubuntu-22.04
+    container: alpine:3.20
+    needs: [test]
+    continue-on-error: true
+    defaults:
+      run:
+        shell: sh
+    steps:
+      - run: ./deploy.sh "${{ env.TOKEN }}"
+`
+
+func TestParseGitHubBytes(t *testing.T) {
+	config, err := ParseGitHubBytes([]byte(sampleWorkflow))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if !reflect.DeepEqual(config.Stages, []string{"stage-1", "stage-2", "stage-3"}) {
+		t.Errorf("Expected one stage per level of needs, got %v", config.Stages)
+	}
+	wantOrder := []string{"lint", "test (1.22, postgres)", "test (1.23, postgres)", "test (1.23, sqlite)", "deploy"}
+	if !reflect.DeepEqual(config.JobOrder, wantOrder) {
+		t.Errorf("Expected the jobs %v, got %v", wantOrder, config.JobOrder)
+	}
+	if !reflect.DeepEqual(config.Variables, map[string]string{"CGO_ENABLED": "0"}) {
+		t.Errorf("Expected the fixed workflow variables, got %v", config.Variables)
+	}
+
+	lint := config.Jobs["lint"]
+	if lint.Stage != "stage-1" || lint.Image != "ubuntu:latest" {
+		t.Errorf("Expected the runner image in the first stage, got %+v", lint)
+	}
+	// The variables refering to a secret are left to the shell, checkout is a no-op
+	if want := []string{`export TOKEN="${DEPLOY_TOKEN}"`, "./lint.sh"}; !reflect.DeepEqual(lint.Script, want) {
+		t.Errorf("Expected the script %q, got %q", want, lint.Script)
+	}
+
+	test := config.Jobs["test (1.23, sqlite)"]
+	if test.Stage != "stage-2" || test.Image != "golang:1.23" || test.Timeout != 600 {
+		t.Errorf("Expected the setup-go image and the timeout in seconds, got %+v", test)
+	}
+	wantStep := "(cd 'backend' && export DB='sqlite' && go vet ./...\ngo test -tags sqlite ./...)"
+	if len(test.Script) != 2 || test.Script[1] != wantStep {
+		t.Errorf("Expected the step %q, got %q", wantStep, test.Script)
+	}
+	wantServices := []ServiceConfig{{Name: "postgres:16", Alias: "postgres", Variables: map[string]string{"POSTGRES_PASSWORD": "test"}}}
+	if !reflect.DeepEqual(test.Services, wantServices) {
+		t.Errorf("Expected the services %+v, got %+v", wantServices, test.Services)
+	}
+
+	deploy := config.Jobs["deploy"]
+	if deploy.Stage != "stage-3" || deploy.Image != "alpine:3.20" || !deploy.AllowFailure || deploy.Shell != "sh" {
+		t.Errorf("Unexpected deploy job %+v", deploy)
+	}
+	if got := deploy.Script[len(deploy.Script)-1]; got != `./deploy.sh "${TOKEN}"` {
+		t.Errorf("Expected the env expression as a variable, got %q", got)
+	}
+}
+
+func TestParseGitHubBytesUnsupported(t *testing.T) {
+	for _, content := range []string{
+		"on: push\njobs:\n  build:\n    runs-on: windows-latest\n    steps:\n      - run: make\n",
+		"on: push\njobs:\n  build:\n    runs-on: ubuntu-latest\n    steps:\n      - uses: docker/build-push-action@v6\n",
+		"on: push\njobs:\n  build:\n    runs-on: ubuntu-latest\n    steps:\n      - run: make\n        if: github.event_name == 'push'\n",
+		"on: push\njobs:\n  build:\n    runs-on: ubuntu-latest\n    steps:\n      - run: echo ${{ github.sha }}\n",
+		"on: push\njobs:\n  build:\n    runs-on: ubuntu-latest\n    strategy:\n      matrix:\n        include: [{go: '1.23'}]\n    steps:\n      - run: make\n",
+		"on: push\njobs:\n  build:\n    uses: org/repo/.github/workflows/build.yml@main\n",
+		"on: push\njobs:\n  build:\n    runs-on: ubuntu-latest\n    strategy:\n      fail-fast: false\n      matrix:\n        go: ['1.23']\n    steps:\n      - run: make\n",
+		"on: push\njobs:\n  build:\n    runs-on: ubuntu-latest\n    strategy:\n      max-parallel: 2\n      matrix:\n        go: ['1.23']\n    steps:\n      - run: make\n",
+		"on: push\njobs:\n  build:\n    runs-on: ubuntu-latest\n    steps:\n      - run: make\n        shell: pwsh\n",
+	} {
+		if _, err := ParseGitHubBytes([]byte(content)); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("Expected an unsupported error for %q, got %v", content, err)
+		}
+	}
+
+	for _, content := range []string{
+		"on: push\njobs:\n  build:\n    runs-on: ubuntu-latest\n    needs: missing\n    steps:\n      - run: make\n",
+		"on: push\njobs:\n  a:\n    runs-on: ubuntu-latest\n    needs: b\n    steps: [{run: make}]\n  b:\n    runs-on: ubuntu-latest\n    needs: a\n    steps: [{run: make}]\n",
+		"on: push\njobs:\n  build:\n    runs-on: ubuntu-latest\n",
+	} {
+		if _, err := ParseGitHubBytes([]byte(content)); err == nil {
+			t.Errorf("Expected %q to be rejected", content)
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	gitlab := []byte("stages: [build]\nbuild:\n  stage: build\n  script: [make]\n")
+	github := []byte("on: push\njobs:\n  build:\n    runs-on: ubuntu-latest\n    steps: [{run: make}]\n")
+
+	tests := []struct {
+		path   string
+		data   []byte
+		format string
+	}{
+		{".gitlab-ci.yml", gitlab, FormatGitLab},
+		{".github/workflows/ci.yml", gitlab, FormatGitHub},
+		{"ci/workflow.yml", github, FormatGitHub},
+		{"", github, FormatGitHub},
+		{"", gitlab, FormatGitLab},
+	}
+	for _, tt := range tests {
+		if got := DetectFormat(tt.path, tt.data); got != tt.format {
+			t.Errorf("DetectFormat(%q) = %s, want %s", tt.path, got, tt.format)
+		}
+	}
+
+	if _, err := ParserFor("jenkins"); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+}
+
+func TestParseWorkflowFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".github", "workflows", "ci.yml")
+	os.MkdirAll(filepath.Dir(path), 0755)
+	os.WriteFile(path, []byte(sampleWorkflow), 0644)
+
+	config, err := NewParser(path).Parse()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := config.Jobs["deploy"]; !ok || len(config.Jobs) != 5 {
+		t.Errorf("Expected the jobs of the workflow, got %v", config.JobOrder)
+	}
+}
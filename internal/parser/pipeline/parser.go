@@ -1,44 +1,475 @@
 package pipeline
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
+// Stratégies d'agrégation de la couverture des jobs
+const (
+	CoverageLast    = "last"
+	CoverageAverage = "average"
+	CoverageMax     = "max"
+)
+
+// Politiques d'un groupe de concurrence quand un pipeline du groupe tourne déjà
+const (
+	ConcurrencyQueue         = "queue"          // le nouveau pipeline attend (défaut)
+	ConcurrencyCancelRunning = "cancel-running" // le pipeline en cours est annulé
+	ConcurrencyRejectNew     = "reject-new"     // le nouveau pipeline est refusé
+)
+
+// MaxRetry est le nombre maximal de nouvelles tentatives d'un job
+const MaxRetry = 5
+
+// Mots-clés des règles only/except, les autres valeurs sont des noms de branche ou de tag ou des /regex/
+const (
+	RefBranches = "branches" // toutes les branches
+	RefTags     = "tags"     // tous les tags
+)
+
+// Politiques de pull de l'image d'un job
+const (
+	PullAlways       = "always"         // l'image est pullée avant chaque job (défaut)
+	PullIfNotPresent = "if-not-present" // l'image n'est pullée que si elle est absente du démon
+	PullNever        = "never"          // l'image n'est jamais pullée, le job échoue si elle est absente
+)
+
 type PipelineConfig struct {
-	Stages []string             `yaml:"stages"`
-	Jobs   map[string]JobConfig `yaml:",inline"`
+	Stages              []string             `yaml:"stages"`
+	CoverageAggregation string               `yaml:"coverage_aggregation,omitempty"` // last (défaut), average, max
+	Jobs                map[string]JobConfig `yaml:",inline"`
+	JobOrder            []string             `yaml:"-"` // Noms des jobs dans l'ordre de déclaration
+	Concurrency         ConcurrencyConfig    `yaml:"concurrency,omitempty"`
+	Variables           map[string]string    `yaml:"variables,omitempty"`     // Variables d'environnement de tous les jobs
+	BeforeScript        []string             `yaml:"before_script,omitempty"` // Commandes par défaut avant le script des jobs
+	AfterScript         []string             `yaml:"after_script,omitempty"`  // Commandes par défaut après le script des jobs
+	Approval            ApprovalConfig       `yaml:"approval,omitempty"`
+	Services            []ServiceConfig      `yaml:"services,omitempty"` // Services par défaut des jobs
+	Cache               *CacheConfig         `yaml:"cache,omitempty"`    // Cache par défaut des jobs
+}
+
+// ApprovalConfig liste les étapes qui attendent une approbation manuelle avant de démarrer
+// Sans réponse avant le délai, l'approbation est rejetée et le pipeline échoue
+type ApprovalConfig struct {
+	Stages     []string `yaml:"stages,omitempty"`     // Stages qui attendent une approbation
+	Deployment bool     `yaml:"deployment,omitempty"` // Le déploiement attend une approbation
+	Timeout    int      `yaml:"timeout,omitempty"`    // Délai d'approbation en secondes (0 = PIPELINE_APPROVAL_TIMEOUT_MINUTES)
+}
+
+// RequiresStage reports whether a stage waits for an approval before it runs
+func (a ApprovalConfig) RequiresStage(stage string) bool {
+	return slices.Contains(a.Stages, stage)
+}
+
+// ConcurrencyConfig place le pipeline dans un groupe dont un seul pipeline du projet tourne à la fois
+type ConcurrencyConfig struct {
+	Group  string `yaml:"group"`
+	Policy string `yaml:"policy,omitempty"` // queue (défaut), cancel-running, reject-new
+}
+
+// StageJobs returns the jobs of a stage in declaration order
+// Jobs missing from JobOrder (configs built in code) follow, sorted by name
+func (c *PipelineConfig) StageJobs(stage string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range c.JobOrder {
+		if job, ok := c.Jobs[name]; ok && job.Stage == stage && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	var rest []string
+	for name, job := range c.Jobs {
+		if job.Stage == stage && !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...)
 }
 
 type JobConfig struct {
-	Stage      string            `yaml:"stage"`
-	Image      string            `yaml:"image"`
-	Script     []string          `yaml:"script"`
-	Type       string            `yaml:"type,omitempty"`       // shell (default), docker-deploy, docker-compose-deploy
-	Properties map[string]string `yaml:"properties,omitempty"` // Params spécifiques au type de job
+	Stage        string            `yaml:"stage"`
+	Image        string            `yaml:"image"`           // `image: alpine` ou `image: {name: alpine, entrypoint: [""]}`
+	Entrypoint   []string          `yaml:"-"`               // Remplace l'entrypoint de l'image ([""] le supprime), lu dans `image:`
+	Shell        string            `yaml:"shell,omitempty"` // Shell exécutant le script : sh (défaut), bash ou chemin absolu
+	Script       []string          `yaml:"script"`
+	Type         string            `yaml:"type,omitempty"`          // shell (default), docker-deploy, docker-compose-deploy
+	Properties   map[string]string `yaml:"properties,omitempty"`    // Params spécifiques au type de job
+	Coverage     string            `yaml:"coverage,omitempty"`      // Regex d'extraction de la couverture, ex: '/total:\s+(\d+\.\d+)%/'
+	Sysctls      map[string]string `yaml:"sysctls,omitempty"`       // Paramètres noyau du conteneur (soumis à JOB_SYSCTL_ALLOWLIST)
+	Exists       []string          `yaml:"exists,omitempty"`        // Le job ne tourne que si un de ces fichiers (globs) existe
+	PullSecret   string            `yaml:"pull_secret,omitempty"`   // Nom des identifiants (PULL_SECRETS_FILE) utilisés pour puller l'image
+	LogFilter    string            `yaml:"log_filter,omitempty"`    // Regex des lignes de log à ne pas stocker (bruit)
+	Timeout      int               `yaml:"timeout,omitempty"`       // Durée maximale du job en secondes (0 = pas de limite)
+	Variables    map[string]string `yaml:"variables,omitempty"`     // Variables d'environnement du job (prioritaires sur les globales)
+	BeforeScript []string          `yaml:"before_script,omitempty"` // Commandes avant le script (remplace le before_script global)
+	AfterScript  []string          `yaml:"after_script,omitempty"`  // Commandes exécutées après le script, même en cas d'échec
+	ReadOnly     *bool             `yaml:"read_only,omitempty"`     // Système de fichiers racine en lecture seule (imposé à tous les jobs par JOB_READ_ONLY_ROOTFS)
+	Artifacts    ArtifactsConfig   `yaml:"artifacts,omitempty"`     // Fichiers transmis aux jobs des stages suivants
+	CPU          string            `yaml:"cpu,omitempty"`           // Limite CPU en nombre de CPUs, ex: 1.5 (défaut : illimité)
+	Memory       string            `yaml:"memory,omitempty"`        // Limite mémoire, ex: 512m, 2g (défaut : illimitée)
+	PullPolicy   string            `yaml:"pull_policy,omitempty"`   // always (défaut), if-not-present, never
+	Only         RefRule           `yaml:"only,omitempty"`          // Refs sur lesquelles le job tourne (toutes si vide)
+	Except       RefRule           `yaml:"except,omitempty"`        // Refs sur lesquelles le job ne tourne pas
+	AllowFailure bool              `yaml:"allow_failure,omitempty"` // L'échec du job ne fait pas échouer le pipeline
+	Retry        RetryConfig       `yaml:"retry,omitempty"`         // Nouvelles tentatives du job en cas d'échec
+	Services     []ServiceConfig   `yaml:"services,omitempty"`      // Conteneurs lancés à côté du job (remplace les services globaux)
+	Cache        *CacheConfig      `yaml:"cache,omitempty"`         // Dossiers conservés entre les pipelines (remplace le cache global)
+	Tags         []string          `yaml:"tags,omitempty"`          // Labels que le runner doit annoncer (RUNNER_TAGS) pour exécuter le job
+}
+
+// JobImage est la forme longue de `image:`, comme GitLab
+type JobImage struct {
+	Name       string   `yaml:"name"`
+	Entrypoint []string `yaml:"entrypoint,omitempty"`
+}
+
+// UnmarshalYAML accepte `image:` sous forme de nom ou de JobImage
+func (j *JobConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain JobConfig
+	if value.Kind != yaml.MappingNode {
+		return value.Decode((*plain)(j))
+	}
+
+	node := *value
+	node.Content = slices.Clone(value.Content)
+	var image JobImage
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != "image" || node.Content[i+1].Kind != yaml.MappingNode {
+			continue
+		}
+		if err := node.Content[i+1].Decode(&image); err != nil {
+			return err
+		}
+		node.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: image.Name}
+	}
+	if err := node.Decode((*plain)(j)); err != nil {
+		return err
+	}
+	j.Entrypoint = image.Entrypoint
+	return nil
+}
+
+// ServiceConfig est un conteneur lancé à côté du job (base de données, cache...), joignable par son alias
+// Comme GitLab, il s'écrit `services: [postgres:15]` ou `services: [{name: postgres:15, alias: db}]`
+type ServiceConfig struct {
+	Name       string            `yaml:"name"`                 // Image du service
+	Alias      string            `yaml:"alias,omitempty"`      // Nom d'hôte du service (défaut : image sans tag, / remplacés par -)
+	Variables  map[string]string `yaml:"variables,omitempty"`  // Variables d'environnement du service
+	Command    []string          `yaml:"command,omitempty"`    // Remplace la commande de l'image
+	Entrypoint []string          `yaml:"entrypoint,omitempty"` // Remplace l'entrypoint de l'image
+}
+
+func (s *ServiceConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&s.Name)
+	}
+	type plain ServiceConfig
+	return value.Decode((*plain)(s))
+}
+
+// validServiceAlias matches the host names a service can be reached by
+var validServiceAlias = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// HostAlias returns the host name of the service, its alias or, as GitLab does, its image
+// without tag nor digest and with / replaced by - (registry.example.com/db/postgres:15 gives registry.example.com-db-postgres)
+func (s ServiceConfig) HostAlias() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	name, _, _ := strings.Cut(s.Name, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	return strings.ReplaceAll(name, "/", "-")
+}
+
+// ArtifactsConfig liste les fichiers (globs relatifs au workspace) archivés après le succès d'un job
+type ArtifactsConfig struct {
+	Paths []string `yaml:"paths"`
+}
+
+// CacheConfig liste les dossiers (chemins relatifs au workspace) restaurés avant le job et sauvegardés après son succès
+// Le cache est partagé par les pipelines du projet qui ont la même clé
+type CacheConfig struct {
+	Key   CacheKey `yaml:"key,omitempty"`
+	Paths []string `yaml:"paths"`
+}
+
+// CacheKey identifie un cache, par un nom fixe (`key: deps`) ou, comme GitLab, par le hash de fichiers
+// (`key: {files: [package-lock.json], prefix: npm}`), le cache change alors avec le lockfile
+type CacheKey struct {
+	Name   string   `yaml:"-"`
+	Files  []string `yaml:"files,omitempty"`  // La clé est le hash du contenu de ces fichiers
+	Prefix string   `yaml:"prefix,omitempty"` // Préfixe du hash des fichiers
+}
+
+func (k *CacheKey) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&k.Name)
+	}
+	type plain CacheKey
+	return value.Decode((*plain)(k))
+}
+
+// RefRule liste des refs : noms de branche ou de tag, /regex/, branches ou tags
+// Comme GitLab, la règle s'écrit en liste (`only: [main]`) ou sous `refs:` (`only: {refs: [main]}`)
+type RefRule struct {
+	Refs []string `yaml:"refs"`
+}
+
+func (r *RefRule) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		r.Refs = []string{value.Value}
+		return nil
+	case yaml.SequenceNode:
+		return value.Decode(&r.Refs)
+	}
+	type plain RefRule
+	return value.Decode((*plain)(r))
+}
+
+// RefPattern returns the regex of a /regex/ ref, nil for names and keywords
+func RefPattern(ref string) (*regexp.Regexp, error) {
+	if len(ref) < 2 || !strings.HasPrefix(ref, "/") || !strings.HasSuffix(ref, "/") {
+		return nil, nil
+	}
+	return regexp.Compile(ref[1 : len(ref)-1])
+}
+
+// RetryConfig relance un job échoué, `retry: 2` ou `retry: {max: 2, exit_codes: [137]}`
+// Sans exit_codes, tout échec est relancé (code de retour non nul, timeout, pull ou démarrage du conteneur)
+type RetryConfig struct {
+	Max       int   `yaml:"max"`
+	ExitCodes []int `yaml:"exit_codes,omitempty"` // Seuls ces codes de retour sont relancés
+}
+
+func (r *RetryConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&r.Max)
+	}
+	type plain RetryConfig
+	return value.Decode((*plain)(r))
 }
 
 type Parser struct {
 	FilePath string
+	Format   string    // gitlab ou github, détecté depuis FilePath et le contenu si vide
+	reader   io.Reader // Source de la configuration à la place de FilePath
 }
 
 func NewParser(filePath string) *Parser {
 	return &Parser{FilePath: filePath}
 }
 
+// NewParserFromReader lit la configuration depuis r plutôt que depuis un fichier
+func NewParserFromReader(r io.Reader) *Parser {
+	return &Parser{reader: r}
+}
+
 func (p *Parser) Parse() (*PipelineConfig, error) {
-	data, err := os.ReadFile(p.FilePath)
+	var data []byte
+	var err error
+	if p.reader != nil {
+		data, err = io.ReadAll(p.reader)
+	} else {
+		data, err = os.ReadFile(p.FilePath)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("impossible de lire le fichier : %w", err)
 	}
+	format := p.Format
+	if format == "" {
+		format = DetectFormat(p.FilePath, data)
+	}
+	parser, err := ParserFor(format)
+	if err != nil {
+		return nil, err
+	}
+	return parser.ParseBytes(data)
+}
+
+// ErrEmptyConfig is returned by CheckNotEmpty for a config without stage nor job
+var ErrEmptyConfig = errors.New("configuration vide : aucun stage ni job")
+
+// CheckNotEmpty rejects a config the runner would have nothing to run for
+// Parsing and validation accept it, only the run path checks it
+func CheckNotEmpty(config *PipelineConfig) error {
+	if len(config.Stages) == 0 && len(config.Jobs) == 0 {
+		return ErrEmptyConfig
+	}
+	return nil
+}
+
+// ParseBytes decodes a pipeline config already read in memory
+func ParseBytes(data []byte) (*PipelineConfig, error) {
+	// Les jobs héritent des jobs cités par `extends:`
+	resolved, err := resolveExtends(data)
+	if err != nil {
+		return nil, err
+	}
 
 	var config PipelineConfig
-	err = yaml.Unmarshal(data, &config)
+	err = yaml.Unmarshal(resolved, &config)
 	if err != nil {
 		return nil, fmt.Errorf("erreur lors du décodage YAML : %w", err)
 	}
 
+	// Comme GitLab, les jobs dont le nom commence par un point sont des modèles, ils ne tournent pas
+	for name := range config.Jobs {
+		if strings.HasPrefix(name, ".") {
+			delete(config.Jobs, name)
+		}
+	}
+
+	config.JobOrder = jobOrder(data, config.Jobs)
+
+	// Comme GitLab, before_script, after_script et services d'un job remplacent ceux déclarés à la racine
+	for name, job := range config.Jobs {
+		if job.BeforeScript == nil {
+			job.BeforeScript = config.BeforeScript
+		}
+		if job.AfterScript == nil {
+			job.AfterScript = config.AfterScript
+		}
+		if job.Services == nil {
+			job.Services = config.Services
+		}
+		if job.Cache == nil {
+			job.Cache = config.Cache
+		}
+		config.Jobs[name] = job
+	}
+
+	if err := checkConfig(&config); err != nil {
+		return nil, err
+	}
 	return &config, nil
-}
\ No newline at end of file
+}
+
+// checkConfig checks the values of a decoded config, whatever its format
+func checkConfig(config *PipelineConfig) error {
+	for name, job := range config.Jobs {
+		if job.Timeout < 0 {
+			return fmt.Errorf("timeout invalide pour le job %s : %d (secondes, 0 = pas de limite)", name, job.Timeout)
+		}
+		if !validShell(job.Shell) {
+			return fmt.Errorf("shell invalide pour le job %s : %s (sh, bash ou chemin absolu)", name, job.Shell)
+		}
+		switch job.PullPolicy {
+		case "", PullAlways, PullIfNotPresent, PullNever:
+		default:
+			return fmt.Errorf("pull_policy invalide pour le job %s : %s (always, if-not-present ou never)", name, job.PullPolicy)
+		}
+		if job.Retry.Max < 0 || job.Retry.Max > MaxRetry {
+			return fmt.Errorf("retry invalide pour le job %s : %d (entre 0 et %d)", name, job.Retry.Max, MaxRetry)
+		}
+		if err := checkServices(job.Services); err != nil {
+			return fmt.Errorf("services invalides pour le job %s : %w", name, err)
+		}
+		if err := checkCache(job.Cache); err != nil {
+			return fmt.Errorf("cache invalide pour le job %s : %w", name, err)
+		}
+		for _, ref := range append(append([]string{}, job.Only.Refs...), job.Except.Refs...) {
+			if _, err := RefPattern(ref); err != nil {
+				return fmt.Errorf("regex de ref invalide pour le job %s : %s : %w", name, ref, err)
+			}
+		}
+	}
+
+	switch config.Concurrency.Policy {
+	case "", ConcurrencyQueue, ConcurrencyCancelRunning, ConcurrencyRejectNew:
+	default:
+		return fmt.Errorf("politique de concurrence invalide : %s (queue, cancel-running ou reject-new)", config.Concurrency.Policy)
+	}
+
+	if config.Approval.Timeout < 0 {
+		return fmt.Errorf("timeout d'approbation invalide : %d (secondes, 0 = délai du serveur)", config.Approval.Timeout)
+	}
+	for _, stage := range config.Approval.Stages {
+		if !slices.Contains(config.Stages, stage) {
+			return fmt.Errorf("approbation demandée pour un stage inconnu : %s", stage)
+		}
+	}
+
+	switch config.CoverageAggregation {
+	case "", CoverageLast, CoverageAverage, CoverageMax:
+	default:
+		return fmt.Errorf("coverage_aggregation invalide : %s (last, average ou max)", config.CoverageAggregation)
+	}
+	return nil
+}
+
+// jobOrder returns the names of the jobs in the order they are declared at the root
+func jobOrder(data []byte, jobs map[string]JobConfig) []string {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var order []string
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if _, ok := jobs[root.Content[i].Value]; ok {
+			order = append(order, root.Content[i].Value)
+		}
+	}
+	return order
+}
+
+// checkServices vérifie que chaque service a une image et un alias valide et unique dans le job
+func checkServices(services []ServiceConfig) error {
+	aliases := make(map[string]bool)
+	for _, service := range services {
+		if service.Name == "" {
+			return fmt.Errorf("service sans image")
+		}
+		alias := service.HostAlias()
+		if !validServiceAlias.MatchString(alias) {
+			return fmt.Errorf("alias invalide : %s", alias)
+		}
+		if aliases[alias] {
+			return fmt.Errorf("alias utilisé par deux services : %s", alias)
+		}
+		aliases[alias] = true
+	}
+	return nil
+}
+
+// checkCache vérifie que le cache a des chemins et que ses chemins et fichiers de clé restent dans le workspace
+func checkCache(cache *CacheConfig) error {
+	if cache == nil {
+		return nil
+	}
+	if len(cache.Paths) == 0 {
+		return fmt.Errorf("aucun chemin")
+	}
+	for _, path := range append(slices.Clone(cache.Paths), cache.Key.Files...) {
+		if !filepath.IsLocal(filepath.Clean(path)) {
+			return fmt.Errorf("chemin hors du workspace : %s", path)
+		}
+	}
+	return nil
+}
+
+// validShell vérifie que le shell d'un job est sh, bash ou le chemin absolu d'un shell
+func validShell(shell string) bool {
+	switch shell {
+	case "", "sh", "bash":
+		return true
+	}
+	return strings.HasPrefix(shell, "/") && !strings.ContainsAny(shell, " \t")
+}
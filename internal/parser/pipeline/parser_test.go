@@ -1,7 +1,10 @@
 package pipeline
 
 import (
+	"errors"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -11,12 +14,11 @@ func TestParse(t *testing.T) {
 stages:
   - build
   - test
-jobs:
-  build-job:
-    stage: build
-    image: golang:1.21
-    script:
-      - go build ./...
+build-job:
+  stage: build
+  image: golang:1.21
+  script:
+    - go build ./...
 `
 	tmpFile, err := os.CreateTemp("", "pipeline-*.yml")
 	if err != nil {
@@ -45,7 +47,7 @@ jobs:
 		if len(config.Jobs) != 1 {
 			t.Errorf("Expected 1 job, got %d", len(config.Jobs))
 		}
-		
+
 		job, ok := config.Jobs["build-job"]
 		if !ok {
 			t.Errorf("Expected job 'build-job' to exist")
@@ -71,7 +73,7 @@ jobs:
 			t.Fatalf("Failed to create temp file: %v", err)
 		}
 		defer os.Remove(invalidTmpFile.Name())
-		
+
 		if _, err := invalidTmpFile.WriteString("invalid: [ yaml"); err != nil {
 			t.Fatalf("Failed to write to temp file: %v", err)
 		}
@@ -83,4 +85,664 @@ jobs:
 			t.Error("Expected error for invalid YAML, got nil")
 		}
 	})
+
+	// Test case 4: Job timeout
+	t.Run("JobTimeout", func(t *testing.T) {
+		timeoutTmpFile, err := os.CreateTemp("", "timeout-pipeline-*.yml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(timeoutTmpFile.Name())
+
+		content := `
+stages:
+  - test
+integration:
+  stage: test
+  image: golang:1.21
+  timeout: 600
+  script:
+    - go test ./...
+unit:
+  stage: test
+  image: golang:1.21
+  script:
+    - go test -short ./...
+`
+		if _, err := timeoutTmpFile.WriteString(content); err != nil {
+			t.Fatalf("Failed to write to temp file: %v", err)
+		}
+		timeoutTmpFile.Close()
+
+		config, err := NewParser(timeoutTmpFile.Name()).Parse()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if timeout := config.Jobs["integration"].Timeout; timeout != 600 {
+			t.Errorf("Expected timeout 600, got %d", timeout)
+		}
+		if timeout := config.Jobs["unit"].Timeout; timeout != 0 {
+			t.Errorf("Expected no timeout by default, got %d", timeout)
+		}
+	})
+
+	// Test case 5: Negative timeout
+	t.Run("NegativeTimeout", func(t *testing.T) {
+		invalidTmpFile, err := os.CreateTemp("", "invalid-timeout-*.yml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(invalidTmpFile.Name())
+
+		if _, err := invalidTmpFile.WriteString("stages: [test]\nunit:\n  stage: test\n  image: alpine\n  timeout: -5\n"); err != nil {
+			t.Fatalf("Failed to write to temp file: %v", err)
+		}
+		invalidTmpFile.Close()
+
+		if _, err := NewParser(invalidTmpFile.Name()).Parse(); err == nil {
+			t.Error("Expected error for a negative timeout, got nil")
+		}
+	})
+
+	// Test case 6: Jobs keep their declaration order within a stage
+	t.Run("JobOrder", func(t *testing.T) {
+		orderTmpFile, err := os.CreateTemp("", "order-pipeline-*.yml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(orderTmpFile.Name())
+
+		content := `
+stages: [build, test]
+zeta:
+  stage: test
+  image: alpine
+build:
+  stage: build
+  image: alpine
+alpha:
+  stage: test
+  image: alpine
+middle:
+  stage: test
+  image: alpine
+`
+		if _, err := orderTmpFile.WriteString(content); err != nil {
+			t.Fatalf("Failed to write to temp file: %v", err)
+		}
+		orderTmpFile.Close()
+
+		// Parse several times, map iteration order must not leak
+		for i := 0; i < 5; i++ {
+			config, err := NewParser(orderTmpFile.Name()).Parse()
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			jobs := config.StageJobs("test")
+			if len(jobs) != 3 || jobs[0] != "zeta" || jobs[1] != "alpha" || jobs[2] != "middle" {
+				t.Fatalf("Expected test jobs in declaration order [zeta alpha middle], got %v", jobs)
+			}
+		}
+	})
+
+	// Test case 7: Jobs without declaration order are sorted by name
+	t.Run("StageJobsSortedByName", func(t *testing.T) {
+		config := &PipelineConfig{Jobs: map[string]JobConfig{
+			"lint":  {Stage: "test"},
+			"build": {Stage: "build"},
+			"e2e":   {Stage: "test"},
+			"unit":  {Stage: "test"},
+		}}
+		jobs := config.StageJobs("test")
+		if len(jobs) != 3 || jobs[0] != "e2e" || jobs[1] != "lint" || jobs[2] != "unit" {
+			t.Errorf("Expected test jobs sorted by name [e2e lint unit], got %v", jobs)
+		}
+	})
+
+	// Test case 8: Concurrency group policy
+	t.Run("ConcurrencyPolicy", func(t *testing.T) {
+		concurrencyTmpFile, err := os.CreateTemp("", "concurrency-pipeline-*.yml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(concurrencyTmpFile.Name())
+
+		if _, err := concurrencyTmpFile.WriteString("stages: [test]\nconcurrency:\n  group: production\n  policy: cancel-running\nunit:\n  stage: test\n  image: alpine\n"); err != nil {
+			t.Fatalf("Failed to write to temp file: %v", err)
+		}
+		concurrencyTmpFile.Close()
+
+		config, err := NewParser(concurrencyTmpFile.Name()).Parse()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if config.Concurrency.Group != "production" || config.Concurrency.Policy != ConcurrencyCancelRunning {
+			t.Errorf("Unexpected concurrency config %+v", config.Concurrency)
+		}
+		if _, ok := config.Jobs["concurrency"]; ok {
+			t.Error("Expected concurrency not to be parsed as a job")
+		}
+
+		invalidTmpFile, err := os.CreateTemp("", "invalid-concurrency-*.yml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(invalidTmpFile.Name())
+		invalidTmpFile.WriteString("stages: [test]\nconcurrency:\n  group: production\n  policy: newest-wins\n")
+		invalidTmpFile.Close()
+
+		if _, err := NewParser(invalidTmpFile.Name()).Parse(); err == nil {
+			t.Error("Expected error for an unknown concurrency policy, got nil")
+		}
+	})
+
+	// Test case 9: Global and per-job variables
+	t.Run("Variables", func(t *testing.T) {
+		variablesTmpFile, err := os.CreateTemp("", "variables-pipeline-*.yml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(variablesTmpFile.Name())
+
+		if _, err := variablesTmpFile.WriteString("stages: [test]\nvariables:\n  GOFLAGS: -mod=vendor\n  PORT: 8080\nunit:\n  stage: test\n  image: alpine\n  variables:\n    PORT: 9090\n"); err != nil {
+			t.Fatalf("Failed to write to temp file: %v", err)
+		}
+		variablesTmpFile.Close()
+
+		config, err := NewParser(variablesTmpFile.Name()).Parse()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if config.Variables["GOFLAGS"] != "-mod=vendor" || config.Variables["PORT"] != "8080" {
+			t.Errorf("Unexpected global variables %v", config.Variables)
+		}
+		if port := config.Jobs["unit"].Variables["PORT"]; port != "9090" {
+			t.Errorf("Expected job variable PORT=9090, got %q", port)
+		}
+		if _, ok := config.Jobs["variables"]; ok {
+			t.Error("Expected variables not to be parsed as a job")
+		}
+	})
+
+	// Test case 10: before_script and after_script, the job ones replace the global ones
+	t.Run("BeforeAfterScript", func(t *testing.T) {
+		scriptsTmpFile, err := os.CreateTemp("", "scripts-pipeline-*.yml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(scriptsTmpFile.Name())
+
+		content := `
+stages: [test]
+before_script:
+  - go mod download
+after_script:
+  - rm -rf tmp
+unit:
+  stage: test
+  image: golang:1.21
+  script:
+    - go test ./...
+lint:
+  stage: test
+  image: golang:1.21
+  before_script:
+    - go install lint
+  after_script: []
+  script:
+    - lint ./...
+`
+		if _, err := scriptsTmpFile.WriteString(content); err != nil {
+			t.Fatalf("Failed to write to temp file: %v", err)
+		}
+		scriptsTmpFile.Close()
+
+		config, err := NewParser(scriptsTmpFile.Name()).Parse()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		unit, lint := config.Jobs["unit"], config.Jobs["lint"]
+		if !reflect.DeepEqual(unit.BeforeScript, []string{"go mod download"}) || !reflect.DeepEqual(unit.AfterScript, []string{"rm -rf tmp"}) {
+			t.Errorf("Expected unit to inherit the global scripts, got %v / %v", unit.BeforeScript, unit.AfterScript)
+		}
+		if !reflect.DeepEqual(lint.BeforeScript, []string{"go install lint"}) || len(lint.AfterScript) != 0 {
+			t.Errorf("Expected lint to replace the global scripts, got %v / %v", lint.BeforeScript, lint.AfterScript)
+		}
+	})
+
+	// Test case 11: Artifacts paths
+	t.Run("Artifacts", func(t *testing.T) {
+		artifactsTmpFile, err := os.CreateTemp("", "artifacts-pipeline-*.yml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(artifactsTmpFile.Name())
+
+		if _, err := artifactsTmpFile.WriteString("stages: [build]\nbuild:\n  stage: build\n  image: golang:1.21\n  artifacts:\n    paths:\n      - bin/\n      - \"*.xml\"\n"); err != nil {
+			t.Fatalf("Failed to write to temp file: %v", err)
+		}
+		artifactsTmpFile.Close()
+
+		config, err := NewParser(artifactsTmpFile.Name()).Parse()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if paths := config.Jobs["build"].Artifacts.Paths; !reflect.DeepEqual(paths, []string{"bin/", "*.xml"}) {
+			t.Errorf("Unexpected artifacts paths %v", paths)
+		}
+	})
+
+	// Test case 12: Pull policy
+	t.Run("PullPolicy", func(t *testing.T) {
+		for content, valid := range map[string]bool{
+			"build:\n  image: golang:1.21\n  pull_policy: if-not-present\n": true,
+			"build:\n  image: golang:1.21\n  pull_policy: never\n":          true,
+			"build:\n  image: golang:1.21\n":                                true,
+			"build:\n  image: golang:1.21\n  pull_policy: sometimes\n":      false,
+		} {
+			policyTmpFile, err := os.CreateTemp("", "pull-policy-*.yml")
+			if err != nil {
+				t.Fatalf("Failed to create temp file: %v", err)
+			}
+			defer os.Remove(policyTmpFile.Name())
+			if _, err := policyTmpFile.WriteString(content); err != nil {
+				t.Fatalf("Failed to write to temp file: %v", err)
+			}
+			policyTmpFile.Close()
+
+			if _, err := NewParser(policyTmpFile.Name()).Parse(); (err == nil) != valid {
+				t.Errorf("Unexpected result for %q: %v", content, err)
+			}
+		}
+	})
+	// Test case 13: only/except
+	t.Run("OnlyExcept", func(t *testing.T) {
+		refsTmpFile, err := os.CreateTemp("", "only-except-*.yml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(refsTmpFile.Name())
+		content := `
+deploy:
+  image: alpine
+  only: [main, /^release-.*$/]
+  except:
+    refs: [tags]
+lint:
+  image: alpine
+  only: develop
+`
+		if _, err := refsTmpFile.WriteString(content); err != nil {
+			t.Fatalf("Failed to write to temp file: %v", err)
+		}
+		refsTmpFile.Close()
+
+		config, err := NewParser(refsTmpFile.Name()).Parse()
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if refs := config.Jobs["deploy"].Only.Refs; !reflect.DeepEqual(refs, []string{"main", "/^release-.*$/"}) {
+			t.Errorf("Unexpected only refs %v", refs)
+		}
+		if refs := config.Jobs["deploy"].Except.Refs; !reflect.DeepEqual(refs, []string{RefTags}) {
+			t.Errorf("Unexpected except refs %v", refs)
+		}
+		if refs := config.Jobs["lint"].Only.Refs; !reflect.DeepEqual(refs, []string{"develop"}) {
+			t.Errorf("Unexpected only refs %v", refs)
+		}
+
+		invalidTmpFile, err := os.CreateTemp("", "only-except-invalid-*.yml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(invalidTmpFile.Name())
+		if _, err := invalidTmpFile.WriteString("build:\n  image: alpine\n  only: [\"/release-(/\"]\n"); err != nil {
+			t.Fatalf("Failed to write to temp file: %v", err)
+		}
+		invalidTmpFile.Close()
+		if _, err := NewParser(invalidTmpFile.Name()).Parse(); err == nil {
+			t.Error("Expected an invalid ref regex to be rejected")
+		}
+	})
+	// Test case 14: anchors and extends
+	t.Run("Extends", func(t *testing.T) {
+		extendsTmpFile, err := os.CreateTemp("", "extends-*.yml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(extendsTmpFile.Name())
+		content := `
+stages: [test, deploy]
+.defaults: &defaults
+  image: golang:1.21
+  stage: test
+.base:
+  image: alpine
+  stage: deploy
+  variables:
+    ENV: staging
+    REGION: eu
+  script:
+    - echo base
+deploy_prod:
+  extends: .base
+  variables:
+    ENV: production
+unit:
+  <<: *defaults
+  script:
+    - go test ./...
+lint:
+  extends: [.base, unit]
+`
+		if _, err := extendsTmpFile.WriteString(content); err != nil {
+			t.Fatalf("Failed to write to temp file: %v", err)
+		}
+		extendsTmpFile.Close()
+
+		config, err := NewParser(extendsTmpFile.Name()).Parse()
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+
+		// Templates do not run
+		if _, ok := config.Jobs[".base"]; ok {
+			t.Error("Expected the hidden job .base to be dropped")
+		}
+		if !reflect.DeepEqual(config.JobOrder, []string{"deploy_prod", "unit", "lint"}) {
+			t.Errorf("Unexpected job order %v", config.JobOrder)
+		}
+
+		deploy := config.Jobs["deploy_prod"]
+		if deploy.Image != "alpine" || deploy.Stage != "deploy" || !reflect.DeepEqual(deploy.Script, []string{"echo base"}) {
+			t.Errorf("Expected deploy_prod to inherit from .base, got %+v", deploy)
+		}
+		if !reflect.DeepEqual(deploy.Variables, map[string]string{"ENV": "production", "REGION": "eu"}) {
+			t.Errorf("Expected merged variables, got %v", deploy.Variables)
+		}
+
+		unit := config.Jobs["unit"]
+		if unit.Image != "golang:1.21" || unit.Stage != "test" {
+			t.Errorf("Expected unit to get the anchored keys, got %+v", unit)
+		}
+
+		// The last extended job wins
+		lint := config.Jobs["lint"]
+		if lint.Image != "golang:1.21" || !reflect.DeepEqual(lint.Script, []string{"go test ./..."}) || lint.Variables["ENV"] != "staging" {
+			t.Errorf("Unexpected lint job %+v", lint)
+		}
+
+		for name, content := range map[string]string{
+			"cycle":   "a:\n  extends: b\n  script: [echo a]\nb:\n  extends: a\n",
+			"unknown": "a:\n  extends: .missing\n  script: [echo a]\n",
+		} {
+			invalidTmpFile, err := os.CreateTemp("", "extends-invalid-*.yml")
+			if err != nil {
+				t.Fatalf("Failed to create temp file: %v", err)
+			}
+			defer os.Remove(invalidTmpFile.Name())
+			if _, err := invalidTmpFile.WriteString(content); err != nil {
+				t.Fatalf("Failed to write to temp file: %v", err)
+			}
+			invalidTmpFile.Close()
+			if _, err := NewParser(invalidTmpFile.Name()).Parse(); err == nil {
+				t.Errorf("Expected the %s extends to be rejected", name)
+			}
+		}
+	})
+	// Test case 15: retry
+	t.Run("Retry", func(t *testing.T) {
+		for content, expected := range map[string]*RetryConfig{
+			"build:\n  image: alpine\n  retry: 2\n":                                  {Max: 2},
+			"build:\n  image: alpine\n  retry:\n    max: 1\n    exit_codes: [137]\n": {Max: 1, ExitCodes: []int{137}},
+			"build:\n  image: alpine\n":                                              {},
+			"build:\n  image: alpine\n  retry: 10\n":                                 nil,
+			"build:\n  image: alpine\n  retry: -1\n":                                 nil,
+		} {
+			retryTmpFile, err := os.CreateTemp("", "retry-*.yml")
+			if err != nil {
+				t.Fatalf("Failed to create temp file: %v", err)
+			}
+			defer os.Remove(retryTmpFile.Name())
+			if _, err := retryTmpFile.WriteString(content); err != nil {
+				t.Fatalf("Failed to write to temp file: %v", err)
+			}
+			retryTmpFile.Close()
+
+			config, err := NewParser(retryTmpFile.Name()).Parse()
+			if expected == nil {
+				if err == nil {
+					t.Errorf("Expected %q to be rejected", content)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("Unexpected error for %q: %v", content, err)
+				continue
+			}
+			if retry := config.Jobs["build"].Retry; !reflect.DeepEqual(retry, *expected) {
+				t.Errorf("Expected retry %+v for %q, got %+v", *expected, content, retry)
+			}
+		}
+	})
+}
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		jobs    int
+		wantErr string
+	}{
+		{"valid", "stages: [build]\nbuild:\n  stage: build\n  image: alpine\n  script: [make]\n", 1, ""},
+		{"stages only", "stages: [build]\n", 0, ""},
+		{"malformed YAML", "stages: [build\n", 0, "erreur lors du décodage YAML"},
+		{"not a mapping", "- build\n- test\n", 0, "erreur lors du décodage YAML"},
+		{"empty file", "", 0, ""},
+		{"comments only", "# TODO\n", 0, ""},
+		{"templates only", ".base:\n  image: alpine\n", 0, ""},
+		{"invalid field", "stages: [build]\nbuild:\n  stage: build\n  pull_policy: sometimes\n", 0, "pull_policy invalide"},
+		{"approval of an unknown stage", "stages: [build]\napproval:\n  stages: [deploy]\n", 0, "stage inconnu"},
+		{"negative approval timeout", "stages: [build]\napproval:\n  deployment: true\n  timeout: -1\n", 0, "timeout d'approbation invalide"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseBytes([]byte(tt.config))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(config.Jobs) != tt.jobs {
+				t.Errorf("Expected %d job(s), got %d", tt.jobs, len(config.Jobs))
+			}
+		})
+	}
+}
+
+func TestCheckNotEmpty(t *testing.T) {
+	for _, content := range []string{"", "# TODO\n", ".base:\n  image: alpine\n"} {
+		config, err := ParseBytes([]byte(content))
+		if err != nil {
+			t.Fatalf("Expected no parse error for %q, got %v", content, err)
+		}
+		if err := CheckNotEmpty(config); !errors.Is(err, ErrEmptyConfig) {
+			t.Errorf("Expected ErrEmptyConfig for %q, got %v", content, err)
+		}
+	}
+
+	config, err := ParseBytes([]byte("stages: [build]\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := CheckNotEmpty(config); err != nil {
+		t.Errorf("Expected a config with stages to be accepted, got %v", err)
+	}
+}
+
+func TestNewParserFromReader(t *testing.T) {
+	config, err := NewParserFromReader(strings.NewReader("stages: [build]\nbuild:\n  stage: build\n  image: alpine\n  script: [make]\n")).Parse()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := config.Jobs["build"]; !ok || len(config.JobOrder) != 1 {
+		t.Errorf("Expected the build job, got %+v", config.Jobs)
+	}
+}
+
+func TestParseApproval(t *testing.T) {
+	config, err := ParseBytes([]byte("stages: [build, deploy]\napproval:\n  stages: [deploy]\n  deployment: true\n  timeout: 3600\nbuild:\n  stage: build\n  image: alpine\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := config.Jobs["approval"]; ok {
+		t.Error("Expected approval not to be parsed as a job")
+	}
+	if !config.Approval.RequiresStage("deploy") || config.Approval.RequiresStage("build") {
+		t.Errorf("Expected only the deploy stage to require an approval, got %+v", config.Approval)
+	}
+	if !config.Approval.Deployment || config.Approval.Timeout != 3600 {
+		t.Errorf("Unexpected approval config %+v", config.Approval)
+	}
+}
+
+func TestParseServices(t *testing.T) {
+	config, err := ParseBytes([]byte(`
+services: [redis:7]
+test:
+  image: golang:1.25
+  services:
+    - postgres:15
+    - name: registry.example.com/db/mysql:8@sha256:abc
+    - name: postgres:16
+      alias: db
+      variables:
+        POSTGRES_PASSWORD: test
+lint:
+  image: golang:1.25
+`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	services := config.Jobs["test"].Services
+	if len(services) != 3 {
+		t.Fatalf("Expected the 3 services of the job, got %+v", services)
+	}
+	for i, alias := range []string{"postgres", "registry.example.com-db-mysql", "db"} {
+		if services[i].HostAlias() != alias {
+			t.Errorf("Expected alias %q for %s, got %q", alias, services[i].Name, services[i].HostAlias())
+		}
+	}
+	if services[2].Variables["POSTGRES_PASSWORD"] != "test" {
+		t.Errorf("Expected the service variables, got %v", services[2].Variables)
+	}
+
+	// Jobs without services get the global ones
+	if lint := config.Jobs["lint"].Services; len(lint) != 1 || lint[0].Name != "redis:7" {
+		t.Errorf("Expected the global services, got %+v", lint)
+	}
+
+	for _, content := range []string{
+		"test:\n  image: alpine\n  services: [postgres:15, postgres:16]\n",
+		"test:\n  image: alpine\n  services: [{alias: db}]\n",
+		"test:\n  image: alpine\n  services: [{name: postgres, alias: 'my db'}]\n",
+	} {
+		if _, err := ParseBytes([]byte(content)); err == nil {
+			t.Errorf("Expected %q to be rejected", content)
+		}
+	}
+}
+
+func TestParseCache(t *testing.T) {
+	config, err := ParseBytes([]byte(`
+cache:
+  key: deps
+  paths: [vendor]
+install:
+  image: node:22
+  cache:
+    key:
+      files: [package-lock.json]
+      prefix: npm
+    paths: [node_modules, .npm]
+lint:
+  image: node:22
+`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	cache := config.Jobs["install"].Cache
+	if cache == nil || cache.Key.Prefix != "npm" || !reflect.DeepEqual(cache.Key.Files, []string{"package-lock.json"}) || len(cache.Paths) != 2 {
+		t.Errorf("Unexpected cache %+v", cache)
+	}
+
+	// Jobs without cache get the global one
+	if cache := config.Jobs["lint"].Cache; cache == nil || cache.Key.Name != "deps" {
+		t.Errorf("Expected the global cache, got %+v", cache)
+	}
+
+	for _, content := range []string{
+		"test:\n  image: alpine\n  cache: {key: deps}\n",
+		"test:\n  image: alpine\n  cache: {paths: [../outside]}\n",
+		"test:\n  image: alpine\n  cache: {key: {files: [/etc/passwd]}, paths: [vendor]}\n",
+	} {
+		if _, err := ParseBytes([]byte(content)); err == nil {
+			t.Errorf("Expected %q to be rejected", content)
+		}
+	}
+}
+
+func TestParseImageEntrypoint(t *testing.T) {
+	config, err := ParseBytes([]byte(`
+lint:
+  image:
+    name: golangci/golangci-lint:v1.60
+    entrypoint: [""]
+  shell: bash
+  script: [golangci-lint run]
+test:
+  image: alpine
+  shell: /busybox/sh
+  script: [go test ./...]
+`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	lint := config.Jobs["lint"]
+	if lint.Image != "golangci/golangci-lint:v1.60" || !reflect.DeepEqual(lint.Entrypoint, []string{""}) || lint.Shell != "bash" {
+		t.Errorf("Unexpected image %q, entrypoint %q and shell %q", lint.Image, lint.Entrypoint, lint.Shell)
+	}
+	if test := config.Jobs["test"]; test.Image != "alpine" || test.Entrypoint != nil || test.Shell != "/busybox/sh" {
+		t.Errorf("Unexpected image %q, entrypoint %q and shell %q", test.Image, test.Entrypoint, test.Shell)
+	}
+
+	for _, shell := range []string{"fish", "bash -x", "/bin/sh -e"} {
+		content := "test:\n  image: alpine\n  shell: " + shell + "\n"
+		if _, err := ParseBytes([]byte(content)); err == nil {
+			t.Errorf("Expected the shell %q to be rejected", shell)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	config, err := ParseBytes([]byte(`
+build:
+  image: golang:1.25
+  tags: [linux, docker]
+  script: [go build ./...]
+`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if tags := config.Jobs["build"].Tags; !reflect.DeepEqual(tags, []string{"linux", "docker"}) {
+		t.Errorf("Expected tags [linux docker], got %v", tags)
+	}
 }
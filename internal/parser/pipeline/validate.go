@@ -0,0 +1,101 @@
+package pipeline
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationError describes a problem of a pipeline config
+type ValidationError struct {
+	Job     string `json:"job,omitempty"`   // Job at fault, empty for an error of the whole config
+	Field   string `json:"field,omitempty"` // Key at fault (stage, script, image, ...)
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	if e.Job != "" {
+		return fmt.Sprintf("job %s : %s", e.Job, e.Message)
+	}
+	return e.Message
+}
+
+// Validate parses a pipeline config, GitLab or GitHub Actions, and checks that its jobs are well-formed, without running anything
+// Besides the parsing errors, it reports duplicate job names, unknown stages, and shell jobs without script or image
+// It returns no error for a valid config
+func Validate(data []byte) []ValidationError {
+	var errs []ValidationError
+	for _, name := range duplicateJobs(data) {
+		errs = append(errs, ValidationError{Job: name, Message: "job déclaré plusieurs fois"})
+	}
+
+	config, err := ParseConfig("", data)
+	if err != nil {
+		return append(errs, ValidationError{Message: err.Error()})
+	}
+
+	stages := make(map[string]bool, len(config.Stages))
+	for _, stage := range config.Stages {
+		stages[stage] = true
+	}
+	for _, name := range config.JobOrder {
+		job := config.Jobs[name]
+		if job.Stage == "" {
+			errs = append(errs, ValidationError{Job: name, Field: "stage", Message: "stage manquant"})
+		} else if !stages[job.Stage] {
+			errs = append(errs, ValidationError{Job: name, Field: "stage", Message: fmt.Sprintf("stage inconnu : %q (stages : %v)", job.Stage, config.Stages)})
+		}
+		// Deployment jobs run no script
+		if job.Type != "" && job.Type != "shell" {
+			continue
+		}
+		if len(job.Script) == 0 {
+			errs = append(errs, ValidationError{Job: name, Field: "script", Message: "aucun script"})
+		}
+		if job.Image == "" {
+			errs = append(errs, ValidationError{Job: name, Field: "image", Message: "image vide"})
+		}
+	}
+	return errs
+}
+
+// rootKeys are the keys of the root of a config that are not jobs, the yaml keys of the PipelineConfig fields
+var rootKeys = configRootKeys()
+
+func configRootKeys() map[string]bool {
+	keys := make(map[string]bool)
+	t := reflect.TypeFor[PipelineConfig]()
+	for i := range t.NumField() {
+		// The inline jobs map has no key of its own
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ","); name != "" && name != "-" {
+			keys[name] = true
+		}
+	}
+	return keys
+}
+
+// duplicateJobs returns the jobs declared more than once at the root, in the order of their first declaration
+// The YAML decoder rejects them with a generic error, this tells which job is at fault
+func duplicateJobs(data []byte) []string {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+
+	root := doc.Content[0]
+	declared := make(map[string]int)
+	var duplicates []string
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		name := root.Content[i].Value
+		if root.Content[i+1].Kind != yaml.MappingNode || rootKeys[name] {
+			continue
+		}
+		declared[name]++
+		if declared[name] == 2 {
+			duplicates = append(duplicates, name)
+		}
+	}
+	return duplicates
+}
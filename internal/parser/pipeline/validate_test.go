@@ -0,0 +1,113 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		errors []ValidationError
+	}{
+		{
+			name: "valid",
+			config: `
+stages: [build, deploy]
+build:
+  stage: build
+  image: golang:1.25
+  script: [go build ./...]
+deploy:
+  stage: deploy
+  type: docker-compose-deploy
+`,
+		},
+		{
+			name:   "empty config",
+			config: "# TODO\n",
+		},
+		{
+			name: "unknown stage",
+			config: `
+stages: [build]
+test:
+  stage: test
+  image: golang:1.25
+  script: [go test ./...]
+`,
+			errors: []ValidationError{{Job: "test", Field: "stage", Message: `stage inconnu : "test" (stages : [build])`}},
+		},
+		{
+			name: "no script and empty image",
+			config: `
+stages: [build]
+build:
+  stage: build
+`,
+			errors: []ValidationError{
+				{Job: "build", Field: "script", Message: "aucun script"},
+				{Job: "build", Field: "image", Message: "image vide"},
+			},
+		},
+		{
+			name: "missing stage",
+			config: `
+stages: [build]
+build:
+  image: alpine
+  script: [make]
+`,
+			errors: []ValidationError{{Job: "build", Field: "stage", Message: "stage manquant"}},
+		},
+		{
+			name: "job declared twice",
+			config: `
+stages: [build]
+build:
+  stage: build
+  image: alpine
+  script: [make]
+test:
+  stage: build
+  image: alpine
+  script: [make test]
+build:
+  stage: build
+  image: alpine
+  script: [make all]
+`,
+			errors: []ValidationError{
+				{Job: "build", Message: "job déclaré plusieurs fois"},
+				{Message: "erreur lors du décodage YAML : yaml: unmarshal errors:\n  line 11: mapping key \"build\" already defined at line 3"},
+			},
+		},
+		{
+			// Every root setting of PipelineConfig is told apart from a job
+			name: "root setting repeated",
+			config: `
+stages: [build]
+approval:
+  deployment: true
+approval:
+  deployment: false
+`,
+			errors: []ValidationError{{Message: "erreur lors du décodage YAML : yaml: unmarshal errors:\n  line 5: mapping key \"approval\" already defined at line 3"}},
+		},
+		{
+			name:   "malformed YAML",
+			config: "stages: [build\n",
+			errors: []ValidationError{{Message: "erreur lors du décodage YAML : yaml: line 1: did not find expected ',' or ']'"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := Validate([]byte(tt.config))
+			if !reflect.DeepEqual(errs, tt.errors) {
+				t.Errorf("Expected %v, got %v", tt.errors, errs)
+			}
+		})
+	}
+}
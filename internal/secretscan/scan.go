@@ -0,0 +1,92 @@
+// Package secretscan detects credentials committed to a repository with regular expressions
+package secretscan
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Modes of the secret scan gate of a project
+const (
+	ModeWarn  = "warn"  // findings are reported, the deployment goes on
+	ModeBlock = "block" // findings fail the pipeline before the deployment
+)
+
+// maxFileSize skips files too large to be source code (archives, datasets, ...)
+const maxFileSize = 1 << 20
+
+// Finding is a potential secret, the matched value itself is never kept
+type Finding struct {
+	File string // path relative to the scanned directory
+	Line int
+	Rule string
+}
+
+type rule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+var rules = []rule{
+	{"AWS access key ID", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"AWS secret access key", regexp.MustCompile(`(?i)aws_?secret_?access_?key\s*[:=]\s*["']?[A-Za-z0-9/+]{40}\b`)},
+	{"Private key", regexp.MustCompile(`-----BEGIN ((RSA|DSA|EC|OPENSSH|PGP) )?PRIVATE KEY( BLOCK)?-----`)},
+	{"GitHub token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36}\b`)},
+	{"GitLab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20}\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+}
+
+// Scan walks dir and returns the lines matching a secret rule
+// The .git directory, binary files and files larger than 1 MB are skipped
+func Scan(dir string) ([]Finding, error) {
+	var findings []Finding
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || info.Size() > maxFileSize {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.IndexByte(content, 0) >= 0 {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(dir, path)
+		findings = append(findings, scanContent(rel, content)...)
+		return nil
+	})
+	return findings, err
+}
+
+// scanContent returns the findings of a single file
+func scanContent(file string, content []byte) []Finding {
+	var findings []Finding
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxFileSize)
+	for line := 1; scanner.Scan(); line++ {
+		for _, r := range rules {
+			if r.pattern.Match(scanner.Bytes()) {
+				findings = append(findings, Finding{File: file, Line: line, Rule: r.name})
+			}
+		}
+	}
+	return findings
+}
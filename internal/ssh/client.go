@@ -3,6 +3,7 @@ package ssh
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -117,4 +118,13 @@ func (c *Client) RunCommandStream(cmd string, onLog func(string)) error {
 	go scan(stderr)
 
 	return session.Wait()
-}
\ No newline at end of file
+}
+
+// ExitCode returns the exit status of the failed remote command wrapped in err
+func ExitCode(err error) (int, bool) {
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), true
+	}
+	return 0, false
+}
@@ -1,20 +1,24 @@
 package main
 
 import (
+	"context"
 	"os"
 
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/api"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/internal/database"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/env"
 	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/tracing"
 	"github.com/joho/godotenv"
 )
 
 func main() {
+	// Load .env file, before the logger so that LOG_FORMAT and LOG_LEVEL may come from it
+	envErr := godotenv.Load()
+
 	// Initialize Logger
 	logger.Init()
-
-	// Load .env file
-	if err := godotenv.Load(); err != nil {
+	if envErr != nil {
 		logger.Warn("No .env file found, using system environment variables")
 	}
 
@@ -31,6 +35,14 @@ func main() {
 		logger.Info("Connected to database successfully")
 	}
 
+	// Export pipeline traces to the OTLP endpoint when enabled
+	shutdownTracing, err := tracing.Init(context.Background(), env.Bool("OTEL_TRACING_ENABLED", false))
+	if err != nil {
+		logger.Warn("Warning: Could not initialize tracing: " + err.Error())
+	} else {
+		defer shutdownTracing(context.Background())
+	}
+
 	// Get port from environment or use default
 	port := os.Getenv("API_PORT")
 	if port == "" {
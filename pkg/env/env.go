@@ -0,0 +1,61 @@
+package env
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Soif2Sang/imt-cloud-CI-CD-backend.git/pkg/logger"
+)
+
+// Bool returns the boolean value of the environment variable key.
+// It returns def when the variable is unset or not a valid boolean.
+func Bool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		logger.Warn("Invalid boolean for " + key + ", using default")
+		return def
+	}
+	return parsed
+}
+
+// String returns the value of the environment variable key.
+// It returns def when the variable is unset; an empty value is kept as is.
+func String(key string, def string) string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	return value
+}
+
+// Int returns the integer value of the environment variable key.
+// It returns def when the variable is unset or not a valid integer.
+func Int(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		logger.Warn("Invalid integer for " + key + ", using default")
+		return def
+	}
+	return parsed
+}
+
+// List returns the comma-separated values of the environment variable key.
+// Blank entries are dropped; it returns nil when the variable is unset.
+func List(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -1,17 +1,36 @@
 package logger
 
 import (
+	"context"
+	"io"
 	"log/slog"
 	"os"
+	"strings"
 )
 
 // Init initializes the global logger.
-// Currently it defaults to a JSON handler on stdout.
+// LOG_FORMAT selects the handler: json (default, for log aggregators) or text (human-readable console output).
+// LOG_LEVEL sets the minimum level: debug, info (default), warn or error.
 func Init() {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
-	slog.SetDefault(logger)
+	slog.SetDefault(slog.New(newHandler(os.Stdout, os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))))
+}
+
+// newHandler returns the handler writing to w in the given format and level.
+func newHandler(w io.Writer, format, level string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: parseLevel(level)}
+	if strings.EqualFold(format, "text") {
+		return slog.NewTextHandler(w, opts)
+	}
+	return slog.NewJSONHandler(w, opts)
+}
+
+// parseLevel returns the level named by level, Info when unknown.
+func parseLevel(level string) slog.Level {
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(level)); err != nil {
+		return slog.LevelInfo
+	}
+	return parsed
 }
 
 // Info logs at Info level.
@@ -38,3 +57,18 @@ func Debug(msg string, args ...any) {
 func With(args ...any) *slog.Logger {
 	return slog.With(args...)
 }
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying l, for the code running on behalf of a pipeline or job.
+func NewContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or the global logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
@@ -0,0 +1,53 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewHandlerFormats(t *testing.T) {
+	var buf bytes.Buffer
+	slog.New(newHandler(&buf, "", "")).Info("Job started", "pipeline_id", 7, "job_name", "build")
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Expected a JSON line by default, got %q", buf.String())
+	}
+	if line["msg"] != "Job started" || line["pipeline_id"] != float64(7) || line["job_name"] != "build" {
+		t.Errorf("Unexpected JSON line %v", line)
+	}
+
+	buf.Reset()
+	slog.New(newHandler(&buf, "text", "")).Info("Job started", "job_name", "build")
+	if !strings.Contains(buf.String(), `msg="Job started" job_name=build`) {
+		t.Errorf("Expected a text line, got %q", buf.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"":      slog.LevelInfo,
+		"debug": slog.LevelDebug,
+		"WARN":  slog.LevelWarn,
+		"error": slog.LevelError,
+		"loud":  slog.LevelInfo,
+	}
+	for level, expected := range tests {
+		if parsed := parseLevel(level); parsed != expected {
+			t.Errorf("parseLevel(%q) = %v, expected %v", level, parsed, expected)
+		}
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if FromContext(context.Background()) != slog.Default() {
+		t.Error("Expected the global logger without a context logger")
+	}
+	l := slog.Default().With("pipeline_id", 3)
+	if FromContext(NewContext(context.Background(), l)) != l {
+		t.Error("Expected the logger carried by the context")
+	}
+}
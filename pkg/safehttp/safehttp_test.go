@@ -0,0 +1,36 @@
+package safehttp
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAllowed(t *testing.T) {
+	blocked := []string{"127.0.0.1", "::1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "fe80::1", "fd00::1", "100.64.0.1", "0.0.0.0", "224.0.0.1"}
+	for _, addr := range blocked {
+		if Allowed(net.ParseIP(addr)) {
+			t.Errorf("Expected %s to be blocked", addr)
+		}
+	}
+	for _, addr := range []string{"1.1.1.1", "93.184.216.34", "2606:4700:4700::1111"} {
+		if !Allowed(net.ParseIP(addr)) {
+			t.Errorf("Expected %s to be allowed", addr)
+		}
+	}
+}
+
+func TestNewClientBlocksLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected the request not to reach the server")
+	}))
+	defer server.Close()
+
+	_, err := NewClient(time.Second).Get(server.URL)
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("Expected the loopback address to be blocked, got %v", err)
+	}
+}